@@ -0,0 +1,308 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// APIConfig says where the HTTP API is served.
+type APIConfig struct {
+	// Listen is the address of the HTTP server, empty for none. It may be
+	// the same as Metrics.Listen, then both share one server.
+	Listen string
+	// MaxQuotes is the most quotes worked out at once; more are turned
+	// away with 429 Too Many Requests.
+	MaxQuotes int
+}
+
+// errNoScan is a question asked before the first scan finished.
+var errNoScan = errors.New("no scan yet")
+
+// apiSnapshot is what one scan saw. It is replaced whole, never changed,
+// and keeps its own copy of the graph so requests never wait on the
+// detector's lock.
+type apiSnapshot struct {
+	head          *types.Header
+	graph         *routeGraph
+	pools         []PoolState
+	opportunities []Opportunity
+}
+
+// API answers questions about the last scan over HTTP: its graph, quotes
+// between two tokens on that graph, and the opportunities it found.
+type API struct {
+	chain   string
+	tokens  *TokenRegistry
+	routing QuoteConfig
+	// quoting holds a slot for every quote being worked out
+	quoting chan struct{}
+
+	mu   sync.RWMutex
+	snap *apiSnapshot
+}
+
+func NewAPI(chain string, tokens *TokenRegistry, config APIConfig, routing QuoteConfig) *API {
+	return &API{chain: chain, tokens: tokens, routing: routing, quoting: make(chan struct{}, config.MaxQuotes)}
+}
+
+// Update publishes a scan: the head its reserves are from, the graph the
+// detector searched, the pools it was built from and what was found.
+func (a *API) Update(head *types.Header, graph *Graph, pools []PoolState, opportunities []Opportunity) {
+	snap := &apiSnapshot{head: head, graph: graph.routeGraph(), pools: copyPools(pools), opportunities: append([]Opportunity{}, opportunities...)}
+	a.mu.Lock()
+	a.snap = snap
+	a.mu.Unlock()
+}
+
+func (a *API) snapshot() (*apiSnapshot, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if a.snap == nil {
+		return nil, errNoScan
+	}
+	return a.snap, nil
+}
+
+// routes are the API's handlers by path.
+func (a *API) routes() map[string]http.Handler {
+	return map[string]http.Handler{
+		"/graph":         apiHandler(a.graph),
+		"/quote":         apiHandler(a.quote),
+		"/opportunities": apiHandler(a.opportunities),
+	}
+}
+
+// apiError is an error with the HTTP status to answer it with.
+type apiError struct {
+	status int
+	err    error
+}
+
+func (e *apiError) Error() string { return e.err.Error() }
+
+func badRequest(format string, args ...interface{}) error {
+	return &apiError{status: http.StatusBadRequest, err: fmt.Errorf(format, args...)}
+}
+
+// apiHandler answers GETs with fn's result as JSON, or its error as
+// {"Error": ...}.
+func apiHandler(fn func(r *http.Request) (interface{}, error)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			json.NewEncoder(w).Encode(map[string]string{"Error": "only GET is supported"})
+			return
+		}
+		result, err := fn(r)
+		if err != nil {
+			status := http.StatusInternalServerError
+			var apiErr *apiError
+			if errors.As(err, &apiErr) {
+				status = apiErr.status
+			} else if errors.Is(err, errNoScan) {
+				status = http.StatusServiceUnavailable
+			}
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(map[string]string{"Error": err.Error()})
+			return
+		}
+		if err := json.NewEncoder(w).Encode(result); err != nil {
+			log.Debug("Cannot write API response", "path", r.URL.Path, "err", err)
+		}
+	})
+}
+
+// APIToken is a token of the graph.
+type APIToken struct {
+	Address  common.Address
+	Symbol   string
+	Decimals uint8
+}
+
+// APIPool is a pool of the graph with its reserves.
+type APIPool struct {
+	Address  common.Address
+	Token0   common.Address
+	Token1   common.Address
+	Reserve0 *big.Int
+	Reserve1 *big.Int
+}
+
+// APIGraph is the graph of the last scan.
+type APIGraph struct {
+	Chain     string
+	Block     uint64
+	BlockHash common.Hash
+	BlockTime time.Time
+	Tokens    []APIToken
+	Pools     []APIPool
+}
+
+func (a *API) graph(r *http.Request) (interface{}, error) {
+	snap, err := a.snapshot()
+	if err != nil {
+		return nil, err
+	}
+	result := &APIGraph{
+		Chain:     a.chain,
+		Block:     snap.head.Number.Uint64(),
+		BlockHash: snap.head.Hash(),
+		BlockTime: time.Unix(int64(snap.head.Time), 0).UTC(),
+		Tokens:    []APIToken{},
+		Pools:     []APIPool{},
+	}
+	for i, token := range snap.graph.tokens {
+		result.Tokens = append(result.Tokens, APIToken{Address: token, Symbol: snap.graph.symbols[i], Decimals: a.tokens.Decimals(token)})
+	}
+	for _, pool := range snap.pools {
+		if pool.reserve0.Sign() == 0 || pool.reserve1.Sign() == 0 {
+			continue
+		}
+		result.Pools = append(result.Pools, APIPool{
+			Address:  pool.in.Factory,
+			Token0:   pool.in.From,
+			Token1:   pool.in.To,
+			Reserve0: new(big.Int).Set(&pool.reserve0),
+			Reserve1: new(big.Int).Set(&pool.reserve1),
+		})
+	}
+	return result, nil
+}
+
+// APIQuote is the best route and best split found for an amount on the
+// last scan's graph.
+type APIQuote struct {
+	Block uint64
+	Quote
+}
+
+// quote answers /quote?from=<token>&to=<token>&amount=<raw amount>.
+func (a *API) quote(r *http.Request) (interface{}, error) {
+	query := r.URL.Query()
+	from, to := query.Get("from"), query.Get("to")
+	if !common.IsHexAddress(from) || !common.IsHexAddress(to) {
+		return nil, badRequest("from and to must be token addresses")
+	}
+	amount, ok := new(big.Int).SetString(query.Get("amount"), 10)
+	if !ok || amount.Sign() <= 0 {
+		return nil, badRequest("amount must be a positive integer in the token's smallest unit")
+	}
+	snap, err := a.snapshot()
+	if err != nil {
+		return nil, err
+	}
+	select {
+	case a.quoting <- struct{}{}:
+		defer func() { <-a.quoting }()
+	default:
+		return nil, &apiError{status: http.StatusTooManyRequests, err: fmt.Errorf("%d quotes are already being worked out", cap(a.quoting))}
+	}
+	quote, err := quoteRoutes(snap.graph, common.HexToAddress(from), common.HexToAddress(to), amount, a.routing)
+	if errors.Is(err, errNoRoute) {
+		return nil, &apiError{status: http.StatusNotFound, err: err}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &APIQuote{Block: snap.head.Number.Uint64(), Quote: *quote}, nil
+}
+
+// APIOpportunity is one opportunity of the last scan.
+type APIOpportunity struct {
+	Block  uint64
+	Path   []string
+	Tokens []common.Address
+	Pools  []common.Address
+	// Split is the loop sized across the pools parallel to its hops, when
+	// that would pay more than AmountIn through Pools.
+	Split    *LoopSplit `json:",omitempty"`
+	AmountIn *big.Int
+	Profit   *big.Int
+	// Return is the loop's price product less one. ProfitNative is the
+	// profit in whole native coins, zero when the graph cannot price it.
+	Return       float64
+	ProfitNative float64
+}
+
+// opportunities are the last scan's, most profitable in the native coin
+// first; those that cannot be priced come last, by return.
+func (a *API) opportunities(r *http.Request) (interface{}, error) {
+	snap, err := a.snapshot()
+	if err != nil {
+		return nil, err
+	}
+	result := []APIOpportunity{}
+	for _, opp := range snap.opportunities {
+		source := opp.pairs[0].from
+		found := APIOpportunity{
+			Block:    opp.number,
+			Split:    opp.split,
+			AmountIn: new(big.Int).Set(&opp.amountIn),
+			Profit:   new(big.Int).Set(&opp.profit),
+			Return:   opp.value - 1,
+		}
+		if opp.nativeRate > 0 {
+			profit, _ := a.tokens.Normalize(source, &opp.profit).Float64()
+			found.ProfitNative = profit / opp.nativeRate
+		}
+		found.Tokens = append(found.Tokens, source)
+		found.Path = append(found.Path, opp.pairs[0].from_symbol)
+		for _, pair := range opp.pairs {
+			found.Tokens = append(found.Tokens, pair.to)
+			found.Path = append(found.Path, pair.to_symbol)
+			found.Pools = append(found.Pools, pair.factory)
+		}
+		result = append(result, found)
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		if result[i].ProfitNative != result[j].ProfitNative {
+			return result[i].ProfitNative > result[j].ProfitNative
+		}
+		return result[i].Return > result[j].Return
+	})
+	return result, nil
+}
+
+// serveHTTP serves each listen address with its handlers by path, so
+// several features can share one server. Only failing to listen is
+// returned; the servers then run until the process exits.
+func serveHTTP(servers map[string]map[string]http.Handler) error {
+	listeners := make(map[string]net.Listener, len(servers))
+	for listen := range servers {
+		listener, err := net.Listen("tcp", listen)
+		if err != nil {
+			for _, opened := range listeners {
+				opened.Close()
+			}
+			return err
+		}
+		listeners[listen] = listener
+	}
+	for listen, routes := range servers {
+		mux := http.NewServeMux()
+		paths := make([]string, 0, len(routes))
+		for path, handler := range routes {
+			mux.Handle(path, handler)
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+		listener := listeners[listen]
+		go func() {
+			log.Error("HTTP server stopped", "addr", listener.Addr(), "err", http.Serve(listener, mux))
+		}()
+		log.Info("Serving HTTP", "addr", listener.Addr(), "paths", paths)
+	}
+	return nil
+}
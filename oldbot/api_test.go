@@ -0,0 +1,51 @@
+package main
+
+import (
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+func TestAPIQuote(t *testing.T) {
+	api := NewAPI("test", nil, APIConfig{MaxQuotes: 1}, QuoteConfig{MaxHops: 2, MaxPaths: 4, MaxRoutes: 2, Parts: 10})
+	handler := api.routes()["/quote"]
+	url := "/quote?from=" + testToken(1).Hex() + "&to=" + testToken(2).Hex() + "&amount=1000000"
+	get := func(url string) int {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, url, nil))
+		return recorder.Code
+	}
+
+	if code := get(url); code != http.StatusServiceUnavailable {
+		t.Errorf("before the first scan: %d, want %d", code, http.StatusServiceUnavailable)
+	}
+	api.Update(&types.Header{Number: big.NewInt(1)}, testGraph(testPair(1, 2, 1000, 1000, 25)), nil, nil)
+
+	tests := []struct {
+		name string
+		url  string
+		want int
+	}{
+		{"quote", url, http.StatusOK},
+		{"no route", "/quote?from=" + testToken(1).Hex() + "&to=" + testToken(3).Hex() + "&amount=1", http.StatusNotFound},
+		{"bad amount", "/quote?from=" + testToken(1).Hex() + "&to=" + testToken(2).Hex() + "&amount=-1", http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		if code := get(test.url); code != test.want {
+			t.Errorf("%s: %d, want %d", test.name, code, test.want)
+		}
+	}
+
+	// With every slot taken, quotes are turned away rather than queued
+	api.quoting <- struct{}{}
+	if code := get(url); code != http.StatusTooManyRequests {
+		t.Errorf("while busy: %d, want %d", code, http.StatusTooManyRequests)
+	}
+	<-api.quoting
+	if code := get(url); code != http.StatusOK {
+		t.Errorf("after the slot was freed: %d, want %d", code, http.StatusOK)
+	}
+}
@@ -0,0 +1,359 @@
+// Code generated - DO NOT EDIT.
+// This file is a generated binding and any manual changes will be lost.
+
+package arbExecutor
+
+import (
+	"errors"
+	"math/big"
+	"strings"
+
+	ethereum "github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/event"
+)
+
+// Reference imports to suppress errors if they are not otherwise used.
+var (
+	_ = errors.New
+	_ = big.NewInt
+	_ = strings.NewReader
+	_ = ethereum.NotFound
+	_ = bind.Bind
+	_ = common.Big1
+	_ = types.BloomLookup
+	_ = event.NewSubscription
+)
+
+// ArbExecutorMetaData contains all meta data concerning the ArbExecutor contract.
+var ArbExecutorMetaData = &bind.MetaData{
+	ABI: "[{\"inputs\":[],\"stateMutability\":\"nonpayable\",\"type\":\"constructor\"},{\"inputs\":[{\"internalType\":\"address\",\"name\":\"sender\",\"type\":\"address\"},{\"internalType\":\"uint256\",\"name\":\"amount0\",\"type\":\"uint256\"},{\"internalType\":\"uint256\",\"name\":\"amount1\",\"type\":\"uint256\"},{\"internalType\":\"bytes\",\"name\":\"data\",\"type\":\"bytes\"}],\"name\":\"BiswapCall\",\"outputs\":[],\"stateMutability\":\"nonpayable\",\"type\":\"function\"},{\"inputs\":[{\"internalType\":\"address[]\",\"name\":\"pools\",\"type\":\"address[]\"},{\"internalType\":\"address[]\",\"name\":\"tokens\",\"type\":\"address[]\"},{\"internalType\":\"uint256[]\",\"name\":\"fees\",\"type\":\"uint256[]\"},{\"internalType\":\"uint256[]\",\"name\":\"minOuts\",\"type\":\"uint256[]\"},{\"internalType\":\"uint256\",\"name\":\"amountIn\",\"type\":\"uint256\"},{\"internalType\":\"uint256\",\"name\":\"minProfit\",\"type\":\"uint256\"}],\"name\":\"execute\",\"outputs\":[{\"internalType\":\"uint256\",\"name\":\"profit\",\"type\":\"uint256\"}],\"stateMutability\":\"nonpayable\",\"type\":\"function\"},{\"inputs\":[{\"internalType\":\"address[]\",\"name\":\"pools\",\"type\":\"address[]\"},{\"internalType\":\"address[]\",\"name\":\"tokens\",\"type\":\"address[]\"},{\"internalType\":\"uint256[]\",\"name\":\"fees\",\"type\":\"uint256[]\"},{\"internalType\":\"uint256[]\",\"name\":\"minOuts\",\"type\":\"uint256[]\"},{\"internalType\":\"uint256\",\"name\":\"borrowAmount\",\"type\":\"uint256\"},{\"internalType\":\"uint256\",\"name\":\"repayAmount\",\"type\":\"uint256\"},{\"internalType\":\"uint256\",\"name\":\"minProfit\",\"type\":\"uint256\"}],\"name\":\"executeFlash\",\"outputs\":[{\"internalType\":\"uint256\",\"name\":\"profit\",\"type\":\"uint256\"}],\"stateMutability\":\"nonpayable\",\"type\":\"function\"},{\"inputs\":[],\"name\":\"owner\",\"outputs\":[{\"internalType\":\"address\",\"name\":\"\",\"type\":\"address\"}],\"stateMutability\":\"view\",\"type\":\"function\"},{\"inputs\":[{\"internalType\":\"address\",\"name\":\"sender\",\"type\":\"address\"},{\"internalType\":\"uint256\",\"name\":\"amount0\",\"type\":\"uint256\"},{\"internalType\":\"uint256\",\"name\":\"amount1\",\"type\":\"uint256\"},{\"internalType\":\"bytes\",\"name\":\"data\",\"type\":\"bytes\"}],\"name\":\"pancakeCall\",\"outputs\":[],\"stateMutability\":\"nonpayable\",\"type\":\"function\"},{\"inputs\":[{\"internalType\":\"address\",\"name\":\"sender\",\"type\":\"address\"},{\"internalType\":\"uint256\",\"name\":\"amount0\",\"type\":\"uint256\"},{\"internalType\":\"uint256\",\"name\":\"amount1\",\"type\":\"uint256\"},{\"internalType\":\"bytes\",\"name\":\"data\",\"type\":\"bytes\"}],\"name\":\"uniswapV2Call\",\"outputs\":[],\"stateMutability\":\"nonpayable\",\"type\":\"function\"},{\"inputs\":[{\"internalType\":\"address\",\"name\":\"token\",\"type\":\"address\"},{\"internalType\":\"uint256\",\"name\":\"amount\",\"type\":\"uint256\"}],\"name\":\"withdraw\",\"outputs\":[],\"stateMutability\":\"nonpayable\",\"type\":\"function\"}]",
+	Bin: "0x60a060405234801561001057600080fd5b503360805260805161189761004b60003960008181609c0152818161014b015281816105a40152818161095b015261099e01526118976000f3fe608060405234801561001057600080fd5b506004361061007d5760003560e01c80638da5cb5b1161005b5780638da5cb5b14610097578063c4178724146100db578063daacdde0146100fc578063f3fef3a31461010f57600080fd5b806310d1e85c146100825780635b3bc4fe146100825780638480081214610082575b600080fd5b6100956100903660046110cf565b610122565b005b6100be7f000000000000000000000000000000000000000000000000000000000000000081565b6040516001600160a01b0390911681526020015b60405180910390f35b6100ee6100e93660046111b1565b61013e565b6040519081526020016100d2565b6100ee61010a366004611287565b610597565b61009561011d366004611368565b610950565b6101378561013085876113aa565b84846109c7565b5050505050565b6000336001600160a01b037f000000000000000000000000000000000000000000000000000000000000000016146101915760405162461bcd60e51b8152600401610188906113c3565b60405180910390fd5b60018a1180156101aa57506101a78a60016113aa565b88145b80156101b55750858a145b80156101c05750838a145b6102045760405162461bcd60e51b8152602060048201526015602482015274082e4c48af0cac6eae8dee47440c4c2c840e0c2e8d605b1b6044820152606401610188565b88888b818110610216576102166113f3565b905060200201602081019061022b9190611409565b6001600160a01b031689896000818110610247576102476113f3565b905060200201602081019061025c9190611409565b6001600160a01b0316146102ac5760405162461bcd60e51b815260206004820152601760248201527604172624578656375746f723a206e6f742061206c6f6f7604c1b6044820152606401610188565b6000898960008181106102c1576102c16113f3565b90506020020160208101906102d69190611409565b6040516370a0823160e01b81523060048201526001600160a01b0391909116906370a0823190602401602060405180830381865afa15801561031c573d6000803e3d6000fd5b505050506040513d601f19601f82011682018060405250810190610340919061142d565b905061039b8a8a6000818110610358576103586113f3565b905060200201602081019061036d9190611409565b8d8d6000818110610380576103806113f3565b90506020020160208101906103959190611409565b86610bb1565b60005b8b8110156104905760008c6103b48360016113aa565b106103bf57306103f0565b8d8d6103cc8460016113aa565b8181106103db576103db6113f3565b90506020020160208101906103f09190611409565b905061047b8e8e84818110610407576104076113f3565b905060200201602081019061041c9190611409565b8d8d8581811061042e5761042e6113f3565b90506020020160208101906104439190611409565b8c8c86818110610455576104556113f3565b905060200201358b8b8781811061046e5761046e6113f3565b9050602002013585610cc5565b5050808061048890611446565b91505061039e565b5060008a8a60008181106104a6576104a66113f3565b90506020020160208101906104bb9190611409565b6040516370a0823160e01b81523060048201526001600160a01b0391909116906370a0823190602401602060405180830381865afa158015610501573d6000803e3d6000fd5b505050506040513d601f19601f82011682018060405250810190610525919061142d565b905061053184836113aa565b81101561057c5760405162461bcd60e51b81526020600482015260196024820152784172624578656375746f723a20756e70726f66697461626c6560381b6044820152606401610188565b610586828261145f565b9d9c50505050505050505050505050565b6000336001600160a01b037f000000000000000000000000000000000000000000000000000000000000000016146105e15760405162461bcd60e51b8152600401610188906113c3565b60018b1180156105fa57506105f78b60016113aa565b89145b80156106055750868b145b80156106105750848b145b6106545760405162461bcd60e51b8152602060048201526015602482015274082e4c48af0cac6eae8dee47440c4c2c840e0c2e8d605b1b6044820152606401610188565b89898c818110610666576106666113f3565b905060200201602081019061067b9190611409565b6001600160a01b03168a8a6000818110610697576106976113f3565b90506020020160208101906106ac9190611409565b6001600160a01b0316146106fc5760405162461bcd60e51b815260206004820152601760248201527604172624578656375746f723a206e6f742061206c6f6f7604c1b6044820152606401610188565b6000831161074c5760405162461bcd60e51b815260206004820152601d60248201527f4172624578656375746f723a206e6f7468696e6720746f2072657061790000006044820152606401610188565b60008a8a6000818110610761576107616113f3565b90506020020160208101906107769190611409565b6040516370a0823160e01b81523060048201526001600160a01b0391909116906370a0823190602401602060405180830381865afa1580156107bc573d6000803e3d6000fd5b505050506040513d601f19601f820116820180604052508101906107e0919061142d565b90506108498d8d60008181106107f8576107f86113f3565b905060200201602081019061080d9190611409565b8c8c6000818110610820576108206113f3565b90506020020160208101906108359190611409565b87610844366004816000611472565b610f8f565b60008b8b600081811061085e5761085e6113f3565b90506020020160208101906108739190611409565b6040516370a0823160e01b81523060048201526001600160a01b0391909116906370a0823190602401602060405180830381865afa1580156108b9573d6000803e3d6000fd5b505050506040513d601f19601f820116820180604052508101906108dd919061142d565b90506108e984836113aa565b8110156109345760405162461bcd60e51b81526020600482015260196024820152784172624578656375746f723a20756e70726f66697461626c6560381b6044820152606401610188565b61093e828261145f565b9e9d5050505050505050505050505050565b336001600160a01b037f000000000000000000000000000000000000000000000000000000000000000016146109985760405162461bcd60e51b8152600401610188906113c3565b6109c3827f000000000000000000000000000000000000000000000000000000000000000083610bb1565b5050565b6000546001600160a01b0316331480156109e957506001600160a01b03841630145b610a355760405162461bcd60e51b815260206004820181905260248201527f4172624578656375746f723a20756e65787065637465642063616c6c6261636b6044820152606401610188565b600080808080610a47868801886115d6565b509550509450945094509450610a9284600181518110610a6957610a696113f3565b602002602001015186600181518110610a8457610a846113f3565b60200260200101518a610bb1565b60015b8551811015610b66578551600090610aae8360016113aa565b10610ab95730610ade565b86610ac58360016113aa565b81518110610ad557610ad56113f3565b60200260200101515b9050610b51878381518110610af557610af56113f3565b6020026020010151878481518110610b0f57610b0f6113f3565b6020026020010151878581518110610b2957610b296113f3565b6020026020010151878681518110610b4357610b436113f3565b602002602001015185610cc5565b50508080610b5e90611446565b915050610a95565b50610ba684600081518110610b7d57610b7d6113f3565b602002602001015186600081518110610b9857610b986113f3565b602002602001015183610bb1565b505050505050505050565b604080516001600160a01b038481166024830152604480830185905283518084039091018152606490920183526020820180516001600160e01b031663a9059cbb60e01b1790529151600092839290871691610c0d91906116c2565b6000604051808303816000865af19150503d8060008114610c4a576040519150601f19603f3d011682016040523d82523d6000602084013e610c4f565b606091505b5091509150818015610c79575080511580610c79575080806020019051810190610c7991906116de565b6101375760405162461bcd60e51b815260206004820152601c60248201527f4172624578656375746f723a207472616e73666572206661696c6564000000006044820152606401610188565b6000806000876001600160a01b0316630902f1ac6040518163ffffffff1660e01b8152600401606060405180830381865afa158015610d08573d6000803e3d6000fd5b505050506040513d601f19601f82011682018060405250810190610d2c919061171c565b506001600160701b031691506001600160701b031691506000876001600160a01b0316896001600160a01b0316630dfe16816040518163ffffffff1660e01b8152600401602060405180830381865afa158015610d8d573d6000803e3d6000fd5b505050506040513d601f19601f82011682018060405250810190610db1919061176c565b6001600160a01b031614905060008082610dcc578385610dcf565b84845b6040516370a0823160e01b81526001600160a01b038e811660048301529294509092506000918491908d16906370a0823190602401602060405180830381865afa158015610e21573d6000803e3d6000fd5b505050506040513d601f19601f82011682018060405250810190610e45919061142d565b610e4f919061145f565b90506000610e5d8b83611789565b905080610e6c85612710611789565b610e7691906113aa565b610e808483611789565b610e8a91906117a0565b975089881015610ed45760405162461bcd60e51b81526020600482015260156024820152744172624578656375746f723a20736c69707061676560581b6044820152606401610188565b60008086610ee457896000610ee8565b60008a5b90925090506001600160a01b038f1663022c0d9f83838e60006040519080825280601f01601f191660200182016040528015610f2b576020820181803683370190505b506040518563ffffffff1660e01b8152600401610f4b94939291906117c2565b600060405180830381600087803b158015610f6557600080fd5b505af1158015610f79573d6000803e3d6000fd5b5050505050505050505050505095945050505050565b6000846001600160a01b0316866001600160a01b0316630dfe16816040518163ffffffff1660e01b8152600401602060405180830381865afa158015610fd9573d6000803e3d6000fd5b505050506040513d601f19601f82011682018060405250810190610ffd919061176c565b6001600160a01b0316149050600080826110195785600061101d565b6000865b600080546001600160a01b0319166001600160a01b038c1690811790915560405163022c0d9f60e01b81529294509092509063022c0d9f9061106b908590859030908b908b90600401611812565b600060405180830381600087803b15801561108557600080fd5b505af1158015611099573d6000803e3d6000fd5b5050600080546001600160a01b031916905550505050505050505050565b6001600160a01b03811681146110cc57600080fd5b50565b6000806000806000608086880312156110e757600080fd5b85356110f2816110b7565b94506020860135935060408601359250606086013567ffffffffffffffff8082111561111d57600080fd5b818801915088601f83011261113157600080fd5b81358181111561114057600080fd5b89602082850101111561115257600080fd5b9699959850939650602001949392505050565b60008083601f84011261117757600080fd5b50813567ffffffffffffffff81111561118f57600080fd5b6020830191508360208260051b85010111156111aa57600080fd5b9250929050565b60008060008060008060008060008060c08b8d0312156111d057600080fd5b8a3567ffffffffffffffff808211156111e857600080fd5b6111f48e838f01611165565b909c509a5060208d013591508082111561120d57600080fd5b6112198e838f01611165565b909a50985060408d013591508082111561123257600080fd5b61123e8e838f01611165565b909850965060608d013591508082111561125757600080fd5b506112648d828e01611165565b9b9e9a9d50989b979a969995989760808101359660a09091013595509350505050565b600080600080600080600080600080600060e08c8e0312156112a857600080fd5b67ffffffffffffffff808d3511156112bf57600080fd5b6112cc8e8e358f01611165565b909c509a5060208d01358110156112e257600080fd5b6112f28e60208f01358f01611165565b909a50985060408d013581101561130857600080fd5b6113188e60408f01358f01611165565b909850965060608d013581101561132e57600080fd5b5061133f8d60608e01358e01611165565b9b9e9a9d50989b979a969995989760808101359660a0820135965060c090910135945092505050565b6000806040838503121561137b57600080fd5b8235611386816110b7565b946020939093013593505050565b634e487b7160e01b600052601160045260246000fd5b808201808211156113bd576113bd611394565b92915050565b60208082526016908201527520b93122bc32b1baba37b91d103737ba1037bbb732b960511b604082015260600190565b634e487b7160e01b600052603260045260246000fd5b60006020828403121561141b57600080fd5b8135611426816110b7565b9392505050565b60006020828403121561143f57600080fd5b5051919050565b60006001820161145857611458611394565b5060010190565b818103818111156113bd576113bd611394565b6000808585111561148257600080fd5b8386111561148f57600080fd5b5050820193919092039150565b634e487b7160e01b600052604160045260246000fd5b604051601f8201601f1916810167ffffffffffffffff811182821017156114db576114db61149c565b604052919050565b600067ffffffffffffffff8211156114fd576114fd61149c565b5060051b60200190565b600082601f83011261151857600080fd5b8135602061152d611528836114e3565b6114b2565b82815260059290921b8401810191818101908684111561154c57600080fd5b8286015b84811015611570578035611563816110b7565b8352918301918301611550565b509695505050505050565b600082601f83011261158c57600080fd5b8135602061159c611528836114e3565b82815260059290921b840181019181810190868411156115bb57600080fd5b8286015b8481101561157057803583529183019183016115bf565b600080600080600080600060e0888a0312156115f157600080fd5b873567ffffffffffffffff8082111561160957600080fd5b6116158b838c01611507565b985060208a013591508082111561162b57600080fd5b6116378b838c01611507565b975060408a013591508082111561164d57600080fd5b6116598b838c0161157b565b965060608a013591508082111561166f57600080fd5b5061167c8a828b0161157b565b979a969950949760808101359660a0820135965060c090910135945092505050565b60005b838110156116b95781810151838201526020016116a1565b50506000910152565b600082516116d481846020870161169e565b9190910192915050565b6000602082840312156116f057600080fd5b8151801515811461142657600080fd5b80516001600160701b038116811461171757600080fd5b919050565b60008060006060848603121561173157600080fd5b61173a84611700565b925061174860208501611700565b9150604084015163ffffffff8116811461176157600080fd5b809150509250925092565b60006020828403121561177e57600080fd5b8151611426816110b7565b80820281158282048414176113bd576113bd611394565b6000826117bd57634e487b7160e01b600052601260045260246000fd5b500490565b84815283602082015260018060a01b038316604082015260806060820152600082518060808401526117fb8160a085016020870161169e565b601f01601f19169190910160a00195945050505050565b858152602081018590526001600160a01b03841660408201526080606082018190528101829052818360a0830137600081830160a090810191909152601f909201601f1916010194935050505056fea26469706673582212209066d49637d38fc02145e6369ab113a1486223fa05151170f9e7c12cd26d401964736f6c63430008150033",
+}
+
+// ArbExecutorABI is the input ABI used to generate the binding from.
+// Deprecated: Use ArbExecutorMetaData.ABI instead.
+var ArbExecutorABI = ArbExecutorMetaData.ABI
+
+// ArbExecutorBin is the compiled bytecode used for deploying new contracts.
+// Deprecated: Use ArbExecutorMetaData.Bin instead.
+var ArbExecutorBin = ArbExecutorMetaData.Bin
+
+// DeployArbExecutor deploys a new Ethereum contract, binding an instance of ArbExecutor to it.
+func DeployArbExecutor(auth *bind.TransactOpts, backend bind.ContractBackend) (common.Address, *types.Transaction, *ArbExecutor, error) {
+	parsed, err := ArbExecutorMetaData.GetAbi()
+	if err != nil {
+		return common.Address{}, nil, nil, err
+	}
+	if parsed == nil {
+		return common.Address{}, nil, nil, errors.New("GetABI returned nil")
+	}
+
+	address, tx, contract, err := bind.DeployContract(auth, *parsed, common.FromHex(ArbExecutorBin), backend)
+	if err != nil {
+		return common.Address{}, nil, nil, err
+	}
+	return address, tx, &ArbExecutor{ArbExecutorCaller: ArbExecutorCaller{contract: contract}, ArbExecutorTransactor: ArbExecutorTransactor{contract: contract}, ArbExecutorFilterer: ArbExecutorFilterer{contract: contract}}, nil
+}
+
+// ArbExecutor is an auto generated Go binding around an Ethereum contract.
+type ArbExecutor struct {
+	ArbExecutorCaller     // Read-only binding to the contract
+	ArbExecutorTransactor // Write-only binding to the contract
+	ArbExecutorFilterer   // Log filterer for contract events
+}
+
+// ArbExecutorCaller is an auto generated read-only Go binding around an Ethereum contract.
+type ArbExecutorCaller struct {
+	contract *bind.BoundContract // Generic contract wrapper for the low level calls
+}
+
+// ArbExecutorTransactor is an auto generated write-only Go binding around an Ethereum contract.
+type ArbExecutorTransactor struct {
+	contract *bind.BoundContract // Generic contract wrapper for the low level calls
+}
+
+// ArbExecutorFilterer is an auto generated log filtering Go binding around an Ethereum contract events.
+type ArbExecutorFilterer struct {
+	contract *bind.BoundContract // Generic contract wrapper for the low level calls
+}
+
+// ArbExecutorSession is an auto generated Go binding around an Ethereum contract,
+// with pre-set call and transact options.
+type ArbExecutorSession struct {
+	Contract     *ArbExecutor      // Generic contract binding to set the session for
+	CallOpts     bind.CallOpts     // Call options to use throughout this session
+	TransactOpts bind.TransactOpts // Transaction auth options to use throughout this session
+}
+
+// ArbExecutorCallerSession is an auto generated read-only Go binding around an Ethereum contract,
+// with pre-set call options.
+type ArbExecutorCallerSession struct {
+	Contract *ArbExecutorCaller // Generic contract caller binding to set the session for
+	CallOpts bind.CallOpts      // Call options to use throughout this session
+}
+
+// ArbExecutorTransactorSession is an auto generated write-only Go binding around an Ethereum contract,
+// with pre-set transact options.
+type ArbExecutorTransactorSession struct {
+	Contract     *ArbExecutorTransactor // Generic contract transactor binding to set the session for
+	TransactOpts bind.TransactOpts      // Transaction auth options to use throughout this session
+}
+
+// ArbExecutorRaw is an auto generated low-level Go binding around an Ethereum contract.
+type ArbExecutorRaw struct {
+	Contract *ArbExecutor // Generic contract binding to access the raw methods on
+}
+
+// ArbExecutorCallerRaw is an auto generated low-level read-only Go binding around an Ethereum contract.
+type ArbExecutorCallerRaw struct {
+	Contract *ArbExecutorCaller // Generic read-only contract binding to access the raw methods on
+}
+
+// ArbExecutorTransactorRaw is an auto generated low-level write-only Go binding around an Ethereum contract.
+type ArbExecutorTransactorRaw struct {
+	Contract *ArbExecutorTransactor // Generic write-only contract binding to access the raw methods on
+}
+
+// NewArbExecutor creates a new instance of ArbExecutor, bound to a specific deployed contract.
+func NewArbExecutor(address common.Address, backend bind.ContractBackend) (*ArbExecutor, error) {
+	contract, err := bindArbExecutor(address, backend, backend, backend)
+	if err != nil {
+		return nil, err
+	}
+	return &ArbExecutor{ArbExecutorCaller: ArbExecutorCaller{contract: contract}, ArbExecutorTransactor: ArbExecutorTransactor{contract: contract}, ArbExecutorFilterer: ArbExecutorFilterer{contract: contract}}, nil
+}
+
+// NewArbExecutorCaller creates a new read-only instance of ArbExecutor, bound to a specific deployed contract.
+func NewArbExecutorCaller(address common.Address, caller bind.ContractCaller) (*ArbExecutorCaller, error) {
+	contract, err := bindArbExecutor(address, caller, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &ArbExecutorCaller{contract: contract}, nil
+}
+
+// NewArbExecutorTransactor creates a new write-only instance of ArbExecutor, bound to a specific deployed contract.
+func NewArbExecutorTransactor(address common.Address, transactor bind.ContractTransactor) (*ArbExecutorTransactor, error) {
+	contract, err := bindArbExecutor(address, nil, transactor, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &ArbExecutorTransactor{contract: contract}, nil
+}
+
+// NewArbExecutorFilterer creates a new log filterer instance of ArbExecutor, bound to a specific deployed contract.
+func NewArbExecutorFilterer(address common.Address, filterer bind.ContractFilterer) (*ArbExecutorFilterer, error) {
+	contract, err := bindArbExecutor(address, nil, nil, filterer)
+	if err != nil {
+		return nil, err
+	}
+	return &ArbExecutorFilterer{contract: contract}, nil
+}
+
+// bindArbExecutor binds a generic wrapper to an already deployed contract.
+func bindArbExecutor(address common.Address, caller bind.ContractCaller, transactor bind.ContractTransactor, filterer bind.ContractFilterer) (*bind.BoundContract, error) {
+	parsed, err := abi.JSON(strings.NewReader(ArbExecutorABI))
+	if err != nil {
+		return nil, err
+	}
+	return bind.NewBoundContract(address, parsed, caller, transactor, filterer), nil
+}
+
+// Call invokes the (constant) contract method with params as input values and
+// sets the output to result. The result type might be a single field for simple
+// returns, a slice of interfaces for anonymous returns and a struct for named
+// returns.
+func (_ArbExecutor *ArbExecutorRaw) Call(opts *bind.CallOpts, result *[]interface{}, method string, params ...interface{}) error {
+	return _ArbExecutor.Contract.ArbExecutorCaller.contract.Call(opts, result, method, params...)
+}
+
+// Transfer initiates a plain transaction to move funds to the contract, calling
+// its default method if one is available.
+func (_ArbExecutor *ArbExecutorRaw) Transfer(opts *bind.TransactOpts) (*types.Transaction, error) {
+	return _ArbExecutor.Contract.ArbExecutorTransactor.contract.Transfer(opts)
+}
+
+// Transact invokes the (paid) contract method with params as input values.
+func (_ArbExecutor *ArbExecutorRaw) Transact(opts *bind.TransactOpts, method string, params ...interface{}) (*types.Transaction, error) {
+	return _ArbExecutor.Contract.ArbExecutorTransactor.contract.Transact(opts, method, params...)
+}
+
+// Call invokes the (constant) contract method with params as input values and
+// sets the output to result. The result type might be a single field for simple
+// returns, a slice of interfaces for anonymous returns and a struct for named
+// returns.
+func (_ArbExecutor *ArbExecutorCallerRaw) Call(opts *bind.CallOpts, result *[]interface{}, method string, params ...interface{}) error {
+	return _ArbExecutor.Contract.contract.Call(opts, result, method, params...)
+}
+
+// Transfer initiates a plain transaction to move funds to the contract, calling
+// its default method if one is available.
+func (_ArbExecutor *ArbExecutorTransactorRaw) Transfer(opts *bind.TransactOpts) (*types.Transaction, error) {
+	return _ArbExecutor.Contract.contract.Transfer(opts)
+}
+
+// Transact invokes the (paid) contract method with params as input values.
+func (_ArbExecutor *ArbExecutorTransactorRaw) Transact(opts *bind.TransactOpts, method string, params ...interface{}) (*types.Transaction, error) {
+	return _ArbExecutor.Contract.contract.Transact(opts, method, params...)
+}
+
+// Owner is a free data retrieval call binding the contract method 0x8da5cb5b.
+//
+// Solidity: function owner() view returns(address)
+func (_ArbExecutor *ArbExecutorCaller) Owner(opts *bind.CallOpts) (common.Address, error) {
+	var out []interface{}
+	err := _ArbExecutor.contract.Call(opts, &out, "owner")
+
+	if err != nil {
+		return *new(common.Address), err
+	}
+
+	out0 := *abi.ConvertType(out[0], new(common.Address)).(*common.Address)
+
+	return out0, err
+
+}
+
+// Owner is a free data retrieval call binding the contract method 0x8da5cb5b.
+//
+// Solidity: function owner() view returns(address)
+func (_ArbExecutor *ArbExecutorSession) Owner() (common.Address, error) {
+	return _ArbExecutor.Contract.Owner(&_ArbExecutor.CallOpts)
+}
+
+// Owner is a free data retrieval call binding the contract method 0x8da5cb5b.
+//
+// Solidity: function owner() view returns(address)
+func (_ArbExecutor *ArbExecutorCallerSession) Owner() (common.Address, error) {
+	return _ArbExecutor.Contract.Owner(&_ArbExecutor.CallOpts)
+}
+
+// BiswapCall is a paid mutator transaction binding the contract method 0x5b3bc4fe.
+//
+// Solidity: function BiswapCall(address sender, uint256 amount0, uint256 amount1, bytes data) returns()
+func (_ArbExecutor *ArbExecutorTransactor) BiswapCall(opts *bind.TransactOpts, sender common.Address, amount0 *big.Int, amount1 *big.Int, data []byte) (*types.Transaction, error) {
+	return _ArbExecutor.contract.Transact(opts, "BiswapCall", sender, amount0, amount1, data)
+}
+
+// BiswapCall is a paid mutator transaction binding the contract method 0x5b3bc4fe.
+//
+// Solidity: function BiswapCall(address sender, uint256 amount0, uint256 amount1, bytes data) returns()
+func (_ArbExecutor *ArbExecutorSession) BiswapCall(sender common.Address, amount0 *big.Int, amount1 *big.Int, data []byte) (*types.Transaction, error) {
+	return _ArbExecutor.Contract.BiswapCall(&_ArbExecutor.TransactOpts, sender, amount0, amount1, data)
+}
+
+// BiswapCall is a paid mutator transaction binding the contract method 0x5b3bc4fe.
+//
+// Solidity: function BiswapCall(address sender, uint256 amount0, uint256 amount1, bytes data) returns()
+func (_ArbExecutor *ArbExecutorTransactorSession) BiswapCall(sender common.Address, amount0 *big.Int, amount1 *big.Int, data []byte) (*types.Transaction, error) {
+	return _ArbExecutor.Contract.BiswapCall(&_ArbExecutor.TransactOpts, sender, amount0, amount1, data)
+}
+
+// Execute is a paid mutator transaction binding the contract method 0xc4178724.
+//
+// Solidity: function execute(address[] pools, address[] tokens, uint256[] fees, uint256[] minOuts, uint256 amountIn, uint256 minProfit) returns(uint256 profit)
+func (_ArbExecutor *ArbExecutorTransactor) Execute(opts *bind.TransactOpts, pools []common.Address, tokens []common.Address, fees []*big.Int, minOuts []*big.Int, amountIn *big.Int, minProfit *big.Int) (*types.Transaction, error) {
+	return _ArbExecutor.contract.Transact(opts, "execute", pools, tokens, fees, minOuts, amountIn, minProfit)
+}
+
+// Execute is a paid mutator transaction binding the contract method 0xc4178724.
+//
+// Solidity: function execute(address[] pools, address[] tokens, uint256[] fees, uint256[] minOuts, uint256 amountIn, uint256 minProfit) returns(uint256 profit)
+func (_ArbExecutor *ArbExecutorSession) Execute(pools []common.Address, tokens []common.Address, fees []*big.Int, minOuts []*big.Int, amountIn *big.Int, minProfit *big.Int) (*types.Transaction, error) {
+	return _ArbExecutor.Contract.Execute(&_ArbExecutor.TransactOpts, pools, tokens, fees, minOuts, amountIn, minProfit)
+}
+
+// Execute is a paid mutator transaction binding the contract method 0xc4178724.
+//
+// Solidity: function execute(address[] pools, address[] tokens, uint256[] fees, uint256[] minOuts, uint256 amountIn, uint256 minProfit) returns(uint256 profit)
+func (_ArbExecutor *ArbExecutorTransactorSession) Execute(pools []common.Address, tokens []common.Address, fees []*big.Int, minOuts []*big.Int, amountIn *big.Int, minProfit *big.Int) (*types.Transaction, error) {
+	return _ArbExecutor.Contract.Execute(&_ArbExecutor.TransactOpts, pools, tokens, fees, minOuts, amountIn, minProfit)
+}
+
+// ExecuteFlash is a paid mutator transaction binding the contract method 0xdaacdde0.
+//
+// Solidity: function executeFlash(address[] pools, address[] tokens, uint256[] fees, uint256[] minOuts, uint256 borrowAmount, uint256 repayAmount, uint256 minProfit) returns(uint256 profit)
+func (_ArbExecutor *ArbExecutorTransactor) ExecuteFlash(opts *bind.TransactOpts, pools []common.Address, tokens []common.Address, fees []*big.Int, minOuts []*big.Int, borrowAmount *big.Int, repayAmount *big.Int, minProfit *big.Int) (*types.Transaction, error) {
+	return _ArbExecutor.contract.Transact(opts, "executeFlash", pools, tokens, fees, minOuts, borrowAmount, repayAmount, minProfit)
+}
+
+// ExecuteFlash is a paid mutator transaction binding the contract method 0xdaacdde0.
+//
+// Solidity: function executeFlash(address[] pools, address[] tokens, uint256[] fees, uint256[] minOuts, uint256 borrowAmount, uint256 repayAmount, uint256 minProfit) returns(uint256 profit)
+func (_ArbExecutor *ArbExecutorSession) ExecuteFlash(pools []common.Address, tokens []common.Address, fees []*big.Int, minOuts []*big.Int, borrowAmount *big.Int, repayAmount *big.Int, minProfit *big.Int) (*types.Transaction, error) {
+	return _ArbExecutor.Contract.ExecuteFlash(&_ArbExecutor.TransactOpts, pools, tokens, fees, minOuts, borrowAmount, repayAmount, minProfit)
+}
+
+// ExecuteFlash is a paid mutator transaction binding the contract method 0xdaacdde0.
+//
+// Solidity: function executeFlash(address[] pools, address[] tokens, uint256[] fees, uint256[] minOuts, uint256 borrowAmount, uint256 repayAmount, uint256 minProfit) returns(uint256 profit)
+func (_ArbExecutor *ArbExecutorTransactorSession) ExecuteFlash(pools []common.Address, tokens []common.Address, fees []*big.Int, minOuts []*big.Int, borrowAmount *big.Int, repayAmount *big.Int, minProfit *big.Int) (*types.Transaction, error) {
+	return _ArbExecutor.Contract.ExecuteFlash(&_ArbExecutor.TransactOpts, pools, tokens, fees, minOuts, borrowAmount, repayAmount, minProfit)
+}
+
+// PancakeCall is a paid mutator transaction binding the contract method 0x84800812.
+//
+// Solidity: function pancakeCall(address sender, uint256 amount0, uint256 amount1, bytes data) returns()
+func (_ArbExecutor *ArbExecutorTransactor) PancakeCall(opts *bind.TransactOpts, sender common.Address, amount0 *big.Int, amount1 *big.Int, data []byte) (*types.Transaction, error) {
+	return _ArbExecutor.contract.Transact(opts, "pancakeCall", sender, amount0, amount1, data)
+}
+
+// PancakeCall is a paid mutator transaction binding the contract method 0x84800812.
+//
+// Solidity: function pancakeCall(address sender, uint256 amount0, uint256 amount1, bytes data) returns()
+func (_ArbExecutor *ArbExecutorSession) PancakeCall(sender common.Address, amount0 *big.Int, amount1 *big.Int, data []byte) (*types.Transaction, error) {
+	return _ArbExecutor.Contract.PancakeCall(&_ArbExecutor.TransactOpts, sender, amount0, amount1, data)
+}
+
+// PancakeCall is a paid mutator transaction binding the contract method 0x84800812.
+//
+// Solidity: function pancakeCall(address sender, uint256 amount0, uint256 amount1, bytes data) returns()
+func (_ArbExecutor *ArbExecutorTransactorSession) PancakeCall(sender common.Address, amount0 *big.Int, amount1 *big.Int, data []byte) (*types.Transaction, error) {
+	return _ArbExecutor.Contract.PancakeCall(&_ArbExecutor.TransactOpts, sender, amount0, amount1, data)
+}
+
+// UniswapV2Call is a paid mutator transaction binding the contract method 0x10d1e85c.
+//
+// Solidity: function uniswapV2Call(address sender, uint256 amount0, uint256 amount1, bytes data) returns()
+func (_ArbExecutor *ArbExecutorTransactor) UniswapV2Call(opts *bind.TransactOpts, sender common.Address, amount0 *big.Int, amount1 *big.Int, data []byte) (*types.Transaction, error) {
+	return _ArbExecutor.contract.Transact(opts, "uniswapV2Call", sender, amount0, amount1, data)
+}
+
+// UniswapV2Call is a paid mutator transaction binding the contract method 0x10d1e85c.
+//
+// Solidity: function uniswapV2Call(address sender, uint256 amount0, uint256 amount1, bytes data) returns()
+func (_ArbExecutor *ArbExecutorSession) UniswapV2Call(sender common.Address, amount0 *big.Int, amount1 *big.Int, data []byte) (*types.Transaction, error) {
+	return _ArbExecutor.Contract.UniswapV2Call(&_ArbExecutor.TransactOpts, sender, amount0, amount1, data)
+}
+
+// UniswapV2Call is a paid mutator transaction binding the contract method 0x10d1e85c.
+//
+// Solidity: function uniswapV2Call(address sender, uint256 amount0, uint256 amount1, bytes data) returns()
+func (_ArbExecutor *ArbExecutorTransactorSession) UniswapV2Call(sender common.Address, amount0 *big.Int, amount1 *big.Int, data []byte) (*types.Transaction, error) {
+	return _ArbExecutor.Contract.UniswapV2Call(&_ArbExecutor.TransactOpts, sender, amount0, amount1, data)
+}
+
+// Withdraw is a paid mutator transaction binding the contract method 0xf3fef3a3.
+//
+// Solidity: function withdraw(address token, uint256 amount) returns()
+func (_ArbExecutor *ArbExecutorTransactor) Withdraw(opts *bind.TransactOpts, token common.Address, amount *big.Int) (*types.Transaction, error) {
+	return _ArbExecutor.contract.Transact(opts, "withdraw", token, amount)
+}
+
+// Withdraw is a paid mutator transaction binding the contract method 0xf3fef3a3.
+//
+// Solidity: function withdraw(address token, uint256 amount) returns()
+func (_ArbExecutor *ArbExecutorSession) Withdraw(token common.Address, amount *big.Int) (*types.Transaction, error) {
+	return _ArbExecutor.Contract.Withdraw(&_ArbExecutor.TransactOpts, token, amount)
+}
+
+// Withdraw is a paid mutator transaction binding the contract method 0xf3fef3a3.
+//
+// Solidity: function withdraw(address token, uint256 amount) returns()
+func (_ArbExecutor *ArbExecutorTransactorSession) Withdraw(token common.Address, amount *big.Int) (*types.Transaction, error) {
+	return _ArbExecutor.Contract.Withdraw(&_ArbExecutor.TransactOpts, token, amount)
+}
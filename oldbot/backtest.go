@@ -0,0 +1,359 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"example.com/m/pancakePair"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// BacktestConfig says where backtest data lives and which blocks to fetch.
+type BacktestConfig struct {
+	// Data is the directory `backtest fetch` writes and `backtest run`
+	// replays. Nothing else is needed to replay it.
+	Data string
+	// FromBlock and ToBlock are the fetched range. Zero ToBlock is the
+	// head less backtestConfirmations.
+	FromBlock   uint64
+	ToBlock     uint64
+	BatchBlocks uint64
+	// Report is where the run's JSON report is written, empty for none.
+	Report string
+}
+
+// backtestConfirmations keeps the fetched range clear of blocks that may
+// still be reorganized.
+const backtestConfirmations = 15
+
+// Files of a backtest data directory.
+const (
+	backtestPairs  = "pairs.json"
+	backtestTokens = "tokens.json"
+	backtestStart  = "start.json"
+	// backtestEvents is an EventStore of the pairs' logs
+	backtestEvents = "events"
+)
+
+// backtestStartState is the range fetched and every pool's reserves just
+// before it. Pools whose reserves could not be read start at their first
+// Sync.
+type backtestStartState struct {
+	FromBlock uint64
+	ToBlock   uint64
+	Reserves  map[common.Address][2]*big.Int
+}
+
+// fetchBacktest stores the market's pairs, tokens, starting reserves and,
+// in an EventStore, every Sync and Swap log of its pairs in the configured
+// range.
+func fetchBacktest(ctx context.Context, market *ChainMarket, config BacktestConfig) error {
+	if err := os.MkdirAll(config.Data, 0755); err != nil {
+		return err
+	}
+	to := config.ToBlock
+	if to == 0 {
+		head, err := market.client.BlockNumber(ctx)
+		if err != nil {
+			return err
+		}
+		to = head - backtestConfirmations
+	}
+	from := config.FromBlock
+	if from == 0 || from > to {
+		return fmt.Errorf("backtest: invalid block range %d-%d", from, to)
+	}
+	batch := config.BatchBlocks
+	if batch == 0 {
+		batch = 5000
+	}
+
+	if err := writeJSON(filepath.Join(config.Data, backtestPairs), market.pairs); err != nil {
+		return err
+	}
+	if err := market.tokens.saveAs(filepath.Join(config.Data, backtestTokens)); err != nil {
+		return err
+	}
+	start := backtestStartState{FromBlock: from, ToBlock: to, Reserves: make(map[common.Address][2]*big.Int)}
+	opts := &bind.CallOpts{Context: ctx, BlockNumber: new(big.Int).SetUint64(from - 1)}
+	for _, pair := range market.pairs {
+		contract, err := pancakePair.NewPancakePairCaller(pair.Factory, market.client)
+		if err != nil {
+			return err
+		}
+		reserves, err := contract.GetReserves(opts)
+		if err != nil {
+			// Pruned nodes cannot serve old state; the pool starts at its
+			// first Sync instead.
+			continue
+		}
+		start.Reserves[pair.Factory] = [2]*big.Int{reserves.Reserve0, reserves.Reserve1}
+	}
+	log.Info("Read starting reserves", "pools", len(start.Reserves), "pairs", len(market.pairs), "block", from-1)
+	if err := writeJSON(filepath.Join(config.Data, backtestStart), start); err != nil {
+		return err
+	}
+
+	store, err := OpenEventStore(filepath.Join(config.Data, backtestEvents))
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	pools := make([]common.Address, len(market.pairs))
+	for i, pair := range market.pairs {
+		pools[i] = pair.Factory
+	}
+	// A fetch cut short resumes where it stopped, and pairs added since
+	// get the blocks already stored
+	if err := store.Backfill(ctx, market.client, pools, nil, from, to, batch); err != nil {
+		return err
+	}
+	log.Info("Stored backtest events", "from", from, "to", to, "dir", config.Data)
+	return nil
+}
+
+// BacktestOpportunity is a loop found while replaying, counted in the
+// block it first appeared. A loop nobody takes stays open over many
+// blocks; it is only counted again after it has closed.
+type BacktestOpportunity struct {
+	Block    uint64
+	Path     []string
+	Pools    []common.Address
+	Source   common.Address
+	AmountIn *big.Int
+	Profit   *big.Int
+	// Return is the loop's price product less one.
+	Return float64
+}
+
+// BacktestReport is what a replay found.
+type BacktestReport struct {
+	FromBlock     uint64
+	ToBlock       uint64
+	Blocks        int
+	Syncs         int
+	Swaps         int
+	Opportunities []BacktestOpportunity
+	// Profit totals theoretical profit by source token, formatted.
+	Profit map[string]string
+}
+
+// runBacktest replays the events in config.Data block by block through the
+// graph and optimizer, with no node at all.
+func runBacktest(config BacktestConfig, sources []common.Address) (*BacktestReport, error) {
+	var pairs []PairIn
+	if err := readJSON(filepath.Join(config.Data, backtestPairs), &pairs); err != nil {
+		return nil, err
+	}
+	for _, pair := range pairs {
+		if pair.Fee == 0 {
+			return nil, fmt.Errorf("backtest: pair %s has no fee, fetch %s again", pair.Factory.Hex(), config.Data)
+		}
+	}
+	var start backtestStartState
+	if err := readJSON(filepath.Join(config.Data, backtestStart), &start); err != nil {
+		return nil, err
+	}
+	tokens, err := NewTokenRegistry(nil, filepath.Join(config.Data, backtestTokens))
+	if err != nil {
+		return nil, err
+	}
+	path := filepath.Join(config.Data, backtestEvents)
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("backtest: %s is missing, run backtest fetch first", path)
+	}
+	store, err := OpenEventStore(path)
+	if err != nil {
+		return nil, err
+	}
+	defer store.Close()
+	addresses := make([]common.Address, len(pairs))
+	for i, pair := range pairs {
+		addresses[i] = pair.Factory
+	}
+	gaps, err := store.Gaps(addresses, start.FromBlock, start.ToBlock)
+	if err != nil {
+		return nil, err
+	}
+	if len(gaps) > 0 {
+		return nil, fmt.Errorf("backtest: blocks %v of %s were not fetched, run backtest fetch again", gaps, config.Data)
+	}
+
+	pools := make([]PoolState, len(pairs))
+	byAddress := make(map[common.Address]*PoolState, len(pairs))
+	for i, pair := range pairs {
+		pools[i].in = pair
+		if reserves, ok := start.Reserves[pair.Factory]; ok {
+			pools[i].reserve0.Set(reserves[0])
+			pools[i].reserve1.Set(reserves[1])
+		}
+		byAddress[pair.Factory] = &pools[i]
+	}
+
+	report := &BacktestReport{FromBlock: start.FromBlock, ToBlock: start.ToBlock, Opportunities: []BacktestOpportunity{}}
+	totals := make(map[common.Address]*big.Int)
+	open := make(map[string]bool)
+	replay := func(block uint64) {
+		market := buildMarket(pools, tokens)
+		market.sources = sources
+		seen := make(map[string]bool)
+		for _, opp := range findOpportunities(market) {
+			key := loopKey(opp.pairs)
+			seen[key] = true
+			if open[key] {
+				continue
+			}
+			source := opp.pairs[0].from
+			found := BacktestOpportunity{
+				Block:    block,
+				Source:   source,
+				AmountIn: new(big.Int).Set(&opp.amountIn),
+				Profit:   new(big.Int).Set(&opp.profit),
+				Return:   opp.value - 1,
+			}
+			found.Path = append(found.Path, opp.pairs[0].from_symbol)
+			for _, pair := range opp.pairs {
+				found.Path = append(found.Path, pair.to_symbol)
+				found.Pools = append(found.Pools, pair.factory)
+			}
+			report.Opportunities = append(report.Opportunities, found)
+			if totals[source] == nil {
+				totals[source] = new(big.Int)
+			}
+			totals[source].Add(totals[source], found.Profit)
+		}
+		open = seen
+	}
+
+	block, changed := uint64(0), false
+	err = store.Each(start.FromBlock, start.ToBlock, func(e PoolEvent) error {
+		if e.Block != block {
+			if changed {
+				replay(block)
+				report.Blocks++
+			}
+			block, changed = e.Block, false
+		}
+		pool, ok := byAddress[e.Pool]
+		if !ok {
+			return nil
+		}
+		switch e.Kind {
+		case eventSync:
+			pool.reserve0.Set(e.Reserve0)
+			pool.reserve1.Set(e.Reserve1)
+			report.Syncs++
+			changed = true
+		case eventSwap:
+			report.Swaps++
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if changed {
+		replay(block)
+		report.Blocks++
+	}
+
+	report.Profit = make(map[string]string, len(totals))
+	for source, total := range totals {
+		report.Profit[tokens.Symbol(source, source.Hex())] = tokens.Format(source, total)
+	}
+	if config.Report != "" {
+		if err := writeJSON(config.Report, report); err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
+
+// backtestCommand runs `backtest fetch`, which needs the chain, or
+// `backtest run`, which needs only the fetched data.
+func backtestCommand(ctx context.Context, profile ChainProfile, config Config, sub string) error {
+	switch sub {
+	case "fetch":
+		market, err := newChainMarket(ctx, profile, config.RPC, config.Market, config.DEX, config.Sources)
+		if err != nil {
+			return err
+		}
+		return fetchBacktest(ctx, market, config.Backtest)
+	case "run":
+		report, err := runBacktest(config.Backtest, config.Sources)
+		if err != nil {
+			return err
+		}
+		printBacktest(report)
+		return nil
+	default:
+		return fmt.Errorf("backtest: want fetch or run, got %q", sub)
+	}
+}
+
+// printBacktest prints the report's totals and its most profitable loops.
+func printBacktest(report *BacktestReport) {
+	fmt.Println("Replayed ", report.Blocks, "blocks of", report.FromBlock, "to", report.ToBlock, "with", report.Syncs, "syncs and", report.Swaps, "swaps")
+	fmt.Println("Opportunities: ", len(report.Opportunities))
+	symbols := make([]string, 0, len(report.Profit))
+	for symbol := range report.Profit {
+		symbols = append(symbols, symbol)
+	}
+	sort.Strings(symbols)
+	for _, symbol := range symbols {
+		fmt.Println("Theoretical profit in ", symbol, ": ", report.Profit[symbol])
+	}
+	top := append([]BacktestOpportunity{}, report.Opportunities...)
+	sort.Slice(top, func(i, j int) bool { return top[i].Return > top[j].Return })
+	if len(top) > 10 {
+		top = top[:10]
+	}
+	for _, opp := range top {
+		fmt.Printf("Block %d: %s return %.4f%% profit %s\n", opp.Block, strings.Join(opp.Path, " -> "), opp.Return*100, opp.Profit)
+	}
+}
+
+// loopKey identifies a loop by the pools it trades through.
+func loopKey(pairs []Pair) string {
+	pools := make([]string, len(pairs))
+	for i, pair := range pairs {
+		pools[i] = pair.factory.Hex()
+	}
+	return strings.Join(pools, ",")
+}
+
+// writeJSON writes v to path atomically.
+func writeJSON(path string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func readJSON(path string, v interface{}) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("backtest: %s is missing, run backtest fetch first", path)
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrDecode, path, err)
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"math/big"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestRunBacktestReplaysStore(t *testing.T) {
+	dir := t.TempDir()
+	unit := new(big.Int).Exp(big.NewInt(10), big.NewInt(18), nil)
+	whole := func(n int64) *big.Int { return new(big.Int).Mul(big.NewInt(n), unit) }
+
+	pairs := []PairIn{}
+	start := backtestStartState{FromBlock: 5, ToBlock: 20, Reserves: map[common.Address][2]*big.Int{}}
+	tokens := []TokenInfo{}
+	for _, loop := range [][2]int{{1, 2}, {2, 3}, {1, 3}} {
+		pair := testPair(loop[0], loop[1], 100, 100, 25)
+		pairs = append(pairs, PairIn{From: pair.from, From_symbol: pair.from_symbol, To: pair.to, To_symbol: pair.to_symbol, Factory: pair.factory, Fee: 25})
+		start.Reserves[pair.factory] = [2]*big.Int{whole(100), whole(100)}
+		tokens = append(tokens, TokenInfo{Address: testToken(loop[0]), Symbol: pair.from_symbol, Decimals: 18})
+	}
+	tokens = append(tokens, TokenInfo{Address: testToken(3), Symbol: "T3", Decimals: 18})
+	for name, v := range map[string]interface{}{backtestPairs: pairs, backtestStart: start, backtestTokens: tokens} {
+		if err := writeJSON(filepath.Join(dir, name), v); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	store, err := OpenEventStore(filepath.Join(dir, backtestEvents))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// T2 gets cheap in its pool with T1 at block 10, so T1 -> T2 -> T3 -> T1 pays
+	pool := pairs[0].Factory
+	batch := store.db.NewBatch()
+	for _, e := range []*PoolEvent{
+		{Kind: eventSwap, Pool: pool, Block: 10, Index: 0, Amount0In: whole(0), Amount1In: whole(30)},
+		{Kind: eventSync, Pool: pool, Block: 10, Index: 1, Reserve0: whole(100), Reserve1: whole(130)},
+	} {
+		if err := store.put(batch, e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, pair := range pairs {
+		if err := store.putRanges(batch, storeKey(ingestedPrefix, pair.Factory.Bytes()), [][2]uint64{{5, 20}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := batch.Write(); err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+
+	report, err := runBacktest(BacktestConfig{Data: dir}, []common.Address{testToken(1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Blocks != 1 || report.Syncs != 1 || report.Swaps != 1 {
+		t.Errorf("replayed %d blocks, %d syncs, %d swaps, want 1 of each", report.Blocks, report.Syncs, report.Swaps)
+	}
+	if len(report.Opportunities) != 1 {
+		t.Fatalf("%d opportunities, want 1", len(report.Opportunities))
+	}
+	opp := report.Opportunities[0]
+	if opp.Block != 10 || strings.Join(opp.Path, " ") != "T1 T2 T3 T1" || opp.Profit.Sign() <= 0 {
+		t.Errorf("found %s in block %d for %s", strings.Join(opp.Path, " -> "), opp.Block, opp.Profit)
+	}
+
+	// blocks the fetch never stored cannot be replayed as if quiet
+	start.ToBlock = 30
+	if err := writeJSON(filepath.Join(dir, backtestStart), start); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := runBacktest(BacktestConfig{Data: dir}, []common.Address{testToken(1)}); err == nil || !strings.Contains(err.Error(), "not fetched") {
+		t.Errorf("replayed a range with a gap: %v", err)
+	}
+}
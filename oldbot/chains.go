@@ -0,0 +1,393 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"sort"
+	"time"
+
+	"example.com/m/pancakePair"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// Gas models a chain can price transactions with.
+const (
+	gasLegacy  = "legacy"
+	gasEIP1559 = "eip1559"
+)
+
+// ChainProfile is what differs between the EVM chains the bot runs on. The
+// graph and the V2 math are the same everywhere.
+type ChainProfile struct {
+	Name    string
+	ChainID uint64
+	// Wrapped is the ERC20 wrapper of the native coin.
+	Wrapped common.Address
+	Stables []common.Address
+	DEXes   []DEXConfig
+	// BlockTime is the usual time between blocks.
+	BlockTime time.Duration
+	GasModel  string
+	// RPC, Pairs and Tokens are the defaults for the config's RPC.URL,
+	// Market.Pairs and Market.Tokens. Only BSC ships with a pairs file.
+	RPC    string
+	Pairs  string
+	Tokens string
+}
+
+// sources are the tokens loops start from on this chain.
+func (p ChainProfile) sources() []common.Address {
+	return append([]common.Address{p.Wrapped}, p.Stables...)
+}
+
+// blocksPer is how many blocks the chain usually makes in d.
+func (p ChainProfile) blocksPer(d time.Duration) uint64 {
+	return uint64(d / p.BlockTime)
+}
+
+var (
+	uniswapInitCodeHash   = common.HexToHash("0x96e8ac4277198ff8b6f785478aa9a39f403cb768dd02cbee326c3e7da348845f")
+	sushiswapInitCodeHash = common.HexToHash("0xe18a34eb0e04b04f7a0ac29a6e80748dca96319b42c54d679cb821dca90c6303")
+	biswapInitCodeHash    = common.HexToHash("0xfea293c909d87cd4153593f077b76bb7e94340200f4ee84211ae8e4f9bd7ffdf")
+)
+
+var chainProfiles = map[string]ChainProfile{
+	"bsc": {
+		Name:    "bsc",
+		ChainID: 56,
+		Wrapped: common.HexToAddress("0xbb4CdB9CBd36B01bD1cBaEBF2De08d9173bc095c"), // WBNB
+		Stables: []common.Address{
+			common.HexToAddress("0xe9e7CEA3DedcA5984780Bafc599bD69ADd087D56"), // BUSD
+			common.HexToAddress("0x55d398326f99059fF775485246999027B3197955"), // USDT
+		},
+		DEXes: []DEXConfig{
+			{Name: "pancake", Factory: pancakeFactoryAddress, Router: pancakeRouterAddress, InitCodeHash: pancakeInitCodeHash, Fee: 25},
+			{Name: "biswap", Factory: common.HexToAddress("0x858E3312ed3A876947EA49d572A7C42DE08af7EE"), InitCodeHash: biswapInitCodeHash, Fee: 10},
+		},
+		BlockTime: 3 * time.Second,
+		GasModel:  gasLegacy,
+		RPC:       "https://bsc-dataseed.binance.org/",
+		Pairs:     "./tokenPairs_final.json",
+		Tokens:    "./tokens.json",
+	},
+	"ethereum": {
+		Name:    "ethereum",
+		ChainID: 1,
+		Wrapped: common.HexToAddress("0xC02aaA39b223FE8D0A0e5C4F27eAD9083C756Cc2"), // WETH
+		Stables: []common.Address{
+			common.HexToAddress("0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48"), // USDC
+			common.HexToAddress("0xdAC17F958D2ee523a2206206994597C13D831ec7"), // USDT
+			common.HexToAddress("0x6B175474E89094C44Da98b954EedeAC495271d0F"), // DAI
+		},
+		DEXes: []DEXConfig{
+			{Name: "uniswap", Factory: common.HexToAddress("0x5C69bEe701ef814a2B6a3EDD4B1652CB9cc5aA6f"), Router: common.HexToAddress("0x7a250d5630B4cF539739dF2C5dAcb4c659F2488D"), InitCodeHash: uniswapInitCodeHash, Fee: 30},
+			{Name: "sushiswap", Factory: common.HexToAddress("0xC0AEe478e3658e2610c5F7A4A2E1777cE9e4f2Ac"), InitCodeHash: sushiswapInitCodeHash, Fee: 30},
+		},
+		BlockTime: 12 * time.Second,
+		GasModel:  gasEIP1559,
+		RPC:       "https://cloudflare-eth.com",
+		Tokens:    "./tokens-ethereum.json",
+	},
+	"polygon": {
+		Name:    "polygon",
+		ChainID: 137,
+		Wrapped: common.HexToAddress("0x0d500B1d8E8eF31E21C99d1Db9A6444d3ADf1270"), // WMATIC
+		Stables: []common.Address{
+			common.HexToAddress("0x2791Bca1f2de4661ED88A30C99A7a9449Aa84174"), // USDC
+			common.HexToAddress("0xc2132D05D31c914a87C6611C10748AEb04B58e8F"), // USDT
+			common.HexToAddress("0x8f3Cf7ad23Cd3CaDbD9735AFf958023239c6A063"), // DAI
+		},
+		DEXes: []DEXConfig{
+			{Name: "quickswap", Factory: common.HexToAddress("0x5757371414417b8C6CAad45bAeF941aBc7d3Ab32"), Router: common.HexToAddress("0xa5E0829CaCEd8fFDD4De3c43696c57F7D7A678ff"), InitCodeHash: uniswapInitCodeHash, Fee: 30},
+		},
+		BlockTime: 2 * time.Second,
+		GasModel:  gasEIP1559,
+		RPC:       "https://polygon-rpc.com",
+		Tokens:    "./tokens-polygon.json",
+	},
+	"arbitrum": {
+		Name:    "arbitrum",
+		ChainID: 42161,
+		Wrapped: common.HexToAddress("0x82aF49447D8a07e3bd95BD0d56f35241523fBab1"), // WETH
+		Stables: []common.Address{
+			common.HexToAddress("0xFF970A61A04b1cA14834A43f5dE4533eBDDB5CC8"), // USDC
+			common.HexToAddress("0xFd086bC7CD5C481DCC9C85ebE478A1C0b69FCbb9"), // USDT
+		},
+		DEXes: []DEXConfig{
+			{Name: "sushiswap", Factory: common.HexToAddress("0xc35DADB65012eC5796536bD9864eD8773aBc74C4"), InitCodeHash: sushiswapInitCodeHash, Fee: 30},
+		},
+		BlockTime: 250 * time.Millisecond,
+		GasModel:  gasEIP1559,
+		RPC:       "https://arb1.arbitrum.io/rpc",
+		Tokens:    "./tokens-arbitrum.json",
+	},
+}
+
+func chainNames() []string {
+	names := make([]string, 0, len(chainProfiles))
+	for name := range chainProfiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// suggestGasPrice prices a legacy transaction the way the chain expects.
+// On EIP-1559 chains it covers twice the base fee plus the suggested tip,
+// so the price holds for a few blocks of rising base fees.
+func suggestGasPrice(ctx context.Context, client *RPCPool, model string) (*big.Int, error) {
+	if model != gasEIP1559 {
+		return client.SuggestGasPrice(ctx)
+	}
+	head, err := client.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	if head.BaseFee == nil {
+		return client.SuggestGasPrice(ctx)
+	}
+	tip, err := client.SuggestGasTipCap(ctx)
+	if err != nil {
+		return nil, err
+	}
+	price := new(big.Int).Mul(head.BaseFee, big.NewInt(2))
+	return price.Add(price, tip), nil
+}
+
+// ChainMarket is everything one chain's graph is built from. Each chain
+// gets its own, so pools, tokens and graphs of different chains never mix.
+type ChainMarket struct {
+	profile ChainProfile
+	client  *RPCPool
+	pairs   []PairIn
+	sources []common.Address
+	tokens  *TokenRegistry
+	filter  *PoolFilter
+	vetter  *TokenVetter
+	// quarantine skips pools that keep failing to read
+	quarantine *quarantine
+	// staleAfter is how old the head may be before a scan refuses it
+	staleAfter time.Duration
+	// splitPools sizes loops across the pools parallel to each hop
+	splitPools bool
+	// tracker follows reserves from Sync logs when set, instead of
+	// reading every pool each scan
+	tracker *ReserveTracker
+	// head is the block the last scan read reserves at, and searched the
+	// graph it built from them
+	head     *types.Header
+	searched *Graph
+}
+
+// newChainMarket connects to the endpoints in rpc, checks they serve the
+// profile's chain and loads the pairs, token registry, filter and vetter
+// market names. Pairs get the fee of the DEX in dexes they belong to.
+func newChainMarket(ctx context.Context, profile ChainProfile, rpc RPCConfig, market MarketConfig, dexes []DEXConfig, sources []common.Address) (*ChainMarket, error) {
+	client, err := DialRPCPool(ctx, rpc)
+	if err != nil {
+		return nil, err
+	}
+	chainID, err := client.ChainID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if chainID.Uint64() != profile.ChainID {
+		return nil, fmt.Errorf("%s is chain %d, not %s (%d)", rpc.URL, chainID, profile.Name, profile.ChainID)
+	}
+	m := &ChainMarket{profile: profile, client: client, sources: sources, quarantine: newQuarantine()}
+
+	//Read Pairs from file
+	byteValue, err := os.ReadFile(market.Pairs)
+	if err != nil {
+		return nil, fmt.Errorf("%w: Market.Pairs: %v", ErrConfigInvalid, err)
+	}
+	if err := json.Unmarshal(byteValue, &m.pairs); err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrDecode, market.Pairs, err)
+	}
+	m.pairs = pairFees(ctx, client, m.pairs, dexes)
+
+	if m.tokens, err = NewTokenRegistry(client, market.Tokens); err != nil {
+		return nil, err
+	}
+	pair_tokens := make([]common.Address, 0, 2*len(m.pairs))
+	for _, pair := range m.pairs {
+		pair_tokens = append(pair_tokens, pair.From, pair.To)
+	}
+	if err := m.tokens.Resolve(ctx, pair_tokens); err != nil {
+		return nil, err
+	}
+
+	filterConfig, err := loadPoolFilter(market.Filter, profile.Wrapped)
+	if err != nil {
+		return nil, err
+	}
+	factories := make([]common.Address, len(dexes))
+	for i, dex := range dexes {
+		factories[i] = dex.Factory
+	}
+	if m.filter, err = NewPoolFilter(filterConfig, client, factories); err != nil {
+		return nil, err
+	}
+
+	if market.Vet != "" {
+		maxAge := profile.blocksPer(24 * time.Hour)
+		if m.vetter, err = NewTokenVetter(client, sources, market.MaxTax, maxAge, profile.BlockTime, market.Vet); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// pairFees fills in the fee of every pair that has none from the DEX that
+// deployed it: the one whose factory derives the pair's address, or else
+// the one its factory() names. Pairs of no known DEX cannot be priced and
+// are left out.
+func pairFees(ctx context.Context, client *RPCPool, pairs []PairIn, dexes []DEXConfig) []PairIn {
+	fees := make(map[common.Address]int64, len(dexes))
+	for _, dex := range dexes {
+		fees[dex.Factory] = dex.Fee
+	}
+	known := make([]PairIn, 0, len(pairs))
+	for _, pair := range pairs {
+		for i := 0; pair.Fee == 0 && i < len(dexes); i++ {
+			dex := dexes[i]
+			if dex.InitCodeHash != (common.Hash{}) && pairFor(dex.Factory, dex.InitCodeHash, pair.From, pair.To) == pair.Factory {
+				pair.Fee = dex.Fee
+			}
+		}
+		if pair.Fee == 0 {
+			factory, err := pairFactory(ctx, client, pair.Factory)
+			if err != nil {
+				log.Warn("Cannot find pair's DEX", "pair", pair.Factory, "err", err)
+				continue
+			}
+			pair.Fee = fees[factory]
+		}
+		if pair.Fee == 0 {
+			log.Warn("Pair is of no configured DEX", "pair", pair.Factory)
+			continue
+		}
+		known = append(known, pair)
+	}
+	return known
+}
+
+// pairFactory is the factory that deployed pair.
+func pairFactory(ctx context.Context, client *RPCPool, pair common.Address) (common.Address, error) {
+	contract, err := pancakePair.NewPancakePairCaller(pair, client)
+	if err != nil {
+		return common.Address{}, err
+	}
+	return contract.Factory(&bind.CallOpts{Context: ctx})
+}
+
+// graph builds the chain's market from pools, searching from its sources.
+func (m *ChainMarket) graph(pools []PoolState) *Graph {
+	market := buildMarket(pools, m.tokens)
+	market.sources = m.sources
+	market.splitPools = m.splitPools
+	return market
+}
+
+// scan searches the chain's current pools for loops.
+func (m *ChainMarket) scan() ([]Opportunity, []PoolState, error) {
+	started := time.Now()
+	pools, err := m.pools()
+	if err != nil {
+		return nil, nil, err
+	}
+	graph := m.graph(pools)
+	opportunities := findOpportunities(graph)
+	m.searched = graph
+	metrics.Observe(metricScanSeconds, time.Since(started), m.profile.Name)
+	metrics.Add(metricDetected, float64(len(opportunities)), m.profile.Name)
+	detected := time.Now()
+	for i := range opportunities {
+		opp := &opportunities[i]
+		opp.block, opp.number = m.head.Hash(), m.head.Number.Uint64()
+		opp.blockTime, opp.detected = time.Unix(int64(m.head.Time), 0), detected
+		opp.nativeRate = nativeRate(graph, m.profile.Wrapped, opp.pairs[0].from)
+	}
+	return opportunities, pools, nil
+}
+
+// stillCanonical reports whether opp was computed on a block that is still
+// on the chain. Without a tracker every opportunity is taken as current.
+func (m *ChainMarket) stillCanonical(ctx context.Context, opp Opportunity) bool {
+	if m.tracker == nil {
+		return true
+	}
+	ok, err := m.tracker.Confirm(ctx, opp.block)
+	if err != nil {
+		log.Warn("Cannot confirm block", "hash", opp.block, "err", err)
+		return false
+	}
+	return ok
+}
+
+// pools reads every pool's reserves at the head, then filters and vets
+// them. Pools that cannot be read are reported and left out; it only fails
+// when the head itself cannot be had, is stale or filtering fails.
+func (m *ChainMarket) pools() ([]PoolState, error) {
+	ctx := context.Background()
+	head, err := m.client.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	age := time.Since(time.Unix(int64(head.Time), 0))
+	if m.staleAfter > 0 && age > m.staleAfter {
+		return nil, fmt.Errorf("%w: %s head %d is %s old", ErrStaleData, m.profile.Name, head.Number, age.Round(time.Second))
+	}
+
+	var pools []PoolState
+	stale := 0
+	if m.tracker != nil && m.tracker.seeded() {
+		orphaned, err := m.tracker.Advance(ctx, head)
+		if len(orphaned) > 0 {
+			log.Warn("Reorg: orphaned tracked blocks", "blocks", len(orphaned))
+		}
+		if err == nil {
+			pools = m.tracker.Pools()
+		} else if !errors.Is(err, errTrackerReset) {
+			return nil, err
+		}
+	}
+	if pools == nil {
+		var report *ScanReport
+		pools, report = fetchPools(ctx, m.pairs, m.client, head.Number, m.quarantine)
+		stale = len(report.Failed) + len(report.Quarantined)
+		for _, line := range report.summary() {
+			log.Warn("Skipped pool", "reason", line)
+		}
+		if len(report.Failed) > 0 || len(report.Quarantined) > 0 {
+			log.Info("Read pools", "read", report.Pools, "pairs", len(m.pairs), "block", report.Block)
+		}
+		if m.tracker != nil {
+			m.tracker.Seed(pools, head)
+		}
+	}
+	m.head = head
+	pools, excluded, err := m.filter.Apply(ctx, pools)
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range summarizeExclusions(excluded) {
+		log.Debug("Filtered out pool", "reason", line)
+	}
+	if m.vetter != nil {
+		if err := m.vetter.Vet(ctx, pools); err != nil {
+			log.Warn("Token vetting failed", "err", err)
+		}
+		pools = m.vetter.Apply(pools)
+	}
+	metrics.Set(metricPoolsTracked, float64(len(pools)), m.profile.Name)
+	metrics.Set(metricPoolsStale, float64(stale), m.profile.Name)
+	return pools, nil
+}
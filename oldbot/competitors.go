@@ -0,0 +1,555 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// CompetitorConfig says which stored blocks `competitors` analyzes.
+type CompetitorConfig struct {
+	// FromBlock and ToBlock bound the analysis, zero for the first and last
+	// stored block. The blocks must be in the event store without gaps.
+	FromBlock uint64
+	ToBlock   uint64
+	// Report is where the JSON report is written, empty for none.
+	Report string
+}
+
+// How the detector did on a competitor's loop.
+const (
+	// detectedAhead is a loop the detector saw at the end of an earlier
+	// block and could have taken first.
+	detectedAhead = "ahead"
+	// detectedInBlock is a loop that only opened inside the block, before
+	// the competitor's transaction: a backrun of a transaction in the same
+	// block, which block-by-block scanning cannot take.
+	detectedInBlock = "in-block"
+	// detectedLater is a loop the detector only saw after it was taken.
+	detectedLater  = "later"
+	detectedMissed = "missed"
+)
+
+// swapLeg is one Swap of a transaction, as the token it took in and the
+// token it paid out.
+type swapLeg struct {
+	pool      common.Address
+	sender    common.Address
+	index     uint
+	tokenIn   common.Address
+	tokenOut  common.Address
+	amountIn  *big.Int
+	amountOut *big.Int
+}
+
+// CompetitorArbitrage is one transaction whose Swaps trade around a loop.
+type CompetitorArbitrage struct {
+	Block    uint64
+	Tx       common.Hash
+	Sender   common.Address
+	Contract common.Address
+	Path     []string
+	Pools    []common.Address
+	// Token is what the loop starts and ends in; Profit is what came out of
+	// the last Swap less what went into the first, in Token.
+	Token     common.Address
+	AmountIn  *big.Int
+	AmountOut *big.Int
+	Profit    *big.Int
+	// GasCost is what the transaction paid for gas, in the native coin.
+	GasCost *big.Int
+	// Detected is ahead, in-block, later or missed. Blocks is how many
+	// blocks before the transaction the detector started finding the loop
+	// in every block up to it, negative when it first saw the loop after.
+	// A stretch that closed again before the transaction does not count:
+	// the loop was gone in between, so the chance taken was a new one.
+	Detected string
+	Blocks   int64
+}
+
+// CompetitorSummary totals the arbitrages of one sender and contract.
+type CompetitorSummary struct {
+	Sender     common.Address
+	Contract   common.Address
+	Arbitrages int
+	Profit     map[string]string
+	GasCost    string
+	Ahead      int
+	InBlock    int
+	Later      int
+	Missed     int
+	MeanLead   float64
+	profits    map[common.Address]*big.Int
+	gas        *big.Int
+	leadBlocks int64
+}
+
+// CompetitorReport is what `competitors` found.
+type CompetitorReport struct {
+	FromBlock   uint64
+	ToBlock     uint64
+	Blocks      int
+	Arbitrages  []CompetitorArbitrage
+	Competitors []CompetitorSummary
+	Ahead       int
+	InBlock     int
+	Later       int
+	Missed      int
+}
+
+// swapLegs turns the Swaps of one transaction into legs, in log order.
+// Swaps of pools the pairs file does not know are left out.
+func swapLegs(swaps []PoolEvent, pairs map[common.Address]PairIn) []swapLeg {
+	legs := []swapLeg{}
+	for _, swap := range swaps {
+		pair, ok := pairs[swap.Pool]
+		if !ok || swap.Amount0In == nil {
+			continue
+		}
+		leg := swapLeg{pool: swap.Pool, sender: *swap.Sender, index: swap.Index}
+		// A Swap can pay both ways; the side with more in than out is the
+		// side the trade came in on
+		in0 := new(big.Int).Sub(swap.Amount0In, swap.Amount0Out)
+		in1 := new(big.Int).Sub(swap.Amount1In, swap.Amount1Out)
+		switch {
+		case in0.Sign() > 0 && in1.Sign() < 0:
+			leg.tokenIn, leg.tokenOut = pair.From, pair.To
+			leg.amountIn, leg.amountOut = in0, in1.Neg(in1)
+		case in1.Sign() > 0 && in0.Sign() < 0:
+			leg.tokenIn, leg.tokenOut = pair.To, pair.From
+			leg.amountIn, leg.amountOut = in1, in0.Neg(in0)
+		default:
+			continue
+		}
+		legs = append(legs, leg)
+	}
+	return legs
+}
+
+// swapCycles finds chains of legs that each spend the token the chain last
+// paid out and end in the token the chain started with. Legs that do not
+// continue a chain, like the fee swap of a taxed token or a second loop
+// interleaved with the first, are passed over and may make up a later
+// cycle. A transaction may hold several; legs that are part of none are
+// skipped.
+func swapCycles(legs []swapLeg) [][]swapLeg {
+	cycles := [][]swapLeg{}
+	used := make([]bool, len(legs))
+	for start := range legs {
+		if used[start] {
+			continue
+		}
+		chain := []int{start}
+		token := legs[start].tokenOut
+		for next := start + 1; next < len(legs) && token != legs[start].tokenIn; next++ {
+			if !used[next] && legs[next].tokenIn == token {
+				chain = append(chain, next)
+				token = legs[next].tokenOut
+			}
+		}
+		if token != legs[start].tokenIn {
+			continue
+		}
+		cycle := make([]swapLeg, len(chain))
+		for i, leg := range chain {
+			used[leg] = true
+			cycle[i] = legs[leg]
+		}
+		cycles = append(cycles, cycle)
+	}
+	return cycles
+}
+
+// cycleKey identifies a loop by its pools in trading order, rotated to
+// start at the lowest address, so the same loop entered from another token
+// has the same key.
+func cycleKey(pools []common.Address) string {
+	first := 0
+	for i, pool := range pools {
+		if bytes.Compare(pool.Bytes(), pools[first].Bytes()) < 0 {
+			first = i
+		}
+	}
+	keys := make([]string, len(pools))
+	for i := range pools {
+		keys[i] = pools[(first+i)%len(pools)].Hex()
+	}
+	return strings.Join(keys, ",")
+}
+
+func opportunityKey(opp Opportunity) string {
+	pools := make([]common.Address, len(opp.pairs))
+	for i, pair := range opp.pairs {
+		pools[i] = pair.factory
+	}
+	return cycleKey(pools)
+}
+
+// detectorSeen is one stretch of blocks over which the detector kept
+// finding a loop, from the first block whose reserves showed it to the
+// last.
+type detectorSeen struct {
+	from, to uint64
+}
+
+// effectiveGasPrice is what tx paid per gas in a block with baseFee.
+func effectiveGasPrice(tx *types.Transaction, baseFee *big.Int) *big.Int {
+	if baseFee == nil || tx.Type() == types.LegacyTxType {
+		return tx.GasPrice()
+	}
+	tip, err := tx.EffectiveGasTip(baseFee)
+	if err != nil {
+		return tx.GasPrice()
+	}
+	return tip.Add(tip, baseFee)
+}
+
+// analyzeCompetitors replays the stored events of the configured blocks.
+// Every transaction whose Swaps trade a loop is looked up on the chain for
+// its sender, contract and gas, and the loop is compared with what the
+// detector finds in the same replay: at the end of each block, and on the
+// reserves inside the block just before the transaction.
+func analyzeCompetitors(ctx context.Context, market *ChainMarket, store *EventStore, config CompetitorConfig, wrapped common.Address) (*CompetitorReport, error) {
+	ranges, err := store.Ranges()
+	if err != nil {
+		return nil, err
+	}
+	if len(ranges) == 0 {
+		return nil, fmt.Errorf("competitors: the event store is empty, run store sync first")
+	}
+	from, to := config.FromBlock, config.ToBlock
+	if from == 0 {
+		from = ranges[0][0] + 1
+	}
+	if to == 0 {
+		to = ranges[len(ranges)-1][1]
+	}
+	if from == 0 || from > to {
+		return nil, fmt.Errorf("competitors: invalid block range %d-%d", from, to)
+	}
+	// The reserves at the end of the block before are the starting point
+	addresses := make([]common.Address, len(market.pairs))
+	for i, pair := range market.pairs {
+		addresses[i] = pair.Factory
+	}
+	gaps, err := store.Gaps(addresses, from-1, to)
+	if err != nil {
+		return nil, err
+	}
+	if len(gaps) > 0 {
+		return nil, fmt.Errorf("competitors: %w: blocks %v of %d-%d", errNotIngested, gaps, from-1, to)
+	}
+
+	pairs := make(map[common.Address]PairIn, len(market.pairs))
+	pools := make([]PoolState, len(market.pairs))
+	byAddress := make(map[common.Address]*PoolState, len(market.pairs))
+	for i, pair := range market.pairs {
+		pairs[pair.Factory] = pair
+		pools[i].in = pair
+		// Pools without a stored Sync yet start empty, out of the graph,
+		// until their first one
+		if reserve0, reserve1, err := store.ReservesAt(pair.Factory, from-1); err == nil {
+			pools[i].reserve0.Set(reserve0)
+			pools[i].reserve1.Set(reserve1)
+		}
+		byAddress[pair.Factory] = &pools[i]
+	}
+	detect := func(pools []PoolState) map[string]bool {
+		graph := buildMarket(pools, market.tokens)
+		graph.sources = market.sources
+		found := make(map[string]bool)
+		for _, opp := range findOpportunities(graph) {
+			found[opportunityKey(opp)] = true
+		}
+		return found
+	}
+
+	chainID, err := market.client.ChainID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	signer := types.LatestSignerForChainID(chainID)
+
+	report := &CompetitorReport{FromBlock: from, ToBlock: to, Arbitrages: []CompetitorArbitrage{}}
+	seen := make(map[string][]detectorSeen)
+	open := detect(pools)
+	for key := range open {
+		seen[key] = []detectorSeen{{from: from - 1, to: from - 1}}
+	}
+
+	// last is the last block replayed. Blocks without events change no
+	// reserves, so what was open after it is open until the next one.
+	last, block, events := from-1, uint64(0), []PoolEvent{}
+	replay := func() error {
+		if len(events) == 0 {
+			return nil
+		}
+		swaps := make(map[common.Hash][]PoolEvent)
+		txs := []common.Hash{}
+		for _, e := range events {
+			if e.Kind != eventSwap {
+				continue
+			}
+			if _, ok := swaps[e.Tx]; !ok {
+				txs = append(txs, e.Tx)
+			}
+			swaps[e.Tx] = append(swaps[e.Tx], e)
+		}
+		var header *types.Header
+		for _, hash := range txs {
+			for _, cycle := range swapCycles(swapLegs(swaps[hash], pairs)) {
+				if header == nil {
+					var err error
+					if header, err = market.client.HeaderByNumber(ctx, new(big.Int).SetUint64(block)); err != nil {
+						return err
+					}
+				}
+				arb, err := competitorArbitrage(ctx, market, signer, header, hash, cycle)
+				if err != nil {
+					return err
+				}
+				key := cycleKey(arb.Pools)
+				if open[key] {
+					// the stretch still open is the one the transaction took
+					stretches := seen[key]
+					arb.Detected, arb.Blocks = detectedAhead, int64(block-stretches[len(stretches)-1].from)
+				} else if detect(reservesBefore(pools, events, cycle[0].index))[key] {
+					arb.Detected = detectedInBlock
+				}
+				report.Arbitrages = append(report.Arbitrages, *arb)
+			}
+		}
+
+		changed := false
+		for _, e := range events {
+			if pool, ok := byAddress[e.Pool]; ok && e.Kind == eventSync {
+				pool.reserve0.Set(e.Reserve0)
+				pool.reserve1.Set(e.Reserve1)
+				changed = true
+			}
+		}
+		if changed {
+			open = detect(pools)
+		}
+		for key := range open {
+			stretches := seen[key]
+			if n := len(stretches); n > 0 && stretches[n-1].to == last {
+				stretches[n-1].to = block
+				continue
+			}
+			seen[key] = append(stretches, detectorSeen{from: block, to: block})
+		}
+		last = block
+		report.Blocks++
+		events = events[:0]
+		return nil
+	}
+
+	err = store.Each(from, to, func(e PoolEvent) error {
+		if e.Block != block {
+			if err := replay(); err != nil {
+				return err
+			}
+			block = e.Block
+		}
+		events = append(events, e)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := replay(); err != nil {
+		return nil, err
+	}
+
+	// Loops the detector had neither ahead nor in the block may still have
+	// shown up after they were taken
+	for i := range report.Arbitrages {
+		arb := &report.Arbitrages[i]
+		if arb.Detected == "" {
+			arb.Detected = detectedMissed
+			for _, s := range seen[cycleKey(arb.Pools)] {
+				if s.from >= arb.Block {
+					arb.Detected, arb.Blocks = detectedLater, -int64(s.from-arb.Block)
+					break
+				}
+			}
+		}
+		switch arb.Detected {
+		case detectedAhead:
+			report.Ahead++
+		case detectedInBlock:
+			report.InBlock++
+		case detectedLater:
+			report.Later++
+		default:
+			report.Missed++
+		}
+	}
+	report.Competitors = summarizeCompetitors(report.Arbitrages, market.tokens, wrapped)
+	if config.Report != "" {
+		if err := writeJSON(config.Report, report); err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
+
+// reservesBefore is pools updated by the Syncs of a block's events that
+// come before log index.
+func reservesBefore(pools []PoolState, events []PoolEvent, index uint) []PoolState {
+	before := copyPools(pools)
+	positions := make(map[common.Address]int, len(before))
+	for i := range before {
+		positions[before[i].in.Factory] = i
+	}
+	for _, e := range events {
+		if e.Index >= index {
+			break
+		}
+		if i, ok := positions[e.Pool]; ok && e.Kind == eventSync {
+			before[i].reserve0.Set(e.Reserve0)
+			before[i].reserve1.Set(e.Reserve1)
+		}
+	}
+	return before
+}
+
+// competitorArbitrage looks up the transaction of cycle for its sender,
+// contract and gas.
+func competitorArbitrage(ctx context.Context, market *ChainMarket, signer types.Signer, header *types.Header, hash common.Hash, cycle []swapLeg) (*CompetitorArbitrage, error) {
+	tx, _, err := market.client.TransactionByHash(ctx, hash)
+	if err != nil {
+		return nil, fmt.Errorf("competitors: transaction %s: %w", hash.Hex(), err)
+	}
+	receipt, err := market.client.TransactionReceipt(ctx, hash)
+	if err != nil {
+		return nil, fmt.Errorf("competitors: receipt of %s: %w", hash.Hex(), err)
+	}
+	sender, err := types.Sender(signer, tx)
+	if err != nil {
+		return nil, fmt.Errorf("competitors: sender of %s: %w", hash.Hex(), err)
+	}
+	first, last := cycle[0], cycle[len(cycle)-1]
+	arb := &CompetitorArbitrage{
+		Block:     header.Number.Uint64(),
+		Tx:        hash,
+		Sender:    sender,
+		Token:     first.tokenIn,
+		AmountIn:  first.amountIn,
+		AmountOut: last.amountOut,
+		Profit:    new(big.Int).Sub(last.amountOut, first.amountIn),
+		GasCost:   new(big.Int).Mul(effectiveGasPrice(tx, header.BaseFee), new(big.Int).SetUint64(receipt.GasUsed)),
+	}
+	// Contract creations have no To; the pools were then called by the
+	// contract being created
+	if tx.To() != nil {
+		arb.Contract = *tx.To()
+	} else {
+		arb.Contract = first.sender
+	}
+	arb.Path = append(arb.Path, market.tokens.Symbol(first.tokenIn, first.tokenIn.Hex()))
+	for _, leg := range cycle {
+		arb.Path = append(arb.Path, market.tokens.Symbol(leg.tokenOut, leg.tokenOut.Hex()))
+		arb.Pools = append(arb.Pools, leg.pool)
+	}
+	return arb, nil
+}
+
+// summarizeCompetitors groups arbitrages by sender and contract, busiest
+// first.
+func summarizeCompetitors(arbs []CompetitorArbitrage, tokens *TokenRegistry, wrapped common.Address) []CompetitorSummary {
+	byKey := make(map[[2]common.Address]*CompetitorSummary)
+	keys := [][2]common.Address{}
+	for _, arb := range arbs {
+		key := [2]common.Address{arb.Sender, arb.Contract}
+		summary, ok := byKey[key]
+		if !ok {
+			summary = &CompetitorSummary{Sender: arb.Sender, Contract: arb.Contract, profits: make(map[common.Address]*big.Int), gas: new(big.Int)}
+			byKey[key] = summary
+			keys = append(keys, key)
+		}
+		summary.Arbitrages++
+		if summary.profits[arb.Token] == nil {
+			summary.profits[arb.Token] = new(big.Int)
+		}
+		summary.profits[arb.Token].Add(summary.profits[arb.Token], arb.Profit)
+		summary.gas.Add(summary.gas, arb.GasCost)
+		switch arb.Detected {
+		case detectedAhead:
+			summary.Ahead++
+			summary.leadBlocks += arb.Blocks
+		case detectedInBlock:
+			summary.InBlock++
+		case detectedLater:
+			summary.Later++
+		default:
+			summary.Missed++
+		}
+	}
+
+	summaries := make([]CompetitorSummary, 0, len(keys))
+	for _, key := range keys {
+		summary := byKey[key]
+		summary.Profit = make(map[string]string, len(summary.profits))
+		for token, profit := range summary.profits {
+			summary.Profit[tokens.Symbol(token, token.Hex())] = tokens.Format(token, profit)
+		}
+		summary.GasCost = tokens.Format(wrapped, summary.gas)
+		if summary.Ahead > 0 {
+			summary.MeanLead = float64(summary.leadBlocks) / float64(summary.Ahead)
+		}
+		summaries = append(summaries, *summary)
+	}
+	sort.SliceStable(summaries, func(i, j int) bool { return summaries[i].Arbitrages > summaries[j].Arbitrages })
+	return summaries
+}
+
+// printCompetitors prints how the detector did and the busiest competitors.
+func printCompetitors(report *CompetitorReport) {
+	fmt.Println("Analyzed ", report.Blocks, "blocks of", report.FromBlock, "to", report.ToBlock)
+	fmt.Println("Competitor arbitrages: ", len(report.Arbitrages))
+	fmt.Println("Detector ahead: ", report.Ahead, "in-block only:", report.InBlock, "later:", report.Later, "missed:", report.Missed)
+	top := report.Competitors
+	if len(top) > 10 {
+		top = top[:10]
+	}
+	for _, c := range top {
+		symbols := make([]string, 0, len(c.Profit))
+		for symbol := range c.Profit {
+			symbols = append(symbols, symbol)
+		}
+		sort.Strings(symbols)
+		profits := make([]string, len(symbols))
+		for i, symbol := range symbols {
+			profits[i] = c.Profit[symbol]
+		}
+		fmt.Printf("%s via %s: %d arbitrages, profit %s, gas %s, detector ahead %d (%.1f blocks) in-block %d later %d missed %d\n",
+			c.Sender.Hex(), c.Contract.Hex(), c.Arbitrages, strings.Join(profits, ", "), c.GasCost, c.Ahead, c.MeanLead, c.InBlock, c.Later, c.Missed)
+	}
+}
+
+// competitorsCommand runs `competitors` over the event store.
+func competitorsCommand(ctx context.Context, profile ChainProfile, config Config) error {
+	store, err := OpenEventStore(config.Store.Path)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	market, err := newChainMarket(ctx, profile, config.RPC, config.Market, config.DEX, config.Sources)
+	if err != nil {
+		return err
+	}
+	report, err := analyzeCompetitors(ctx, market, store, config.Competitors, profile.Wrapped)
+	if err != nil {
+		return err
+	}
+	printCompetitors(report)
+	return nil
+}
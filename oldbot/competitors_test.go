@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/ethdb/memorydb"
+	"github.com/ethereum/go-ethereum/rpc"
+	"golang.org/x/time/rate"
+)
+
+// testSwap is a Swap of pool with the four amounts in whole tokens.
+func testSwap(pool common.Address, block uint64, tx common.Hash, index uint, in0, in1, out0, out1 int64) PoolEvent {
+	unit := new(big.Int).Exp(big.NewInt(10), big.NewInt(18), nil)
+	whole := func(n int64) *big.Int { return new(big.Int).Mul(big.NewInt(n), unit) }
+	sender := common.HexToAddress("0xc0")
+	return PoolEvent{Kind: eventSwap, Pool: pool, Block: block, Tx: tx, Index: index, Sender: &sender,
+		Amount0In: whole(in0), Amount1In: whole(in1), Amount0Out: whole(out0), Amount1Out: whole(out1)}
+}
+
+// testSync is a Sync of pool to reserves in whole tokens.
+func testSync(pool common.Address, block uint64, tx common.Hash, index uint, reserve0, reserve1 int64) PoolEvent {
+	unit := new(big.Int).Exp(big.NewInt(10), big.NewInt(18), nil)
+	return PoolEvent{Kind: eventSync, Pool: pool, Block: block, Tx: tx, Index: index,
+		Reserve0: new(big.Int).Mul(big.NewInt(reserve0), unit), Reserve1: new(big.Int).Mul(big.NewInt(reserve1), unit)}
+}
+
+func TestSwapLegs(t *testing.T) {
+	pool := testPool(1, 2).in
+	pairs := map[common.Address]PairIn{pool.Factory: pool}
+	unknown := testSwap(testToken(99), 1, common.Hash{}, 0, 1, 0, 0, 1)
+	sync := testSync(pool.Factory, 1, common.Hash{}, 0, 1, 1)
+
+	tests := []struct {
+		name  string
+		swaps []PoolEvent
+		// want are tokenIn, tokenOut, amountIn and amountOut of each leg
+		want [][4]int64
+	}{
+		{"token0 in", []PoolEvent{testSwap(pool.Factory, 1, common.Hash{}, 0, 10, 0, 0, 7)}, [][4]int64{{1, 2, 10, 7}}},
+		{"token1 in", []PoolEvent{testSwap(pool.Factory, 1, common.Hash{}, 0, 0, 10, 7, 0)}, [][4]int64{{2, 1, 10, 7}}},
+		// a flash swap pays back part of what it took out
+		{"paid both ways", []PoolEvent{testSwap(pool.Factory, 1, common.Hash{}, 0, 10, 2, 2, 9)}, [][4]int64{{1, 2, 8, 7}}},
+		{"no net trade", []PoolEvent{testSwap(pool.Factory, 1, common.Hash{}, 0, 5, 0, 5, 0)}, nil},
+		{"unknown pool", []PoolEvent{unknown}, nil},
+		{"not a swap", []PoolEvent{sync}, nil},
+		{"in log order", []PoolEvent{
+			testSwap(pool.Factory, 1, common.Hash{}, 0, 10, 0, 0, 7),
+			unknown,
+			testSwap(pool.Factory, 1, common.Hash{}, 2, 0, 7, 9, 0),
+		}, [][4]int64{{1, 2, 10, 7}, {2, 1, 7, 9}}},
+	}
+	unit := new(big.Int).Exp(big.NewInt(10), big.NewInt(18), nil)
+	for _, test := range tests {
+		legs := swapLegs(test.swaps, pairs)
+		if len(legs) != len(test.want) {
+			t.Errorf("%s: %d legs, want %d", test.name, len(legs), len(test.want))
+			continue
+		}
+		for i, leg := range legs {
+			want := test.want[i]
+			if leg.tokenIn != testToken(int(want[0])) || leg.tokenOut != testToken(int(want[1])) ||
+				leg.amountIn.Cmp(new(big.Int).Mul(big.NewInt(want[2]), unit)) != 0 || leg.amountOut.Cmp(new(big.Int).Mul(big.NewInt(want[3]), unit)) != 0 {
+				t.Errorf("%s: leg %d is %s of %s for %s of %s, want %v", test.name, i, leg.amountIn, leg.tokenIn.Hex(), leg.amountOut, leg.tokenOut.Hex(), want)
+			}
+		}
+	}
+}
+
+func TestSwapCycles(t *testing.T) {
+	// leg n trades token in for token out in pool n
+	leg := func(n, in, out int) swapLeg {
+		return swapLeg{pool: testToken(100 + n), index: uint(n), tokenIn: testToken(in), tokenOut: testToken(out)}
+	}
+	tests := []struct {
+		name string
+		legs []swapLeg
+		// want are the leg numbers of each cycle
+		want [][]int
+	}{
+		{"triangle", []swapLeg{leg(0, 1, 2), leg(1, 2, 3), leg(2, 3, 1)}, [][]int{{0, 1, 2}}},
+		{"two loops in a row", []swapLeg{leg(0, 1, 2), leg(1, 2, 1), leg(2, 3, 4), leg(3, 4, 3)}, [][]int{{0, 1}, {2, 3}}},
+		// a taxed token's contract swaps its fees in the middle of the loop
+		{"swap in between", []swapLeg{leg(0, 1, 2), leg(1, 5, 6), leg(2, 2, 3), leg(3, 3, 1)}, [][]int{{0, 2, 3}}},
+		{"interleaved loops", []swapLeg{leg(0, 1, 2), leg(1, 3, 4), leg(2, 2, 1), leg(3, 4, 3)}, [][]int{{0, 2}, {1, 3}}},
+		{"legs before the loop", []swapLeg{leg(0, 7, 8), leg(1, 1, 2), leg(2, 2, 1)}, [][]int{{1, 2}}},
+		{"open path", []swapLeg{leg(0, 1, 2), leg(1, 2, 3)}, nil},
+		{"no continuation", []swapLeg{leg(0, 1, 2), leg(1, 3, 1)}, nil},
+		{"no legs", nil, nil},
+	}
+	for _, test := range tests {
+		cycles := swapCycles(test.legs)
+		got := make([]string, len(cycles))
+		for i, cycle := range cycles {
+			numbers := make([]string, len(cycle))
+			for j, leg := range cycle {
+				numbers[j] = fmt.Sprint(leg.index)
+			}
+			got[i] = strings.Join(numbers, " ")
+		}
+		want := make([]string, len(test.want))
+		for i, cycle := range test.want {
+			want[i] = strings.Trim(fmt.Sprint(cycle), "[]")
+		}
+		if strings.Join(got, ", ") != strings.Join(want, ", ") {
+			t.Errorf("%s: cycles %q, want %q", test.name, got, want)
+		}
+	}
+}
+
+func TestCycleKey(t *testing.T) {
+	a, b, c := testToken(1), testToken(2), testToken(3)
+	tests := []struct {
+		name  string
+		pools []common.Address
+		same  bool
+	}{
+		{"same order", []common.Address{a, b, c}, true},
+		{"rotated", []common.Address{b, c, a}, true},
+		{"rotated twice", []common.Address{c, a, b}, true},
+		// the other way round trades the pools in the other direction
+		{"reversed", []common.Address{c, b, a}, false},
+		{"other pools", []common.Address{a, b, testToken(4)}, false},
+		{"fewer pools", []common.Address{a, b}, false},
+	}
+	want := cycleKey([]common.Address{a, b, c})
+	for _, test := range tests {
+		if same := cycleKey(test.pools) == want; same != test.same {
+			t.Errorf("%s: key %s, same as %s %v, want %v", test.name, cycleKey(test.pools), want, same, test.same)
+		}
+	}
+}
+
+// fakeReceipts serves the chain id, headers, and the transactions and
+// receipts of txs.
+type fakeReceipts struct {
+	txs map[common.Hash]*types.Transaction
+}
+
+func (f *fakeReceipts) ChainId() *hexutil.Big {
+	return (*hexutil.Big)(big.NewInt(56))
+}
+
+func (f *fakeReceipts) GetBlockByNumber(number rpc.BlockNumber, full bool) *types.Header {
+	return &types.Header{Number: big.NewInt(number.Int64()), Difficulty: new(big.Int)}
+}
+
+func (f *fakeReceipts) GetTransactionByHash(hash common.Hash) *types.Transaction {
+	return f.txs[hash]
+}
+
+func (f *fakeReceipts) GetTransactionReceipt(hash common.Hash) *types.Receipt {
+	return &types.Receipt{TxHash: hash, Status: types.ReceiptStatusSuccessful, GasUsed: 100000, Logs: []*types.Log{}}
+}
+
+func TestAnalyzeCompetitors(t *testing.T) {
+	// T1 -> T2 -> T3 -> T1 through a, b and c pays once T2 is cheap in a
+	a, b, c := testPool(1, 2).in, testPool(2, 3).in, testPool(1, 3).in
+	pairs := []PairIn{a, b, c}
+	tokens := &TokenRegistry{tokens: map[common.Address]TokenInfo{}}
+	for i := 1; i <= 3; i++ {
+		tokens.tokens[testToken(i)] = TokenInfo{Address: testToken(i), Symbol: fmt.Sprintf("T%d", i), Decimals: 18}
+	}
+
+	key, _ := crypto.GenerateKey()
+	signer := types.LatestSignerForChainID(big.NewInt(56))
+	contract := common.HexToAddress("0xc0")
+	receipts := &fakeReceipts{txs: map[common.Hash]*types.Transaction{}}
+	tx := func(nonce uint64) common.Hash {
+		signed, err := types.SignTx(types.NewTransaction(nonce, contract, new(big.Int), 300000, big.NewInt(5e9), nil), signer, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		receipts.txs[signed.Hash()] = signed
+		return signed.Hash()
+	}
+	// loop is the three swaps of the loop from index, as a competitor
+	// trades it
+	loop := func(block uint64, hash common.Hash, index uint) []PoolEvent {
+		return []PoolEvent{
+			testSwap(a.Factory, block, hash, index, 10, 0, 0, 11),
+			testSwap(b.Factory, block, hash, index+1, 11, 0, 0, 10),
+			testSwap(c.Factory, block, hash, index+2, 0, 10, 11, 0),
+		}
+	}
+	later, ahead, inBlock, other := tx(0), tx(1), tx(2), tx(3)
+
+	events := []PoolEvent{
+		testSync(a.Factory, 9, common.Hash{}, 0, 100, 100),
+		testSync(b.Factory, 9, common.Hash{}, 1, 100, 100),
+		testSync(c.Factory, 9, common.Hash{}, 2, 100, 100),
+	}
+	// a loop traded while the reserves show none, which the detector only
+	// finds a block later
+	events = append(events, loop(10, later, 0)...)
+	// the loop opens, closes and opens again two blocks before it is taken
+	events = append(events, testSync(a.Factory, 11, common.Hash{}, 0, 100, 130))
+	events = append(events, testSync(a.Factory, 12, common.Hash{}, 0, 100, 100))
+	events = append(events, testSync(a.Factory, 13, common.Hash{}, 0, 100, 130))
+	events = append(events, loop(15, ahead, 0)...)
+	events = append(events, testSync(a.Factory, 15, ahead, 3, 100, 100))
+	// a swap opens the loop inside the block and the competitor backruns it
+	events = append(events, testSwap(a.Factory, 16, other, 0, 0, 30, 0, 0))
+	events = append(events, testSync(a.Factory, 16, other, 1, 100, 130))
+	events = append(events, loop(16, inBlock, 2)...)
+	events = append(events, testSync(a.Factory, 16, inBlock, 5, 100, 100))
+
+	store, err := newEventStore(memorydb.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	batch := store.db.NewBatch()
+	for i := range events {
+		if err := store.put(batch, &events[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	keys := [][]byte{rangesKey}
+	for _, pair := range pairs {
+		keys = append(keys, storeKey(ingestedPrefix, pair.Factory.Bytes()))
+	}
+	for _, key := range keys {
+		if err := store.putRanges(batch, key, [][2]uint64{{9, 16}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := batch.Write(); err != nil {
+		t.Fatal(err)
+	}
+
+	server := rpc.NewServer()
+	if err := server.RegisterName("eth", receipts); err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+	client := rpc.DialInProc(server)
+	market := &ChainMarket{
+		client: &RPCPool{
+			endpoints: []*rpcEndpoint{{url: "inproc", rpc: client, client: ethclient.NewClient(client), limiter: rate.NewLimiter(rate.Inf, 1), healthy: true}},
+			timeout:   5 * time.Second,
+		},
+		pairs:   pairs,
+		sources: []common.Address{testToken(1)},
+		tokens:  tokens,
+	}
+
+	report, err := analyzeCompetitors(context.Background(), market, store, CompetitorConfig{FromBlock: 10, ToBlock: 16}, testToken(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		tx       common.Hash
+		block    uint64
+		detected string
+		blocks   int64
+	}{
+		{later, 10, detectedLater, -1},
+		// counted from block 13, where the loop opened for the last time
+		{ahead, 15, detectedAhead, 2},
+		{inBlock, 16, detectedInBlock, 0},
+	}
+	if len(report.Arbitrages) != len(want) {
+		t.Fatalf("%d arbitrages, want %d", len(report.Arbitrages), len(want))
+	}
+	sender := crypto.PubkeyToAddress(key.PublicKey)
+	for i, arb := range report.Arbitrages {
+		w := want[i]
+		if arb.Tx != w.tx || arb.Block != w.block || arb.Detected != w.detected || arb.Blocks != w.blocks {
+			t.Errorf("arbitrage %d: %s in %d %s by %d, want %s in %d %s by %d", i, arb.Tx.Hex(), arb.Block, arb.Detected, arb.Blocks, w.tx.Hex(), w.block, w.detected, w.blocks)
+		}
+		if strings.Join(arb.Path, " ") != "T1 T2 T3 T1" || arb.Sender != sender || arb.Contract != contract {
+			t.Errorf("arbitrage %d: %s by %s via %s", i, strings.Join(arb.Path, " -> "), arb.Sender.Hex(), arb.Contract.Hex())
+		}
+		// 11 T1 back for 10, and 100000 gas at 5 gwei
+		if arb.Profit.Cmp(new(big.Int).Mul(big.NewInt(1), big.NewInt(1e18))) != 0 || arb.GasCost.Cmp(big.NewInt(5e14)) != 0 {
+			t.Errorf("arbitrage %d: profit %s gas %s", i, arb.Profit, arb.GasCost)
+		}
+	}
+	if report.Ahead != 1 || report.InBlock != 1 || report.Later != 1 || report.Missed != 0 || report.Blocks != 6 {
+		t.Errorf("report: %d ahead %d in-block %d later %d missed over %d blocks", report.Ahead, report.InBlock, report.Later, report.Missed, report.Blocks)
+	}
+	if len(report.Competitors) != 1 || report.Competitors[0].Arbitrages != 3 || report.Competitors[0].MeanLead != 2 {
+		t.Errorf("competitors: %+v", report.Competitors)
+	}
+}
@@ -0,0 +1,552 @@
+package main
+
+import (
+	"bufio"
+	"encoding"
+	"errors"
+	"flag"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/naoina/toml"
+)
+
+// tomlSettings keeps TOML keys identical to the Go field names and rejects
+// keys that match no field, so typos fail loudly.
+var tomlSettings = toml.Config{
+	NormFieldName: func(rt reflect.Type, key string) string {
+		return key
+	},
+	FieldToKey: func(rt reflect.Type, field string) string {
+		return field
+	},
+	MissingField: func(rt reflect.Type, field string) error {
+		return fmt.Errorf("field '%s' is not defined in %s", field, rt.String())
+	},
+}
+
+// Config is everything the bot reads at startup. It is filled from
+// defaultConfig, then the -config file, then ARB_<SECTION>_<FIELD>
+// environment variables, then command line flags. Whatever is still unset
+// comes from the chain profile.
+type Config struct {
+	// Chain names the profile in chainProfiles the bot runs against.
+	Chain       string
+	RPC         RPCConfig
+	Market      MarketConfig
+	DEX         []DEXConfig
+	Sources     []common.Address
+	Thresholds  ThresholdConfig
+	Gas         GasConfig
+	Execution   ExecutionConfig
+	Search      SearchConfig
+	Logging     LoggingConfig
+	Journal     JournalConfig
+	Metrics     MetricsConfig
+	API         APIConfig
+	Quote       QuoteConfig
+	Monitor     MonitorConfig
+	Backtest    BacktestConfig
+	Store       StoreConfig
+	Competitors CompetitorConfig
+}
+
+type RPCConfig struct {
+	URL string
+	// Fallbacks are more endpoints of the same chain, HTTP or websocket,
+	// that calls fail over to.
+	Fallbacks []string
+	// Mempool is a websocket endpoint to watch pending swaps on. Empty
+	// leaves back-running off.
+	Mempool string
+	// RateLimit is requests per second per endpoint, zero for no limit.
+	RateLimit float64
+	Burst     int
+	// MaxLagBlocks is how far an endpoint's head may trail the best one
+	// before calls avoid it.
+	MaxLagBlocks   uint64
+	Retries        int
+	TimeoutSeconds uint64
+	HealthSeconds  uint64
+}
+
+// MarketConfig is where pools come from and which reach the graph.
+type MarketConfig struct {
+	Pairs  string
+	Tokens string
+	// Filter is a JSON pool filter config. Empty keeps pools worth 0.01 of
+	// the wrapped native coin a side.
+	Filter string
+	// Vet is where token vetting results are kept. Empty turns vetting off.
+	Vet    string
+	MaxTax float64
+}
+
+// DEXConfig is one V2 exchange. Router and InitCodeHash are only needed to
+// decode pending router swaps.
+type DEXConfig struct {
+	Name         string
+	Factory      common.Address
+	Router       common.Address
+	InitCodeHash common.Hash
+	// Fee is the swap fee of its pairs, in basis points.
+	Fee int64
+}
+
+// ThresholdConfig holds the basis point tolerances trades are checked with.
+type ThresholdConfig struct {
+	Tolerance       int64
+	Slippage        int64
+	BundleMinProfit *big.Int
+}
+
+type GasConfig struct {
+	// LimitPercent is the gas limit as a percentage of estimated gas.
+	LimitPercent uint64
+	// MaxPrice caps the suggested gas price, in wei. Zero is no cap.
+	MaxPrice *big.Int
+	// BumpPercent raises the gas price of each replacement.
+	BumpPercent int64
+	// StuckSeconds is how long an attempt may stay pending before it is
+	// sped up.
+	StuckSeconds uint64
+}
+
+type ExecutionConfig struct {
+	Mode     string
+	Executor common.Address
+	From     common.Address
+	Pending  bool
+	Submit   string
+	// Relay is an eth_sendBundle URL, or "standin" for a local stand-in.
+	Relay        string
+	BundleBlocks uint64
+	TxState      string
+}
+
+type SearchConfig struct {
+	// Count is how many searches to run. Zero runs until stopped.
+	Count           int
+	IntervalSeconds uint64
+	// StaleSeconds is how old the head may be before a search is skipped.
+	// Zero accepts any head.
+	StaleSeconds uint64
+	// TrackReserves follows reserves from each block's Sync logs, rolling
+	// back reorganized blocks, instead of reading every pool each search.
+	TrackReserves bool
+	// SplitPools also sizes loops with each hop's input split across the
+	// pools parallel to it. The executor trades one pool per hop, so loops
+	// are still traded through their own pairs and the split is reported
+	// next to them.
+	SplitPools bool
+}
+
+type LoggingConfig struct {
+	// Level is one of error, warn, info or debug.
+	Level string
+	// Format is terminal, logfmt or json.
+	Format string
+}
+
+func defaultConfig() Config {
+	return Config{
+		Chain: "bsc",
+		RPC: RPCConfig{
+			Burst:          1,
+			MaxLagBlocks:   3,
+			Retries:        3,
+			TimeoutSeconds: 10,
+			HealthSeconds:  15,
+		},
+		Market: MarketConfig{MaxTax: 0.05},
+		Thresholds: ThresholdConfig{
+			Tolerance:       100,
+			Slippage:        50,
+			BundleMinProfit: new(big.Int),
+		},
+		Gas: GasConfig{
+			LimitPercent: 120,
+			MaxPrice:     new(big.Int),
+			BumpPercent:  12,
+			StuckSeconds: 30,
+		},
+		Execution: ExecutionConfig{
+			Mode:         string(modeCapital),
+			Pending:      true,
+			Submit:       "mempool",
+			BundleBlocks: 3,
+			TxState:      "./txstate.json",
+		},
+		Search:      SearchConfig{Count: 5, IntervalSeconds: 10, StaleSeconds: 60},
+		Logging:     LoggingConfig{Level: "info", Format: "terminal"},
+		Journal:     JournalConfig{Path: "./journal/opportunities.jsonl", MaxMB: 64, Keep: 10},
+		API:         APIConfig{MaxQuotes: 4},
+		Quote:       QuoteConfig{MaxHops: 3, MaxPaths: 32, MaxRoutes: 4, Parts: 20},
+		Monitor:     MonitorConfig{TradeUSD: 10000, VolatilityBps: 10, MinSpreadBps: 20},
+		Backtest:    BacktestConfig{Data: "./backtest", BatchBlocks: 5000},
+		Store:       StoreConfig{Path: "./events", BatchBlocks: 2000},
+		Competitors: CompetitorConfig{Report: "./competitors.json"},
+	}
+}
+
+// loadConfig reads path over the defaults, then applies environment
+// overrides. An empty path uses the defaults alone.
+func loadConfig(path string) (Config, error) {
+	config := defaultConfig()
+	if path != "" {
+		file, err := os.Open(path)
+		if err != nil {
+			return config, err
+		}
+		defer file.Close()
+		err = tomlSettings.NewDecoder(bufio.NewReader(file)).Decode(&config)
+		// Add file name to errors that have a line number.
+		if _, ok := err.(*toml.LineError); ok {
+			err = errors.New(path + ", " + err.Error())
+		}
+		if err != nil {
+			return config, err
+		}
+	}
+	if err := applyEnv(&config, os.Environ()); err != nil {
+		return config, err
+	}
+	return config, nil
+}
+
+// applyEnv sets fields named by ARB_<SECTION>_<FIELD> variables, with
+// section and field upper-cased, e.g. ARB_RPC_URL or ARB_GAS_MAXPRICE.
+// ARB_PRIVATE_KEY is not a config field and is left alone. Variables that
+// name no field are warned about and ignored, since the environment is
+// shared with other tools; a bad value for a field is still an error.
+func applyEnv(config *Config, environ []string) error {
+	sections := reflect.ValueOf(config).Elem()
+	for _, entry := range environ {
+		name, value := entry, ""
+		if i := strings.IndexByte(entry, '='); i >= 0 {
+			name, value = entry[:i], entry[i+1:]
+		}
+		if !strings.HasPrefix(name, "ARB_") || name == "ARB_PRIVATE_KEY" {
+			continue
+		}
+		parts := strings.SplitN(strings.TrimPrefix(name, "ARB_"), "_", 2)
+		section := findField(sections, parts[0])
+		if !section.IsValid() {
+			log.Warn("Ignoring environment variable", "name", name, "reason", "no config section "+parts[0])
+			continue
+		}
+		field := section
+		if section.Kind() == reflect.Struct {
+			if len(parts) < 2 {
+				log.Warn("Ignoring environment variable", "name", name, "reason", "names no field of section "+parts[0])
+				continue
+			}
+			field = findField(section, parts[1])
+			if !field.IsValid() {
+				log.Warn("Ignoring environment variable", "name", name, "reason", "no field "+parts[1]+" in section "+parts[0])
+				continue
+			}
+		}
+		if err := setField(field, value); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func findField(v reflect.Value, upper string) reflect.Value {
+	return v.FieldByNameFunc(func(name string) bool {
+		return strings.ToUpper(name) == upper
+	})
+}
+
+var textUnmarshaler = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+
+// setField parses value into a config field of any type the config uses.
+// Lists are comma separated.
+func setField(field reflect.Value, value string) error {
+	if field.Kind() == reflect.Ptr && field.Type().Implements(textUnmarshaler) {
+		target := reflect.New(field.Type().Elem())
+		if err := target.Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(value)); err != nil {
+			return err
+		}
+		field.Set(target)
+		return nil
+	}
+	if field.CanAddr() && field.Addr().Type().Implements(textUnmarshaler) {
+		return field.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(value))
+	}
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		i, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		field.SetInt(i)
+	case reflect.Uint64:
+		u, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		field.SetUint(u)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		field.SetFloat(f)
+	case reflect.Slice:
+		items := strings.FieldsFunc(value, func(r rune) bool { return r == ',' || unicode.IsSpace(r) })
+		list := reflect.MakeSlice(field.Type(), len(items), len(items))
+		for i, item := range items {
+			if err := setField(list.Index(i), item); err != nil {
+				return err
+			}
+		}
+		field.Set(list)
+	default:
+		return fmt.Errorf("cannot set %s from the environment", field.Type())
+	}
+	return nil
+}
+
+// applyProfile fills RPC.URL, Market.Pairs, Market.Tokens, DEX and Sources
+// from the chain profile where the config left them unset.
+func (c *Config) applyProfile() (ChainProfile, error) {
+	profile, ok := chainProfiles[c.Chain]
+	if !ok {
+		return profile, fmt.Errorf("unknown Chain %q, expected one of %s", c.Chain, strings.Join(chainNames(), ", "))
+	}
+	if c.RPC.URL == "" {
+		c.RPC.URL = profile.RPC
+	}
+	if c.Market.Pairs == "" {
+		c.Market.Pairs = profile.Pairs
+	}
+	if c.Market.Tokens == "" {
+		c.Market.Tokens = profile.Tokens
+	}
+	if len(c.DEX) == 0 {
+		c.DEX = profile.DEXes
+	}
+	if len(c.Sources) == 0 {
+		c.Sources = profile.sources()
+	}
+	return profile, nil
+}
+
+// Validate checks the config as a whole and names every problem found.
+func (c *Config) Validate() error {
+	problems := []string{}
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, args...))
+		}
+	}
+
+	check(c.RPC.URL != "", "RPC.URL is empty")
+	check(c.RPC.RateLimit >= 0, "RPC.RateLimit must not be negative")
+	check(c.RPC.Retries >= 0, "RPC.Retries must not be negative")
+	check(c.RPC.TimeoutSeconds > 0, "RPC.TimeoutSeconds must be positive")
+	check(c.Market.Pairs != "", "Market.Pairs is empty and chain %s has no default pairs file", c.Chain)
+	if c.Market.Pairs != "" {
+		_, err := os.Stat(c.Market.Pairs)
+		check(err == nil, "Market.Pairs: %v", err)
+	}
+	check(c.Market.Tokens != "", "Market.Tokens is empty")
+	check(c.Market.MaxTax >= 0 && c.Market.MaxTax <= 1, "Market.MaxTax must be a fraction between 0 and 1, got %v", c.Market.MaxTax)
+	check(len(c.DEX) > 0, "no DEX is configured")
+	for i, dex := range c.DEX {
+		check(dex.Factory != (common.Address{}), "DEX %d (%s) has no Factory", i, dex.Name)
+		check(dex.Router == (common.Address{}) || dex.InitCodeHash != (common.Hash{}), "DEX %d (%s) has a Router but no InitCodeHash", i, dex.Name)
+		check(dex.Fee > 0 && dex.Fee < feeDenom, "DEX %d (%s) Fee must be 1-9999 basis points, got %d", i, dex.Name, dex.Fee)
+	}
+	check(len(c.Sources) > 0, "no Sources are configured")
+
+	check(c.Thresholds.Tolerance >= 0 && c.Thresholds.Tolerance <= 10000, "Thresholds.Tolerance must be 0-10000 basis points, got %d", c.Thresholds.Tolerance)
+	check(c.Thresholds.Slippage >= 0 && c.Thresholds.Slippage < 10000, "Thresholds.Slippage must be 0-9999 basis points, got %d", c.Thresholds.Slippage)
+	check(c.Thresholds.BundleMinProfit != nil && c.Thresholds.BundleMinProfit.Sign() >= 0, "Thresholds.BundleMinProfit must be a non-negative amount in wei")
+
+	check(c.Gas.LimitPercent >= 100, "Gas.LimitPercent must be at least 100, got %d", c.Gas.LimitPercent)
+	check(c.Gas.MaxPrice != nil && c.Gas.MaxPrice.Sign() >= 0, "Gas.MaxPrice must be a non-negative amount in wei")
+	// nodes refuse replacements priced less than 10% higher
+	check(c.Gas.BumpPercent >= 10, "Gas.BumpPercent must be at least 10, got %d", c.Gas.BumpPercent)
+	check(c.Gas.StuckSeconds > 0, "Gas.StuckSeconds must be positive")
+
+	mode := executionMode(c.Execution.Mode)
+	check(mode == modeCapital || mode == modeFlash, "Execution.Mode must be capital or flash, got %q", c.Execution.Mode)
+	switch c.Execution.Submit {
+	case "mempool":
+	case "bundle", "auto":
+		check(c.Execution.Relay != "", "Execution.Submit %q needs Execution.Relay", c.Execution.Submit)
+	default:
+		check(false, "Execution.Submit must be mempool, bundle or auto, got %q", c.Execution.Submit)
+	}
+	check(c.Execution.BundleBlocks > 0, "Execution.BundleBlocks must be positive")
+	check(c.Execution.TxState != "", "Execution.TxState is empty")
+
+	check(c.Search.Count >= 0, "Search.Count must not be negative")
+	check(c.Search.IntervalSeconds > 0, "Search.IntervalSeconds must be positive")
+
+	switch c.Logging.Level {
+	case "error", "warn", "info", "debug":
+	default:
+		check(false, "Logging.Level must be error, warn, info or debug, got %q", c.Logging.Level)
+	}
+	switch c.Logging.Format {
+	case "terminal", "logfmt", "json":
+	default:
+		check(false, "Logging.Format must be terminal, logfmt or json, got %q", c.Logging.Format)
+	}
+	check(c.Journal.MaxMB >= 0, "Journal.MaxMB must not be negative")
+	check(c.Journal.Keep >= 0, "Journal.Keep must not be negative")
+	for _, listen := range [][2]string{{"Metrics.Listen", c.Metrics.Listen}, {"API.Listen", c.API.Listen}} {
+		if listen[1] != "" {
+			_, _, err := net.SplitHostPort(listen[1])
+			check(err == nil, "%s: %v", listen[0], err)
+		}
+	}
+	check(c.API.MaxQuotes > 0, "API.MaxQuotes must be positive")
+	check(c.Quote.MaxHops > 0, "Quote.MaxHops must be positive")
+	check(c.Quote.MaxPaths > 0, "Quote.MaxPaths must be positive")
+	check(c.Quote.MaxRoutes <= c.Quote.MaxPaths, "Quote.MaxRoutes must not be more than Quote.MaxPaths")
+	check(c.Quote.MaxRoutes > 0, "Quote.MaxRoutes must be positive")
+	check(c.Quote.Parts > 0, "Quote.Parts must be positive")
+
+	c.Monitor.validate(check)
+
+	check(c.Backtest.Data != "", "Backtest.Data is empty")
+	check(c.Backtest.BatchBlocks > 0, "Backtest.BatchBlocks must be positive")
+	check(c.Store.Path != "", "Store.Path is empty")
+	check(c.Store.BatchBlocks > 0, "Store.BatchBlocks must be positive")
+	check(c.Competitors.ToBlock == 0 || c.Competitors.FromBlock <= c.Competitors.ToBlock, "Competitors.FromBlock is after Competitors.ToBlock")
+
+	if len(problems) > 0 {
+		return fmt.Errorf("%w:\n  %s", ErrConfigInvalid, strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+// gasPriceCap limits price to Gas.MaxPrice when one is set.
+func (c *Config) gasPriceCap(price *big.Int) *big.Int {
+	if c.Gas.MaxPrice.Sign() > 0 && price.Cmp(c.Gas.MaxPrice) > 0 {
+		return new(big.Int).Set(c.Gas.MaxPrice)
+	}
+	return price
+}
+
+func (c *Config) interval() time.Duration {
+	return time.Duration(c.Search.IntervalSeconds) * time.Second
+}
+
+// configFlag overrides one config field from the command line. Values are
+// kept until the config file and environment have been read, so flags win.
+type configFlag struct {
+	name   string
+	field  string
+	isBool bool
+	value  *string
+}
+
+func (f *configFlag) String() string {
+	if f.value == nil {
+		return ""
+	}
+	return *f.value
+}
+
+func (f *configFlag) Set(value string) error {
+	f.value = &value
+	return nil
+}
+
+func (f *configFlag) IsBoolFlag() bool { return f.isBool }
+
+// configFlags are the command line overrides, by flag name and the
+// Section.Field they set.
+var configFlags = []*configFlag{
+	{name: "chain", field: "Chain"},
+	{name: "rpc", field: "RPC.URL"},
+	{name: "rpc-fallbacks", field: "RPC.Fallbacks"},
+	{name: "mempool", field: "RPC.Mempool"},
+	{name: "rate-limit", field: "RPC.RateLimit"},
+	{name: "pairs", field: "Market.Pairs"},
+	{name: "tokens", field: "Market.Tokens"},
+	{name: "filter", field: "Market.Filter"},
+	{name: "vet", field: "Market.Vet"},
+	{name: "max-tax", field: "Market.MaxTax"},
+	{name: "tolerance", field: "Thresholds.Tolerance"},
+	{name: "slippage", field: "Thresholds.Slippage"},
+	{name: "bundle-min-profit", field: "Thresholds.BundleMinProfit"},
+	{name: "mode", field: "Execution.Mode"},
+	{name: "executor", field: "Execution.Executor"},
+	{name: "from", field: "Execution.From"},
+	{name: "pending", field: "Execution.Pending", isBool: true},
+	{name: "submit", field: "Execution.Submit"},
+	{name: "relay", field: "Execution.Relay"},
+	{name: "bundle-blocks", field: "Execution.BundleBlocks"},
+	{name: "txstate", field: "Execution.TxState"},
+	{name: "searches", field: "Search.Count"},
+	{name: "interval", field: "Search.IntervalSeconds"},
+	{name: "track", field: "Search.TrackReserves", isBool: true},
+	{name: "split-pools", field: "Search.SplitPools", isBool: true},
+	{name: "log-level", field: "Logging.Level"},
+	{name: "log-format", field: "Logging.Format"},
+	{name: "journal", field: "Journal.Path"},
+	{name: "metrics", field: "Metrics.Listen"},
+	{name: "api", field: "API.Listen"},
+	{name: "quote-hops", field: "Quote.MaxHops"},
+	{name: "quote-paths", field: "Quote.MaxPaths"},
+	{name: "quote-routes", field: "Quote.MaxRoutes"},
+	{name: "monitor", field: "Monitor.Enabled", isBool: true},
+	{name: "backtest-data", field: "Backtest.Data"},
+	{name: "from-block", field: "Backtest.FromBlock"},
+	{name: "to-block", field: "Backtest.ToBlock"},
+	{name: "backtest-report", field: "Backtest.Report"},
+	{name: "store", field: "Store.Path"},
+	{name: "store-from-block", field: "Store.FromBlock"},
+	{name: "competitors-from", field: "Competitors.FromBlock"},
+	{name: "competitors-to", field: "Competitors.ToBlock"},
+	{name: "competitors-report", field: "Competitors.Report"},
+}
+
+func registerConfigFlags(fs *flag.FlagSet) {
+	for _, f := range configFlags {
+		fs.Var(f, f.name, "overrides "+f.field)
+	}
+}
+
+// applyFlags sets every field whose flag was given.
+func applyFlags(config *Config) error {
+	root := reflect.ValueOf(config).Elem()
+	for _, f := range configFlags {
+		if f.value == nil {
+			continue
+		}
+		field := root
+		for _, name := range strings.Split(f.field, ".") {
+			field = field.FieldByName(name)
+		}
+		if err := setField(field, *f.value); err != nil {
+			return fmt.Errorf("-%s: %w", f.name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestApplyEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		environ []string
+		check   func(Config) bool
+		err     bool
+	}{
+		{"field set", []string{"ARB_RPC_URL=http://node:8545"}, func(c Config) bool { return c.RPC.URL == "http://node:8545" }, false},
+		{"number set", []string{"ARB_GAS_BUMPPERCENT=25"}, func(c Config) bool { return c.Gas.BumpPercent == 25 }, false},
+		{"list set", []string{"ARB_RPC_FALLBACKS=http://a, http://b"}, func(c Config) bool { return len(c.RPC.Fallbacks) == 2 }, false},
+		{"unknown section ignored", []string{"ARB_NOSUCH_FIELD=1", "ARB_RPC_URL=http://node"}, func(c Config) bool { return c.RPC.URL == "http://node" }, false},
+		{"unknown field ignored", []string{"ARB_RPC_NOSUCH=1"}, func(c Config) bool { return true }, false},
+		{"section without field ignored", []string{"ARB_RPC=1"}, func(c Config) bool { return true }, false},
+		{"private key left alone", []string{"ARB_PRIVATE_KEY=00"}, func(c Config) bool { return true }, false},
+		{"other variables left alone", []string{"HOME=/root", "PATH"}, func(c Config) bool { return true }, false},
+		{"bad value", []string{"ARB_GAS_BUMPPERCENT=lots"}, nil, true},
+	}
+	for _, test := range tests {
+		config := defaultConfig()
+		err := applyEnv(&config, test.environ)
+		if test.err {
+			if err == nil {
+				t.Errorf("%s: no error", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if !test.check(config) {
+			t.Errorf("%s: config not as expected", test.name)
+		}
+	}
+}
+
+func TestValidateNamesEveryProblem(t *testing.T) {
+	pairs := filepath.Join(t.TempDir(), "pairs.json")
+	if err := os.WriteFile(pairs, []byte("[]"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	valid := func() Config {
+		config := defaultConfig()
+		if _, err := config.applyProfile(); err != nil {
+			t.Fatal(err)
+		}
+		config.Market.Pairs = pairs
+		// the profile's DEX list is shared, and tests change it
+		config.DEX = append([]DEXConfig{}, config.DEX...)
+		return config
+	}
+	if config := valid(); config.Validate() != nil {
+		t.Fatalf("default config invalid: %v", config.Validate())
+	}
+
+	tests := []struct {
+		name  string
+		spoil func(*Config)
+		want  []string
+	}{
+		{"one problem", func(c *Config) { c.Gas.BumpPercent = 5 }, []string{"Gas.BumpPercent"}},
+		{"several problems", func(c *Config) {
+			c.RPC.URL = ""
+			c.Thresholds.Slippage = 10000
+			c.Quote.MaxRoutes = c.Quote.MaxPaths + 1
+		}, []string{"RPC.URL", "Thresholds.Slippage", "Quote.MaxRoutes"}},
+		{"bad DEX fee and submit", func(c *Config) {
+			c.DEX[0].Fee = 0
+			c.Execution.Submit = "bundle"
+			c.Execution.Relay = ""
+		}, []string{"DEX 0", "Execution.Relay"}},
+	}
+	for _, test := range tests {
+		config := valid()
+		test.spoil(&config)
+		err := config.Validate()
+		if !errors.Is(err, ErrConfigInvalid) {
+			t.Errorf("%s: %v, want ErrConfigInvalid", test.name, err)
+			continue
+		}
+		if got := strings.Count(err.Error(), "\n  "); got != len(test.want) {
+			t.Errorf("%s: %d problems named, want %d: %v", test.name, got, len(test.want), err)
+		}
+		for _, want := range test.want {
+			if !strings.Contains(err.Error(), want) {
+				t.Errorf("%s: %q not named in %v", test.name, want, err)
+			}
+		}
+	}
+}
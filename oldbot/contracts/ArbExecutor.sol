@@ -0,0 +1,256 @@
+// SPDX-License-Identifier: MIT
+pragma solidity 0.8.21;
+
+interface IERC20 {
+    function balanceOf(address owner) external view returns (uint256);
+
+    function transfer(address to, uint256 value) external returns (bool);
+}
+
+interface IPancakePair {
+    function token0() external view returns (address);
+
+    function getReserves()
+        external
+        view
+        returns (
+            uint112 reserve0,
+            uint112 reserve1,
+            uint32 blockTimestampLast
+        );
+
+    function swap(
+        uint256 amount0Out,
+        uint256 amount1Out,
+        address to,
+        bytes calldata data
+    ) external;
+}
+
+/// @notice Runs a whole arbitrage loop in a single transaction. Tokens are
+/// pushed straight from one pair to the next so nothing is held between hops,
+/// and the call reverts unless the loop ends with more than it started with.
+/// Loops can also be funded by a flash swap on their first pool.
+contract ArbExecutor {
+    address public immutable owner;
+
+    // pool a flash swap is currently borrowing from, only set during
+    // executeFlash so callbacks from anywhere else are rejected
+    address private flashPool;
+
+    constructor() {
+        owner = msg.sender;
+    }
+
+    modifier onlyOwner() {
+        require(msg.sender == owner, "ArbExecutor: not owner");
+        _;
+    }
+
+    /// @param pools pairs to swap through, in order
+    /// @param tokens token sent into each pool, plus the token received from the
+    /// last one; tokens[0] and tokens[pools.length] must match
+    /// @param fees part of each swap input, out of 10000, left after the pool fee
+    /// @param minOuts least each pool may pay out before the loop reverts
+    /// @param amountIn amount of tokens[0], held by this contract, to start with
+    /// @param minProfit revert unless the balance of tokens[0] grows by this much
+    function execute(
+        address[] calldata pools,
+        address[] calldata tokens,
+        uint256[] calldata fees,
+        uint256[] calldata minOuts,
+        uint256 amountIn,
+        uint256 minProfit
+    ) external onlyOwner returns (uint256 profit) {
+        require(
+            pools.length > 1 &&
+                tokens.length == pools.length + 1 &&
+                fees.length == pools.length &&
+                minOuts.length == pools.length,
+            "ArbExecutor: bad path"
+        );
+        require(tokens[0] == tokens[pools.length], "ArbExecutor: not a loop");
+
+        uint256 balanceBefore = IERC20(tokens[0]).balanceOf(address(this));
+        _safeTransfer(tokens[0], pools[0], amountIn);
+        for (uint256 i = 0; i < pools.length; i++) {
+            address to = i + 1 < pools.length ? pools[i + 1] : address(this);
+            _swap(pools[i], tokens[i], fees[i], minOuts[i], to);
+        }
+        uint256 balanceAfter = IERC20(tokens[0]).balanceOf(address(this));
+        require(
+            balanceAfter >= balanceBefore + minProfit,
+            "ArbExecutor: unprofitable"
+        );
+        return balanceAfter - balanceBefore;
+    }
+
+    /// @notice Borrows borrowAmount of tokens[1] from pools[0], trades it through
+    /// the rest of the loop from the swap callback and pays pools[0] back with
+    /// repayAmount of tokens[0]. No starting balance is needed. minOuts[0] is
+    /// not checked since the borrowed amount is exact.
+    /// @param repayAmount what pools[0] must be sent for the borrow, fee included
+    function executeFlash(
+        address[] calldata pools,
+        address[] calldata tokens,
+        uint256[] calldata fees,
+        uint256[] calldata minOuts,
+        uint256 borrowAmount,
+        uint256 repayAmount,
+        uint256 minProfit
+    ) external onlyOwner returns (uint256 profit) {
+        require(
+            pools.length > 1 &&
+                tokens.length == pools.length + 1 &&
+                fees.length == pools.length &&
+                minOuts.length == pools.length,
+            "ArbExecutor: bad path"
+        );
+        require(tokens[0] == tokens[pools.length], "ArbExecutor: not a loop");
+        require(repayAmount > 0, "ArbExecutor: nothing to repay");
+
+        uint256 balanceBefore = IERC20(tokens[0]).balanceOf(address(this));
+        // the arguments are passed on to the callback as they came, already
+        // abi encoded
+        _borrow(pools[0], tokens[0], borrowAmount, msg.data[4:]);
+        uint256 balanceAfter = IERC20(tokens[0]).balanceOf(address(this));
+        require(
+            balanceAfter >= balanceBefore + minProfit,
+            "ArbExecutor: unprofitable"
+        );
+        return balanceAfter - balanceBefore;
+    }
+
+    // _borrow flash swaps amount of the token pool pays for tokenIn, handing
+    // data to the callback that trades it and pays the pool back.
+    function _borrow(
+        address pool,
+        address tokenIn,
+        uint256 amount,
+        bytes calldata data
+    ) private {
+        bool zeroForOne = IPancakePair(pool).token0() == tokenIn;
+        (uint256 amount0Out, uint256 amount1Out) = zeroForOne
+            ? (uint256(0), amount)
+            : (amount, uint256(0));
+
+        flashPool = pool;
+        IPancakePair(pool).swap(amount0Out, amount1Out, address(this), data);
+        flashPool = address(0);
+    }
+
+    function pancakeCall(
+        address sender,
+        uint256 amount0,
+        uint256 amount1,
+        bytes calldata data
+    ) external {
+        _flashCallback(sender, amount0 + amount1, data);
+    }
+
+    function BiswapCall(
+        address sender,
+        uint256 amount0,
+        uint256 amount1,
+        bytes calldata data
+    ) external {
+        _flashCallback(sender, amount0 + amount1, data);
+    }
+
+    function uniswapV2Call(
+        address sender,
+        uint256 amount0,
+        uint256 amount1,
+        bytes calldata data
+    ) external {
+        _flashCallback(sender, amount0 + amount1, data);
+    }
+
+    function withdraw(address token, uint256 amount) external onlyOwner {
+        _safeTransfer(token, owner, amount);
+    }
+
+    // _flashCallback runs the hops after the first one with the borrowed
+    // tokens, then repays the pool that lent them.
+    function _flashCallback(
+        address sender,
+        uint256 borrowed,
+        bytes calldata data
+    ) internal {
+        require(
+            msg.sender == flashPool && sender == address(this),
+            "ArbExecutor: unexpected callback"
+        );
+        (
+            address[] memory pools,
+            address[] memory tokens,
+            uint256[] memory fees,
+            uint256[] memory minOuts,
+            ,
+            uint256 repayAmount,
+
+        ) = abi.decode(
+                data,
+                (
+                    address[],
+                    address[],
+                    uint256[],
+                    uint256[],
+                    uint256,
+                    uint256,
+                    uint256
+                )
+            );
+
+        _safeTransfer(tokens[1], pools[1], borrowed);
+        for (uint256 i = 1; i < pools.length; i++) {
+            address to = i + 1 < pools.length ? pools[i + 1] : address(this);
+            _swap(pools[i], tokens[i], fees[i], minOuts[i], to);
+        }
+        _safeTransfer(tokens[0], pools[0], repayAmount);
+    }
+
+    // _swap prices whatever has been sent to pool since its last sync and
+    // forwards the output to the next hop, reverting if reserves moved enough
+    // that the output fell below minOut.
+    function _swap(
+        address pool,
+        address tokenIn,
+        uint256 fee,
+        uint256 minOut,
+        address to
+    ) internal returns (uint256 amountOut) {
+        (uint256 reserve0, uint256 reserve1, ) = IPancakePair(pool)
+            .getReserves();
+        bool zeroForOne = IPancakePair(pool).token0() == tokenIn;
+        (uint256 reserveIn, uint256 reserveOut) = zeroForOne
+            ? (reserve0, reserve1)
+            : (reserve1, reserve0);
+
+        uint256 amountIn = IERC20(tokenIn).balanceOf(pool) - reserveIn;
+        uint256 amountInWithFee = amountIn * fee;
+        amountOut =
+            (amountInWithFee * reserveOut) /
+            (reserveIn * 10000 + amountInWithFee);
+        require(amountOut >= minOut, "ArbExecutor: slippage");
+
+        (uint256 amount0Out, uint256 amount1Out) = zeroForOne
+            ? (uint256(0), amountOut)
+            : (amountOut, uint256(0));
+        IPancakePair(pool).swap(amount0Out, amount1Out, to, new bytes(0));
+    }
+
+    function _safeTransfer(
+        address token,
+        address to,
+        uint256 value
+    ) private {
+        (bool success, bytes memory data) = token.call(
+            abi.encodeWithSelector(IERC20.transfer.selector, to, value)
+        );
+        require(
+            success && (data.length == 0 || abi.decode(data, (bool))),
+            "ArbExecutor: transfer failed"
+        );
+    }
+}
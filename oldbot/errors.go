@@ -0,0 +1,130 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Kinds of failure, for errors.Is. Errors from the scan wrap one of them.
+var (
+	// ErrPoolUnreachable is a pool that no endpoint could be asked about.
+	ErrPoolUnreachable = errors.New("pool unreachable")
+	// ErrDecode is an answer or file that is not what was expected, like a
+	// pair that reverts on getReserves or a pairs file that is not JSON.
+	ErrDecode = errors.New("decode failure")
+	// ErrStaleData is state read from a head too old to trade on.
+	ErrStaleData = errors.New("stale data")
+	// ErrConfigInvalid is a config that cannot be run.
+	ErrConfigInvalid = errors.New("invalid config")
+)
+
+// PoolError is a failure to read one pool.
+type PoolError struct {
+	Pool common.Address
+	// Kind is ErrPoolUnreachable or ErrDecode.
+	Kind error
+	Err  error
+}
+
+func (e *PoolError) Error() string {
+	return fmt.Sprintf("pool %s: %v: %v", e.Pool.Hex(), e.Kind, e.Err)
+}
+
+func (e *PoolError) Unwrap() error { return e.Err }
+
+func (e *PoolError) Is(target error) bool { return target == e.Kind }
+
+// poolError classifies err from reading pool. Failures the RPC pool would
+// retry never reached a node; anything else is an answer that could not be
+// used.
+func poolError(pool common.Address, err error) *PoolError {
+	kind := ErrDecode
+	if retryable(err) {
+		kind = ErrPoolUnreachable
+	}
+	return &PoolError{Pool: pool, Kind: kind, Err: err}
+}
+
+const (
+	// quarantineAfter is how many scans in a row a pool may fail before it
+	// is skipped.
+	quarantineAfter = 3
+	quarantineFirst = time.Minute
+	quarantineMax   = 30 * time.Minute
+)
+
+type quarantineEntry struct {
+	failures int
+	until    time.Time
+	lastErr  *PoolError
+}
+
+// quarantine skips pools that keep failing, so one dead pair does not cost
+// every scan a round of retries. A quarantined pool is tried again once its
+// time is up, which doubles with each failure after that.
+type quarantine struct {
+	pools map[common.Address]*quarantineEntry
+	now   func() time.Time
+}
+
+func newQuarantine() *quarantine {
+	return &quarantine{pools: make(map[common.Address]*quarantineEntry), now: time.Now}
+}
+
+// skip reports whether pool is quarantined right now.
+func (q *quarantine) skip(pool common.Address) bool {
+	entry, ok := q.pools[pool]
+	return ok && q.now().Before(entry.until)
+}
+
+func (q *quarantine) failed(err *PoolError) {
+	entry, ok := q.pools[err.Pool]
+	if !ok {
+		entry = &quarantineEntry{}
+		q.pools[err.Pool] = entry
+	}
+	entry.failures++
+	entry.lastErr = err
+	if entry.failures < quarantineAfter {
+		return
+	}
+	wait := quarantineFirst << uint(entry.failures-quarantineAfter)
+	if wait > quarantineMax || wait <= 0 {
+		wait = quarantineMax
+	}
+	entry.until = q.now().Add(wait)
+}
+
+func (q *quarantine) succeeded(pool common.Address) {
+	delete(q.pools, pool)
+}
+
+// ScanReport is what a scan read and what it had to leave out.
+type ScanReport struct {
+	Block uint64
+	Pools int
+	// Failed are pools that could not be read this scan.
+	Failed []*PoolError
+	// Quarantined are pools skipped without trying, with their last error.
+	Quarantined []*PoolError
+}
+
+// summary is one line per skipped pool, failures first.
+func (r *ScanReport) summary() []string {
+	lines := []string{}
+	for _, err := range r.Failed {
+		lines = append(lines, "failed "+err.Error())
+	}
+	quarantined := append([]*PoolError{}, r.Quarantined...)
+	sort.Slice(quarantined, func(i, j int) bool {
+		return quarantined[i].Pool.Hex() < quarantined[j].Pool.Hex()
+	})
+	for _, err := range quarantined {
+		lines = append(lines, "quarantined "+err.Error())
+	}
+	return lines
+}
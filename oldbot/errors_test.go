@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+func TestPoolErrorKinds(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		kind error
+	}{
+		{"node overloaded", rpc.HTTPError{StatusCode: 503}, ErrPoolUnreachable},
+		{"timeout", context.DeadlineExceeded, ErrPoolUnreachable},
+		{"lagging node", testRPCError{-32000, "header not found"}, ErrPoolUnreachable},
+		{"reverted", testRPCError{3, "execution reverted"}, ErrDecode},
+		{"invalid argument", testRPCError{-32602, "invalid argument"}, ErrDecode},
+	}
+
+	pool := testToken(1)
+	for _, test := range tests {
+		err := poolError(pool, test.err)
+		if !errors.Is(err, test.kind) {
+			t.Errorf("%s: %v is not %v", test.name, err, test.kind)
+		}
+		for _, other := range []error{ErrPoolUnreachable, ErrDecode, ErrStaleData, ErrConfigInvalid} {
+			if other != test.kind && errors.Is(err, other) {
+				t.Errorf("%s: %v is also %v", test.name, err, other)
+			}
+		}
+		// rpc.HTTPError holds its body, so errors.Is cannot compare it
+		if cause := errors.Unwrap(err); cause == nil || cause.Error() != test.err.Error() {
+			t.Errorf("%s: %v does not wrap %v", test.name, err, test.err)
+		}
+		if !strings.Contains(err.Error(), pool.Hex()) {
+			t.Errorf("%s: %q does not name the pool", test.name, err)
+		}
+	}
+}
+
+func TestQuarantine(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	q := newQuarantine()
+	q.now = func() time.Time { return now }
+	pool := testToken(1)
+	fail := func() { q.failed(&PoolError{Pool: pool, Kind: ErrPoolUnreachable, Err: errors.New("timeout")}) }
+
+	for i := 1; i < quarantineAfter; i++ {
+		fail()
+		if q.skip(pool) {
+			t.Fatalf("skipped after %d failures", i)
+		}
+	}
+	// from the third failure in a row the wait starts at a minute and
+	// doubles with every failure after it, up to half an hour
+	waits := []time.Duration{time.Minute, 2 * time.Minute, 4 * time.Minute, 8 * time.Minute, 16 * time.Minute, 30 * time.Minute, 30 * time.Minute}
+	for i, wait := range waits {
+		fail()
+		now = now.Add(wait - time.Second)
+		if !q.skip(pool) {
+			t.Errorf("failure %d: released before %s", quarantineAfter+i, wait)
+		}
+		now = now.Add(time.Second)
+		if q.skip(pool) {
+			t.Errorf("failure %d: still skipped after %s", quarantineAfter+i, wait)
+		}
+	}
+
+	// a read that works releases the pool and forgets its failures
+	q.succeeded(pool)
+	fail()
+	if q.skip(pool) {
+		t.Errorf("skipped after one failure since it was released")
+	}
+	if other := testToken(2); q.skip(other) {
+		t.Errorf("skipped a pool that never failed")
+	}
+}
+
+func TestFetchPoolsReport(t *testing.T) {
+	node := newFakeEndpoint(t, 100)
+	defer node.Close()
+	node.failMethod, node.status = "eth_call", http.StatusServiceUnavailable
+	client := dialFakeEndpoints(t, RPCConfig{}, node)
+	defer client.Close()
+
+	now := time.Unix(1700000000, 0)
+	skipped := newQuarantine()
+	skipped.now = func() time.Time { return now }
+	pairs := []PairIn{testPool(1, 2).in}
+	block := big.NewInt(100)
+
+	for scan := 1; scan <= quarantineAfter; scan++ {
+		pools, report := fetchPools(context.Background(), pairs, client, block, skipped)
+		if len(pools) != 0 || report.Pools != 0 || report.Block != 100 {
+			t.Errorf("scan %d: read %d pools at %d, want none at 100", scan, report.Pools, report.Block)
+		}
+		if len(report.Failed) != 1 || len(report.Quarantined) != 0 {
+			t.Fatalf("scan %d: %d failed %d quarantined, want 1 failed", scan, len(report.Failed), len(report.Quarantined))
+		}
+		if failed := report.Failed[0]; failed.Pool != pairs[0].Factory || !errors.Is(failed, ErrPoolUnreachable) {
+			t.Errorf("scan %d: failure %v, want %s unreachable", scan, failed, pairs[0].Factory)
+		}
+	}
+
+	calls := node.called("eth_call")
+	_, report := fetchPools(context.Background(), pairs, client, block, skipped)
+	if node.called("eth_call") != calls {
+		t.Errorf("quarantined pool was read")
+	}
+	if len(report.Failed) != 0 || len(report.Quarantined) != 1 || report.Quarantined[0].Pool != pairs[0].Factory {
+		t.Errorf("quarantined scan reported %v failed %v quarantined", report.Failed, report.Quarantined)
+	}
+	summary := report.summary()
+	if len(summary) != 1 || !strings.HasPrefix(summary[0], "quarantined pool "+pairs[0].Factory.Hex()) {
+		t.Errorf("summary %q", summary)
+	}
+
+	now = now.Add(quarantineFirst)
+	if _, report := fetchPools(context.Background(), pairs, client, block, skipped); len(report.Failed) != 1 {
+		t.Errorf("pool not tried again once its time was up")
+	}
+}
+
+func TestScanReportSummary(t *testing.T) {
+	failed := &PoolError{Pool: testToken(3), Kind: ErrDecode, Err: errors.New("execution reverted")}
+	report := &ScanReport{
+		Failed: []*PoolError{failed},
+		Quarantined: []*PoolError{
+			{Pool: testToken(2), Kind: ErrPoolUnreachable, Err: errors.New("timeout")},
+			{Pool: testToken(1), Kind: ErrPoolUnreachable, Err: errors.New("timeout")},
+		},
+	}
+	want := []string{
+		"failed pool " + testToken(3).Hex() + ": decode failure: execution reverted",
+		"quarantined pool " + testToken(1).Hex() + ": pool unreachable: timeout",
+		"quarantined pool " + testToken(2).Hex() + ": pool unreachable: timeout",
+	}
+	got := report.summary()
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("summary\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
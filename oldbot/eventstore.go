@@ -0,0 +1,777 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"strconv"
+	"time"
+
+	"example.com/m/pancakeFactory"
+	"example.com/m/pancakePair"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/ethdb/leveldb"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// StoreConfig places the event store and says where its history starts.
+type StoreConfig struct {
+	Path string
+	// FromBlock is the first block `store sync` ingests.
+	FromBlock   uint64
+	BatchBlocks uint64
+}
+
+// Kinds of stored event.
+const (
+	eventSync        = "sync"
+	eventSwap        = "swap"
+	eventMint        = "mint"
+	eventBurn        = "burn"
+	eventPairCreated = "pairCreated"
+)
+
+// PoolEvent is one stored log of a pool, or of a factory creating it. Only
+// the fields of its Kind are set.
+type PoolEvent struct {
+	Kind      string
+	Pool      common.Address
+	Block     uint64
+	BlockHash common.Hash
+	Tx        common.Hash
+	Index     uint
+
+	Reserve0 *big.Int `json:",omitempty"`
+	Reserve1 *big.Int `json:",omitempty"`
+
+	Sender     *common.Address `json:",omitempty"`
+	To         *common.Address `json:",omitempty"`
+	Amount0In  *big.Int        `json:",omitempty"`
+	Amount1In  *big.Int        `json:",omitempty"`
+	Amount0Out *big.Int        `json:",omitempty"`
+	Amount1Out *big.Int        `json:",omitempty"`
+	Amount0    *big.Int        `json:",omitempty"`
+	Amount1    *big.Int        `json:",omitempty"`
+
+	Factory *common.Address `json:",omitempty"`
+	Token0  *common.Address `json:",omitempty"`
+	Token1  *common.Address `json:",omitempty"`
+}
+
+// eventBackend is what ingesting needs from a node. RPCPool satisfies it.
+type eventBackend interface {
+	FilterLogs(ctx context.Context, query ethereum.FilterQuery) ([]types.Log, error)
+	HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error)
+}
+
+// storeReorgDepth is how many stored block hashes are checked against the
+// chain before ingesting. BSC reorgs are a few blocks deep.
+const storeReorgDepth = 64
+
+// storeAddressChunk bounds the addresses of one eth_getLogs query.
+const storeAddressChunk = 200
+
+// Key layout. Numbers are big-endian so keys sort by block; ^n sorts
+// newest first, so a forward iterator finds the latest entry at or before
+// a block.
+//
+//	e pool block index   -> PoolEvent, every event of a pool
+//	s pool ^block ^index -> PoolEvent of a Sync
+//	c pair               -> PoolEvent of its PairCreated
+//	k block index        -> kind and pool, to roll back by block
+//	h ^block             -> hash of an ingested block
+//	i address            -> block ranges ingested for a pool or factory
+//	ranges               -> block ranges ingested for any address
+var (
+	eventPrefix    = []byte("e")
+	syncPrefix     = []byte("s")
+	createdPrefix  = []byte("c")
+	blockPrefix    = []byte("k")
+	hashPrefix     = []byte("h")
+	ingestedPrefix = []byte("i")
+	rangesKey      = []byte("ranges")
+	errNotIngested = errors.New("block not ingested")
+)
+
+func be64(n uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, n)
+	return b
+}
+
+func be32(n uint32) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, n)
+	return b
+}
+
+func storeKey(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
+
+func eventKey(e *PoolEvent) []byte {
+	return storeKey(eventPrefix, e.Pool.Bytes(), be64(e.Block), be32(uint32(e.Index)))
+}
+
+func syncKey(e *PoolEvent) []byte {
+	return storeKey(syncPrefix, e.Pool.Bytes(), be64(^e.Block), be32(^uint32(e.Index)))
+}
+
+func blockKey(block uint64, index uint) []byte {
+	return storeKey(blockPrefix, be64(block), be32(uint32(index)))
+}
+
+// EventStore keeps pool events in LevelDB, indexed by pool and block.
+type EventStore struct {
+	db     ethdb.KeyValueStore
+	pair   *pancakePair.PancakePairFilterer
+	topics map[common.Hash]string
+}
+
+// OpenEventStore opens or creates the store at path.
+func OpenEventStore(path string) (*EventStore, error) {
+	db, err := leveldb.New(path, 64, 64, "", false)
+	if err != nil {
+		return nil, err
+	}
+	return newEventStore(db)
+}
+
+func newEventStore(db ethdb.KeyValueStore) (*EventStore, error) {
+	pair, err := pancakePair.NewPancakePairFilterer(common.Address{}, nil)
+	if err != nil {
+		return nil, err
+	}
+	pairABI, err := pancakePair.PancakePairMetaData.GetAbi()
+	if err != nil {
+		return nil, err
+	}
+	factoryABI, err := pancakeFactory.PancakeFactoryMetaData.GetAbi()
+	if err != nil {
+		return nil, err
+	}
+	return &EventStore{db: db, pair: pair, topics: map[common.Hash]string{
+		pairABI.Events["Sync"].ID:           eventSync,
+		pairABI.Events["Swap"].ID:           eventSwap,
+		pairABI.Events["Mint"].ID:           eventMint,
+		pairABI.Events["Burn"].ID:           eventBurn,
+		factoryABI.Events["PairCreated"].ID: eventPairCreated,
+	}}, nil
+}
+
+func (s *EventStore) Close() error {
+	return s.db.Close()
+}
+
+func (s *EventStore) topicsOf(kinds ...string) []common.Hash {
+	topics := []common.Hash{}
+	for topic, kind := range s.topics {
+		for _, want := range kinds {
+			if kind == want {
+				topics = append(topics, topic)
+			}
+		}
+	}
+	return topics
+}
+
+// decode turns a log into a PoolEvent with the pair and factory bindings.
+func (s *EventStore) decode(log types.Log) (*PoolEvent, error) {
+	if len(log.Topics) == 0 {
+		return nil, fmt.Errorf("%w: log %d of block %d has no topics", ErrDecode, log.Index, log.BlockNumber)
+	}
+	e := &PoolEvent{
+		Kind:      s.topics[log.Topics[0]],
+		Pool:      log.Address,
+		Block:     log.BlockNumber,
+		BlockHash: log.BlockHash,
+		Tx:        log.TxHash,
+		Index:     log.Index,
+	}
+	var err error
+	switch e.Kind {
+	case eventSync:
+		var sync *pancakePair.PancakePairSync
+		if sync, err = s.pair.ParseSync(log); err == nil {
+			e.Reserve0, e.Reserve1 = sync.Reserve0, sync.Reserve1
+		}
+	case eventSwap:
+		var swap *pancakePair.PancakePairSwap
+		if swap, err = s.pair.ParseSwap(log); err == nil {
+			e.Sender, e.To = &swap.Sender, &swap.To
+			e.Amount0In, e.Amount1In, e.Amount0Out, e.Amount1Out = swap.Amount0In, swap.Amount1In, swap.Amount0Out, swap.Amount1Out
+		}
+	case eventMint:
+		var mint *pancakePair.PancakePairMint
+		if mint, err = s.pair.ParseMint(log); err == nil {
+			e.Sender = &mint.Sender
+			e.Amount0, e.Amount1 = mint.Amount0, mint.Amount1
+		}
+	case eventBurn:
+		var burn *pancakePair.PancakePairBurn
+		if burn, err = s.pair.ParseBurn(log); err == nil {
+			e.Sender, e.To = &burn.Sender, &burn.To
+			e.Amount0, e.Amount1 = burn.Amount0, burn.Amount1
+		}
+	case eventPairCreated:
+		var factory *pancakeFactory.PancakeFactoryFilterer
+		if factory, err = pancakeFactory.NewPancakeFactoryFilterer(log.Address, nil); err != nil {
+			return nil, err
+		}
+		var created *pancakeFactory.PancakeFactoryPairCreated
+		if created, err = factory.ParsePairCreated(log); err == nil {
+			e.Pool = created.Pair
+			e.Factory = &log.Address
+			e.Token0, e.Token1 = &created.Token0, &created.Token1
+		}
+	default:
+		return nil, fmt.Errorf("%w: unknown event %s", ErrDecode, log.Topics[0].Hex())
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s in block %d: %v", ErrDecode, e.Kind, log.BlockNumber, err)
+	}
+	return e, nil
+}
+
+// put stages an event under every index it belongs to.
+func (s *EventStore) put(batch ethdb.Batch, e *PoolEvent) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if err := batch.Put(eventKey(e), data); err != nil {
+		return err
+	}
+	switch e.Kind {
+	case eventSync:
+		if err := batch.Put(syncKey(e), data); err != nil {
+			return err
+		}
+	case eventPairCreated:
+		if err := batch.Put(storeKey(createdPrefix, e.Pool.Bytes()), data); err != nil {
+			return err
+		}
+	}
+	if err := batch.Put(blockKey(e.Block, e.Index), append([]byte(e.Kind+":"), e.Pool.Bytes()...)); err != nil {
+		return err
+	}
+	return batch.Put(storeKey(hashPrefix, be64(^e.Block)), e.BlockHash.Bytes())
+}
+
+// Ingest stores every Sync, Swap, Mint and Burn of pools and every
+// PairCreated of factories from `from` to `to`, batch blocks at a time.
+// Each batch is written atomically with its range, recorded for every one
+// of the addresses, so an interrupted ingest leaves a gap rather than half
+// a batch. Stored blocks that the chain has since reorganized away are
+// rolled back first.
+func (s *EventStore) Ingest(ctx context.Context, backend eventBackend, pools, factories []common.Address, from, to, batch uint64) error {
+	if err := s.CheckReorg(ctx, backend); err != nil {
+		return err
+	}
+	pairTopics := s.topicsOf(eventSync, eventSwap, eventMint, eventBurn)
+	factoryTopics := s.topicsOf(eventPairCreated)
+	for lo := from; lo <= to; lo += batch {
+		hi := lo + batch - 1
+		if hi > to {
+			hi = to
+		}
+		logs := []types.Log{}
+		queries := []ethereum.FilterQuery{}
+		for start := 0; start < len(pools); start += storeAddressChunk {
+			end := start + storeAddressChunk
+			if end > len(pools) {
+				end = len(pools)
+			}
+			queries = append(queries, ethereum.FilterQuery{Addresses: pools[start:end], Topics: [][]common.Hash{pairTopics}})
+		}
+		if len(factories) > 0 {
+			queries = append(queries, ethereum.FilterQuery{Addresses: factories, Topics: [][]common.Hash{factoryTopics}})
+		}
+		for _, query := range queries {
+			query.FromBlock, query.ToBlock = new(big.Int).SetUint64(lo), new(big.Int).SetUint64(hi)
+			found, err := backend.FilterLogs(ctx, query)
+			if err != nil {
+				return fmt.Errorf("store: logs of blocks %d-%d: %w", lo, hi, err)
+			}
+			logs = append(logs, found...)
+		}
+		last, err := backend.HeaderByNumber(ctx, new(big.Int).SetUint64(hi))
+		if err != nil {
+			return err
+		}
+
+		write := s.db.NewBatch()
+		for _, log := range logs {
+			if log.Removed {
+				continue
+			}
+			e, err := s.decode(log)
+			if err != nil {
+				return err
+			}
+			if err := s.put(write, e); err != nil {
+				return err
+			}
+		}
+		// The last block's hash is kept even without logs, so a reorg of
+		// quiet blocks is still noticed
+		if err := write.Put(storeKey(hashPrefix, be64(^hi)), last.Hash().Bytes()); err != nil {
+			return err
+		}
+		ingested := [2]uint64{lo, hi}
+		for _, address := range append(append([]common.Address{}, pools...), factories...) {
+			key := storeKey(ingestedPrefix, address.Bytes())
+			ranges, err := s.readRanges(key)
+			if err != nil {
+				return err
+			}
+			if err := s.putRanges(write, key, addRange(ranges, ingested)); err != nil {
+				return err
+			}
+		}
+		ranges, err := s.Ranges()
+		if err != nil {
+			return err
+		}
+		if err := s.putRanges(write, rangesKey, addRange(ranges, ingested)); err != nil {
+			return err
+		}
+		if err := write.Write(); err != nil {
+			return err
+		}
+		log.Info("Stored events", "events", len(logs), "from", lo, "to", hi)
+	}
+	return nil
+}
+
+// Backfill ingests every block from `from` to `to` that pools or factories
+// are missing, each for just the addresses missing it. Addresses new to
+// the store get their whole history while the rest only catch up, so
+// adding pools never leaves them without the blocks already stored.
+func (s *EventStore) Backfill(ctx context.Context, backend eventBackend, pools, factories []common.Address, from, to, batch uint64) error {
+	// A reorg rolls back the newest blocks, so check before the gaps
+	if err := s.CheckReorg(ctx, backend); err != nil {
+		return err
+	}
+	type missing struct {
+		gaps      [][2]uint64
+		pools     []common.Address
+		factories []common.Address
+	}
+	groups := make(map[string]*missing)
+	keys := []string{}
+	group := func(address common.Address) (*missing, error) {
+		ranges, err := s.readRanges(storeKey(ingestedPrefix, address.Bytes()))
+		if err != nil {
+			return nil, err
+		}
+		gaps := gapsIn(ranges, from, to)
+		if len(gaps) == 0 {
+			return nil, nil
+		}
+		key := fmt.Sprint(gaps)
+		if groups[key] == nil {
+			groups[key] = &missing{gaps: gaps}
+			keys = append(keys, key)
+		}
+		return groups[key], nil
+	}
+	for _, pool := range pools {
+		g, err := group(pool)
+		if err != nil {
+			return err
+		}
+		if g != nil {
+			g.pools = append(g.pools, pool)
+		}
+	}
+	for _, factory := range factories {
+		g, err := group(factory)
+		if err != nil {
+			return err
+		}
+		if g != nil {
+			g.factories = append(g.factories, factory)
+		}
+	}
+	for _, key := range keys {
+		g := groups[key]
+		log.Info("Backfilling events", "pools", len(g.pools), "factories", len(g.factories), "gaps", g.gaps)
+		for _, gap := range g.gaps {
+			if err := s.Ingest(ctx, backend, g.pools, g.factories, gap[0], gap[1], batch); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// CheckReorg compares the newest stored block hashes with the chain's and
+// rolls back everything above the newest block both agree on.
+func (s *EventStore) CheckReorg(ctx context.Context, backend eventBackend) error {
+	it := s.db.NewIterator(hashPrefix, nil)
+	defer it.Release()
+	orphaned := uint64(0)
+	for checked := 0; checked < storeReorgDepth && it.Next(); checked++ {
+		block := ^binary.BigEndian.Uint64(it.Key()[len(hashPrefix):])
+		header, err := backend.HeaderByNumber(ctx, new(big.Int).SetUint64(block))
+		if err != nil {
+			return err
+		}
+		if header.Hash() == common.BytesToHash(it.Value()) {
+			break
+		}
+		orphaned = block
+	}
+	if err := it.Error(); err != nil {
+		return err
+	}
+	if orphaned == 0 {
+		return nil
+	}
+	log.Warn("Reorg: rolling back stored blocks", "from", orphaned)
+	return s.Rollback(orphaned)
+}
+
+// Rollback deletes every event, hash and range of block `from` onwards.
+func (s *EventStore) Rollback(from uint64) error {
+	write := s.db.NewBatch()
+	it := s.db.NewIterator(blockPrefix, be64(from))
+	for it.Next() {
+		key := it.Key()[len(blockPrefix):]
+		block := binary.BigEndian.Uint64(key)
+		index := uint(binary.BigEndian.Uint32(key[8:]))
+		value := it.Value()
+		sep := bytes.IndexByte(value, ':')
+		e := &PoolEvent{Kind: string(value[:sep]), Pool: common.BytesToAddress(value[sep+1:]), Block: block, Index: index}
+		write.Delete(eventKey(e))
+		switch e.Kind {
+		case eventSync:
+			write.Delete(syncKey(e))
+		case eventPairCreated:
+			write.Delete(storeKey(createdPrefix, e.Pool.Bytes()))
+		}
+		write.Delete(append([]byte{}, it.Key()...))
+	}
+	it.Release()
+	if err := it.Error(); err != nil {
+		return err
+	}
+
+	hashes := s.db.NewIterator(hashPrefix, nil)
+	for hashes.Next() {
+		if ^binary.BigEndian.Uint64(hashes.Key()[len(hashPrefix):]) < from {
+			break
+		}
+		write.Delete(append([]byte{}, hashes.Key()...))
+	}
+	hashes.Release()
+	if err := hashes.Error(); err != nil {
+		return err
+	}
+
+	keys := [][]byte{rangesKey}
+	addresses := s.db.NewIterator(ingestedPrefix, nil)
+	for addresses.Next() {
+		keys = append(keys, append([]byte{}, addresses.Key()...))
+	}
+	addresses.Release()
+	if err := addresses.Error(); err != nil {
+		return err
+	}
+	for _, key := range keys {
+		ranges, err := s.readRanges(key)
+		if err != nil {
+			return err
+		}
+		if err := s.putRanges(write, key, cutRanges(ranges, from)); err != nil {
+			return err
+		}
+	}
+	return write.Write()
+}
+
+// cutRanges is ranges without block `from` onwards.
+func cutRanges(ranges [][2]uint64, from uint64) [][2]uint64 {
+	kept := [][2]uint64{}
+	for _, r := range ranges {
+		if r[0] >= from {
+			continue
+		}
+		if r[1] >= from {
+			r[1] = from - 1
+		}
+		kept = append(kept, r)
+	}
+	return kept
+}
+
+// Ranges are the block ranges ingested for any address, merged and in
+// order.
+func (s *EventStore) Ranges() ([][2]uint64, error) {
+	return s.readRanges(rangesKey)
+}
+
+// AddressRanges are the block ranges ingested for a pool or factory.
+func (s *EventStore) AddressRanges(address common.Address) ([][2]uint64, error) {
+	return s.readRanges(storeKey(ingestedPrefix, address.Bytes()))
+}
+
+func (s *EventStore) readRanges(key []byte) ([][2]uint64, error) {
+	if has, err := s.db.Has(key); err != nil || !has {
+		return [][2]uint64{}, err
+	}
+	data, err := s.db.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	var ranges [][2]uint64
+	if err := json.Unmarshal(data, &ranges); err != nil {
+		return nil, fmt.Errorf("%w: store ranges: %v", ErrDecode, err)
+	}
+	return ranges, nil
+}
+
+func (s *EventStore) putRanges(batch ethdb.Batch, key []byte, ranges [][2]uint64) error {
+	data, err := json.Marshal(ranges)
+	if err != nil {
+		return err
+	}
+	return batch.Put(key, data)
+}
+
+// addRange merges r into sorted, merged ranges.
+func addRange(ranges [][2]uint64, r [2]uint64) [][2]uint64 {
+	all := append(append([][2]uint64{}, ranges...), r)
+	sort.Slice(all, func(i, j int) bool { return all[i][0] < all[j][0] })
+	merged := [][2]uint64{}
+	for _, next := range all {
+		if n := len(merged); n > 0 && next[0] <= merged[n-1][1]+1 {
+			if next[1] > merged[n-1][1] {
+				merged[n-1][1] = next[1]
+			}
+			continue
+		}
+		merged = append(merged, next)
+	}
+	return merged
+}
+
+// Gaps are the blocks between from and to that have not been ingested for
+// every one of addresses.
+func (s *EventStore) Gaps(addresses []common.Address, from, to uint64) ([][2]uint64, error) {
+	gaps := [][2]uint64{}
+	for _, address := range addresses {
+		ranges, err := s.AddressRanges(address)
+		if err != nil {
+			return nil, err
+		}
+		for _, gap := range gapsIn(ranges, from, to) {
+			gaps = addRange(gaps, gap)
+		}
+	}
+	return gaps, nil
+}
+
+// gapsIn are the blocks between from and to outside ranges.
+func gapsIn(ranges [][2]uint64, from, to uint64) [][2]uint64 {
+	gaps := [][2]uint64{}
+	next := from
+	for _, r := range ranges {
+		if r[1] < next {
+			continue
+		}
+		if r[0] > to {
+			break
+		}
+		if r[0] > next {
+			gaps = append(gaps, [2]uint64{next, r[0] - 1})
+		}
+		next = r[1] + 1
+		if next > to {
+			return gaps
+		}
+	}
+	if next <= to {
+		gaps = append(gaps, [2]uint64{next, to})
+	}
+	return gaps
+}
+
+// ReservesAt is pool's reserves at the end of block, from its latest Sync
+// at or before it. The blocks from that Sync up to block must all be
+// ingested for pool, or a later Sync could have been missed.
+func (s *EventStore) ReservesAt(pool common.Address, block uint64) (*big.Int, *big.Int, error) {
+	ranges, err := s.AddressRanges(pool)
+	if err != nil {
+		return nil, nil, err
+	}
+	var covering *[2]uint64
+	for i := range ranges {
+		if ranges[i][0] <= block && block <= ranges[i][1] {
+			covering = &ranges[i]
+		}
+	}
+	if covering == nil {
+		return nil, nil, fmt.Errorf("store: %w: %d", errNotIngested, block)
+	}
+
+	prefix := storeKey(syncPrefix, pool.Bytes())
+	it := s.db.NewIterator(prefix, be64(^block))
+	defer it.Release()
+	if !it.Next() {
+		if err := it.Error(); err != nil {
+			return nil, nil, err
+		}
+		return nil, nil, fmt.Errorf("store: no Sync of %s at or before block %d", pool.Hex(), block)
+	}
+	var e PoolEvent
+	if err := json.Unmarshal(it.Value(), &e); err != nil {
+		return nil, nil, fmt.Errorf("%w: stored Sync of %s: %v", ErrDecode, pool.Hex(), err)
+	}
+	if e.Block < covering[0] {
+		return nil, nil, fmt.Errorf("store: %w: gap between the Sync of %s in block %d and block %d", errNotIngested, pool.Hex(), e.Block, block)
+	}
+	return e.Reserve0, e.Reserve1, nil
+}
+
+// Events are pool's stored events from `from` to `to`, in order.
+func (s *EventStore) Events(pool common.Address, from, to uint64) ([]PoolEvent, error) {
+	prefix := storeKey(eventPrefix, pool.Bytes())
+	it := s.db.NewIterator(prefix, be64(from))
+	defer it.Release()
+	events := []PoolEvent{}
+	for it.Next() {
+		if binary.BigEndian.Uint64(it.Key()[len(prefix):]) > to {
+			break
+		}
+		var e PoolEvent
+		if err := json.Unmarshal(it.Value(), &e); err != nil {
+			return nil, fmt.Errorf("%w: stored event of %s: %v", ErrDecode, pool.Hex(), err)
+		}
+		events = append(events, e)
+	}
+	return events, it.Error()
+}
+
+// Each calls fn with every stored event from `from` to `to`, across all
+// pools, in block and log order.
+func (s *EventStore) Each(from, to uint64, fn func(PoolEvent) error) error {
+	it := s.db.NewIterator(blockPrefix, be64(from))
+	defer it.Release()
+	for it.Next() {
+		key := it.Key()[len(blockPrefix):]
+		block := binary.BigEndian.Uint64(key)
+		if block > to {
+			break
+		}
+		value := it.Value()
+		sep := bytes.IndexByte(value, ':')
+		e := &PoolEvent{Pool: common.BytesToAddress(value[sep+1:]), Block: block, Index: uint(binary.BigEndian.Uint32(key[8:]))}
+		data, err := s.db.Get(eventKey(e))
+		if err != nil {
+			return fmt.Errorf("store: event %d of block %d: %w", e.Index, block, err)
+		}
+		if err := json.Unmarshal(data, e); err != nil {
+			return fmt.Errorf("%w: stored event %d of block %d: %v", ErrDecode, e.Index, block, err)
+		}
+		if err := fn(*e); err != nil {
+			return err
+		}
+	}
+	return it.Error()
+}
+
+// Created is the PairCreated event of pair, if it was ingested.
+func (s *EventStore) Created(pair common.Address) (*PoolEvent, bool, error) {
+	key := storeKey(createdPrefix, pair.Bytes())
+	if has, err := s.db.Has(key); err != nil || !has {
+		return nil, false, err
+	}
+	data, err := s.db.Get(key)
+	if err != nil {
+		return nil, false, err
+	}
+	var e PoolEvent
+	if err := json.Unmarshal(data, &e); err != nil {
+		return nil, false, fmt.Errorf("%w: stored PairCreated of %s: %v", ErrDecode, pair.Hex(), err)
+	}
+	return &e, true, nil
+}
+
+// storeCommand runs `store sync`, which ingests every gap of each pool and
+// factory from Store.FromBlock to the head, `store gaps`, or `store
+// reserves <pool> <block>`.
+func storeCommand(ctx context.Context, profile ChainProfile, config Config, args []string) error {
+	store, err := OpenEventStore(config.Store.Path)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	sub := ""
+	if len(args) > 0 {
+		sub = args[0]
+	}
+	switch sub {
+	case "sync":
+		market, err := newChainMarket(ctx, profile, config.RPC, config.Market, config.DEX, config.Sources)
+		if err != nil {
+			return err
+		}
+		head, err := market.client.BlockNumber(ctx)
+		if err != nil {
+			return err
+		}
+		from := config.Store.FromBlock
+		// a chain younger than a day is synced from genesis
+		if day := profile.blocksPer(24 * time.Hour); from == 0 && head > day {
+			from = head - day
+		}
+		pools := make([]common.Address, len(market.pairs))
+		for i, pair := range market.pairs {
+			pools[i] = pair.Factory
+		}
+		factories := make([]common.Address, 0, len(config.DEX))
+		for _, dex := range config.DEX {
+			factories = append(factories, dex.Factory)
+		}
+		return store.Backfill(ctx, market.client, pools, factories, from, head, config.Store.BatchBlocks)
+	case "gaps":
+		ranges, err := store.Ranges()
+		if err != nil {
+			return err
+		}
+		if len(ranges) == 0 {
+			fmt.Println("Nothing stored")
+			return nil
+		}
+		fmt.Println("Stored blocks: ", ranges)
+		fmt.Println("Gaps: ", gapsIn(ranges, ranges[0][0], ranges[len(ranges)-1][1]))
+		return nil
+	case "reserves":
+		if len(args) != 3 || !common.IsHexAddress(args[1]) {
+			return errors.New("store: want reserves <pool> <block>")
+		}
+		block, err := strconv.ParseUint(args[2], 10, 64)
+		if err != nil {
+			return err
+		}
+		reserve0, reserve1, err := store.ReservesAt(common.HexToAddress(args[1]), block)
+		if err != nil {
+			return err
+		}
+		fmt.Println("Reserves: ", reserve0, reserve1)
+		return nil
+	default:
+		return fmt.Errorf("store: want sync, gaps or reserves, got %q", sub)
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"reflect"
+	"testing"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethdb/memorydb"
+)
+
+// fakeChain serves logs by address, topic and block, and headers whose
+// hash changes with the block's fork.
+type fakeChain struct {
+	logs    []types.Log
+	fork    map[uint64]byte
+	queries []ethereum.FilterQuery
+}
+
+func (c *fakeChain) FilterLogs(ctx context.Context, query ethereum.FilterQuery) ([]types.Log, error) {
+	c.queries = append(c.queries, query)
+	found := []types.Log{}
+	for _, log := range c.logs {
+		if log.BlockNumber < query.FromBlock.Uint64() || log.BlockNumber > query.ToBlock.Uint64() {
+			continue
+		}
+		if !containsAddress(query.Addresses, log.Address) || !containsHash(query.Topics[0], log.Topics[0]) {
+			continue
+		}
+		log.BlockHash = c.header(log.BlockNumber).Hash()
+		found = append(found, log)
+	}
+	return found, nil
+}
+
+func (c *fakeChain) HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error) {
+	return c.header(number.Uint64()), nil
+}
+
+func (c *fakeChain) header(number uint64) *types.Header {
+	return &types.Header{Number: new(big.Int).SetUint64(number), Extra: []byte{c.fork[number]}}
+}
+
+func containsAddress(addresses []common.Address, address common.Address) bool {
+	for _, a := range addresses {
+		if a == address {
+			return true
+		}
+	}
+	return false
+}
+
+func containsHash(hashes []common.Hash, hash common.Hash) bool {
+	for _, h := range hashes {
+		if h == hash {
+			return true
+		}
+	}
+	return false
+}
+
+func syncLog(store *EventStore, pool common.Address, block uint64, index uint, reserve0, reserve1 int64) types.Log {
+	data := append(common.LeftPadBytes(big.NewInt(reserve0).Bytes(), 32), common.LeftPadBytes(big.NewInt(reserve1).Bytes(), 32)...)
+	return types.Log{Address: pool, Topics: []common.Hash{store.topicsOf(eventSync)[0]}, Data: data, BlockNumber: block, Index: index}
+}
+
+func TestEventStore(t *testing.T) {
+	ctx := context.Background()
+	store, err := newEventStore(memorydb.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	old, added := common.HexToAddress("0xa1"), common.HexToAddress("0xa2")
+	chain := &fakeChain{fork: map[uint64]byte{}, logs: []types.Log{
+		syncLog(store, old, 3, 0, 100, 200),
+		syncLog(store, old, 8, 1, 110, 190),
+		syncLog(store, added, 5, 0, 7, 9),
+		syncLog(store, old, 14, 0, 120, 180),
+	}}
+
+	if err := store.Backfill(ctx, chain, []common.Address{old}, nil, 1, 10, 4); err != nil {
+		t.Fatal(err)
+	}
+	// a pool added later is backfilled over the blocks the others have
+	chain.queries = nil
+	if err := store.Backfill(ctx, chain, []common.Address{old, added}, nil, 1, 16, 100); err != nil {
+		t.Fatal(err)
+	}
+	queried := map[common.Address][2]uint64{}
+	for _, query := range chain.queries {
+		for _, address := range query.Addresses {
+			queried[address] = [2]uint64{query.FromBlock.Uint64(), query.ToBlock.Uint64()}
+		}
+	}
+	if want := map[common.Address][2]uint64{old: {11, 16}, added: {1, 16}}; !reflect.DeepEqual(queried, want) {
+		t.Errorf("backfill queried %v, want %v", queried, want)
+	}
+
+	gaps := []struct {
+		addresses []common.Address
+		from, to  uint64
+		want      [][2]uint64
+	}{
+		{[]common.Address{old}, 1, 16, [][2]uint64{}},
+		{[]common.Address{old, added}, 0, 20, [][2]uint64{{0, 0}, {17, 20}}},
+		{[]common.Address{common.HexToAddress("0xa3")}, 5, 6, [][2]uint64{{5, 6}}},
+		{nil, 5, 6, [][2]uint64{}},
+	}
+	for _, test := range gaps {
+		got, err := store.Gaps(test.addresses, test.from, test.to)
+		if err != nil || !reflect.DeepEqual(got, test.want) {
+			t.Errorf("gaps of %v in %d-%d: %v (%v), want %v", test.addresses, test.from, test.to, got, err, test.want)
+		}
+	}
+
+	type reservesAt struct {
+		pool     common.Address
+		block    uint64
+		reserve0 int64
+		err      error
+	}
+	reserves := []reservesAt{
+		{old, 3, 100, nil},
+		{old, 7, 100, nil},
+		{old, 8, 110, nil},
+		{old, 16, 120, nil},
+		{added, 9, 7, nil},
+		{old, 17, 0, errNotIngested},
+		{old, 2, 0, errors.New("no Sync")},
+	}
+	check := func(stage string) {
+		for _, test := range reserves {
+			reserve0, _, err := store.ReservesAt(test.pool, test.block)
+			switch {
+			case test.err == errNotIngested:
+				if !errors.Is(err, errNotIngested) {
+					t.Errorf("%s: reserves of %s at %d: %v, want not ingested", stage, test.pool.Hex(), test.block, err)
+				}
+			case test.err != nil:
+				if err == nil {
+					t.Errorf("%s: reserves of %s at %d: %v, want an error", stage, test.pool.Hex(), test.block, reserve0)
+				}
+			case err != nil || reserve0.Int64() != test.reserve0:
+				t.Errorf("%s: reserves of %s at %d: %v (%v), want %d", stage, test.pool.Hex(), test.block, reserve0, err, test.reserve0)
+			}
+		}
+	}
+	check("synced")
+
+	// A reorg from block 8 rolls back its Sync and every later range
+	for block := uint64(8); block <= 16; block++ {
+		chain.fork[block] = 1
+	}
+	if err := store.CheckReorg(ctx, chain); err != nil {
+		t.Fatal(err)
+	}
+	for _, address := range []common.Address{old, added} {
+		if ranges, err := store.AddressRanges(address); err != nil || ranges[len(ranges)-1][1] != 7 {
+			t.Errorf("%s ingested %v (%v) after the reorg, want up to block 7", address.Hex(), ranges, err)
+		}
+	}
+	if events, err := store.Events(old, 0, 20); err != nil || len(events) != 1 || events[0].Block != 3 {
+		t.Errorf("events of %s after the reorg: %v (%v), want only block 3", old.Hex(), events, err)
+	}
+	reserves = []reservesAt{
+		{old, 7, 100, nil},
+		{old, 8, 0, errNotIngested},
+	}
+	check("rolled back")
+
+	// syncing again takes the new fork's blocks
+	if err := store.Backfill(ctx, chain, []common.Address{old, added}, nil, 1, 16, 100); err != nil {
+		t.Fatal(err)
+	}
+	if gaps, err := store.Gaps([]common.Address{old, added}, 1, 16); err != nil || len(gaps) != 0 {
+		t.Errorf("gaps after syncing the new fork: %v (%v)", gaps, err)
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+// The contract pins solc 0.8.21, built for london so the bytecode also runs
+// on the fork simulator's EVM.
+//go:generate sh -c "solc --optimize --optimize-runs 200 --evm-version london --abi --bin --overwrite -o abi contracts/ArbExecutor.sol && mv abi/ArbExecutor.abi abi/arbExecutor.abi && mv abi/ArbExecutor.bin abi/arbExecutor.bin"
+//go:generate sh -c "abigen --abi abi/arbExecutor.abi --bin abi/arbExecutor.bin --pkg arbExecutor --type ArbExecutor --out arbExecutor/arbExecutor.go"
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"example.com/m/arbExecutor"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// hopFee is the part of a swap input, out of 10000, that the executor
+// contract prices after the pair's fee. It is fee_num/fee_dom from the
+// volume math so the contract never asks a pool for more than optimalVolume
+// expects.
+func hopFee(pair Pair) *big.Int {
+	return big.NewInt(feeDenom - pair.fee)
+}
+
+// executionMode picks how a loop is funded.
+type executionMode string
+
+const (
+	// modeCapital trades tokens the executor contract already holds.
+	modeCapital executionMode = "capital"
+	// modeFlash borrows the first hop's output with a flash swap and repays
+	// it from the end of the loop, so no starting balance is needed.
+	modeFlash executionMode = "flash"
+)
+
+// Opportunity is a loop found by a market scan along with the volume to trade.
+type Opportunity struct {
+	pairs    []Pair
+	amountIn big.Int
+	profit   big.Int
+	value    float64
+	// block is the hash of the block the reserves were read at
+	block common.Hash
+	// number and blockTime are that block's; detected is when the scan
+	// found the loop
+	number    uint64
+	blockTime time.Time
+	detected  time.Time
+	// nativeRate is how many whole source tokens one whole native coin is
+	// worth, to net gas out of profit, or zero when the graph cannot tell
+	nativeRate float64
+	// split is the loop sized across the pools parallel to its hops, when
+	// that pays more than amountIn through its own pairs; only amountIn is
+	// traded
+	split *LoopSplit
+}
+
+// executionPath turns a loop of pairs into the pools, tokens and fees
+// arguments of ArbExecutor.execute.
+func executionPath(pairs []Pair) ([]common.Address, []common.Address, []*big.Int, error) {
+	if len(pairs) < 2 {
+		return nil, nil, nil, errors.New("executor: a loop needs at least two pairs")
+	}
+	pools := make([]common.Address, 0, len(pairs))
+	tokens := make([]common.Address, 0, len(pairs)+1)
+	fees := make([]*big.Int, 0, len(pairs))
+
+	tokens = append(tokens, pairs[0].from)
+	for i, pair := range pairs {
+		if pair.from != tokens[i] {
+			return nil, nil, nil, errors.New("executor: pairs do not form a path")
+		}
+		pools = append(pools, pair.factory)
+		tokens = append(tokens, pair.to)
+		fees = append(fees, hopFee(pair))
+	}
+	if tokens[len(tokens)-1] != tokens[0] {
+		return nil, nil, nil, errors.New("executor: path does not return to its first token")
+	}
+	return pools, tokens, fees, nil
+}
+
+// executeCalldata encodes a call to ArbExecutor.execute for the opportunity,
+// with every hop and the loop as a whole protected by slippageBps.
+func executeCalldata(opp Opportunity, slippageBps int64) ([]byte, error) {
+	pools, tokens, fees, err := executionPath(opp.pairs)
+	if err != nil {
+		return nil, err
+	}
+	minOuts := minAmountsOut(hopAmounts(opp.pairs, &opp.amountIn), slippageBps)
+	minProfit := minProfitFor(minOuts, &opp.amountIn)
+
+	parsed, err := arbExecutor.ArbExecutorMetaData.GetAbi()
+	if err != nil {
+		return nil, err
+	}
+	return parsed.Pack("execute", pools, tokens, fees, minOuts, &opp.amountIn, minProfit)
+}
+
+// flashAmounts is what executeFlash borrows from the first pool of a loop
+// traded with amountIn, and what that pool must be paid back, fee included.
+func flashAmounts(pairs []Pair, amountIn *big.Int) (*big.Int, *big.Int) {
+	first := pairs[0]
+	borrow := getAmountOut(amountIn, &first.r_from, &first.r_to, first.fee)
+	repay := getAmountIn(borrow, &first.r_from, &first.r_to, first.fee)
+	return borrow, repay
+}
+
+// executeFlashCalldata encodes a call to ArbExecutor.executeFlash for the
+// opportunity, borrowing what its first hop would have produced.
+func executeFlashCalldata(opp Opportunity, slippageBps int64) ([]byte, error) {
+	pools, tokens, fees, err := executionPath(opp.pairs)
+	if err != nil {
+		return nil, err
+	}
+	borrow, repay := flashAmounts(opp.pairs, &opp.amountIn)
+	if borrow.Sign() <= 0 {
+		return nil, errors.New("executor: loop input too small to borrow against")
+	}
+	// The borrowed tokens reach the second pool through the executor, so a
+	// taxed token is taxed twice on the way
+	hops := append([]Pair{}, opp.pairs...)
+	hops[1].tax = 1 - (1-hops[1].tax)*(1-hops[1].tax)
+	minOuts := minAmountsOut(hopAmounts(hops, &opp.amountIn), slippageBps)
+	minProfit := minProfitFor(minOuts, repay)
+
+	parsed, err := arbExecutor.ArbExecutorMetaData.GetAbi()
+	if err != nil {
+		return nil, err
+	}
+	return parsed.Pack("executeFlash", pools, tokens, fees, minOuts, borrow, repay, minProfit)
+}
+
+// opportunityCalldata encodes the opportunity for the given execution mode.
+func opportunityCalldata(opp Opportunity, mode executionMode, slippageBps int64) ([]byte, error) {
+	switch mode {
+	case modeCapital:
+		return executeCalldata(opp, slippageBps)
+	case modeFlash:
+		return executeFlashCalldata(opp, slippageBps)
+	default:
+		return nil, fmt.Errorf("executor: unknown execution mode %q", mode)
+	}
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"example.com/m/arbExecutor"
+	ethereum "github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind/backends"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func TestArbExecutorDeploys(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	owner := crypto.PubkeyToAddress(key.PublicKey)
+	backend := backends.NewSimulatedBackend(core.GenesisAlloc{
+		owner: {Balance: new(big.Int).Lsh(big.NewInt(1), 100)},
+	}, 30000000)
+	defer backend.Close()
+	auth, err := bind.NewKeyedTransactorWithChainID(key, big.NewInt(1337))
+	if err != nil {
+		t.Fatal(err)
+	}
+	address, _, executor, err := arbExecutor.DeployArbExecutor(auth, backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend.Commit()
+
+	got, err := executor.Owner(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != owner {
+		t.Fatalf("owner %s, want %s", got.Hex(), owner.Hex())
+	}
+
+	parsed, err := arbExecutor.ArbExecutorMetaData.GetAbi()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := common.HexToAddress("0x1000000000000000000000000000000000000001")
+	token := common.HexToAddress("0x2000000000000000000000000000000000000002")
+	// One hop is not a loop
+	calldata, err := parsed.Pack("execute", []common.Address{pool}, []common.Address{token, token},
+		[]*big.Int{big.NewInt(9975)}, []*big.Int{big.NewInt(0)}, big.NewInt(1), big.NewInt(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		from common.Address
+		want string
+	}{
+		{common.HexToAddress("0x3000000000000000000000000000000000000003"), "not owner"},
+		{owner, "bad path"},
+	}
+	for _, test := range tests {
+		_, err := backend.CallContract(context.Background(), ethereum.CallMsg{From: test.from, To: &address, Data: calldata}, nil)
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("call from %s: %v, want a %q revert", test.from.Hex(), err, test.want)
+		}
+	}
+}
+
+//go:generate sh -c "solc --optimize --optimize-runs 200 --evm-version london --abi --bin --overwrite -o testdata testdata/contracts/MockToken.sol testdata/contracts/MockPair.sol"
+
+// mockChain deploys the contracts under testdata/contracts on a simulated
+// chain from an account that holds, and so owns, everything it deploys.
+type mockChain struct {
+	t       *testing.T
+	backend *backends.SimulatedBackend
+	key     *ecdsa.PrivateKey
+	owner   common.Address
+	abis    map[string]abi.ABI
+}
+
+func newMockChain(t *testing.T) *mockChain {
+	key, _ := crypto.GenerateKey()
+	owner := crypto.PubkeyToAddress(key.PublicKey)
+	backend := backends.NewSimulatedBackend(core.GenesisAlloc{
+		owner: {Balance: new(big.Int).Lsh(big.NewInt(1), 100)},
+	}, 30000000)
+	t.Cleanup(func() { backend.Close() })
+	c := &mockChain{t: t, backend: backend, key: key, owner: owner, abis: make(map[string]abi.ABI)}
+	for _, name := range []string{"MockToken", "MockPair"} {
+		data, err := os.ReadFile(filepath.Join("testdata", name+".abi"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.abis[name], err = abi.JSON(bytes.NewReader(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return c
+}
+
+func (c *mockChain) auth() *bind.TransactOpts {
+	auth, err := bind.NewKeyedTransactorWithChainID(c.key, big.NewInt(1337))
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return auth
+}
+
+// deploy creates one of the mock contracts and mines it.
+func (c *mockChain) deploy(name string, args ...interface{}) common.Address {
+	bin, err := os.ReadFile(filepath.Join("testdata", name+".bin"))
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	address, _, _, err := bind.DeployContract(c.auth(), c.abis[name], common.FromHex(string(bin)), c.backend, args...)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	c.backend.Commit()
+	return address
+}
+
+// token deploys a token with taxBps charged on transfers the owner does not
+// send.
+func (c *mockChain) token(symbol string, taxBps int64) common.Address {
+	return c.deploy("MockToken", symbol, new(big.Int).Lsh(big.NewInt(1), 120), big.NewInt(taxBps))
+}
+
+// pair deploys a 0.25% pair holding reserve0 and reserve1 of its tokens,
+// calling back the way callback picks.
+func (c *mockChain) pair(token0, token1 common.Address, reserve0, reserve1 *big.Int, callback uint8) common.Address {
+	pair := c.deploy("MockPair", token0, token1, big.NewInt(25), callback)
+	c.transact("MockToken", token0, "transfer", pair, reserve0)
+	c.transact("MockToken", token1, "transfer", pair, reserve1)
+	c.transact("MockPair", pair, "sync")
+	return pair
+}
+
+// transact calls method on a mock contract as the owner and mines it,
+// failing the test if it reverts.
+func (c *mockChain) transact(name string, to common.Address, method string, args ...interface{}) {
+	data, err := c.abis[name].Pack(method, args...)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if receipt := c.send(to, data); receipt.Status != types.ReceiptStatusSuccessful {
+		c.t.Fatalf("%s.%s reverted", name, method)
+	}
+}
+
+// send mines a transaction from the owner carrying data.
+func (c *mockChain) send(to common.Address, data []byte) *types.Receipt {
+	auth := c.auth()
+	auth.GasLimit = 3000000
+	tx, err := bind.NewBoundContract(to, abi.ABI{}, c.backend, c.backend, c.backend).RawTransact(auth, data)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	c.backend.Commit()
+	receipt, err := c.backend.TransactionReceipt(context.Background(), tx.Hash())
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return receipt
+}
+
+func (c *mockChain) balanceOf(token, owner common.Address) *big.Int {
+	data, err := c.abis["MockToken"].Pack("balanceOf", owner)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	out, err := c.backend.CallContract(context.Background(), ethereum.CallMsg{To: &token, Data: data}, nil)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return new(big.Int).SetBytes(out)
+}
+
+func TestArbExecutorLoops(t *testing.T) {
+	unit := new(big.Int).Exp(big.NewInt(10), big.NewInt(18), nil)
+	ether := func(n int64) *big.Int { return new(big.Int).Mul(big.NewInt(n), unit) }
+	amountIn := ether(20)
+
+	tests := []struct {
+		name     string
+		mode     executionMode
+		callback uint8
+	}{
+		{"capital", modeCapital, 0},
+		{"flash pancakeCall", modeFlash, 0},
+		{"flash BiswapCall", modeFlash, 1},
+		{"flash uniswapV2Call", modeFlash, 2},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			chain := newMockChain(t)
+			a, b := chain.token("A", 0), chain.token("B", 0)
+			// B is twice as dear in the first pool as in the second
+			first := chain.pair(a, b, ether(1000), ether(2000), test.callback)
+			second := chain.pair(a, b, ether(1000), ether(1000), test.callback)
+			executor, _, _, err := arbExecutor.DeployArbExecutor(chain.auth(), chain.backend)
+			if err != nil {
+				t.Fatal(err)
+			}
+			chain.backend.Commit()
+
+			opp := Opportunity{pairs: []Pair{
+				{from: a, to: b, factory: first, fee: 25, r_from: *ether(1000), r_to: *ether(2000)},
+				{from: b, to: a, factory: second, fee: 25, r_from: *ether(1000), r_to: *ether(1000)},
+			}}
+			opp.amountIn.Set(amountIn)
+			amounts := hopAmounts(opp.pairs, amountIn)
+			opp.profit.Sub(amounts[1], amountIn)
+			want := expectedProfit(opp, test.mode)
+			if want.Sign() <= 0 {
+				t.Fatalf("loop pays %s, the test needs a profitable one", want)
+			}
+			if test.mode == modeCapital {
+				chain.transact("MockToken", a, "transfer", executor, amountIn)
+			}
+			before := chain.balanceOf(a, executor)
+
+			calldata, err := opportunityCalldata(opp, test.mode, 50)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if receipt := chain.send(executor, calldata); receipt.Status != types.ReceiptStatusSuccessful {
+				t.Fatalf("loop reverted")
+			}
+			if got := new(big.Int).Sub(chain.balanceOf(a, executor), before); got.Cmp(want) != 0 {
+				t.Errorf("executor made %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+func TestArbExecutorFloors(t *testing.T) {
+	unit := new(big.Int).Exp(big.NewInt(10), big.NewInt(18), nil)
+	ether := func(n int64) *big.Int { return new(big.Int).Mul(big.NewInt(n), unit) }
+	chain := newMockChain(t)
+	a, b := chain.token("A", 0), chain.token("B", 0)
+	first := chain.pair(a, b, ether(1000), ether(2000), 0)
+	second := chain.pair(a, b, ether(1000), ether(1000), 0)
+	executor, _, _, err := arbExecutor.DeployArbExecutor(chain.auth(), chain.backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain.backend.Commit()
+
+	pairs := []Pair{
+		{from: a, to: b, factory: first, fee: 25, r_from: *ether(1000), r_to: *ether(2000)},
+		{from: b, to: a, factory: second, fee: 25, r_from: *ether(1000), r_to: *ether(1000)},
+	}
+	borrow, repay := flashAmounts(pairs, ether(20))
+	out := getAmountOut(borrow, ether(1000), ether(1000), 25)
+	profit := new(big.Int).Sub(out, repay)
+	pools, tokens, fees, err := executionPath(pairs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := arbExecutor.ArbExecutorMetaData.GetAbi()
+	if err != nil {
+		t.Fatal(err)
+	}
+	one := big.NewInt(1)
+
+	tests := []struct {
+		name      string
+		minOut    *big.Int
+		minProfit *big.Int
+		want      string
+	}{
+		{"at the floors", out, profit, ""},
+		{"hop below its floor", new(big.Int).Add(out, one), new(big.Int), "slippage"},
+		{"loop below its floor", out, new(big.Int).Add(profit, one), "unprofitable"},
+	}
+	for _, test := range tests {
+		calldata, err := parsed.Pack("executeFlash", pools, tokens, fees, []*big.Int{new(big.Int), test.minOut}, borrow, repay, test.minProfit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = chain.backend.CallContract(context.Background(), ethereum.CallMsg{From: chain.owner, To: &executor, Data: calldata}, nil)
+		if test.want == "" && err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		}
+		if test.want != "" && (err == nil || !strings.Contains(err.Error(), test.want)) {
+			t.Errorf("%s: %v, want a %q revert", test.name, err, test.want)
+		}
+	}
+
+	// Only the pool being borrowed from may call back
+	calldata, err := parsed.Pack("pancakeCall", executor, borrow, new(big.Int), []byte{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = chain.backend.CallContract(context.Background(), ethereum.CallMsg{From: chain.owner, To: &executor, Data: calldata}, nil)
+	if err == nil || !strings.Contains(err.Error(), "unexpected callback") {
+		t.Errorf("callback from outside a flash swap: %v, want an \"unexpected callback\" revert", err)
+	}
+}
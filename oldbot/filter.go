@@ -0,0 +1,392 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"sort"
+
+	"example.com/m/pancakeFactory"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// PoolFilterConfig is the JSON file that decides which pools reach the graph.
+// Zero values switch a check off.
+type PoolFilterConfig struct {
+	// Allow, when not empty, keeps only pools whose tokens are both listed.
+	Allow []common.Address `json:"allow"`
+	Block []common.Address `json:"block"`
+	// Reference is the asset pool liquidity is valued in.
+	Reference common.Address `json:"reference"`
+	// MinReserve is the least either side of a pool may be worth, in raw
+	// units of Reference. Values go through pool price ratios, so token
+	// decimals cancel out.
+	MinReserve *big.Int `json:"minReserve"`
+	// MinAgeBlocks keeps out pools created too recently to be trusted.
+	MinAgeBlocks uint64 `json:"minAgeBlocks"`
+	// BatchBlocks is how many blocks each query for pool creations spans.
+	// Public endpoints cap the range of eth_getLogs.
+	BatchBlocks uint64 `json:"batchBlocks"`
+	// MaxDeviation is how far, as a fraction, a pool's price may be from
+	// the deepest pool trading the same two tokens.
+	MaxDeviation float64 `json:"maxDeviation"`
+	// Report, when set, is where every exclusion is written as JSON.
+	Report string `json:"report"`
+}
+
+// defaultPoolFilter keeps roughly what the old raw-reserve check let
+// through: pools worth at least 0.01 of the wrapped native coin a side.
+func defaultPoolFilter(wrapped common.Address) PoolFilterConfig {
+	return PoolFilterConfig{
+		Reference:   wrapped,
+		MinReserve:  big.NewInt(10000000000000000),
+		BatchBlocks: 2000,
+	}
+}
+
+func loadPoolFilter(path string, wrapped common.Address) (PoolFilterConfig, error) {
+	config := defaultPoolFilter(wrapped)
+	if path == "" {
+		return config, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return config, err
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return config, fmt.Errorf("filter: cannot parse %s: %w", path, err)
+	}
+	if config.MinReserve != nil && config.MinReserve.Sign() < 0 {
+		return config, fmt.Errorf("filter: minReserve in %s is negative", path)
+	}
+	if config.MaxDeviation < 0 {
+		return config, fmt.Errorf("filter: maxDeviation in %s is negative", path)
+	}
+	if config.BatchBlocks == 0 {
+		return config, fmt.Errorf("filter: batchBlocks in %s is zero", path)
+	}
+	return config, nil
+}
+
+// PoolExclusion is one pool the filter kept out and why.
+type PoolExclusion struct {
+	Pool   common.Address `json:"pool"`
+	From   string         `json:"from"`
+	To     string         `json:"to"`
+	Check  string         `json:"check"`
+	Reason string         `json:"reason"`
+}
+
+// creationReader finds when pools were created. RPCPool satisfies it.
+type creationReader interface {
+	FilterLogs(ctx context.Context, query ethereum.FilterQuery) ([]types.Log, error)
+	BlockNumber(ctx context.Context) (uint64, error)
+}
+
+// PoolFilter applies a PoolFilterConfig. Creation blocks never change, so
+// the PairCreated logs of the factories are read once for every pool and
+// later scans only read the blocks since.
+type PoolFilter struct {
+	config    PoolFilterConfig
+	chain     creationReader
+	factories []common.Address
+	allow     map[common.Address]bool
+	block     map[common.Address]bool
+
+	// created holds the pools the factories created from block since up to
+	// the block before next; every other pool is older
+	created map[common.Address]uint64
+	since   uint64
+	next    uint64
+	started bool
+	// readErr is why the last scan could not read up to its head
+	readErr error
+
+	parser       *pancakeFactory.PancakeFactoryFilterer
+	createdTopic common.Hash
+}
+
+// NewPoolFilter applies config to pools, dating them by the PairCreated
+// logs of factories.
+func NewPoolFilter(config PoolFilterConfig, chain creationReader, factories []common.Address) (*PoolFilter, error) {
+	if config.BatchBlocks == 0 {
+		config.BatchBlocks = defaultPoolFilter(common.Address{}).BatchBlocks
+	}
+	parser, err := pancakeFactory.NewPancakeFactoryFilterer(common.Address{}, nil)
+	if err != nil {
+		return nil, err
+	}
+	parsed, err := pancakeFactory.PancakeFactoryMetaData.GetAbi()
+	if err != nil {
+		return nil, err
+	}
+	f := &PoolFilter{
+		config:       config,
+		chain:        chain,
+		factories:    factories,
+		allow:        make(map[common.Address]bool),
+		block:        make(map[common.Address]bool),
+		created:      make(map[common.Address]uint64),
+		parser:       parser,
+		createdTopic: parsed.Events["PairCreated"].ID,
+	}
+	for _, token := range config.Allow {
+		f.allow[token] = true
+	}
+	for _, token := range config.Block {
+		f.block[token] = true
+	}
+	return f, nil
+}
+
+// Apply returns the pools that pass every check and, for each other pool,
+// the check that dropped it and why. Checks run cheapest first, so the age
+// lookup is only paid for pools that would otherwise be kept.
+func (f *PoolFilter) Apply(ctx context.Context, pools []PoolState) ([]PoolState, []PoolExclusion, error) {
+	prices := referencePrices(pools, f.config.Reference)
+	deepest := deepestParallel(pools)
+	head := uint64(0)
+	if f.config.MinAgeBlocks > 0 {
+		var err error
+		if head, err = f.chain.BlockNumber(ctx); err != nil {
+			return nil, nil, err
+		}
+		// pools the logs read so far cannot date are dropped by the age
+		// check, the rest are still judged
+		if f.readErr = f.readCreations(ctx, head); f.readErr != nil {
+			log.Warn("Cannot read pool creations", "from", f.next, "head", head, "err", f.readErr)
+		}
+	}
+
+	kept := make([]PoolState, 0, len(pools))
+	excluded := []PoolExclusion{}
+	for i := range pools {
+		check, reason := f.check(&pools[i], prices, deepest, head)
+		if check != "" {
+			excluded = append(excluded, PoolExclusion{pools[i].in.Factory, pools[i].in.From_symbol, pools[i].in.To_symbol, check, reason})
+			continue
+		}
+		kept = append(kept, pools[i])
+	}
+	if f.config.Report != "" {
+		if err := writeFilterReport(f.config.Report, excluded); err != nil {
+			return nil, nil, err
+		}
+	}
+	return kept, excluded, nil
+}
+
+// check is the check that drops pool and why, or "" to keep it. A pool
+// whose creation cannot be looked up is dropped by the age check.
+func (f *PoolFilter) check(pool *PoolState, prices map[common.Address]*big.Float, deepest map[[2]common.Address]*PoolState, head uint64) (string, string) {
+	for _, token := range []common.Address{pool.in.From, pool.in.To} {
+		if f.block[token] {
+			return "blocklist", "blocked token " + token.Hex()
+		}
+		if len(f.allow) > 0 && !f.allow[token] {
+			return "allowlist", "token not allowed " + token.Hex()
+		}
+	}
+
+	if f.config.MinReserve != nil && f.config.MinReserve.Sign() > 0 {
+		value, ok := reserveValue(pool, prices)
+		if !ok {
+			return "liquidity", "no price in the reference asset"
+		}
+		if value.Cmp(new(big.Float).SetInt(f.config.MinReserve)) < 0 {
+			return "liquidity", fmt.Sprintf("reserve worth %s, below %s", value.Text('f', 0), f.config.MinReserve)
+		}
+	}
+
+	if f.config.MaxDeviation > 0 {
+		reference := deepest[parallelKey(pool)]
+		if reference != nil && reference != pool {
+			deviation := priceDeviation(pool, reference)
+			if deviation > f.config.MaxDeviation {
+				return "deviation", fmt.Sprintf("price %.2f%% away from %s", deviation*100, reference.in.Factory.Hex())
+			}
+		}
+	}
+
+	if f.config.MinAgeBlocks > 0 {
+		created, err := f.createdAt(pool, head)
+		if err != nil {
+			return "age", "creation unknown: " + err.Error()
+		}
+		if head-created < f.config.MinAgeBlocks {
+			return "age", fmt.Sprintf("created %d blocks ago", head-created)
+		}
+	}
+	return "", ""
+}
+
+// referencePrices prices every token in raw units of reference per raw unit
+// of the token, using its deepest pool against reference.
+func referencePrices(pools []PoolState, reference common.Address) map[common.Address]*big.Float {
+	prices := map[common.Address]*big.Float{reference: big.NewFloat(1)}
+	depth := make(map[common.Address]*big.Int)
+	for i := range pools {
+		pool := &pools[i]
+		if pool.in.From != reference && pool.in.To != reference {
+			continue
+		}
+		token := otherToken(*pool, reference)
+		reserveRef, reserveToken := pool.reserves(reference)
+		if reserveToken.Sign() == 0 {
+			continue
+		}
+		if best, ok := depth[token]; ok && best.Cmp(reserveRef) >= 0 {
+			continue
+		}
+		depth[token] = reserveRef
+		prices[token] = new(big.Float).Quo(new(big.Float).SetInt(reserveRef), new(big.Float).SetInt(reserveToken))
+	}
+	return prices
+}
+
+// reserveValue is the smaller side of the pool valued in the reference
+// asset, or the only side that has a price.
+func reserveValue(pool *PoolState, prices map[common.Address]*big.Float) (*big.Float, bool) {
+	var value *big.Float
+	sides := []struct {
+		token   common.Address
+		reserve *big.Int
+	}{{pool.in.From, &pool.reserve0}, {pool.in.To, &pool.reserve1}}
+	for _, side := range sides {
+		price, ok := prices[side.token]
+		if !ok {
+			continue
+		}
+		sideValue := new(big.Float).Mul(new(big.Float).SetInt(side.reserve), price)
+		if value == nil || sideValue.Cmp(value) < 0 {
+			value = sideValue
+		}
+	}
+	return value, value != nil
+}
+
+// parallelKey is the same for every pool trading two tokens, whichever
+// order it lists them in.
+func parallelKey(pool *PoolState) [2]common.Address {
+	if bytes.Compare(pool.in.From.Bytes(), pool.in.To.Bytes()) > 0 {
+		return [2]common.Address{pool.in.To, pool.in.From}
+	}
+	return [2]common.Address{pool.in.From, pool.in.To}
+}
+
+// deepestParallel maps each token pair to its pool with the most of the
+// pair's lower token.
+func deepestParallel(pools []PoolState) map[[2]common.Address]*PoolState {
+	deepest := make(map[[2]common.Address]*PoolState)
+	for i := range pools {
+		key := parallelKey(&pools[i])
+		depth, _ := pools[i].reserves(key[0])
+		if best, ok := deepest[key]; ok {
+			if bestDepth, _ := best.reserves(key[0]); bestDepth.Cmp(depth) >= 0 {
+				continue
+			}
+		}
+		deepest[key] = &pools[i]
+	}
+	return deepest
+}
+
+// priceDeviation is |price/referencePrice - 1|, both pools priced in the
+// reference's first token.
+func priceDeviation(pool, reference *PoolState) float64 {
+	base := reference.in.From
+	reserveBase, reserveQuote := pool.reserves(base)
+	referenceBase, referenceQuote := reference.reserves(base)
+	if reserveBase.Sign() == 0 || referenceBase.Sign() == 0 || referenceQuote.Sign() == 0 {
+		return 0
+	}
+	price := new(big.Float).Quo(new(big.Float).SetInt(reserveQuote), new(big.Float).SetInt(reserveBase))
+	referencePrice := new(big.Float).Quo(new(big.Float).SetInt(referenceQuote), new(big.Float).SetInt(referenceBase))
+	ratio, _ := price.Quo(price, referencePrice).Float64()
+	if ratio < 1 {
+		return 1 - ratio
+	}
+	return ratio - 1
+}
+
+// readCreations reads the PairCreated logs of the factories up to head in
+// BatchBlocks ranges. The first read starts MinAgeBlocks back, since an
+// older pool is old enough whenever it was made, so no archive node is
+// needed; later reads start where the last one stopped.
+func (f *PoolFilter) readCreations(ctx context.Context, head uint64) error {
+	if !f.started {
+		if head >= f.config.MinAgeBlocks {
+			f.since = head - f.config.MinAgeBlocks
+		}
+		f.next, f.started = f.since, true
+	}
+	for f.next <= head {
+		to := f.next + f.config.BatchBlocks - 1
+		if to > head {
+			to = head
+		}
+		logs, err := f.chain.FilterLogs(ctx, ethereum.FilterQuery{
+			FromBlock: new(big.Int).SetUint64(f.next),
+			ToBlock:   new(big.Int).SetUint64(to),
+			Addresses: f.factories,
+			Topics:    [][]common.Hash{{f.createdTopic}},
+		})
+		if err != nil {
+			return err
+		}
+		for _, entry := range logs {
+			event, err := f.parser.ParsePairCreated(entry)
+			if err != nil || entry.Removed {
+				continue
+			}
+			f.created[event.Pair] = entry.BlockNumber
+		}
+		f.next = to + 1
+	}
+	return nil
+}
+
+// createdAt is the block pool was created in. A pool the logs do not name
+// is older than they go back, and the block they start at is a bound for
+// it, unless they could not be read up to head.
+func (f *PoolFilter) createdAt(pool *PoolState, head uint64) (uint64, error) {
+	if created, ok := f.created[pool.in.Factory]; ok {
+		return created, nil
+	}
+	if f.next <= head {
+		return 0, f.readErr
+	}
+	return f.since, nil
+}
+
+// summarizeExclusions counts exclusions by the check that made them.
+func summarizeExclusions(excluded []PoolExclusion) []string {
+	counts := make(map[string]int)
+	for _, exclusion := range excluded {
+		counts[exclusion.Check]++
+	}
+	lines := make([]string, 0, len(counts))
+	for check, count := range counts {
+		lines = append(lines, fmt.Sprintf("%s: %d pools", check, count))
+	}
+	sort.Strings(lines)
+	return lines
+}
+
+func writeFilterReport(path string, excluded []PoolExclusion) error {
+	data, err := json.MarshalIndent(excluded, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// fakeCreations answers log queries with the logs in their block range,
+// or err, and keeps the queries.
+type fakeCreations struct {
+	head    uint64
+	logs    []types.Log
+	err     error
+	queries []ethereum.FilterQuery
+}
+
+func (c *fakeCreations) FilterLogs(ctx context.Context, query ethereum.FilterQuery) ([]types.Log, error) {
+	c.queries = append(c.queries, query)
+	if c.err != nil {
+		return nil, c.err
+	}
+	logs := []types.Log{}
+	for _, entry := range c.logs {
+		if entry.BlockNumber >= query.FromBlock.Uint64() && entry.BlockNumber <= query.ToBlock.Uint64() {
+			logs = append(logs, entry)
+		}
+	}
+	return logs, nil
+}
+
+func (c *fakeCreations) BlockNumber(ctx context.Context) (uint64, error) {
+	return c.head, nil
+}
+
+func testPool(from, to int) PoolState {
+	pair := testPair(from, to, 100, 100, 25)
+	pool := PoolState{in: PairIn{From: pair.from, From_symbol: pair.from_symbol, To: pair.to, To_symbol: pair.to_symbol, Factory: pair.factory}}
+	pool.reserve0.Set(&pair.r_from)
+	pool.reserve1.Set(&pair.r_to)
+	return pool
+}
+
+func TestPoolFilterLists(t *testing.T) {
+	tests := []struct {
+		name  string
+		allow []int
+		block []int
+		from  int
+		to    int
+		check string
+	}{
+		{"no lists", nil, nil, 1, 2, ""},
+		{"blocked from", nil, []int{1}, 1, 2, "blocklist"},
+		{"blocked to", nil, []int{2}, 1, 2, "blocklist"},
+		{"both allowed", []int{1, 2}, nil, 1, 2, ""},
+		{"one allowed", []int{1}, nil, 1, 2, "allowlist"},
+		{"block wins over allow", []int{1, 2}, []int{2}, 1, 2, "blocklist"},
+		{"other token blocked", nil, []int{3}, 1, 2, ""},
+	}
+	for _, test := range tests {
+		config := PoolFilterConfig{}
+		for _, n := range test.allow {
+			config.Allow = append(config.Allow, testToken(n))
+		}
+		for _, n := range test.block {
+			config.Block = append(config.Block, testToken(n))
+		}
+		filter, err := NewPoolFilter(config, &fakeCreations{}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		kept, excluded, err := filter.Apply(context.Background(), []PoolState{testPool(test.from, test.to)})
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if test.check == "" {
+			if len(kept) != 1 || len(excluded) != 0 {
+				t.Errorf("%s: kept %d excluded %v, want the pool kept", test.name, len(kept), excluded)
+			}
+			continue
+		}
+		if len(kept) != 0 || len(excluded) != 1 || excluded[0].Check != test.check {
+			t.Errorf("%s: kept %d excluded %v, want a %s exclusion", test.name, len(kept), excluded, test.check)
+		}
+	}
+}
+
+func TestPoolFilterAge(t *testing.T) {
+	factory := common.HexToAddress("0xfac")
+	filter, err := NewPoolFilter(PoolFilterConfig{}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	young, old := testPool(1, 2), testPool(3, 4)
+	created := func(pool PoolState, block uint64) types.Log {
+		return types.Log{
+			Address:     factory,
+			Topics:      []common.Hash{filter.createdTopic, pool.in.From.Hash(), pool.in.To.Hash()},
+			Data:        append(common.LeftPadBytes(pool.in.Factory.Bytes(), 32), common.LeftPadBytes(big.NewInt(1).Bytes(), 32)...),
+			BlockNumber: block,
+		}
+	}
+	tests := []struct {
+		name  string
+		chain *fakeCreations
+		want  map[common.Address]string
+	}{
+		{"none created lately", &fakeCreations{head: 1000}, map[common.Address]string{}},
+		{"one created lately", &fakeCreations{head: 1000, logs: []types.Log{created(young, 950)}},
+			map[common.Address]string{young.in.Factory: "age"}},
+		{"one created long ago", &fakeCreations{head: 1000, logs: []types.Log{created(young, 850)}}, map[common.Address]string{}},
+		{"lookup fails", &fakeCreations{head: 1000, err: errors.New("range too large")},
+			map[common.Address]string{young.in.Factory: "age", old.in.Factory: "age"}},
+	}
+	for _, test := range tests {
+		filter, err := NewPoolFilter(PoolFilterConfig{MinAgeBlocks: 100, BatchBlocks: 30}, test.chain, []common.Address{factory})
+		if err != nil {
+			t.Fatal(err)
+		}
+		kept, excluded, err := filter.Apply(context.Background(), []PoolState{young, old})
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if len(kept)+len(excluded) != 2 || len(excluded) != len(test.want) {
+			t.Errorf("%s: kept %d excluded %v", test.name, len(kept), excluded)
+			continue
+		}
+		for _, exclusion := range excluded {
+			if test.want[exclusion.Pool] != exclusion.Check {
+				t.Errorf("%s: %s excluded by %s", test.name, exclusion.Pool.Hex(), exclusion.Check)
+			}
+		}
+	}
+}
+
+func TestPoolFilterCreationQueries(t *testing.T) {
+	factories := []common.Address{common.HexToAddress("0xfac1"), common.HexToAddress("0xfac2")}
+	chain := &fakeCreations{head: 1000}
+	filter, err := NewPoolFilter(PoolFilterConfig{MinAgeBlocks: 100, BatchBlocks: 30}, chain, factories)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pools := []PoolState{testPool(1, 2), testPool(3, 4), testPool(5, 6)}
+	if _, _, err := filter.Apply(context.Background(), pools); err != nil {
+		t.Fatal(err)
+	}
+	chain.head = 1010
+	if _, _, err := filter.Apply(context.Background(), pools); err != nil {
+		t.Fatal(err)
+	}
+
+	// Blocks 900 to 1000 in ranges of 30 for every pool at once, then only
+	// the blocks since
+	want := [][2]uint64{{900, 929}, {930, 959}, {960, 989}, {990, 1000}, {1001, 1010}}
+	if len(chain.queries) != len(want) {
+		t.Fatalf("%d log queries, want %d", len(chain.queries), len(want))
+	}
+	for i, query := range chain.queries {
+		if query.FromBlock.Uint64() != want[i][0] || query.ToBlock.Uint64() != want[i][1] {
+			t.Errorf("query %d covers %s-%s, want %d-%d", i, query.FromBlock, query.ToBlock, want[i][0], want[i][1])
+		}
+		if len(query.Addresses) != len(factories) || query.Addresses[0] != factories[0] || query.Addresses[1] != factories[1] {
+			t.Errorf("query %d asks %v, want only the factories", i, query.Addresses)
+		}
+	}
+}
+
+func TestPoolFilterDeviation(t *testing.T) {
+	// the deep pool lists the tokens the other way round
+	deep, cheap, fair := testPool(2, 1), testPool(1, 2), testPool(1, 2)
+	deep.reserve0.SetInt64(2000)
+	deep.reserve1.SetInt64(1000)
+	cheap.reserve0.SetInt64(100)
+	cheap.reserve1.SetInt64(150)
+	fair.in.Factory = testToken(99)
+	fair.reserve0.SetInt64(100)
+	fair.reserve1.SetInt64(199)
+	filter, err := NewPoolFilter(PoolFilterConfig{MaxDeviation: 0.05}, &fakeCreations{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kept, excluded, err := filter.Apply(context.Background(), []PoolState{deep, cheap, fair})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(kept) != 2 || len(excluded) != 1 || excluded[0].Pool != cheap.in.Factory || excluded[0].Check != "deviation" {
+		t.Errorf("kept %d excluded %v, want only the cheap pool excluded", len(kept), excluded)
+	}
+}
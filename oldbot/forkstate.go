@@ -0,0 +1,305 @@
+package main
+
+import (
+	"context"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// stateReader reads accounts and storage at a block. ethclient.Client
+// satisfies it.
+type stateReader interface {
+	BalanceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (*big.Int, error)
+	NonceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (uint64, error)
+	CodeAt(ctx context.Context, account common.Address, blockNumber *big.Int) ([]byte, error)
+	StorageAt(ctx context.Context, account common.Address, key common.Hash, blockNumber *big.Int) ([]byte, error)
+}
+
+type forkAccount struct {
+	balance *big.Int
+	nonce   uint64
+	code    []byte
+}
+
+// forkOverlay is everything written since the fork was taken.
+type forkOverlay struct {
+	accounts map[common.Address]*forkAccount
+	storage  map[common.Address]map[common.Hash]common.Hash
+	suicided map[common.Address]bool
+	refund   uint64
+}
+
+func (o *forkOverlay) copy() *forkOverlay {
+	c := &forkOverlay{
+		accounts: make(map[common.Address]*forkAccount, len(o.accounts)),
+		storage:  make(map[common.Address]map[common.Hash]common.Hash, len(o.storage)),
+		suicided: make(map[common.Address]bool, len(o.suicided)),
+		refund:   o.refund,
+	}
+	for addr, account := range o.accounts {
+		c.accounts[addr] = &forkAccount{new(big.Int).Set(account.balance), account.nonce, account.code}
+	}
+	for addr, slots := range o.storage {
+		c.storage[addr] = make(map[common.Hash]common.Hash, len(slots))
+		for k, v := range slots {
+			c.storage[addr][k] = v
+		}
+	}
+	for addr := range o.suicided {
+		c.suicided[addr] = true
+	}
+	return c
+}
+
+// forkState is a vm.StateDB over a remote node's state at one block. Reads
+// are fetched on first use and cached, writes stay local, so contracts can be
+// run against live state without sending anything.
+type forkState struct {
+	ctx    context.Context
+	remote stateReader
+	block  *big.Int
+
+	accounts map[common.Address]*forkAccount
+	storage  map[common.Address]map[common.Hash]common.Hash
+
+	overlay   *forkOverlay
+	snapshots []*forkOverlay
+	logs      []*types.Log
+	// err is the first failed remote read. Results are meaningless once set.
+	err error
+}
+
+func newForkState(ctx context.Context, remote stateReader, block *big.Int) *forkState {
+	s := &forkState{
+		ctx:      ctx,
+		remote:   remote,
+		block:    block,
+		accounts: make(map[common.Address]*forkAccount),
+		storage:  make(map[common.Address]map[common.Hash]common.Hash),
+	}
+	s.reset()
+	return s
+}
+
+// reset drops every local write but keeps what was fetched, so the next
+// simulation at the same block starts clean and cheap.
+func (s *forkState) reset() {
+	s.overlay = &forkOverlay{
+		accounts: make(map[common.Address]*forkAccount),
+		storage:  make(map[common.Address]map[common.Hash]common.Hash),
+		suicided: make(map[common.Address]bool),
+	}
+	s.snapshots = nil
+	s.logs = nil
+}
+
+func (s *forkState) fail(err error) {
+	if s.err == nil {
+		s.err = err
+	}
+}
+
+// remoteAccount is the account as of the fork block.
+func (s *forkState) remoteAccount(addr common.Address) *forkAccount {
+	if account, ok := s.accounts[addr]; ok {
+		return account
+	}
+	account := &forkAccount{balance: new(big.Int)}
+	var err error
+	if account.balance, err = s.remote.BalanceAt(s.ctx, addr, s.block); err != nil {
+		s.fail(err)
+		account.balance = new(big.Int)
+	}
+	if account.nonce, err = s.remote.NonceAt(s.ctx, addr, s.block); err != nil {
+		s.fail(err)
+	}
+	if account.code, err = s.remote.CodeAt(s.ctx, addr, s.block); err != nil {
+		s.fail(err)
+	}
+	s.accounts[addr] = account
+	return account
+}
+
+// account is the current account, copied into the overlay when writable.
+func (s *forkState) account(addr common.Address, writable bool) *forkAccount {
+	if account, ok := s.overlay.accounts[addr]; ok {
+		return account
+	}
+	remote := s.remoteAccount(addr)
+	if !writable {
+		return remote
+	}
+	account := &forkAccount{new(big.Int).Set(remote.balance), remote.nonce, remote.code}
+	s.overlay.accounts[addr] = account
+	return account
+}
+
+func (s *forkState) CreateAccount(addr common.Address) {
+	balance := s.account(addr, false).balance
+	s.overlay.accounts[addr] = &forkAccount{balance: new(big.Int).Set(balance)}
+	s.overlay.storage[addr] = make(map[common.Hash]common.Hash)
+}
+
+func (s *forkState) SubBalance(addr common.Address, amount *big.Int) {
+	account := s.account(addr, true)
+	account.balance.Sub(account.balance, amount)
+}
+
+func (s *forkState) AddBalance(addr common.Address, amount *big.Int) {
+	account := s.account(addr, true)
+	account.balance.Add(account.balance, amount)
+}
+
+func (s *forkState) GetBalance(addr common.Address) *big.Int {
+	return new(big.Int).Set(s.account(addr, false).balance)
+}
+
+func (s *forkState) GetNonce(addr common.Address) uint64 {
+	return s.account(addr, false).nonce
+}
+
+func (s *forkState) SetNonce(addr common.Address, nonce uint64) {
+	s.account(addr, true).nonce = nonce
+}
+
+func (s *forkState) GetCodeHash(addr common.Address) common.Hash {
+	if !s.Exist(addr) {
+		return common.Hash{}
+	}
+	return crypto.Keccak256Hash(s.GetCode(addr))
+}
+
+func (s *forkState) GetCode(addr common.Address) []byte {
+	return s.account(addr, false).code
+}
+
+func (s *forkState) SetCode(addr common.Address, code []byte) {
+	s.account(addr, true).code = code
+}
+
+func (s *forkState) GetCodeSize(addr common.Address) int {
+	return len(s.GetCode(addr))
+}
+
+func (s *forkState) AddRefund(gas uint64) { s.overlay.refund += gas }
+
+func (s *forkState) SubRefund(gas uint64) { s.overlay.refund -= gas }
+
+func (s *forkState) GetRefund() uint64 { return s.overlay.refund }
+
+func (s *forkState) GetCommittedState(addr common.Address, key common.Hash) common.Hash {
+	if slots, ok := s.storage[addr]; ok {
+		if value, ok := slots[key]; ok {
+			return value
+		}
+	} else {
+		s.storage[addr] = make(map[common.Hash]common.Hash)
+	}
+	value, err := s.remote.StorageAt(s.ctx, addr, key, s.block)
+	if err != nil {
+		s.fail(err)
+	}
+	s.storage[addr][key] = common.BytesToHash(value)
+	return s.storage[addr][key]
+}
+
+func (s *forkState) GetState(addr common.Address, key common.Hash) common.Hash {
+	if slots, ok := s.overlay.storage[addr]; ok {
+		if value, ok := slots[key]; ok {
+			return value
+		}
+	}
+	return s.GetCommittedState(addr, key)
+}
+
+func (s *forkState) SetState(addr common.Address, key, value common.Hash) {
+	if _, ok := s.overlay.storage[addr]; !ok {
+		s.overlay.storage[addr] = make(map[common.Hash]common.Hash)
+	}
+	s.overlay.storage[addr][key] = value
+}
+
+func (s *forkState) Suicide(addr common.Address) bool {
+	if !s.Exist(addr) {
+		return false
+	}
+	s.overlay.suicided[addr] = true
+	s.account(addr, true).balance = new(big.Int)
+	return true
+}
+
+func (s *forkState) HasSuicided(addr common.Address) bool {
+	return s.overlay.suicided[addr]
+}
+
+func (s *forkState) Exist(addr common.Address) bool {
+	if _, ok := s.overlay.accounts[addr]; ok {
+		return true
+	}
+	return !s.Empty(addr)
+}
+
+func (s *forkState) Empty(addr common.Address) bool {
+	account := s.account(addr, false)
+	return account.nonce == 0 && account.balance.Sign() == 0 && len(account.code) == 0
+}
+
+// The access list only changes gas costs, so every address and slot is
+// treated as warm.
+func (s *forkState) PrepareAccessList(sender common.Address, dest *common.Address, precompiles []common.Address, txAccesses types.AccessList) {
+}
+func (s *forkState) AddressInAccessList(addr common.Address) bool { return true }
+func (s *forkState) SlotInAccessList(addr common.Address, slot common.Hash) (bool, bool) {
+	return true, true
+}
+func (s *forkState) AddAddressToAccessList(addr common.Address)                {}
+func (s *forkState) AddSlotToAccessList(addr common.Address, slot common.Hash) {}
+
+func (s *forkState) Snapshot() int {
+	s.snapshots = append(s.snapshots, s.overlay.copy())
+	return len(s.snapshots) - 1
+}
+
+func (s *forkState) RevertToSnapshot(id int) {
+	s.overlay = s.snapshots[id]
+	s.snapshots = s.snapshots[:id]
+}
+
+func (s *forkState) AddLog(log *types.Log) { s.logs = append(s.logs, log) }
+
+func (s *forkState) AddPreimage(common.Hash, []byte) {}
+
+func (s *forkState) ForEachStorage(addr common.Address, cb func(common.Hash, common.Hash) bool) error {
+	for key, value := range s.overlay.storage[addr] {
+		if !cb(key, value) {
+			break
+		}
+	}
+	return nil
+}
+
+// newForkEVM runs calls on state as if in the block after header, blockTime
+// later.
+func newForkEVM(state *forkState, header *types.Header, chainID *big.Int, blockTime time.Duration) *vm.EVM {
+	config := *params.AllEthashProtocolChanges
+	config.ChainID = chainID
+	blockCtx := vm.BlockContext{
+		CanTransfer: core.CanTransfer,
+		Transfer:    core.Transfer,
+		GetHash:     func(uint64) common.Hash { return common.Hash{} },
+		Coinbase:    header.Coinbase,
+		GasLimit:    header.GasLimit,
+		BlockNumber: new(big.Int).Add(header.Number, common.Big1),
+		Time:        new(big.Int).SetUint64(header.Time + uint64(blockTime.Round(time.Second)/time.Second)),
+		Difficulty:  header.Difficulty,
+		BaseFee:     new(big.Int),
+	}
+	return vm.NewEVM(blockCtx, vm.TxContext{GasPrice: new(big.Int)}, state, &config, vm.Config{NoBaseFee: true})
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// fakeState is remote state held in maps, counting the reads it serves.
+type fakeState struct {
+	balances map[common.Address]int64
+	storage  map[common.Address]map[common.Hash]common.Hash
+	reads    int
+	err      error
+}
+
+func (f *fakeState) BalanceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (*big.Int, error) {
+	f.reads++
+	return big.NewInt(f.balances[account]), f.err
+}
+
+func (f *fakeState) NonceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (uint64, error) {
+	return 0, f.err
+}
+
+func (f *fakeState) CodeAt(ctx context.Context, account common.Address, blockNumber *big.Int) ([]byte, error) {
+	return nil, f.err
+}
+
+func (f *fakeState) StorageAt(ctx context.Context, account common.Address, key common.Hash, blockNumber *big.Int) ([]byte, error) {
+	f.reads++
+	return f.storage[account][key].Bytes(), f.err
+}
+
+func TestForkStateOverlay(t *testing.T) {
+	contract := testToken(1)
+	slot, other := common.HexToHash("0x01"), common.HexToHash("0x02")
+	remote := &fakeState{
+		balances: map[common.Address]int64{contract: 100},
+		storage:  map[common.Address]map[common.Hash]common.Hash{contract: {slot: common.HexToHash("0xaa")}},
+	}
+	state := newForkState(context.Background(), remote, big.NewInt(10))
+
+	state.SetState(contract, slot, common.HexToHash("0xbb"))
+	if got := state.GetState(contract, slot); got != common.HexToHash("0xbb") {
+		t.Errorf("slot reads %s after a write, want 0xbb", got.Hex())
+	}
+	// the committed value is the one the transaction started from
+	if got := state.GetCommittedState(contract, slot); got != common.HexToHash("0xaa") {
+		t.Errorf("committed slot %s, want the remote 0xaa", got.Hex())
+	}
+	if got := state.GetState(contract, other); got != (common.Hash{}) {
+		t.Errorf("unset slot reads %s, want zero", got.Hex())
+	}
+	state.AddBalance(contract, big.NewInt(5))
+	if got := state.GetBalance(contract); got.Int64() != 105 {
+		t.Errorf("balance %s after adding 5, want 105", got)
+	}
+
+	// Writes are dropped on reset but what was read stays cached
+	reads := remote.reads
+	state.reset()
+	if got := state.GetState(contract, slot); got != common.HexToHash("0xaa") {
+		t.Errorf("slot reads %s after reset, want the remote 0xaa", got.Hex())
+	}
+	if got := state.GetBalance(contract); got.Int64() != 100 {
+		t.Errorf("balance %s after reset, want the remote 100", got)
+	}
+	if remote.reads != reads {
+		t.Errorf("reset state read the node %d more times", remote.reads-reads)
+	}
+	if state.err != nil {
+		t.Errorf("reads failed: %v", state.err)
+	}
+}
+
+func TestForkStateSnapshots(t *testing.T) {
+	contract := testToken(1)
+	slot := common.HexToHash("0x01")
+	state := newForkState(context.Background(), &fakeState{}, big.NewInt(10))
+	value := func() common.Hash { return state.GetState(contract, slot) }
+
+	state.SetState(contract, slot, common.HexToHash("0x01"))
+	state.AddBalance(contract, big.NewInt(1))
+	outer := state.Snapshot()
+	state.SetState(contract, slot, common.HexToHash("0x02"))
+	state.AddBalance(contract, big.NewInt(1))
+	state.AddRefund(10)
+	inner := state.Snapshot()
+	state.SetState(contract, slot, common.HexToHash("0x03"))
+	state.Suicide(contract)
+
+	state.RevertToSnapshot(inner)
+	if value() != common.HexToHash("0x02") || state.HasSuicided(contract) || state.GetBalance(contract).Int64() != 2 {
+		t.Errorf("inner revert left slot %s, suicided %v, balance %s; want 0x02, false, 2", value().Hex(), state.HasSuicided(contract), state.GetBalance(contract))
+	}
+	// a later snapshot reuses the reverted one's id
+	if again := state.Snapshot(); again != inner {
+		t.Errorf("snapshot after revert got id %d, want %d", again, inner)
+	}
+	state.SetState(contract, slot, common.HexToHash("0x04"))
+
+	state.RevertToSnapshot(outer)
+	if value() != common.HexToHash("0x01") || state.GetBalance(contract).Int64() != 1 || state.GetRefund() != 0 {
+		t.Errorf("outer revert left slot %s, balance %s, refund %d; want 0x01, 1, 0", value().Hex(), state.GetBalance(contract), state.GetRefund())
+	}
+}
+
+func TestForkStateRemoteFailure(t *testing.T) {
+	remote := &fakeState{err: errors.New("missing trie node")}
+	state := newForkState(context.Background(), remote, big.NewInt(10))
+	state.GetBalance(testToken(1))
+	state.GetState(testToken(1), common.Hash{})
+	if !errors.Is(state.err, remote.err) {
+		t.Errorf("state err %v, want the first failed read", state.err)
+	}
+}
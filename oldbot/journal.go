@@ -0,0 +1,253 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// JournalConfig places the opportunity journal and says when to rotate it.
+type JournalConfig struct {
+	// Path is the JSONL file records are appended to, empty for none.
+	Path string
+	// MaxMB is the size the journal is rotated at. Rotated files are kept
+	// next to it with the time of rotation appended, Keep of them.
+	MaxMB int64
+	Keep  int
+}
+
+// Decisions taken on an opportunity.
+const (
+	// decisionObserved is an opportunity only logged: no executor or key
+	// is configured, or it was found in the mempool.
+	decisionObserved = "observed"
+	decisionDropped  = "dropped"
+	decisionSent     = "sent"
+	decisionFailed   = "failed"
+)
+
+// OpportunityRecord is one detected opportunity and what became of it, as
+// one line of the journal.
+type OpportunityRecord struct {
+	Time      time.Time
+	Chain     string
+	Block     uint64
+	BlockHash common.Hash
+	Pools     []common.Address
+	Tokens    []common.Address
+	Path      []string
+	// Split is the loop sized across the pools parallel to its hops, when
+	// that would pay more than the single pool sizing that is traded.
+	Split     *LoopSplit `json:",omitempty"`
+	AmountIn  *big.Int
+	AmountOut *big.Int
+	// GrossProfit is in the first token: the simulated profit once
+	// simulated, else the computed one. NetProfit has the gas cost taken
+	// out, when the gas was estimated and the graph prices the native coin
+	// in the first token.
+	GrossProfit *big.Int
+	NetProfit   *big.Int `json:",omitempty"`
+	GasEstimate uint64   `json:",omitempty"`
+	GasPrice    *big.Int `json:",omitempty"`
+	// LatencyMs is from the block's timestamp to the scan finding the loop.
+	LatencyMs int64
+	Decision  string
+	Reason    string      `json:",omitempty"`
+	Tx        common.Hash `json:",omitempty"`
+	Via       string      `json:",omitempty"`
+
+	nativeRate float64
+	tokens     *TokenRegistry
+	wrapped    common.Address
+}
+
+func newOpportunityRecord(chain string, opp Opportunity, tokens *TokenRegistry, wrapped common.Address) *OpportunityRecord {
+	record := &OpportunityRecord{
+		Time:        opp.detected,
+		Chain:       chain,
+		Block:       opp.number,
+		BlockHash:   opp.block,
+		AmountIn:    new(big.Int).Set(&opp.amountIn),
+		AmountOut:   new(big.Int).Add(&opp.amountIn, &opp.profit),
+		GrossProfit: new(big.Int).Set(&opp.profit),
+		Split:       opp.split,
+		nativeRate:  opp.nativeRate,
+		tokens:      tokens,
+		wrapped:     wrapped,
+	}
+	if record.Time.IsZero() {
+		record.Time = time.Now()
+	}
+	if !opp.blockTime.IsZero() {
+		record.LatencyMs = record.Time.Sub(opp.blockTime).Milliseconds()
+	}
+	record.Tokens = append(record.Tokens, opp.pairs[0].from)
+	record.Path = append(record.Path, opp.pairs[0].from_symbol)
+	for _, pair := range opp.pairs {
+		record.Pools = append(record.Pools, pair.factory)
+		record.Tokens = append(record.Tokens, pair.to)
+		record.Path = append(record.Path, pair.to_symbol)
+	}
+	return record
+}
+
+// simulated replaces the computed profit with the simulated one.
+func (r *OpportunityRecord) simulated(profit *big.Int, gas uint64) {
+	r.GrossProfit = new(big.Int).Set(profit)
+	r.GasEstimate = gas
+	r.net()
+}
+
+// priced sets the gas price the transaction would pay.
+func (r *OpportunityRecord) priced(gasPrice *big.Int) {
+	r.GasPrice = new(big.Int).Set(gasPrice)
+	r.net()
+}
+
+// net takes the gas cost, converted to the first token, out of the gross
+// profit.
+func (r *OpportunityRecord) net() {
+	if r.GasEstimate == 0 || r.GasPrice == nil || r.nativeRate <= 0 {
+		return
+	}
+	cost := new(big.Int).Mul(r.GasPrice, new(big.Int).SetUint64(r.GasEstimate))
+	if r.Tokens[0] != r.wrapped {
+		whole := new(big.Float).Mul(r.tokens.Normalize(r.wrapped, cost), big.NewFloat(r.nativeRate))
+		scale := new(big.Float).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(r.tokens.Decimals(r.Tokens[0]))), nil))
+		cost, _ = whole.Mul(whole, scale).Int(nil)
+	}
+	r.NetProfit = new(big.Int).Sub(r.GrossProfit, cost)
+}
+
+func (r *OpportunityRecord) decide(decision string, reason error) {
+	r.Decision = decision
+	if reason != nil {
+		r.Reason = reason.Error()
+	}
+}
+
+// nativeRate is how many whole token one whole native coin buys on the
+// graph, or zero without a direct pool.
+func nativeRate(graph *Graph, wrapped, token common.Address) float64 {
+	if token == wrapped {
+		return 1
+	}
+	rate, ok := graph.rate(wrapped, token)
+	if !ok {
+		return 0
+	}
+	return rate
+}
+
+// Journal appends opportunity records to a JSONL file, rotating it when it
+// grows past its limit. It is safe for concurrent use; the main loop and
+// the mempool watcher both write to it.
+type Journal struct {
+	config JournalConfig
+	mu     sync.Mutex
+	file   *os.File
+	size   int64
+}
+
+// OpenJournal opens the journal for appending. An empty path journals
+// nothing.
+func OpenJournal(config JournalConfig) (*Journal, error) {
+	j := &Journal{config: config}
+	if config.Path == "" {
+		return j, nil
+	}
+	if err := j.open(); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+func (j *Journal) open() error {
+	if dir := filepath.Dir(j.config.Path); dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	file, err := os.OpenFile(j.config.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	j.file, j.size = file, info.Size()
+	return nil
+}
+
+// Write appends record as one line.
+func (j *Journal) Write(record *OpportunityRecord) error {
+	if j.config.Path == "" {
+		return nil
+	}
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.config.MaxMB > 0 && j.size > 0 && j.size+int64(len(line)) > j.config.MaxMB<<20 {
+		if err := j.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := j.file.Write(line)
+	j.size += int64(n)
+	return err
+}
+
+// rotate moves the full journal aside and starts a new one, deleting the
+// oldest rotated files beyond Keep.
+func (j *Journal) rotate() error {
+	if err := j.file.Close(); err != nil {
+		return err
+	}
+	stamp := time.Now().UTC().Format("20060102T150405.000")
+	rotated := j.config.Path + "." + stamp
+	// a second rotation within the millisecond must not overwrite the first
+	for n := 1; ; n++ {
+		if _, err := os.Stat(rotated); os.IsNotExist(err) {
+			break
+		}
+		rotated = fmt.Sprintf("%s.%s.%d", j.config.Path, stamp, n)
+	}
+	if err := os.Rename(j.config.Path, rotated); err != nil {
+		return err
+	}
+	old, err := filepath.Glob(j.config.Path + ".*")
+	if err != nil {
+		return err
+	}
+	// The timestamps sort in time order
+	sort.Strings(old)
+	for len(old) > j.config.Keep {
+		if err := os.Remove(old[0]); err != nil {
+			return err
+		}
+		old = old[1:]
+	}
+	return j.open()
+}
+
+func (j *Journal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.file == nil {
+		return nil
+	}
+	return j.file.Close()
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestJournalRotation(t *testing.T) {
+	// two of these fill most of a megabyte, so every other write rotates
+	reason := strings.Repeat("x", 400<<10)
+	tests := []struct {
+		name    string
+		maxMB   int64
+		keep    int
+		writes  int
+		rotated int
+		current int
+	}{
+		{"under the limit", 1, 3, 2, 0, 2},
+		{"rotated files kept", 1, 3, 5, 2, 1},
+		{"oldest rotated files deleted", 1, 2, 9, 2, 1},
+		{"none kept", 1, 0, 7, 0, 1},
+		{"no limit", 0, 3, 9, 0, 9},
+	}
+	for _, test := range tests {
+		path := filepath.Join(t.TempDir(), "journal", "opportunities.jsonl")
+		journal, err := OpenJournal(JournalConfig{Path: path, MaxMB: test.maxMB, Keep: test.keep})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < test.writes; i++ {
+			if err := journal.Write(&OpportunityRecord{Block: uint64(i), Reason: reason}); err != nil {
+				t.Fatalf("%s: write %d: %v", test.name, i, err)
+			}
+		}
+		if err := journal.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		rotated, err := filepath.Glob(path + ".*")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rotated) != test.rotated {
+			t.Errorf("%s: %d rotated files, want %d", test.name, len(rotated), test.rotated)
+		}
+		blocks := journalBlocks(t, path)
+		if len(blocks) != test.current || blocks[len(blocks)-1] != uint64(test.writes-1) {
+			t.Errorf("%s: journal holds blocks %v, want the last %d", test.name, blocks, test.current)
+		}
+		// the newest rotated files are the ones kept
+		sort.Strings(rotated)
+		next := uint64(test.writes - test.current)
+		for i := len(rotated) - 1; i >= 0; i-- {
+			blocks := journalBlocks(t, rotated[i])
+			if len(blocks) == 0 || blocks[len(blocks)-1] != next-1 {
+				t.Errorf("%s: %s holds blocks %v, want up to %d", test.name, filepath.Base(rotated[i]), blocks, next-1)
+				break
+			}
+			next = blocks[0]
+		}
+	}
+}
+
+// journalBlocks are the blocks of the records in a journal file, in order.
+func journalBlocks(t *testing.T, path string) []uint64 {
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	blocks := []uint64{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 1<<20), 1<<20)
+	for scanner.Scan() {
+		var record OpportunityRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatal(err)
+		}
+		blocks = append(blocks, record.Block)
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return blocks
+}
+
+func TestJournalWithoutPath(t *testing.T) {
+	journal, err := OpenJournal(JournalConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := journal.Write(&OpportunityRecord{}); err != nil {
+		t.Error(err)
+	}
+	if err := journal.Close(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// setupLogging sends logs at config.Level and above to stderr, as aligned
+// text for a terminal, logfmt, or one JSON object per line for collectors.
+func setupLogging(config LoggingConfig) error {
+	level, err := log.LvlFromString(config.Level)
+	if err != nil {
+		return err
+	}
+	var format log.Format
+	switch config.Format {
+	case "terminal":
+		format = log.TerminalFormat(false)
+	case "logfmt":
+		format = log.LogfmtFormat()
+	case "json":
+		format = log.JSONFormat()
+	default:
+		return fmt.Errorf("unknown log format %q", config.Format)
+	}
+	log.Root().SetHandler(log.LvlFilterHandler(level, log.StreamHandler(os.Stderr, format)))
+	return nil
+}
@@ -1,20 +1,24 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"flag"
 	"fmt"
-	"io"
-	"log"
 	"math"
 	"math/big"
+	"net/http"
 	"os"
+	"strings"
 	"sync"
 	"time"
 
-	"example.com/m/pancakeFactory"
 	"example.com/m/pancakePair"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
 	"github.com/ethereum/go-ethereum/common"
-	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/log"
 )
 
 type Pairs struct {
@@ -27,6 +31,9 @@ type PairIn struct {
 	To          common.Address `json:"to"`
 	To_symbol   string         `json:"to_symbol"`
 	Factory     common.Address `json:"factory"`
+	// Fee is the pair's swap fee in basis points, looked up from its
+	// factory's DEX when the pairs file leaves it out.
+	Fee int64 `json:"fee,omitempty"`
 }
 
 type Pair struct {
@@ -38,6 +45,21 @@ type Pair struct {
 	r_to        big.Int
 	price       big.Float
 	factory     common.Address
+	// fee is the pool's swap fee in basis points
+	fee int64
+	// tax is the transfer tax of from, the fraction of what is sent that
+	// never reaches the pool
+	tax float64
+}
+
+// inputFee is the part of what is sent to the pool, in basis points, that
+// it does not trade: the transfer tax and then the pool fee. It is rounded
+// up so outputs are never overestimated.
+func (p Pair) inputFee() int64 {
+	if p.tax <= 0 {
+		return p.fee
+	}
+	return int64(math.Ceil(feeDenom - float64(feeDenom-p.fee)*(1-p.tax)))
 }
 
 type TokenPair struct {
@@ -57,8 +79,14 @@ type TokenPair struct {
 
 type Graph struct {
 	nodes   []*GraphNode
-	nodeIds map[string]int
-	mu      sync.Mutex
+	nodeIds map[common.Address]int
+	tokens  *TokenRegistry
+	// sources are the tokens loops are searched from
+	sources []common.Address
+	// splitPools sizes loops with each hop's input split across the pools
+	// parallel to it
+	splitPools bool
+	mu         sync.Mutex
 }
 
 type GraphNode struct {
@@ -67,6 +95,8 @@ type GraphNode struct {
 	address  common.Address
 	edges    map[int]float64
 	edgePair map[int]Pair
+	// edgePools are all the pools to a neighbor, edgePair the best of them
+	edgePools map[int][]Pair
 }
 
 type Edge struct {
@@ -79,13 +109,13 @@ type Edge struct {
 func New() *Graph {
 	return &Graph{
 		nodes:   []*GraphNode{},
-		nodeIds: make(map[string]int),
+		nodeIds: make(map[common.Address]int),
 	}
 }
 
 func (g *Graph) AddNode(asset string, address common.Address) (id int, exists bool) {
 	g.mu.Lock()
-	id, exist := g.nodeIds[asset]
+	id, exist := g.nodeIds[address]
 	g.mu.Unlock()
 	if exist {
 		return id, true
@@ -93,13 +123,14 @@ func (g *Graph) AddNode(asset string, address common.Address) (id int, exists bo
 		g.mu.Lock()
 		id = len(g.nodes)
 		g.nodes = append(g.nodes, &GraphNode{
-			id:       id,
-			asset:    asset,
-			address:  address,
-			edges:    make(map[int]float64),
-			edgePair: make(map[int]Pair),
+			id:        id,
+			asset:     asset,
+			address:   address,
+			edges:     make(map[int]float64),
+			edgePair:  make(map[int]Pair),
+			edgePools: make(map[int][]Pair),
 		})
-		g.nodeIds[asset] = id
+		g.nodeIds[address] = id
 		g.mu.Unlock()
 		return id, false
 	}
@@ -112,6 +143,13 @@ func (g *Graph) AddEdge(n1, n2 int, w float64, pair Pair) {
 	g.mu.Unlock()
 }
 
+// AddPool notes one more pool trading from n1 to n2.
+func (g *Graph) AddPool(n1, n2 int, pair Pair) {
+	g.mu.Lock()
+	g.nodes[n1].edgePools[n2] = append(g.nodes[n1].edgePools[n2], pair)
+	g.mu.Unlock()
+}
+
 func (g *Graph) Neighbors(id int) []int {
 	g.mu.Lock()
 	defer g.mu.Unlock()
@@ -176,7 +214,8 @@ func (g *Graph) FindNegativeWeightCycle(predecessors []int, distances []float64,
 
 func arbitrageLoop(predecessors []int, source int) []int {
 	size := len(predecessors)
-	loop := make([]int, size)
+	// a loop through every node comes back to its start at index size
+	loop := make([]int, size+1)
 	loop[0] = source
 
 	exists := make([]bool, size)
@@ -189,7 +228,12 @@ func arbitrageLoop(predecessors []int, source int) []int {
 		next = predecessors[next]
 		loop[index] = next
 		if exists[next] {
-			return loop[indices[next] : index+1]
+			// predecessors walk the loop backwards, turn it to trading order
+			cycle := loop[indices[next] : index+1]
+			for i, j := 0, len(cycle)-1; i < j; i, j = i+1, j-1 {
+				cycle[i], cycle[j] = cycle[j], cycle[i]
+			}
+			return cycle
 		}
 		indices[next] = index
 		exists[next] = true
@@ -208,14 +252,17 @@ func (g *Graph) FindArbitrageLoop(source int) []int {
 	}
 }
 
-func Eb(e1, convertFrom, convertTo *big.Int) big.Int {
+// feeDenom is what pool fees are out of: they are in basis points.
+const feeDenom = 10000
+
+func Eb(e1, convertFrom, convertTo *big.Int, fee int64) big.Int {
 	eb := new(big.Int)
 	numerator := new(big.Int)
 	denominator := new(big.Int)
 
-	//pancake swap fee is 0.025 so value out r, is .975
-	fee_num := big.NewInt(975)
-	fee_dom := big.NewInt(1000)
+	// the value out r of a pool is what its fee leaves
+	fee_num := big.NewInt(feeDenom - fee)
+	fee_dom := big.NewInt(feeDenom)
 	// (E1*r*ConvertTo)/(ConvertFrom+r*E1)
 	//e1 * r
 	numerator.Mul(e1, fee_num)
@@ -230,14 +277,14 @@ func Eb(e1, convertFrom, convertTo *big.Int) big.Int {
 	return *eb
 }
 
-func Ea(e0, e1, convertFrom *big.Int) big.Int {
+func Ea(e0, e1, convertFrom *big.Int, fee int64) big.Int {
 	ea := new(big.Int)
 	numerator := new(big.Int)
 	denominator := new(big.Int)
 
-	//pancake swap fee is 0.025 so value out r, is .975
-	fee_num := big.NewInt(975)
-	fee_dom := big.NewInt(1000)
+	// the value out r of a pool is what its fee leaves
+	fee_num := big.NewInt(feeDenom - fee)
+	fee_dom := big.NewInt(feeDenom)
 	// (E0*ConvertFrom)/(ConvertFrom+r*E1)
 	//e1 * r
 
@@ -251,27 +298,63 @@ func Ea(e0, e1, convertFrom *big.Int) big.Int {
 	return *ea
 }
 
-func evaluate(e0, f0, delta *big.Int) big.Int {
+func evaluate(e0, f0, delta *big.Int, fee int64) big.Int {
 	e := new(big.Int)
 	numerator := new(big.Int)
 	denominator := new(big.Int)
 
-	//pancake swap fee is 0.025 so value out r, is .975
-	fee_num := big.NewInt(975)
-	fee_dom := big.NewInt(1000)
+	// the value out r of a pool is what its fee leaves
+	fee_num := big.NewInt(feeDenom - fee)
+	fee_dom := big.NewInt(feeDenom)
 
 	delta_r := new(big.Int)
 
 	delta_r.Mul(delta, fee_num)
-	delta_r.Div(delta, fee_dom)
+	delta_r.Div(delta_r, fee_dom)
 
-	numerator.Mul(e0, delta_r)
-	denominator.Add(f0, delta_r)
+	// (F0*r*delta)/(E0+r*delta), E0 the reserve in and F0 the reserve out
+	numerator.Mul(f0, delta_r)
+	denominator.Add(e0, delta_r)
 
 	e.Div(numerator, denominator)
 	return *e
 }
 
+// getAmountOut is what a pool charging fee pays out for amountIn.
+func getAmountOut(amountIn, reserveIn, reserveOut *big.Int, fee int64) *big.Int {
+	numerator := new(big.Int)
+	denominator := new(big.Int)
+
+	// the value out r of a pool is what its fee leaves
+	fee_num := big.NewInt(feeDenom - fee)
+	fee_dom := big.NewInt(feeDenom)
+
+	// (amountIn*r*reserveOut)/(reserveIn+amountIn*r)
+	amountIn_r := new(big.Int).Mul(amountIn, fee_num)
+	numerator.Mul(amountIn_r, reserveOut)
+	denominator.Mul(reserveIn, fee_dom)
+	denominator.Add(denominator, amountIn_r)
+	return numerator.Div(numerator, denominator)
+}
+
+// getAmountIn is the smallest input that gets amountOut from a pool, rounded
+// up so the pool's invariant still holds.
+func getAmountIn(amountOut, reserveIn, reserveOut *big.Int, fee int64) *big.Int {
+	numerator := new(big.Int)
+	denominator := new(big.Int)
+
+	fee_num := big.NewInt(feeDenom - fee)
+	fee_dom := big.NewInt(feeDenom)
+
+	// (reserveIn*amountOut)/((reserveOut-amountOut)*r) + 1
+	numerator.Mul(reserveIn, amountOut)
+	numerator.Mul(numerator, fee_dom)
+	denominator.Sub(reserveOut, amountOut)
+	denominator.Mul(denominator, fee_num)
+	numerator.Div(numerator, denominator)
+	return numerator.Add(numerator, big.NewInt(1))
+}
+
 func simplifyArb(eVals [][]big.Int, pairs []Pair) [][]big.Int {
 
 	// 4 pairs [(A,B), (B',C), (C',D), (D',A)]
@@ -291,24 +374,25 @@ func simplifyArb(eVals [][]big.Int, pairs []Pair) [][]big.Int {
 		e1 := eVals[last][1]
 		e1_ := pairs[i].r_from
 		e2 := pairs[i].r_to
-		val_i0 := Ea(&e0, &e1, &e1_)
-		val_i1 := Eb(&e1, &e1_, &e2)
+		// the loop so far is one virtual pool in front of pair i, and
+		// pair i's fee is taken from what the virtual pool pays it
+		val_i0 := Ea(&e0, &e1, &e1_, pairs[i].inputFee())
+		val_i1 := Eb(&e1, &e1_, &e2, pairs[i].inputFee())
 		val_i := []big.Int{val_i0, val_i1}
 		eVals = append(eVals, val_i)
 	}
-	fmt.Println(len(eVals))
 	return eVals
 }
 
-func findDelta(e0, e1 big.Int) big.Int {
+func findDelta(e0, e1 big.Int, fee int64) big.Int {
 	delta := new(big.Int)
 
 	numerator := new(big.Int)
 	x := new(big.Int)
 
-	//pancake swap fee is 0.025 so value out r, is .975
-	fee_num := big.NewInt(975)
-	fee_dom := big.NewInt(1000)
+	// the value out r of a pool is what its fee leaves
+	fee_num := big.NewInt(feeDenom - fee)
+	fee_dom := big.NewInt(feeDenom)
 
 	x.Mul(&e0, &e1)
 	x.Mul(x, fee_num)
@@ -320,95 +404,167 @@ func findDelta(e0, e1 big.Int) big.Int {
 	return *delta
 }
 
-// Note: this might not work for a swap
-func optimalVolume(pairs []Pair) (big.Int, big.Int) {
+// optimalVolume sizes a loop. hops are the pools each hop can trade
+// through, the loop's own pair first. The closed form sizes the loop over
+// those pairs, which is what the executor trades. When a hop has more
+// pools, splitVolume also sizes it with the hop's input split across them,
+// and that split is returned alongside when it pays more. It is only
+// reported: an executor hop swaps through one pool, so the split cannot be
+// traded yet.
+func optimalVolume(hops [][]Pair) (big.Int, big.Int, *LoopSplit) {
+	pairs := make([]Pair, len(hops))
+	parallel := false
+	for i, hop := range hops {
+		pairs[i] = hop[0]
+		parallel = parallel || len(hop) > 1
+	}
+	delta_in, delta_out := loopVolume(pairs)
+	if !parallel || delta_in.Sign() <= 0 {
+		return delta_in, delta_out, nil
+	}
+	split_in, split_out, split := splitVolume(hops, &delta_in)
+	if split == nil || split_out.Cmp(&delta_out) <= 0 {
+		return delta_in, delta_out, nil
+	}
+	return delta_in, delta_out, &LoopSplit{AmountIn: &split_in, Profit: &split_out, Hops: split}
+}
+
+// loopVolume is the closed form volume and profit of a loop of pairs.
+func loopVolume(pairs []Pair) (big.Int, big.Int) {
 	eVals := make([][]big.Int, 0)
-	e0 := Ea(&pairs[0].r_from, &pairs[0].r_to, &pairs[1].r_from)
-	e1 := Eb(&pairs[0].r_to, &pairs[1].r_from, &pairs[1].r_to)
-	eVals = append(eVals, []big.Int{e0, e1})
+	// simplifyArb folds in every pair after the first
+	eVals = append(eVals, []big.Int{pairs[0].r_from, pairs[0].r_to})
 	eVals_simp := simplifyArb(eVals, pairs)
 
 	ea_val := eVals_simp[len(eVals_simp)-1][0]
 	eb_val := eVals_simp[len(eVals_simp)-1][1]
 
-	delta_in := findDelta(ea_val, eb_val)
+	// the virtual pool charges the first pair's fee on the way in
+	delta_in := findDelta(ea_val, eb_val, pairs[0].inputFee())
 	if delta_in.Cmp(big.NewInt(0)) > 0 {
-		delta_out := evaluate(&ea_val, &eb_val, &delta_in)
-		fmt.Println(ea_val.String(), eb_val.String())
-		fmt.Println("In & out: ", delta_in.String(), delta_out.String())
+		delta_out := evaluate(&ea_val, &eb_val, &delta_in, pairs[0].inputFee())
 		delta_out.Sub(&delta_out, &delta_in)
 		return delta_in, delta_out
 	}
 	return *big.NewInt(0), *big.NewInt(0)
 }
 
-func runArb(factory *pancakeFactory.PancakeFactory, pairs []PairIn, client *ethclient.Client) {
-	market := New()
+// PoolState is a pair from the pairs file with its reserves at one block.
+// reserve0 belongs to in.From, which the pairs file always lists as token0.
+type PoolState struct {
+	in       PairIn
+	reserve0 big.Int
+	reserve1 big.Int
+	// transfer taxes of token0 and token1 as fractions, when vetted
+	tax0 float64
+	tax1 float64
+}
+
+// fetchPools reads every pair's reserves at block. Pools in quarantine are
+// skipped and pools that fail are left out, so the scan goes on with
+// whatever could be read; report says which were missed.
+func fetchPools(ctx context.Context, pairs []PairIn, client *RPCPool, block *big.Int, skipped *quarantine) ([]PoolState, *ScanReport) {
+	report := &ScanReport{Block: block.Uint64()}
+	pools := make([]PoolState, 0, len(pairs))
+	opts := &bind.CallOpts{Context: ctx, BlockNumber: block}
 	for i := 0; i < len(pairs); i++ {
 		pair_address := pairs[i].Factory
+		if skipped.skip(pair_address) {
+			report.Quarantined = append(report.Quarantined, skipped.pools[pair_address].lastErr)
+			continue
+		}
 		pair_contract, err := pancakePair.NewPancakePair(pair_address, client)
 		if err != nil {
-			log.Fatal(err)
+			report.Failed = append(report.Failed, &PoolError{Pool: pair_address, Kind: ErrDecode, Err: err})
+			continue
 		}
-		reserves, err := pair_contract.GetReserves(nil)
+		reserves, err := pair_contract.GetReserves(opts)
 		if err != nil {
-			log.Fatal(err)
+			if ctx.Err() != nil {
+				break
+			}
+			failure := poolError(pair_address, err)
+			skipped.failed(failure)
+			report.Failed = append(report.Failed, failure)
+			continue
 		}
+		skipped.succeeded(pair_address)
+		pools = append(pools, PoolState{in: pairs[i], reserve0: *reserves.Reserve0, reserve1: *reserves.Reserve1})
+	}
+	report.Pools = len(pools)
+	return pools, report
+}
 
-		from := pairs[i].From_symbol
-		to := pairs[i].To_symbol
-		r_from := *reserves.Reserve0
-		r_to := *reserves.Reserve1
-		res0 := new(big.Float).SetInt(reserves.Reserve0)
-		res1 := new(big.Float).SetInt(reserves.Reserve1)
-		one_token := 10000000000000000.0
-		if res0.Cmp(big.NewFloat(one_token)) < 0 || res1.Cmp(big.NewFloat(one_token)) < 0 {
+func buildMarket(pools []PoolState, tokens *TokenRegistry) *Graph {
+	market := New()
+	market.tokens = tokens
+	for i := 0; i < len(pools); i++ {
+		pair := pools[i].in
+		pair.From_symbol = tokens.Symbol(pair.From, pair.From_symbol)
+		pair.To_symbol = tokens.Symbol(pair.To, pair.To_symbol)
+		from := pair.From_symbol
+		to := pair.To_symbol
+		r_from := pools[i].reserve0
+		r_to := pools[i].reserve1
+		if r_from.Sign() == 0 || r_to.Sign() == 0 {
+			continue
+		}
+		// Prices need both tokens' decimals, and a guess would misprice
+		// the pool by powers of ten
+		if !tokens.Resolved(pair.From) || !tokens.Resolved(pair.To) {
+			log.Debug("Skipping pool of unresolved token", "pool", pair.Factory, "from", from, "to", to)
 			continue
 		}
 
-		price := new(big.Float)
-		price_float, _ := price.Quo(res1, res0).Float64()
+		// A taxed token loses part of every transfer, and each transfer of
+		// a loop goes into the next pool, so each way is charged the tax of
+		// the token sent in. The pool keeps its fee of what arrives.
+		keep := float64(feeDenom-pair.Fee) / feeDenom
+		// Prices are in whole tokens so they read right across decimals
+		price := new(big.Float).Mul(tokens.Price(pair.From, pair.To, &r_from, &r_to), big.NewFloat(keep*(1-pools[i].tax0)))
+		reverse := new(big.Float).Mul(tokens.Price(pair.To, pair.From, &r_to, &r_from), big.NewFloat(keep*(1-pools[i].tax1)))
+		price_float, _ := price.Float64()
+		reverse_float, _ := reverse.Float64()
 		// pairs[i].price = *pairs[i].price.Quo(res1, res0)
 
-		from_id, from_exists := market.AddNode(from, pairs[i].From)
-		to_id, to_exist := market.AddNode(to, pairs[i].To)
+		from_id, _ := market.AddNode(from, pair.From)
+		to_id, _ := market.AddNode(to, pair.To)
 
 		/**
 		Need to change how edges are modeled
-		Right now a node is a token of a particular symbol
-		This should be a tokenAddress to be correct
-		Second there can be multiple edges from one node to the next
+		There can be multiple edges from one node to the next
 
 		*/
 
-		if from_exists && to_exist {
-			existing_price := market.nodes[from_id].edgePair[to_id].price
-			// Going A => B you want the biggest price or smaller in reverse
-			if price.Cmp(&existing_price) > 0 {
-				pair_ := Pair{pairs[i].From, pairs[i].To, pairs[i].From_symbol, pairs[i].To_symbol, r_from, r_to, *price.Quo(res1, res0), pairs[i].Factory}
-				market.AddEdge(from_id, to_id, -math.Log(price_float), pair_)
-			} else {
-				reverse_pair := Pair{pairs[i].To, pairs[i].From, pairs[i].To_symbol, pairs[i].From_symbol, r_to, r_from, *price.Quo(res0, res1), pairs[i].Factory}
-				market.AddEdge(to_id, from_id, -math.Log(1/(price_float)), reverse_pair)
-			}
-		} else {
-			pair_ := Pair{pairs[i].From, pairs[i].To, pairs[i].From_symbol, pairs[i].To_symbol, r_from, r_to, *price.Quo(res1, res0), pairs[i].Factory}
+		// Going A => B you want the biggest price, each way on its own
+		pair_ := Pair{pair.From, pair.To, pair.From_symbol, pair.To_symbol, r_from, r_to, *price, pair.Factory, pair.Fee, pools[i].tax0}
+		reverse_pair := Pair{pair.To, pair.From, pair.To_symbol, pair.From_symbol, r_to, r_from, *reverse, pair.Factory, pair.Fee, pools[i].tax1}
+		if existing, ok := market.nodes[from_id].edgePair[to_id]; !ok || price.Cmp(&existing.price) > 0 {
 			market.AddEdge(from_id, to_id, -math.Log(price_float), pair_)
-			reverse_pair := Pair{pairs[i].To, pairs[i].From, pairs[i].To_symbol, pairs[i].From_symbol, r_to, r_from, *price.Quo(res0, res1), pairs[i].Factory}
-			market.AddEdge(to_id, from_id, -math.Log(1/(price_float)), reverse_pair)
 		}
-		fmt.Println(&market)
+		if existing, ok := market.nodes[to_id].edgePair[from_id]; !ok || reverse.Cmp(&existing.price) > 0 {
+			market.AddEdge(to_id, from_id, -math.Log(reverse_float), reverse_pair)
+		}
+		market.AddPool(from_id, to_id, pair_)
+		market.AddPool(to_id, from_id, reverse_pair)
 	}
+	return market
+}
 
-	//Find Arbs starting from the tokens below
-	sources := [3]int{market.nodeIds["WBNB"], market.nodeIds["BUSD"], market.nodeIds["USDT"]}
+func findOpportunities(market *Graph) []Opportunity {
+	//Find Arbs starting from the source tokens
 	loops := [][]int{}
-	for i := 0; i < len(sources); i++ {
-		loop := market.FindArbitrageLoop(sources[i])
+	for _, token := range market.sources {
+		source, ok := market.nodeIds[token]
+		if !ok {
+			continue
+		}
+		loop := market.FindArbitrageLoop(source)
 		loops = append(loops, loop)
 	}
 
 	var arbPairs = make([][]Pair, len(loops))
+	opportunities := []Opportunity{}
 	for loop_i, loop := range loops {
 		market.mu.Lock()
 		var value = 1.0
@@ -419,53 +575,295 @@ func runArb(factory *pancakeFactory.PancakeFactory, pairs []PairIn, client *ethc
 			value = value * price
 		}
 		if len(arbPairs[loop_i]) > 0 {
-			// fmt.Println(arbPairs[loop_i])
-			value := 1.0
-			for _, pair_in_arb := range arbPairs[loop_i] {
-				price_in_pair, _ := pair_in_arb.price.Float64()
-				value *= price_in_pair
-				fmt.Println(pair_in_arb.from_symbol, pair_in_arb.to_symbol, price_in_pair, pair_in_arb.factory.String(), pair_in_arb.r_from.Uint64(), pair_in_arb.r_to.Uint64())
+			hops := make([][]Pair, len(arbPairs[loop_i]))
+			for i := range hops {
+				hops[i] = market.parallelPools(loop[i], loop[i+1], arbPairs[loop_i])
 			}
-			delta_in, profit := optimalVolume(arbPairs[loop_i])
-			fmt.Println(delta_in.String(), profit.String())
+			delta_in, profit, split := optimalVolume(hops)
 			if delta_in.Cmp(big.NewInt(0)) > 0 {
-				fmt.Printf("Expected Return: %0.2f%%\n", ((value - 1) * 100))
-				fmt.Println("Tokens in wei in: ", delta_in.String())
-				fmt.Println("Expected profit in wei: ", profit.String())
-				fmt.Println()
+				source := arbPairs[loop_i][0].from
+				log.Debug("Found loop", "path", loopPath(arbPairs[loop_i]), "return", value-1, "in", market.tokens.Format(source, &delta_in), "profit", market.tokens.Format(source, &profit), "split", split != nil)
+				opportunities = append(opportunities, Opportunity{pairs: arbPairs[loop_i], amountIn: delta_in, profit: profit, value: value, split: split})
 			}
 		}
 		market.mu.Unlock()
 	}
+	return opportunities
+}
+
+// loopPath is the symbols a loop trades through, joined for logs.
+func loopPath(pairs []Pair) string {
+	symbols := []string{pairs[0].from_symbol}
+	for _, pair := range pairs {
+		symbols = append(symbols, pair.to_symbol)
+	}
+	return strings.Join(symbols, " -> ")
 }
 
 func main() {
-	//Binance Client
-	client, err := ethclient.Dial("https://bsc-dataseed.binance.org/")
+	configPath := flag.String("config", "", "TOML config file, built-in defaults when empty")
+	registerConfigFlags(flag.CommandLine)
+	flag.Parse()
+
+	// Until the config says otherwise, log at the default level
+	if err := setupLogging(defaultConfig().Logging); err != nil {
+		log.Crit("Cannot set up logging", "err", err)
+	}
+	config, err := loadConfig(*configPath)
+	if err != nil {
+		log.Crit("Cannot load config", "err", err)
+	}
+	if err := applyFlags(&config); err != nil {
+		log.Crit("Bad flag", "err", err)
+	}
+	profile, err := config.applyProfile()
+	if err != nil {
+		log.Crit("Unknown chain", "err", err)
+	}
+	if err := config.Validate(); err != nil {
+		log.Crit("Invalid config", "err", err)
+	}
+	if err := setupLogging(config.Logging); err != nil {
+		log.Crit("Cannot set up logging", "err", err)
+	}
+	// Routers of the configured DEXes are decoded next to the built-in ones
+	for _, dex := range config.DEX {
+		if dex.Router != (common.Address{}) {
+			routerFactories[dex.Router] = routerFactory{dex.Factory, dex.InitCodeHash}
+		}
+	}
+	switch command := flag.Arg(0); command {
+	case "":
+	case "backtest":
+		if err := backtestCommand(context.Background(), profile, config, flag.Arg(1)); err != nil {
+			log.Crit("Backtest failed", "err", err)
+		}
+		return
+	case "store":
+		if err := storeCommand(context.Background(), profile, config, flag.Args()[1:]); err != nil {
+			log.Crit("Store command failed", "err", err)
+		}
+		return
+	case "quote":
+		if err := quoteCommand(context.Background(), profile, config, flag.Args()[1:]); err != nil {
+			log.Crit("Quote failed", "err", err)
+		}
+		return
+	case "competitors":
+		if err := competitorsCommand(context.Background(), profile, config); err != nil {
+			log.Crit("Competitor analysis failed", "err", err)
+		}
+		return
+	default:
+		log.Crit("Unknown command", "command", command)
+	}
+	if config.Monitor.Enabled {
+		if err := runMonitor(&config); err != nil {
+			log.Crit("Monitor failed", "err", err)
+		}
+		return
+	}
+	mode := executionMode(config.Execution.Mode)
+
+	market, err := newChainMarket(context.Background(), profile, config.RPC, config.Market, config.DEX, config.Sources)
 	if err != nil {
-		log.Fatal(err)
-	}
-	//Read Pairs from file
-	jsonFile, _ := os.Open("./tokenPairs_final.json")
-	defer jsonFile.Close()
-	byteValue, _ := io.ReadAll(jsonFile)
-	var read_pairs []PairIn
-	json.Unmarshal(byteValue, &read_pairs)
-
-	// //Get pancake factory
-	address := common.HexToAddress("0xca143ce32fe78f1f7019d7d551a6402fc5350c73")
-	factory, err := pancakeFactory.NewPancakeFactory(address, client)
+		log.Crit("Cannot set up market", "err", err)
+	}
+	market.staleAfter = time.Duration(config.Search.StaleSeconds) * time.Second
+	market.splitPools = config.Search.SplitPools
+	if config.Search.TrackReserves {
+		if market.tracker, err = NewReserveTracker(market.client); err != nil {
+			log.Crit("Cannot set up reserve tracker", "err", err)
+		}
+	}
+	client := market.client
+	tokens := market.tokens
+
+	journal, err := OpenJournal(config.Journal)
 	if err != nil {
-		log.Fatal(err)
+		log.Crit("Cannot open journal", "err", err)
+	}
+	defer journal.Close()
+	servers := make(map[string]map[string]http.Handler)
+	serve := func(listen string, routes map[string]http.Handler) {
+		if servers[listen] == nil {
+			servers[listen] = make(map[string]http.Handler)
+		}
+		for path, handler := range routes {
+			servers[listen][path] = handler
+		}
+	}
+	if config.Metrics.Listen != "" {
+		serve(config.Metrics.Listen, map[string]http.Handler{"/metrics": metrics})
+	}
+	var api *API
+	if config.API.Listen != "" {
+		api = NewAPI(profile.Name, tokens, config.API, config.Quote)
+		serve(config.API.Listen, api.routes())
+	}
+	if err := serveHTTP(servers); err != nil {
+		log.Crit("Cannot serve HTTP", "err", err)
+	}
+	record := func(r *OpportunityRecord) {
+		if err := journal.Write(r); err != nil {
+			log.Error("Cannot write journal", "err", err)
+		}
+	}
+
+	// Trades are only sent when a key is given, otherwise they stop at simulation
+	var txm *TxManager
+	if hexKey := os.Getenv("ARB_PRIVATE_KEY"); hexKey != "" {
+		key, err := crypto.HexToECDSA(strings.TrimPrefix(hexKey, "0x"))
+		if err != nil {
+			log.Crit("Bad ARB_PRIVATE_KEY", "err", err)
+		}
+		chainID := new(big.Int).SetUint64(profile.ChainID)
+		txm, err = NewTxManager(context.Background(), client, key, chainID, config.Execution.TxState)
+		if err != nil {
+			log.Crit("Cannot set up transaction manager", "err", err)
+		}
+		txm.stuck = time.Duration(config.Gas.StuckSeconds) * time.Second
+		txm.bumpPct = config.Gas.BumpPercent
+		config.Execution.From = txm.from
+	}
+
+	policy := &submissionPolicy{mode: config.Execution.Submit, mempool: &mempoolSubmitter{client}, bundleMinProfit: config.Thresholds.BundleMinProfit}
+	relayURL := config.Execution.Relay
+	if relayURL == "standin" {
+		relay, err := NewStandInRelay(nil)
+		if err != nil {
+			log.Crit("Cannot start stand-in relay", "err", err)
+		}
+		defer relay.Close()
+		relayURL = relay.URL()
+	}
+	if relayURL != "" {
+		policy.bundle, err = newBundleSubmitter(relayURL, client, config.Execution.BundleBlocks)
+		if err != nil {
+			log.Crit("Cannot set up bundle relay", "err", err)
+		}
+		// bundles still pending from before a restart go back to the relay
+		if txm != nil {
+			txm.AddSubmitter(policy.bundle)
+		}
+	}
+
+	var watcher *MempoolWatcher
+	if config.RPC.Mempool != "" {
+		watcher, err = NewMempoolWatcher(context.Background(), config.RPC.Mempool, market)
+		if err != nil {
+			log.Crit("Cannot watch mempool", "err", err)
+		}
+	}
+
+	executor := config.Execution.Executor
+	// execute takes opp as far as the configuration allows and notes on r
+	// what was decided and why
+	execute := func(opp Opportunity, r *OpportunityRecord) {
+		calldata, err := opportunityCalldata(opp, mode, config.Thresholds.Slippage)
+		if err != nil {
+			r.decide(decisionFailed, fmt.Errorf("cannot encode loop: %w", err))
+			return
+		}
+		log.Debug("Executor calldata", "data", hexutil.Encode(calldata))
+		if executor == (common.Address{}) {
+			r.decide(decisionObserved, nil)
+			return
+		}
+		expected := expectedProfit(opp, mode)
+		sim, err := simulateOpportunity(context.Background(), client, config.Execution.From, executor, calldata, mode, expected, config.Thresholds.Tolerance, config.Execution.Pending)
+		if err != nil {
+			r.decide(decisionDropped, err)
+			return
+		}
+		r.simulated(sim.profit, sim.gas)
+		if txm == nil {
+			r.decide(decisionObserved, nil)
+			return
+		}
+		if !market.stillCanonical(context.Background(), opp) {
+			r.decide(decisionDropped, fmt.Errorf("computed on orphaned block %s", opp.block.Hex()))
+			return
+		}
+		gasPrice, err := suggestGasPrice(context.Background(), client, profile.GasModel)
+		if err != nil {
+			r.decide(decisionDropped, err)
+			return
+		}
+		gasPrice = config.gasPriceCap(gasPrice)
+		r.priced(gasPrice)
+		via, err := policy.choose(sim.profit)
+		if err != nil {
+			r.decide(decisionDropped, err)
+			return
+		}
+		gasLimit := sim.gas * config.Gas.LimitPercent / 100
+		tracked, err := txm.Send(context.Background(), via, executor, calldata, gasLimit, gasPrice, sim.profit, opp.pairs[0].from)
+		if err != nil {
+			r.decide(decisionFailed, fmt.Errorf("send failed: %w", err))
+			return
+		}
+		r.decide(decisionSent, nil)
+		r.Tx, r.Via = tracked.latest().Hash, tracked.Via
+		log.Info("Sent transaction", "nonce", tracked.Nonce, "tx", r.Tx, "via", tracked.Via)
 	}
 
 	var searches = 0
-	for searches < 5 {
-		fmt.Println("Search: ", searches)
-		runArb(factory, read_pairs, client)
-		time.Sleep(10 * time.Second)
+	// watching is set once the watcher runs, after the first scan that
+	// succeeds: it needs pools to quote against
+	var watching = false
+	for config.Search.Count == 0 || searches < config.Search.Count {
+		log.Debug("Searching", "search", searches)
+		opportunities, pools, err := market.scan()
+		if err != nil {
+			log.Warn("Scan failed", "err", err)
+			time.Sleep(config.interval())
+			searches++
+			continue
+		}
+		if api != nil {
+			api.Update(market.head, market.searched, pools, opportunities)
+		}
+		if watcher != nil {
+			watcher.Update(pools)
+			if !watching {
+				watching = true
+				go func() {
+					err := watcher.Run(context.Background(), func(tx *types.Transaction, backruns []Opportunity) {
+						for _, opp := range backruns {
+							r := newOpportunityRecord(profile.Name, opp, tokens, profile.Wrapped)
+							r.decide(decisionObserved, fmt.Errorf("back-run of pending %s", tx.Hash().Hex()))
+							log.Info("Back-run found", "tx", tx.Hash(), "path", strings.Join(r.Path, " -> "), "in", tokens.Format(opp.pairs[0].from, &opp.amountIn), "profit", tokens.Format(opp.pairs[0].from, &opp.profit))
+							record(r)
+						}
+					})
+					log.Error("Mempool watcher stopped", "err", err)
+				}()
+			}
+		}
+		for _, opp := range opportunities {
+			r := newOpportunityRecord(profile.Name, opp, tokens, profile.Wrapped)
+			execute(opp, r)
+			metrics.Add(metricDecided, 1, profile.Name, r.Decision)
+			if r.Decision == decisionSent {
+				metrics.Add(metricExecuted, 1, profile.Name, r.Via)
+			}
+			source := opp.pairs[0].from
+			log.Info("Opportunity", "block", r.Block, "path", strings.Join(r.Path, " -> "), "in", tokens.Format(source, r.AmountIn),
+				"profit", tokens.Format(source, r.GrossProfit), "latency", time.Duration(r.LatencyMs)*time.Millisecond, "decision", r.Decision, "reason", r.Reason)
+			record(r)
+		}
+		if txm != nil {
+			settled, err := txm.Poll(context.Background())
+			if err != nil {
+				log.Warn("Cannot poll transactions", "err", err)
+			}
+			for _, tracked := range settled {
+				log.Info("Transaction settled", "nonce", tracked.Nonce, "outcome", tracked.Outcome, "block", tracked.Block, "profit", tracked.Profit, "gas", tracked.GasCost)
+				realized(profile, tokens, tracked)
+			}
+		}
+		time.Sleep(config.interval())
 		searches++
 	}
-
-	return
 }
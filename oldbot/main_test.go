@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// testPair is a pair between two numbered tokens with reserves in whole
+// tokens of 18 decimals.
+func testPair(from, to int, reserveFrom, reserveTo int64, fee int64) Pair {
+	unit := new(big.Int).Exp(big.NewInt(10), big.NewInt(18), nil)
+	pair := Pair{
+		from:        testToken(from),
+		to:          testToken(to),
+		from_symbol: fmt.Sprintf("T%d", from),
+		to_symbol:   fmt.Sprintf("T%d", to),
+		factory:     common.BigToAddress(big.NewInt(int64(1000*from + to))),
+		fee:         fee,
+	}
+	pair.r_from.Mul(big.NewInt(reserveFrom), unit)
+	pair.r_to.Mul(big.NewInt(reserveTo), unit)
+	return pair
+}
+
+func testToken(n int) common.Address {
+	return common.BigToAddress(big.NewInt(int64(n)))
+}
+
+// testGraph builds a graph of pairs, each traded both ways.
+func testGraph(pairs ...Pair) *Graph {
+	g := New()
+	for _, pair := range pairs {
+		reverse := Pair{from: pair.to, to: pair.from, from_symbol: pair.to_symbol, to_symbol: pair.from_symbol, r_from: pair.r_to, r_to: pair.r_from, factory: pair.factory, fee: pair.fee}
+		from, _ := g.AddNode(pair.from_symbol, pair.from)
+		to, _ := g.AddNode(pair.to_symbol, pair.to)
+		if _, ok := g.nodes[from].edgePair[to]; !ok {
+			g.AddEdge(from, to, 0, pair)
+			g.AddEdge(to, from, 0, reverse)
+		}
+		g.AddPool(from, to, pair)
+		g.AddPool(to, from, reverse)
+	}
+	return g
+}
+
+func TestGetAmountOutFee(t *testing.T) {
+	reserveIn, reserveOut := big.NewInt(1000000), big.NewInt(2000000)
+	tests := []struct {
+		fee  int64
+		want int64
+	}{
+		// 1000*9970*2000000 / (1000000*10000 + 1000*9970)
+		{30, 1992},
+		{25, 1993},
+		{10, 1996},
+	}
+	for _, test := range tests {
+		got := getAmountOut(big.NewInt(1000), reserveIn, reserveOut, test.fee)
+		if got.Int64() != test.want {
+			t.Errorf("fee %d: got %d, want %d", test.fee, got, test.want)
+		}
+		in := getAmountIn(got, reserveIn, reserveOut, test.fee)
+		if getAmountOut(in, reserveIn, reserveOut, test.fee).Cmp(got) < 0 || in.Int64() > 1000 {
+			t.Errorf("fee %d: getAmountIn(%d) = %d does not buy it back", test.fee, got, in)
+		}
+	}
+}
+
+func TestLoopVolumeFees(t *testing.T) {
+	tests := []struct {
+		name  string
+		pairs []Pair
+	}{
+		{"same fee", []Pair{testPair(1, 2, 1000, 2000, 25), testPair(2, 3, 2000, 1000, 25), testPair(3, 1, 1000, 1100, 25)}},
+		{"mixed fees", []Pair{testPair(1, 2, 1000, 2000, 25), testPair(2, 3, 2000, 1000, 10), testPair(3, 1, 1000, 1100, 30)}},
+	}
+	for _, test := range tests {
+		in, profit := loopVolume(test.pairs)
+		if in.Sign() <= 0 {
+			t.Fatalf("%s: no volume", test.name)
+		}
+		// The closed form agrees with trading the loop hop by hop
+		amounts := hopAmounts(test.pairs, &in)
+		swapped := new(big.Int).Sub(amounts[len(amounts)-1], &in)
+		if diff := new(big.Int).Sub(swapped, &profit); diff.CmpAbs(big.NewInt(10)) > 0 {
+			t.Errorf("%s: closed form profit %s, hops pay %s", test.name, &profit, swapped)
+		}
+		// and no nearby volume pays more
+		for _, pct := range []int64{99, 101} {
+			near := new(big.Int).Mul(&in, big.NewInt(pct))
+			near.Div(near, big.NewInt(100))
+			amounts := hopAmounts(test.pairs, near)
+			if nearProfit := new(big.Int).Sub(amounts[len(amounts)-1], near); nearProfit.Cmp(swapped) > 0 {
+				t.Errorf("%s: %d%% of the volume pays %s, more than %s", test.name, pct, nearProfit, swapped)
+			}
+		}
+	}
+}
+
+func TestInputFee(t *testing.T) {
+	tests := []struct {
+		fee  int64
+		tax  float64
+		want int64
+	}{
+		{25, 0, 25},
+		{30, 0.01, 130},
+		// 10000 - 9975*0.95 = 523.75, rounded up
+		{25, 0.05, 524},
+	}
+	for _, test := range tests {
+		if got := (Pair{fee: test.fee, tax: test.tax}).inputFee(); got != test.want {
+			t.Errorf("fee %d, tax %v: got %d, want %d", test.fee, test.tax, got, test.want)
+		}
+	}
+}
+
+func TestLoopVolumeTax(t *testing.T) {
+	loop := []Pair{testPair(1, 2, 1000, 2000, 25), testPair(2, 3, 2000, 1000, 25), testPair(3, 1, 1000, 1100, 25)}
+	untaxedIn, untaxedProfit := loopVolume(loop)
+	// T2 loses 2% of every transfer
+	loop[1].tax = 0.02
+	in, profit := loopVolume(loop)
+	if in.Sign() <= 0 || in.Cmp(&untaxedIn) >= 0 || profit.Cmp(&untaxedProfit) >= 0 {
+		t.Fatalf("taxed loop sized %s for %s, want less than %s for %s", &in, &profit, &untaxedIn, &untaxedProfit)
+	}
+
+	// Trade the loop by hand, taking the tax off what reaches the second pool
+	out := getAmountOut(&in, &loop[0].r_from, &loop[0].r_to, loop[0].fee)
+	arrived := new(big.Int).Mul(out, big.NewInt(98))
+	arrived.Div(arrived, big.NewInt(100))
+	out = getAmountOut(arrived, &loop[1].r_from, &loop[1].r_to, loop[1].fee)
+	out = getAmountOut(out, &loop[2].r_from, &loop[2].r_to, loop[2].fee)
+	traded := new(big.Int).Sub(out, &in)
+	// The closed form rounds the taxed fee up, so it may promise a little
+	// less than the trade pays but never more
+	if traded.Cmp(&profit) < 0 {
+		t.Errorf("closed form promises %s, the trade pays %s", &profit, traded)
+	}
+	if slack := new(big.Int).Sub(traded, &profit); slack.Cmp(new(big.Int).Div(traded, big.NewInt(100))) > 0 {
+		t.Errorf("closed form promises %s, far below the %s the trade pays", &profit, traded)
+	}
+	amounts := hopAmounts(loop, &in)
+	if amounts[1].Cmp(getAmountOut(arrived, &loop[1].r_from, &loop[1].r_to, loop[1].fee)) > 0 {
+		t.Errorf("hopAmounts expects %s from the taxed hop, more than it pays", amounts[1])
+	}
+}
+
+func TestBuildMarketSkipsUnresolvedTokens(t *testing.T) {
+	tokens := &TokenRegistry{tokens: map[common.Address]TokenInfo{
+		testToken(1): {Address: testToken(1), Symbol: "T1", Decimals: 18},
+		testToken(2): {Address: testToken(2), Symbol: "T2", Decimals: 6},
+	}}
+	pools := []PoolState{testPool(1, 2), testPool(2, 3)}
+	market := buildMarket(pools, tokens)
+	if len(market.nodes) != 2 {
+		t.Fatalf("%d tokens in the graph, want the 2 resolved ones", len(market.nodes))
+	}
+	for _, node := range market.nodes {
+		if node.address == testToken(3) {
+			t.Errorf("unresolved %s in the graph", node.address.Hex())
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"sync"
+	"time"
+
+	"example.com/m/pancakePair"
+	ethereum "github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/ethclient/gethclient"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// predictedSwap is one pool a pending transaction will trade through.
+type predictedSwap struct {
+	pool     common.Address
+	tokenIn  common.Address
+	amountIn *big.Int
+}
+
+// decodePendingSwaps finds the pool swaps a pending transaction will make,
+// as far as they touch pools in index. Router swaps are quoted hop by hop
+// against the pools' current reserves.
+func decodePendingSwaps(tx *types.Transaction, pools []PoolState, index map[common.Address]int) ([]predictedSwap, error) {
+	if tx.To() == nil || len(tx.Data()) < 4 {
+		return nil, nil
+	}
+	if _, ok := routerFactories[*tx.To()]; ok {
+		return decodeRouterSwaps(tx, pools, index)
+	}
+	if i, ok := index[*tx.To()]; ok {
+		return decodePairSwap(tx, pools[i])
+	}
+	return nil, nil
+}
+
+func decodeRouterSwaps(tx *types.Transaction, pools []PoolState, index map[common.Address]int) ([]predictedSwap, error) {
+	swap, err := decodeRouterSwap(*tx.To(), tx.Data(), tx.Value())
+	if errors.Is(err, errNotSwap) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	swap.Quote(pools, index)
+	return swap.predictedSwaps(), nil
+}
+
+// decodePairSwap handles a direct pancakePair.swap call. Only the outputs are
+// in the calldata, so the input is taken to be the least that pays for them.
+func decodePairSwap(tx *types.Transaction, pool PoolState) ([]predictedSwap, error) {
+	parsed, err := pancakePair.PancakePairMetaData.GetAbi()
+	if err != nil {
+		return nil, err
+	}
+	method, err := parsed.MethodById(tx.Data()[:4])
+	if err != nil || method.Name != "swap" {
+		return nil, nil
+	}
+	args, err := method.Inputs.Unpack(tx.Data()[4:])
+	if err != nil {
+		return nil, err
+	}
+	amount0Out, amount1Out := args[0].(*big.Int), args[1].(*big.Int)
+
+	tokenIn, amountOut := pool.in.To, amount0Out
+	if amount0Out.Sign() == 0 {
+		tokenIn, amountOut = pool.in.From, amount1Out
+	}
+	reserveIn, reserveOut := pool.reserves(tokenIn)
+	if amountOut.Cmp(reserveOut) >= 0 {
+		return nil, errors.New("swap takes the whole reserve")
+	}
+	return []predictedSwap{{pool.in.Factory, tokenIn, getAmountIn(amountOut, reserveIn, reserveOut, pool.in.Fee)}}, nil
+}
+
+// reserves orders the pool's reserves as (in, out) for a swap of tokenIn.
+func (p *PoolState) reserves(tokenIn common.Address) (*big.Int, *big.Int) {
+	if tokenIn == p.in.From {
+		return &p.reserve0, &p.reserve1
+	}
+	return &p.reserve1, &p.reserve0
+}
+
+// applySwaps returns a copy of pools with the swaps' reserve changes made.
+func applySwaps(pools []PoolState, index map[common.Address]int, swaps []predictedSwap) []PoolState {
+	shadow := make([]PoolState, len(pools))
+	for i := range pools {
+		shadow[i] = pools[i]
+		shadow[i].reserve0 = *new(big.Int).Set(&pools[i].reserve0)
+		shadow[i].reserve1 = *new(big.Int).Set(&pools[i].reserve1)
+	}
+	for _, swap := range swaps {
+		pool := &shadow[index[swap.pool]]
+		reserveIn, reserveOut := pool.reserves(swap.tokenIn)
+		amountOut := getAmountOut(swap.amountIn, reserveIn, reserveOut, pool.in.Fee)
+		reserveIn.Add(reserveIn, swap.amountIn)
+		reserveOut.Sub(reserveOut, amountOut)
+	}
+	return shadow
+}
+
+// MempoolWatcher follows pending transactions and reports the loops each
+// pending swap would open up once mined.
+type MempoolWatcher struct {
+	rpc    *rpc.Client
+	client *ethclient.Client
+	market *ChainMarket
+	// backoff is the wait before resubscribing after the subscription
+	// fails, doubling up to maxBackoff while it keeps failing
+	backoff    time.Duration
+	maxBackoff time.Duration
+
+	mu    sync.Mutex
+	pools []PoolState
+	index map[common.Address]int
+}
+
+// NewMempoolWatcher connects to a websocket endpoint that supports
+// newPendingTransactions subscriptions on market's chain.
+func NewMempoolWatcher(ctx context.Context, url string, market *ChainMarket) (*MempoolWatcher, error) {
+	client, err := rpc.DialContext(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	return newMempoolWatcher(client, market), nil
+}
+
+func newMempoolWatcher(client *rpc.Client, market *ChainMarket) *MempoolWatcher {
+	return &MempoolWatcher{rpc: client, client: ethclient.NewClient(client), market: market, backoff: time.Second, maxBackoff: time.Minute}
+}
+
+// Update replaces the mined reserves pending swaps are applied to.
+func (w *MempoolWatcher) Update(pools []PoolState) {
+	index := make(map[common.Address]int, len(pools))
+	for i, pool := range pools {
+		index[pool.in.Factory] = i
+	}
+	w.mu.Lock()
+	w.pools, w.index = pools, index
+	w.mu.Unlock()
+}
+
+// Run blocks until ctx is done, calling found for every pending transaction
+// that leaves a loop behind it. The subscription is made again whenever it
+// fails, with doubling backoff while it cannot be made.
+func (w *MempoolWatcher) Run(ctx context.Context, found func(tx *types.Transaction, opportunities []Opportunity)) error {
+	backoff := w.backoff
+	for {
+		subscribed, err := w.follow(ctx, found)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if subscribed {
+			backoff = w.backoff
+		}
+		log.Warn("Mempool subscription failed", "err", err, "retry", backoff)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		if !subscribed && backoff < w.maxBackoff {
+			backoff *= 2
+		}
+	}
+}
+
+// follow subscribes to pending transactions and handles them until the
+// subscription fails, reporting whether it was made at all. A transaction
+// that cannot be looked up is skipped.
+func (w *MempoolWatcher) follow(ctx context.Context, found func(tx *types.Transaction, opportunities []Opportunity)) (bool, error) {
+	hashes := make(chan common.Hash, 256)
+	sub, err := gethclient.New(w.rpc).SubscribePendingTransactions(ctx, hashes)
+	if err != nil {
+		return false, err
+	}
+	defer sub.Unsubscribe()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return true, ctx.Err()
+		case err := <-sub.Err():
+			return true, err
+		case hash := <-hashes:
+			tx, _, err := w.client.TransactionByHash(ctx, hash)
+			if errors.Is(err, ethereum.NotFound) {
+				continue
+			}
+			if err != nil {
+				log.Debug("Cannot look up pending tx", "tx", hash, "err", err)
+				continue
+			}
+
+			w.mu.Lock()
+			pools, index := w.pools, w.index
+			w.mu.Unlock()
+
+			swaps, err := decodePendingSwaps(tx, pools, index)
+			if err != nil {
+				log.Debug("Cannot decode pending tx", "tx", hash, "err", err)
+				continue
+			}
+			if len(swaps) == 0 {
+				continue
+			}
+			opportunities := findOpportunities(w.market.graph(applySwaps(pools, index, swaps)))
+			if len(opportunities) > 0 {
+				found(tx, opportunities)
+			}
+		}
+	}
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+
+	"example.com/m/pancakePair"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+func TestDecodePendingSwaps(t *testing.T) {
+	unit := new(big.Int).Exp(big.NewInt(10), big.NewInt(18), nil)
+	ether := func(n int64) *big.Int { return new(big.Int).Mul(big.NewInt(n), unit) }
+	pools := []PoolState{
+		{in: PairIn{From: testWBNB, To: testBUSD, Factory: testWBNBBUSD, Fee: 25}},
+		{in: PairIn{From: testCAKE, To: testWBNB, Factory: testCAKEWBNB, Fee: 25}},
+	}
+	pools[0].reserve0.Set(ether(1000))
+	pools[0].reserve1.Set(ether(300000))
+	pools[1].reserve0.Set(ether(100000))
+	pools[1].reserve1.Set(ether(500))
+	index := map[common.Address]int{testWBNBBUSD: 0, testCAKEWBNB: 1}
+
+	parsed, err := pancakePair.PancakePairMetaData.GetAbi()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pairSwap := func(amount0Out, amount1Out *big.Int) []byte {
+		data, err := parsed.Pack("swap", amount0Out, amount1Out, testCAKE, []byte{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+	to := common.HexToAddress("0x5a1E8B6d1F1e9C7D3F2b4c6E8d0A1b2C3d4E5f60")
+	deadline := big.NewInt(1700000000)
+	cakeOut := getAmountOut(ether(100), ether(100000), ether(500), 25)
+	unknown := testToken(1)
+
+	tests := []struct {
+		name  string
+		to    *common.Address
+		data  []byte
+		value *big.Int
+		want  []predictedSwap
+		err   bool
+	}{
+		{
+			name: "router exact input",
+			to:   &pancakeRouterAddress,
+			data: packRouterCall(t, "swapExactTokensForTokens", ether(100), big.NewInt(1), []common.Address{testCAKE, testWBNB, testBUSD}, to, deadline),
+			want: []predictedSwap{
+				{testCAKEWBNB, testCAKE, ether(100)},
+				{testWBNBBUSD, testWBNB, cakeOut},
+			},
+		},
+		{
+			name:  "router native input",
+			to:    &pancakeRouterAddress,
+			data:  packRouterCall(t, "swapExactETHForTokens", big.NewInt(1), []common.Address{testWBNB, testBUSD}, to, deadline),
+			value: ether(2),
+			want:  []predictedSwap{{testWBNBBUSD, testWBNB, ether(2)}},
+		},
+		{
+			name: "router exact output",
+			to:   &pancakeRouterAddress,
+			data: packRouterCall(t, "swapTokensForExactTokens", ether(1), ether(1000), []common.Address{testBUSD, testWBNB}, to, deadline),
+			want: []predictedSwap{{testWBNBBUSD, testBUSD, getAmountIn(ether(1), ether(300000), ether(1000), 25)}},
+		},
+		{
+			// the router swaps through a pool the market does not hold, so
+			// nothing after it can be quoted
+			name: "router unknown pool",
+			to:   &pancakeRouterAddress,
+			data: packRouterCall(t, "swapExactTokensForTokens", ether(1), big.NewInt(1), []common.Address{unknown, testWBNB, testBUSD}, to, deadline),
+		},
+		{
+			name: "router not a swap",
+			to:   &pancakeRouterAddress,
+			data: packRouterCall(t, "addLiquidity", testCAKE, testWBNB, big.NewInt(1), big.NewInt(1), big.NewInt(1), big.NewInt(1), to, deadline),
+		},
+		{
+			name: "pair swap for token1",
+			to:   &testWBNBBUSD,
+			data: pairSwap(new(big.Int), ether(3000)),
+			want: []predictedSwap{{testWBNBBUSD, testWBNB, getAmountIn(ether(3000), ether(1000), ether(300000), 25)}},
+		},
+		{
+			name: "pair swap for token0",
+			to:   &testWBNBBUSD,
+			data: pairSwap(ether(1), new(big.Int)),
+			want: []predictedSwap{{testWBNBBUSD, testBUSD, getAmountIn(ether(1), ether(300000), ether(1000), 25)}},
+		},
+		{
+			name: "pair swap for the whole reserve",
+			to:   &testWBNBBUSD,
+			data: pairSwap(ether(1000), new(big.Int)),
+			err:  true,
+		},
+		{
+			name: "pair not a swap",
+			to:   &testCAKEWBNB,
+			data: common.FromHex("0xfff6cae9"), // sync()
+		},
+		{
+			name: "unrelated contract",
+			to:   &unknown,
+			data: pairSwap(new(big.Int), ether(1)),
+		},
+		{
+			name: "contract creation",
+			data: pairSwap(new(big.Int), ether(1)),
+		},
+	}
+	for _, test := range tests {
+		value := test.value
+		if value == nil {
+			value = new(big.Int)
+		}
+		tx := types.NewTx(&types.LegacyTx{To: test.to, Value: value, Gas: 300000, GasPrice: big.NewInt(5e9), Data: test.data})
+		swaps, err := decodePendingSwaps(tx, pools, index)
+		if (err != nil) != test.err {
+			t.Errorf("%s: err = %v, want error %v", test.name, err, test.err)
+			continue
+		}
+		if len(swaps) != len(test.want) {
+			t.Errorf("%s: %d swaps, want %d", test.name, len(swaps), len(test.want))
+			continue
+		}
+		for i, swap := range swaps {
+			want := test.want[i]
+			if swap.pool != want.pool || swap.tokenIn != want.tokenIn || swap.amountIn.Cmp(want.amountIn) != 0 {
+				t.Errorf("%s: swap %d is %s of %s into %s, want %s of %s into %s", test.name, i,
+					swap.amountIn, swap.tokenIn, swap.pool, want.amountIn, want.tokenIn, want.pool)
+			}
+		}
+	}
+}
+
+func TestApplySwaps(t *testing.T) {
+	pools := []PoolState{testPool(1, 2)}
+	live := new(big.Int).Set(&pools[0].reserve0)
+	index := map[common.Address]int{pools[0].in.Factory: 0}
+	amountIn := big.NewInt(10)
+	amountOut := getAmountOut(amountIn, &pools[0].reserve0, &pools[0].reserve1, pools[0].in.Fee)
+
+	shadow := applySwaps(pools, index, []predictedSwap{{pools[0].in.Factory, pools[0].in.From, amountIn}})
+	if got, want := &shadow[0].reserve0, new(big.Int).Add(&pools[0].reserve0, amountIn); got.Cmp(want) != 0 {
+		t.Errorf("reserve in is %s, want %s", got, want)
+	}
+	if got, want := &shadow[0].reserve1, new(big.Int).Sub(&pools[0].reserve1, amountOut); got.Cmp(want) != 0 {
+		t.Errorf("reserve out is %s, want %s", got, want)
+	}
+	if pools[0].reserve0.Cmp(live) != 0 {
+		t.Errorf("applySwaps changed the live reserves")
+	}
+}
+
+// fakeMempool serves pending transaction hashes to every subscription but
+// the first, which it refuses, and fails lookups of the hashes in broken.
+type fakeMempool struct {
+	hashes []common.Hash
+	txs    map[common.Hash]*types.Transaction
+	broken map[common.Hash]bool
+
+	mu            sync.Mutex
+	subscriptions int
+	lookups       int
+	looked        chan struct{}
+}
+
+func (f *fakeMempool) NewPendingTransactions(ctx context.Context) (*rpc.Subscription, error) {
+	f.mu.Lock()
+	f.subscriptions++
+	first := f.subscriptions == 1
+	f.mu.Unlock()
+	if first {
+		return nil, errors.New("too many subscriptions")
+	}
+	notifier, _ := rpc.NotifierFromContext(ctx)
+	sub := notifier.CreateSubscription()
+	go func() {
+		for _, hash := range f.hashes {
+			notifier.Notify(sub.ID, hash)
+		}
+	}()
+	return sub, nil
+}
+
+func (f *fakeMempool) GetTransactionByHash(hash common.Hash) (*types.Transaction, error) {
+	f.mu.Lock()
+	f.lookups++
+	if f.lookups == len(f.hashes) {
+		close(f.looked)
+	}
+	f.mu.Unlock()
+	if f.broken[hash] {
+		return nil, errors.New("internal error")
+	}
+	return f.txs[hash], nil
+}
+
+func TestMempoolWatcherKeepsWatching(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	tx, err := types.SignTx(types.NewTransaction(0, testToken(1), new(big.Int), 21000, big.NewInt(1), nil), types.HomesteadSigner{}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	broken, missing := common.HexToHash("0x01"), common.HexToHash("0x02")
+	mempool := &fakeMempool{
+		// the lookup error and the unknown hash come first: neither may stop
+		// the watcher before the last hash is looked up
+		hashes: []common.Hash{broken, missing, tx.Hash()},
+		txs:    map[common.Hash]*types.Transaction{tx.Hash(): tx},
+		broken: map[common.Hash]bool{broken: true},
+		looked: make(chan struct{}),
+	}
+	server := rpc.NewServer()
+	if err := server.RegisterName("eth", mempool); err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+	watcher := newMempoolWatcher(rpc.DialInProc(server), nil)
+	watcher.backoff = time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan error)
+	go func() {
+		stopped <- watcher.Run(ctx, func(*types.Transaction, []Opportunity) {})
+	}()
+	select {
+	case <-mempool.looked:
+	case err := <-stopped:
+		t.Fatalf("watcher stopped: %v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("watcher never looked up every pending hash")
+	}
+	cancel()
+	if err := <-stopped; !errors.Is(err, context.Canceled) {
+		t.Errorf("watcher stopped with %v, want context.Canceled", err)
+	}
+	mempool.mu.Lock()
+	defer mempool.mu.Unlock()
+	if mempool.subscriptions != 2 {
+		t.Errorf("subscribed %d times, want the refused subscription made again", mempool.subscriptions)
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MetricsConfig says where /metrics is served.
+type MetricsConfig struct {
+	// Listen is the address of the HTTP server, like 127.0.0.1:9100, empty
+	// for none.
+	Listen string
+}
+
+// Kinds of metric, as Prometheus types them.
+const (
+	metricCounter = "counter"
+	metricGauge   = "gauge"
+	metricSummary = "summary"
+)
+
+// metricSeries is one set of label values of a family. Summaries keep a
+// count and a sum; the others a value.
+type metricSeries struct {
+	labels []string
+	value  float64
+	count  uint64
+	sum    float64
+}
+
+type metricFamily struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+	series map[string]*metricSeries
+}
+
+// Metrics is a registry of labelled counters, gauges and summaries,
+// written in the Prometheus text format. Recording into a family that was
+// never described is a programming error and panics.
+type Metrics struct {
+	mu       sync.Mutex
+	families map[string]*metricFamily
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{families: make(map[string]*metricFamily)}
+}
+
+// metrics is the process's registry. Series only appear once recorded, so
+// commands that never run the search export nothing.
+var metrics = newBotMetrics()
+
+// Metric names.
+const (
+	metricScanSeconds  = "arb_scan_duration_seconds"
+	metricPoolsTracked = "arb_pools_tracked"
+	metricPoolsStale   = "arb_pools_stale"
+	metricRPCSeconds   = "arb_rpc_request_duration_seconds"
+	metricRPCErrors    = "arb_rpc_errors_total"
+	metricRPCHealthy   = "arb_rpc_endpoint_healthy"
+	metricRPCHead      = "arb_rpc_endpoint_head"
+	metricDetected     = "arb_opportunities_detected_total"
+	metricDecided      = "arb_opportunities_decided_total"
+	metricExecuted     = "arb_opportunities_executed_total"
+	metricReverted     = "arb_opportunities_reverted_total"
+	metricRealizedPnL  = "arb_realized_pnl"
+)
+
+func newBotMetrics() *Metrics {
+	m := NewMetrics()
+	m.describe(metricScanSeconds, metricSummary, "Time to read, filter and search the pools of one scan.", "chain")
+	m.describe(metricPoolsTracked, metricGauge, "Pools in the last scan's graph after filtering and vetting.", "chain")
+	m.describe(metricPoolsStale, metricGauge, "Pools whose reserves the last scan could not refresh, failed or quarantined.", "chain")
+	m.describe(metricRPCSeconds, metricSummary, "Duration of RPC calls, per endpoint.", "endpoint")
+	m.describe(metricRPCErrors, metricCounter, "Failed RPC calls, per endpoint.", "endpoint")
+	m.describe(metricRPCHealthy, metricGauge, "Whether the endpoint passed its last health check.", "endpoint")
+	m.describe(metricRPCHead, metricGauge, "The endpoint's head block at its last health check.", "endpoint")
+	m.describe(metricDetected, metricCounter, "Opportunities found by scans.", "chain")
+	m.describe(metricDecided, metricCounter, "Opportunities by the decision taken on them.", "chain", "decision")
+	m.describe(metricExecuted, metricCounter, "Opportunities sent as transactions.", "chain", "via")
+	m.describe(metricReverted, metricCounter, "Sent transactions that were mined and reverted.", "chain")
+	m.describe(metricRealizedPnL, metricGauge, "Profit of mined trades less the gas of every mined transaction, in whole tokens.", "chain", "token")
+	return m
+}
+
+func (m *Metrics) describe(name, kind, help string, labels ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.families[name] = &metricFamily{name: name, help: help, kind: kind, labels: labels, series: make(map[string]*metricSeries)}
+}
+
+// series finds or adds the series of name with label values. The caller
+// holds mu.
+func (m *Metrics) series(name string, values []string) *metricSeries {
+	family, ok := m.families[name]
+	if !ok {
+		panic("metrics: " + name + " is not described")
+	}
+	if len(values) != len(family.labels) {
+		panic(fmt.Sprintf("metrics: %s takes %d labels, got %d", name, len(family.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+	s, ok := family.series[key]
+	if !ok {
+		s = &metricSeries{labels: append([]string{}, values...)}
+		family.series[key] = s
+	}
+	return s
+}
+
+// Add adds delta to a counter or gauge.
+func (m *Metrics) Add(name string, delta float64, labels ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.series(name, labels).value += delta
+}
+
+// Set sets a gauge.
+func (m *Metrics) Set(name string, value float64, labels ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.series(name, labels).value = value
+}
+
+// Observe records one duration in a summary, in seconds.
+func (m *Metrics) Observe(name string, d time.Duration, labels ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := m.series(name, labels)
+	s.count++
+	s.sum += d.Seconds()
+}
+
+// ServeHTTP writes every family with at least one series, sorted by name
+// and labels so scrapes diff cleanly.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	names := make([]string, 0, len(m.families))
+	for name, family := range m.families {
+		if len(family.series) > 0 {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var out strings.Builder
+	for _, name := range names {
+		family := m.families[name]
+		fmt.Fprintf(&out, "# HELP %s %s\n# TYPE %s %s\n", name, family.help, name, family.kind)
+		keys := make([]string, 0, len(family.series))
+		for key := range family.series {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			s := family.series[key]
+			labels := formatLabels(family.labels, s.labels)
+			if family.kind == metricSummary {
+				fmt.Fprintf(&out, "%s_sum%s %s\n", name, labels, formatValue(s.sum))
+				fmt.Fprintf(&out, "%s_count%s %d\n", name, labels, s.count)
+				continue
+			}
+			fmt.Fprintf(&out, "%s%s %s\n", name, labels, formatValue(s.value))
+		}
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write([]byte(out.String()))
+}
+
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	pairs := make([]string, len(names))
+	for i := range names {
+		pairs[i] = names[i] + "=" + strconv.Quote(values[i])
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatValue(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// realized records a settled transaction: a revert, and the profit and gas
+// of whatever was mined, both as its receipt shows them.
+func realized(profile ChainProfile, tokens *TokenRegistry, tracked *TrackedTx) {
+	if tracked.Outcome == outcomeReverted {
+		metrics.Add(metricReverted, 1, profile.Name)
+	}
+	if tracked.Outcome == outcomeProfitable && tracked.Profit != nil {
+		profit, _ := tokens.Normalize(tracked.ProfitToken, tracked.Profit).Float64()
+		metrics.Add(metricRealizedPnL, profit, profile.Name, tokens.Symbol(tracked.ProfitToken, tracked.ProfitToken.Hex()))
+	}
+	if tracked.GasCost != nil {
+		gas, _ := tokens.Normalize(profile.Wrapped, tracked.GasCost).Float64()
+		metrics.Add(metricRealizedPnL, -gas, profile.Name, tokens.Symbol(profile.Wrapped, profile.Wrapped.Hex()))
+	}
+}
+
+// endpointLabel names an RPC endpoint by scheme and host only; paths and
+// query strings often carry API keys.
+func endpointLabel(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return "invalid"
+	}
+	return u.Scheme + "://" + u.Host
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+	"golang.org/x/time/rate"
+)
+
+// fakeReserves is a node at block 100 whose pools answer getReserves with
+// their reserves in whole tokens.
+type fakeReserves struct {
+	reserves map[common.Address][2]int64
+}
+
+func (f *fakeReserves) BlockNumber() hexutil.Uint64 {
+	return 100
+}
+
+func (f *fakeReserves) GetBlockByNumber(number rpc.BlockNumber, full bool) *types.Header {
+	return &types.Header{Number: big.NewInt(100), Time: uint64(time.Now().Unix()), Difficulty: new(big.Int)}
+}
+
+func (f *fakeReserves) Call(args struct{ To common.Address }, block rpc.BlockNumber) hexutil.Bytes {
+	unit := new(big.Int).Exp(big.NewInt(10), big.NewInt(18), nil)
+	reserves := f.reserves[args.To]
+	out := []byte{}
+	for _, reserve := range reserves {
+		out = append(out, common.LeftPadBytes(new(big.Int).Mul(big.NewInt(reserve), unit).Bytes(), 32)...)
+	}
+	return append(out, make([]byte, 32)...)
+}
+
+// scrape reads every sample of a /metrics page by name and labels.
+func scrape(t *testing.T, url string) map[string]string {
+	resp, err := http.Get(url + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/plain") {
+		t.Fatalf("scrape: %s %s", resp.Status, resp.Header.Get("Content-Type"))
+	}
+	samples := make(map[string]string)
+	lines := bufio.NewScanner(resp.Body)
+	for lines.Scan() {
+		line := lines.Text()
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		space := strings.LastIndex(line, " ")
+		samples[line[:space]] = line[space+1:]
+	}
+	return samples
+}
+
+func TestMetricsScrape(t *testing.T) {
+	defer func(old *Metrics) { metrics = old }(metrics)
+	metrics = newBotMetrics()
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	if samples := scrape(t, server.URL); len(samples) != 0 {
+		t.Errorf("fresh registry exports %v", samples)
+	}
+
+	// T2 is cheap in a, so T1 -> T2 -> T3 -> T1 pays
+	a, b, c := testPool(1, 2).in, testPool(2, 3).in, testPool(1, 3).in
+	node := &fakeReserves{reserves: map[common.Address][2]int64{
+		a.Factory: {100, 130},
+		b.Factory: {100, 100},
+		c.Factory: {100, 100},
+	}}
+	rpcServer := rpc.NewServer()
+	if err := rpcServer.RegisterName("eth", node); err != nil {
+		t.Fatal(err)
+	}
+	defer rpcServer.Stop()
+	client := rpc.DialInProc(rpcServer)
+	pool := &RPCPool{
+		endpoints: []*rpcEndpoint{{url: "inproc", label: "inproc", rpc: client, client: ethclient.NewClient(client), limiter: rate.NewLimiter(rate.Inf, 1), healthy: true}},
+		timeout:   5 * time.Second,
+	}
+	tokens := &TokenRegistry{tokens: map[common.Address]TokenInfo{
+		testToken(1): {Address: testToken(1), Symbol: "T1", Decimals: 18},
+		testToken(2): {Address: testToken(2), Symbol: "T2", Decimals: 18},
+		testToken(3): {Address: testToken(3), Symbol: "T3", Decimals: 18},
+	}}
+	filter, err := NewPoolFilter(PoolFilterConfig{}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	profile := ChainProfile{Name: "bsc", Wrapped: testToken(1)}
+	market := &ChainMarket{profile: profile, client: pool, pairs: []PairIn{a, b, c}, sources: []common.Address{testToken(1)},
+		tokens: tokens, filter: filter, quarantine: newQuarantine()}
+
+	pool.checkHealth(context.Background())
+	if _, _, err := market.scan(); err != nil {
+		t.Fatal(err)
+	}
+	// the node has no eth_chainId
+	if _, err := pool.ChainID(context.Background()); err == nil {
+		t.Fatal("chain id of a node without one")
+	}
+	unit := new(big.Int).Exp(big.NewInt(10), big.NewInt(18), nil)
+	realized(profile, tokens, &TrackedTx{Outcome: outcomeProfitable, ProfitToken: testToken(2),
+		Profit: new(big.Int).Mul(big.NewInt(3), unit), GasCost: new(big.Int).Div(unit, big.NewInt(4))})
+
+	samples := scrape(t, server.URL)
+	want := map[string]string{
+		`arb_scan_duration_seconds_count{chain="bsc"}`:  "1",
+		`arb_opportunities_detected_total{chain="bsc"}`: "1",
+		`arb_pools_tracked{chain="bsc"}`:                "3",
+		`arb_pools_stale{chain="bsc"}`:                  "0",
+		`arb_rpc_errors_total{endpoint="inproc"}`:       "1",
+		`arb_rpc_endpoint_healthy{endpoint="inproc"}`:   "1",
+		`arb_rpc_endpoint_head{endpoint="inproc"}`:      "100",
+		`arb_realized_pnl{chain="bsc",token="T2"}`:      "3",
+		`arb_realized_pnl{chain="bsc",token="T1"}`:      "-0.25",
+		// the head, three pools and the chain id; health checks are not timed
+		`arb_rpc_request_duration_seconds_count{endpoint="inproc"}`: "5",
+	}
+	for series, value := range want {
+		if samples[series] != value {
+			t.Errorf("%s is %q, want %s", series, samples[series], value)
+		}
+	}
+	if _, ok := samples[`arb_scan_duration_seconds_sum{chain="bsc"}`]; !ok {
+		t.Errorf("scan duration has no sum")
+	}
+
+	// the next scan and a revert update the same series
+	node.reserves[a.Factory] = [2]int64{100, 100}
+	if _, _, err := market.scan(); err != nil {
+		t.Fatal(err)
+	}
+	realized(profile, tokens, &TrackedTx{Outcome: outcomeReverted, GasCost: new(big.Int).Div(unit, big.NewInt(4))})
+	samples = scrape(t, server.URL)
+	want = map[string]string{
+		`arb_scan_duration_seconds_count{chain="bsc"}`:  "2",
+		`arb_opportunities_detected_total{chain="bsc"}`: "1",
+		`arb_opportunities_reverted_total{chain="bsc"}`: "1",
+		`arb_realized_pnl{chain="bsc",token="T1"}`:      "-0.5",
+	}
+	for series, value := range want {
+		if samples[series] != value {
+			t.Errorf("after the second scan %s is %q, want %s", series, samples[series], value)
+		}
+	}
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// MonitorConfig sets up the read-only cross-chain price monitor. Nothing is
+// simulated or sent while it runs.
+type MonitorConfig struct {
+	Enabled bool
+	Chains  []MonitorChainConfig
+	// Assets are the tokens compared across chains. Empty uses
+	// defaultAssets.
+	Assets  []AssetConfig
+	Bridges []BridgeConfig
+	// TradeUSD is the trade size fixed bridge fees are spread over.
+	TradeUSD float64
+	// VolatilityBps is how far prices are expected to move in a minute.
+	// Bridging risks that move scaled by the square root of its latency.
+	VolatilityBps float64
+	// MinSpreadBps is the net spread from which a route is flagged.
+	MinSpreadBps float64
+}
+
+// MonitorChainConfig is one chain to watch. Empty fields come from the
+// chain's profile, like the trading config's.
+type MonitorChainConfig struct {
+	Chain  string
+	RPC    string
+	Pairs  string
+	Tokens string
+	Filter string
+}
+
+// AssetConfig is one asset and its token address on each chain.
+type AssetConfig struct {
+	Symbol string
+	Tokens map[string]common.Address
+}
+
+// BridgeConfig is the cost of moving value from one chain to another.
+type BridgeConfig struct {
+	From           string
+	To             string
+	FeeBps         float64
+	FixedUSD       float64
+	LatencySeconds uint64
+}
+
+var defaultAssets = []AssetConfig{
+	{Symbol: "ETH", Tokens: map[string]common.Address{
+		"bsc":      common.HexToAddress("0x2170Ed0880ac9A755fd29B2688956BD959F933F8"),
+		"ethereum": common.HexToAddress("0xC02aaA39b223FE8D0A0e5C4F27eAD9083C756Cc2"),
+		"polygon":  common.HexToAddress("0x7ceB23fD6bC0adD59E62ac25578270cFf1b9f619"),
+		"arbitrum": common.HexToAddress("0x82aF49447D8a07e3bd95BD0d56f35241523fBab1"),
+	}},
+	{Symbol: "USDT", Tokens: map[string]common.Address{
+		"bsc":      common.HexToAddress("0x55d398326f99059fF775485246999027B3197955"),
+		"ethereum": common.HexToAddress("0xdAC17F958D2ee523a2206206994597C13D831ec7"),
+		"polygon":  common.HexToAddress("0xc2132D05D31c914a87C6611C10748AEb04B58e8F"),
+		"arbitrum": common.HexToAddress("0xFd086bC7CD5C481DCC9C85ebE478A1C0b69FCbb9"),
+	}},
+	{Symbol: "USDC", Tokens: map[string]common.Address{
+		"bsc":      common.HexToAddress("0x8AC76a51cc950d9822D68b83fE1Ad97B32Cd580d"),
+		"ethereum": common.HexToAddress("0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48"),
+		"polygon":  common.HexToAddress("0x2791Bca1f2de4661ED88A30C99A7a9449Aa84174"),
+		"arbitrum": common.HexToAddress("0xFF970A61A04b1cA14834A43f5dE4533eBDDB5CC8"),
+	}},
+}
+
+func (m *MonitorConfig) validate(check func(ok bool, format string, args ...interface{})) {
+	if !m.Enabled {
+		return
+	}
+	check(len(m.Chains) >= 2, "Monitor needs at least two Chains, got %d", len(m.Chains))
+	chains := make(map[string]bool)
+	for i, chain := range m.Chains {
+		_, known := chainProfiles[chain.Chain]
+		check(known, "Monitor.Chains %d: unknown chain %q", i, chain.Chain)
+		check(!chains[chain.Chain], "Monitor.Chains %d: %s is listed twice", i, chain.Chain)
+		check(!known || chain.Pairs != "" || chainProfiles[chain.Chain].Pairs != "", "Monitor.Chains %d: %s has no default pairs file, set Pairs", i, chain.Chain)
+		chains[chain.Chain] = true
+	}
+	for i, bridge := range m.Bridges {
+		check(chains[bridge.From] && chains[bridge.To], "Monitor.Bridges %d: %s to %s is not between monitored chains", i, bridge.From, bridge.To)
+		check(bridge.FeeBps >= 0 && bridge.FixedUSD >= 0, "Monitor.Bridges %d: fees must not be negative", i)
+	}
+	check(m.TradeUSD > 0, "Monitor.TradeUSD must be positive")
+	check(m.VolatilityBps >= 0, "Monitor.VolatilityBps must not be negative")
+}
+
+// assetPrice is an asset's price in USD on one chain. Stables count as a
+// dollar each; everything else is priced against a stable directly or
+// through the wrapped native coin.
+func assetPrice(market *Graph, profile ChainProfile, token common.Address) (float64, bool) {
+	for _, stable := range profile.Stables {
+		if token == stable {
+			return 1, true
+		}
+	}
+	for _, stable := range profile.Stables {
+		if price, ok := market.rate(token, stable); ok {
+			return price, true
+		}
+	}
+	inWrapped := 1.0
+	if token != profile.Wrapped {
+		var ok bool
+		if inWrapped, ok = market.rate(token, profile.Wrapped); !ok {
+			return 0, false
+		}
+	}
+	for _, stable := range profile.Stables {
+		if price, ok := market.rate(profile.Wrapped, stable); ok {
+			return inWrapped * price, true
+		}
+	}
+	return 0, false
+}
+
+// rate is the direct edge price from one token to another, in whole tokens.
+func (g *Graph) rate(from, to common.Address) (float64, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	fromID, ok := g.nodeIds[from]
+	if !ok {
+		return 0, false
+	}
+	toID, ok := g.nodeIds[to]
+	if !ok {
+		return 0, false
+	}
+	pair, ok := g.nodes[fromID].edgePair[toID]
+	if !ok {
+		return 0, false
+	}
+	price, _ := pair.price.Float64()
+	return price, price > 0
+}
+
+// BridgeSpread is the gap between an asset's price on two chains, bought
+// on From and sold on To.
+type BridgeSpread struct {
+	Asset     string
+	From      string
+	To        string
+	BuyPrice  float64
+	SellPrice float64
+	GrossBps  float64
+	// NetBps is what is left after bridge fees and the latency haircut.
+	NetBps float64
+}
+
+// bridgeSpreads compares every asset between every bridged pair of chains,
+// widest net spread first.
+func (m *MonitorConfig) bridgeSpreads(prices map[string]map[string]float64) []BridgeSpread {
+	spreads := []BridgeSpread{}
+	for _, bridge := range m.Bridges {
+		latency := time.Duration(bridge.LatencySeconds) * time.Second
+		haircut := m.VolatilityBps * math.Sqrt(latency.Minutes())
+		for asset, byChain := range prices {
+			buy, okBuy := byChain[bridge.From]
+			sell, okSell := byChain[bridge.To]
+			if !okBuy || !okSell {
+				continue
+			}
+			gross := (sell/buy - 1) * 10000
+			net := gross - bridge.FeeBps - bridge.FixedUSD/m.TradeUSD*10000 - haircut
+			spreads = append(spreads, BridgeSpread{asset, bridge.From, bridge.To, buy, sell, gross, net})
+		}
+	}
+	sort.Slice(spreads, func(i, j int) bool { return spreads[i].NetBps > spreads[j].NetBps })
+	return spreads
+}
+
+// runMonitor watches every configured chain and prints each asset's price
+// and the bridge spreads between chains, once per search.
+func runMonitor(config *Config) error {
+	monitor := &config.Monitor
+	assets := monitor.Assets
+	if len(assets) == 0 {
+		assets = defaultAssets
+	}
+
+	markets := make(map[string]*ChainMarket, len(monitor.Chains))
+	for _, chain := range monitor.Chains {
+		profile := chainProfiles[chain.Chain]
+		market := MarketConfig{Pairs: chain.Pairs, Tokens: chain.Tokens, Filter: chain.Filter}
+		if market.Pairs == "" {
+			market.Pairs = profile.Pairs
+		}
+		if market.Tokens == "" {
+			market.Tokens = profile.Tokens
+		}
+		// Each chain gets the trading RPC settings with its own endpoint
+		rpc := config.RPC
+		rpc.URL, rpc.Fallbacks, rpc.Mempool = chain.RPC, nil, ""
+		if rpc.URL == "" {
+			rpc.URL = profile.RPC
+		}
+		m, err := newChainMarket(context.Background(), profile, rpc, market, profile.DEXes, profile.sources())
+		if err != nil {
+			return fmt.Errorf("monitor: %s: %w", chain.Chain, err)
+		}
+		m.staleAfter = time.Duration(config.Search.StaleSeconds) * time.Second
+		markets[chain.Chain] = m
+	}
+
+	for searches := 0; config.Search.Count == 0 || searches < config.Search.Count; searches++ {
+		log.Debug("Monitoring", "search", searches)
+		// prices[asset][chain] in USD
+		prices := make(map[string]map[string]float64)
+		for name, m := range markets {
+			pools, err := m.pools()
+			if err != nil {
+				log.Warn("Scan failed", "chain", name, "err", err)
+				continue
+			}
+			market := m.graph(pools)
+			for _, asset := range assets {
+				token, ok := asset.Tokens[name]
+				if !ok {
+					continue
+				}
+				price, ok := assetPrice(market, m.profile, token)
+				if !ok {
+					log.Warn("No USD price", "asset", asset.Symbol, "chain", name)
+					continue
+				}
+				if prices[asset.Symbol] == nil {
+					prices[asset.Symbol] = make(map[string]float64)
+				}
+				prices[asset.Symbol][name] = price
+				log.Info("Price", "asset", asset.Symbol, "chain", name, "usd", price)
+			}
+		}
+		for _, spread := range monitor.bridgeSpreads(prices) {
+			logSpread := log.Debug
+			if spread.NetBps >= monitor.MinSpreadBps {
+				logSpread = log.Info
+			}
+			logSpread("Bridge spread", "asset", spread.Asset, "from", spread.From, "to", spread.To, "buy", spread.BuyPrice, "sell", spread.SellPrice,
+				"grossBps", spread.GrossBps, "netBps", spread.NetBps)
+		}
+		time.Sleep(config.interval())
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"math"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// testRate is the price of one numbered token in another, in whole tokens.
+type testRate struct {
+	from, to int
+	price    float64
+}
+
+// pricedGraph is a graph with one edge per rate, priced at it.
+func pricedGraph(rates ...testRate) *Graph {
+	g := New()
+	for _, rate := range rates {
+		from, _ := g.AddNode("", testToken(rate.from))
+		to, _ := g.AddNode("", testToken(rate.to))
+		pair := Pair{from: testToken(rate.from), to: testToken(rate.to)}
+		pair.price.SetFloat64(rate.price)
+		g.AddEdge(from, to, 0, pair)
+	}
+	return g
+}
+
+func TestAssetPrice(t *testing.T) {
+	wrapped, stable, otherStable := testToken(1), testToken(2), testToken(3)
+	profile := ChainProfile{Wrapped: wrapped, Stables: []common.Address{stable, otherStable}}
+	market := pricedGraph(
+		testRate{1, 3, 300},
+		testRate{4, 2, 2.5},
+		testRate{5, 1, 0.5},
+		// only priced against a token that is neither stable nor wrapped
+		testRate{6, 4, 10},
+	)
+
+	tests := []struct {
+		name  string
+		token common.Address
+		want  float64
+		ok    bool
+	}{
+		{"stable", stable, 1, true},
+		{"stable without pools", otherStable, 1, true},
+		// the wrapped coin has a pool with the second stable only
+		{"wrapped", wrapped, 300, true},
+		{"against a stable", testToken(4), 2.5, true},
+		{"through the wrapped coin", testToken(5), 150, true},
+		{"no route to a dollar", testToken(6), 0, false},
+		{"unknown", testToken(7), 0, false},
+	}
+	for _, test := range tests {
+		got, ok := assetPrice(market, profile, test.token)
+		if ok != test.ok || got != test.want {
+			t.Errorf("%s: price %v %v, want %v %v", test.name, got, ok, test.want, test.ok)
+		}
+	}
+
+	// without a stable pool for the wrapped coin nothing routed through it
+	// has a price
+	unpriced := pricedGraph(testRate{5, 1, 0.5})
+	if got, ok := assetPrice(unpriced, profile, testToken(5)); ok {
+		t.Errorf("wrapped coin without a stable pool: priced at %v", got)
+	}
+}
+
+func TestBridgeSpreads(t *testing.T) {
+	monitor := &MonitorConfig{
+		TradeUSD:      10000,
+		VolatilityBps: 10,
+		Bridges: []BridgeConfig{
+			// 5 bps, 10 bps of fixed fee on the trade size and 20 bps of
+			// haircut for four minutes in flight
+			{From: "bsc", To: "ethereum", FeeBps: 5, FixedUSD: 10, LatencySeconds: 240},
+			{From: "ethereum", To: "bsc"},
+			// no prices on polygon
+			{From: "bsc", To: "polygon"},
+		},
+	}
+	prices := map[string]map[string]float64{
+		"ETH":  {"bsc": 1000, "ethereum": 1010},
+		"USDT": {"bsc": 1},
+	}
+
+	want := []BridgeSpread{
+		{"ETH", "bsc", "ethereum", 1000, 1010, 100, 65},
+		{"ETH", "ethereum", "bsc", 1010, 1000, -99.0099, -99.0099},
+	}
+	spreads := monitor.bridgeSpreads(prices)
+	if len(spreads) != len(want) {
+		t.Fatalf("%d spreads, want %d: %+v", len(spreads), len(want), spreads)
+	}
+	near := func(a, b float64) bool { return math.Abs(a-b) < 1e-3 }
+	for i, spread := range spreads {
+		w := want[i]
+		if spread.Asset != w.Asset || spread.From != w.From || spread.To != w.To || spread.BuyPrice != w.BuyPrice || spread.SellPrice != w.SellPrice {
+			t.Errorf("spread %d is %+v, want %+v", i, spread, w)
+		}
+		if !near(spread.GrossBps, w.GrossBps) || !near(spread.NetBps, w.NetBps) {
+			t.Errorf("spread %d: %v bps gross %v net, want %v %v", i, spread.GrossBps, spread.NetBps, w.GrossBps, w.NetBps)
+		}
+	}
+}
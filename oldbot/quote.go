@@ -0,0 +1,457 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// QuoteConfig bounds the routes a quote considers.
+type QuoteConfig struct {
+	// MaxHops is the most pools one route goes through.
+	MaxHops int
+	// MaxPaths is the most routes kept to each token after each hop, and
+	// the most routes to the last token ranked.
+	MaxPaths int
+	// MaxRoutes is the most routes an amount is split across, and Parts
+	// the slices it is split in; each slice goes to whichever route pays
+	// the most for it at that point.
+	MaxRoutes int
+	Parts     int
+}
+
+var errNoRoute = errors.New("no route")
+
+// RouteHop is one hop of a route: the pools its input is split across,
+// what each of them is given, and what they pay together.
+type RouteHop struct {
+	Pools     []common.Address
+	AmountsIn []*big.Int
+	AmountOut *big.Int
+}
+
+// Route is one path through the graph and what it pays for AmountIn.
+type Route struct {
+	Tokens    []common.Address
+	Path      []string
+	Hops      []RouteHop
+	AmountIn  *big.Int
+	AmountOut *big.Int
+}
+
+// Quote is the best way found to trade AmountIn of one token for another.
+// Best is the best single route. Split spreads the amount over several
+// routes, or is Best alone when splitting does not pay more.
+type Quote struct {
+	From      common.Address
+	To        common.Address
+	AmountIn  *big.Int
+	Best      Route
+	Split     []Route
+	AmountOut *big.Int
+}
+
+// reserveKey is one side of a pool.
+type reserveKey struct {
+	pool  common.Address
+	token common.Address
+}
+
+// routeReserves are pool reserves as routed amounts move them, so routes
+// that share a pool see each other's trades.
+type routeReserves map[reserveKey]*big.Int
+
+func (r routeReserves) get(pool, token common.Address, initial *big.Int) *big.Int {
+	key := reserveKey{pool, token}
+	reserve, ok := r[key]
+	if !ok {
+		reserve = new(big.Int).Set(initial)
+		r[key] = reserve
+	}
+	return reserve
+}
+
+// swap is what amount pays through hops at the current reserves, each
+// hop's input split across its pools by splitParallel, and how it was
+// traded. apply moves the reserves the way the trade would.
+func (r routeReserves) swap(hops [][]Pair, amount *big.Int, apply bool) (*big.Int, []RouteHop) {
+	out := amount
+	traded := make([]RouteHop, len(hops))
+	for i, hop := range hops {
+		reservesIn := make([]*big.Int, len(hop))
+		reservesOut := make([]*big.Int, len(hop))
+		fees := make([]int64, len(hop))
+		for j := range hop {
+			fees[j] = hop[j].inputFee()
+			reservesIn[j] = r.get(hop[j].factory, hop[j].from, &hop[j].r_from)
+			reservesOut[j] = r.get(hop[j].factory, hop[j].to, &hop[j].r_to)
+		}
+		amounts := splitParallel(reservesIn, reservesOut, fees, out)
+		out = new(big.Int)
+		for j, in := range amounts {
+			if in.Sign() == 0 {
+				continue
+			}
+			pairOut := getAmountOut(in, reservesIn[j], reservesOut[j], fees[j])
+			out.Add(out, pairOut)
+			traded[i].Pools = append(traded[i].Pools, hop[j].factory)
+			traded[i].AmountsIn = append(traded[i].AmountsIn, in)
+			if apply {
+				reservesIn[j].Add(reservesIn[j], in)
+				reservesOut[j].Sub(reservesOut[j], pairOut)
+			}
+		}
+		traded[i].AmountOut = out
+	}
+	return out, traded
+}
+
+// routeGraph is a copy of a graph's tokens and pools, so routes are
+// searched and priced without holding the graph's lock. The pairs' reserves
+// are shared with the graph and only ever read.
+type routeGraph struct {
+	ids     map[common.Address]int
+	symbols []string
+	tokens  []common.Address
+	// hops are the pools from each token to each neighbor, the edge's pair
+	// first
+	hops []map[int][]Pair
+}
+
+// routeGraph copies the graph for quoting.
+func (g *Graph) routeGraph() *routeGraph {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	r := &routeGraph{
+		ids:     make(map[common.Address]int, len(g.nodes)),
+		symbols: make([]string, len(g.nodes)),
+		tokens:  make([]common.Address, len(g.nodes)),
+		hops:    make([]map[int][]Pair, len(g.nodes)),
+	}
+	for id, node := range g.nodes {
+		r.ids[node.address] = id
+		r.symbols[id], r.tokens[id] = node.asset, node.address
+		r.hops[id] = make(map[int][]Pair, len(node.edgePair))
+		for next := range node.edgePair {
+			r.hops[id][next] = g.hopPools(id, next)
+		}
+	}
+	return r
+}
+
+// partialRoute is a route being searched: the tokens it went through, its
+// hops and what it pays so far.
+type partialRoute struct {
+	nodes []int
+	hops  [][]Pair
+	out   *big.Int
+}
+
+func (p partialRoute) visits(node int) bool {
+	for _, visited := range p.nodes {
+		if visited == node {
+			return true
+		}
+	}
+	return false
+}
+
+// rankRoutes orders routes by what they pay, most first. Ties go to the
+// shorter route, then by pools, so the same graph quotes the same whatever
+// order its maps are walked in.
+func rankRoutes(routes []partialRoute) {
+	sort.Slice(routes, func(i, j int) bool {
+		if c := routes[i].out.Cmp(routes[j].out); c != 0 {
+			return c > 0
+		}
+		if len(routes[i].hops) != len(routes[j].hops) {
+			return len(routes[i].hops) < len(routes[j].hops)
+		}
+		return routeKey(routes[i].hops) < routeKey(routes[j].hops)
+	})
+}
+
+// paths finds the routes of at most MaxHops hops from one token to
+// another that pay the most for amount, best first. Routes are extended
+// one hop at a time, keeping only the MaxPaths that pay the most into each
+// token, so the search stays bounded however dense the graph is; a route
+// past the cut is only missed when MaxPaths others pay more to the same
+// token in as many hops.
+func (r *routeGraph) paths(from, to common.Address, amount *big.Int, config QuoteConfig) []partialRoute {
+	start, ok := r.ids[from]
+	if !ok {
+		return nil
+	}
+	end, ok := r.ids[to]
+	if !ok || start == end {
+		return nil
+	}
+	var found []partialRoute
+	frontier := []partialRoute{{nodes: []int{start}, out: amount}}
+	for hop := 0; hop < config.MaxHops && len(frontier) > 0; hop++ {
+		into := make(map[int][]partialRoute)
+		for _, route := range frontier {
+			for next, pools := range r.hops[route.nodes[len(route.nodes)-1]] {
+				if route.visits(next) {
+					continue
+				}
+				// No pool is on two hops of a route, so each hop can be
+				// priced on its own
+				out, _ := routeReserves{}.swap([][]Pair{pools}, route.out, false)
+				if out.Sign() == 0 {
+					continue
+				}
+				extended := partialRoute{
+					nodes: append(append([]int{}, route.nodes...), next),
+					hops:  append(append([][]Pair{}, route.hops...), pools),
+					out:   out,
+				}
+				if next == end {
+					found = append(found, extended)
+				} else {
+					into[next] = append(into[next], extended)
+				}
+			}
+		}
+		frontier = frontier[:0]
+		for _, routes := range into {
+			rankRoutes(routes)
+			if len(routes) > config.MaxPaths {
+				routes = routes[:config.MaxPaths]
+			}
+			frontier = append(frontier, routes...)
+		}
+	}
+	rankRoutes(found)
+	if len(found) > config.MaxPaths {
+		found = found[:config.MaxPaths]
+	}
+	return found
+}
+
+// quoteRoutes finds the route that pays the most for amount, with the
+// exact output of every pool on it at its own fee and each hop split
+// across the pools parallel to it, then splits amount across the best
+// MaxRoutes routes. Transfer taxes of vetted tokens are taken out.
+func quoteRoutes(r *routeGraph, from, to common.Address, amount *big.Int, config QuoteConfig) (*Quote, error) {
+	found := r.paths(from, to, amount, config)
+	if len(found) == 0 {
+		return nil, fmt.Errorf("%w from %s to %s within %d pools", errNoRoute, from.Hex(), to.Hex(), config.MaxHops)
+	}
+	bestOut, traded := routeReserves{}.swap(found[0].hops, amount, false)
+	quote := &Quote{
+		From:      from,
+		To:        to,
+		AmountIn:  new(big.Int).Set(amount),
+		Best:      r.route(found[0].hops, new(big.Int).Set(amount), bestOut, traded),
+		AmountOut: bestOut,
+	}
+	quote.Split = []Route{quote.Best}
+
+	if len(found) > config.MaxRoutes {
+		found = found[:config.MaxRoutes]
+	}
+	candidates := make([][][]Pair, len(found))
+	for i, route := range found {
+		candidates[i] = route.hops
+	}
+	amounts := splitAmount(candidates, amount, config.Parts)
+	// Replay the split as the trades would go, one route after another
+	reserves := routeReserves{}
+	split := []Route{}
+	total := new(big.Int)
+	for i, in := range amounts {
+		if in.Sign() == 0 {
+			continue
+		}
+		out, hops := reserves.swap(candidates[i], in, true)
+		split = append(split, r.route(candidates[i], in, out, hops))
+		total.Add(total, out)
+	}
+	if len(split) > 1 && total.Cmp(quote.AmountOut) > 0 {
+		quote.Split, quote.AmountOut = split, total
+	}
+	return quote, nil
+}
+
+// route describes a path of the graph and what it pays.
+func (r *routeGraph) route(path [][]Pair, amountIn, amountOut *big.Int, hops []RouteHop) Route {
+	route := Route{Hops: hops, AmountIn: amountIn, AmountOut: amountOut}
+	route.Tokens = append(route.Tokens, path[0][0].from)
+	route.Path = append(route.Path, r.symbols[r.ids[path[0][0].from]])
+	for _, hop := range path {
+		route.Tokens = append(route.Tokens, hop[0].to)
+		route.Path = append(route.Path, r.symbols[r.ids[hop[0].to]])
+	}
+	return route
+}
+
+// splitAmount cuts amount in parts and gives each part to the route that
+// pays the most for it after the parts before it, returning each route's
+// share. A route is only quoted again once a part went through a pool it
+// shares, since nothing else changes what it pays.
+func splitAmount(routes [][][]Pair, amount *big.Int, parts int) []*big.Int {
+	amounts := make([]*big.Int, len(routes))
+	pools := make([]map[common.Address]bool, len(routes))
+	for i, route := range routes {
+		amounts[i] = new(big.Int)
+		pools[i] = make(map[common.Address]bool)
+		for _, hop := range route {
+			for _, pair := range hop {
+				pools[i][pair.factory] = true
+			}
+		}
+	}
+	reserves := routeReserves{}
+	// outs are what each route pays for quoted at the current reserves,
+	// nil once that is out of date
+	outs := make([]*big.Int, len(routes))
+	var quoted *big.Int
+	part := new(big.Int).Div(amount, big.NewInt(int64(parts)))
+	left := new(big.Int).Set(amount)
+	for left.Sign() > 0 {
+		in := part
+		// The last part takes what division left over
+		if in.Sign() == 0 || left.Cmp(new(big.Int).Mul(part, big.NewInt(2))) < 0 {
+			in = new(big.Int).Set(left)
+		}
+		if quoted == nil || in.Cmp(quoted) != 0 {
+			quoted = in
+			for i := range outs {
+				outs[i] = nil
+			}
+		}
+		best := 0
+		for i, route := range routes {
+			if outs[i] == nil {
+				outs[i], _ = reserves.swap(route, in, false)
+			}
+			if outs[i].Cmp(outs[best]) > 0 {
+				best = i
+			}
+		}
+		reserves.swap(routes[best], in, true)
+		amounts[best].Add(amounts[best], in)
+		left.Sub(left, in)
+		for i := range routes {
+			for pool := range pools[best] {
+				if pools[i][pool] {
+					outs[i] = nil
+					break
+				}
+			}
+		}
+	}
+	return amounts
+}
+
+// routeKey identifies a path by the best pool of each hop.
+func routeKey(path [][]Pair) string {
+	pairs := make([]Pair, len(path))
+	for i, hop := range path {
+		pairs[i] = hop[0]
+	}
+	return loopKey(pairs)
+}
+
+// token finds a token of the graph by address or, case aside, by symbol.
+func (g *Graph) token(name string) (common.Address, error) {
+	if common.IsHexAddress(name) {
+		return common.HexToAddress(name), nil
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	var found []common.Address
+	for _, node := range g.nodes {
+		if strings.EqualFold(node.asset, name) {
+			found = append(found, node.address)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return common.Address{}, fmt.Errorf("no token %q in the graph", name)
+	case 1:
+		return found[0], nil
+	default:
+		return common.Address{}, fmt.Errorf("%d tokens are called %q, give an address", len(found), name)
+	}
+}
+
+func printQuote(quote *Quote, tokens *TokenRegistry, block uint64) {
+	fmt.Printf("Quote at block %d: %s to %s\n", block, tokens.Format(quote.From, quote.AmountIn), tokens.Symbol(quote.To, quote.To.Hex()))
+	fmt.Printf("Best route %s via %s: %s\n", strings.Join(quote.Best.Path, " -> "), hopList(quote.Best.Hops), tokens.Format(quote.To, quote.Best.AmountOut))
+	if len(quote.Split) < 2 {
+		fmt.Println("Splitting across routes pays no more")
+		return
+	}
+	gain := new(big.Int).Sub(quote.AmountOut, quote.Best.AmountOut)
+	fmt.Printf("Split over %d routes: %s (+%s)\n", len(quote.Split), tokens.Format(quote.To, quote.AmountOut), tokens.Format(quote.To, gain))
+	whole := new(big.Float).SetInt(quote.AmountIn)
+	for _, route := range quote.Split {
+		share, _ := new(big.Float).Quo(new(big.Float).SetInt(route.AmountIn), whole).Float64()
+		fmt.Printf("  %5.1f%% %s via %s: %s\n", 100*share, strings.Join(route.Path, " -> "), hopList(route.Hops), tokens.Format(quote.To, route.AmountOut))
+	}
+}
+
+// hopList names the pools of each hop, with the share of the hop's input
+// each pool is given when there are several.
+func hopList(hops []RouteHop) string {
+	names := make([]string, len(hops))
+	for i, hop := range hops {
+		if len(hop.Pools) == 1 {
+			names[i] = hop.Pools[0].Hex()
+			continue
+		}
+		whole := new(big.Int)
+		for _, in := range hop.AmountsIn {
+			whole.Add(whole, in)
+		}
+		pools := make([]string, len(hop.Pools))
+		for j, pool := range hop.Pools {
+			share, _ := new(big.Float).Quo(new(big.Float).SetInt(hop.AmountsIn[j]), new(big.Float).SetInt(whole)).Float64()
+			pools[j] = fmt.Sprintf("%s (%.1f%%)", pool.Hex(), 100*share)
+		}
+		names[i] = strings.Join(pools, " + ")
+	}
+	return strings.Join(names, ", ")
+}
+
+// quoteCommand runs `quote <from> <to> <amount>` on the current pools.
+// Tokens are addresses or symbols and amount is in whole tokens.
+func quoteCommand(ctx context.Context, profile ChainProfile, config Config, args []string) error {
+	if len(args) != 3 {
+		return errors.New("quote: want <from> <to> <amount>")
+	}
+	market, err := newChainMarket(ctx, profile, config.RPC, config.Market, config.DEX, config.Sources)
+	if err != nil {
+		return err
+	}
+	pools, err := market.pools()
+	if err != nil {
+		return err
+	}
+	graph := market.graph(pools)
+	from, err := graph.token(args[0])
+	if err != nil {
+		return err
+	}
+	to, err := graph.token(args[1])
+	if err != nil {
+		return err
+	}
+	amount, err := market.tokens.Parse(from, args[2])
+	if err != nil {
+		return err
+	}
+	quote, err := quoteRoutes(graph.routeGraph(), from, to, amount, config.Quote)
+	if err != nil {
+		return err
+	}
+	printQuote(quote, market.tokens, market.head.Number.Uint64())
+	return nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+)
+
+func TestSplitAmount(t *testing.T) {
+	unit := new(big.Int).Exp(big.NewInt(10), big.NewInt(18), nil)
+	amount := new(big.Int).Mul(big.NewInt(100), unit)
+	shallow := [][]Pair{{testPair(1, 2, 1000, 1000, 25)}}
+	twin := [][]Pair{{testPair(3, 4, 1000, 1000, 25)}}
+	deep := [][]Pair{{testPair(5, 6, 9000, 9000, 25)}}
+	tests := []struct {
+		name   string
+		routes [][][]Pair
+		parts  int
+		// want is each route's share of amount in percent, within one part
+		want []int64
+	}{
+		{"one route", [][][]Pair{shallow}, 10, []int64{100}},
+		{"twin routes", [][][]Pair{shallow, twin}, 10, []int64{50, 50}},
+		{"deeper route", [][][]Pair{shallow, deep}, 20, []int64{10, 90}},
+		// The same route twice shares its pool, so the copy gets nothing
+		// it would not have had anyway
+		{"shared pool", [][][]Pair{shallow, shallow}, 10, []int64{100, 0}},
+	}
+	for _, test := range tests {
+		amounts := splitAmount(test.routes, amount, test.parts)
+		total := new(big.Int)
+		for i, got := range amounts {
+			total.Add(total, got)
+			pct := new(big.Int).Div(new(big.Int).Mul(got, big.NewInt(100)), amount).Int64()
+			if diff := pct - test.want[i]; diff > 100/int64(test.parts) || diff < -100/int64(test.parts) {
+				t.Errorf("%s: route %d gets %d%%, want %d%%", test.name, i, pct, test.want[i])
+			}
+		}
+		if total.Cmp(amount) != 0 {
+			t.Errorf("%s: shares add up to %s, want %s", test.name, total, amount)
+		}
+	}
+}
+
+func TestQuoteRoutes(t *testing.T) {
+	unit := new(big.Int).Exp(big.NewInt(10), big.NewInt(18), nil)
+	graph := testGraph(
+		// a shallow direct pool and a deep detour through T3
+		testPair(1, 2, 100, 100, 10),
+		testPair(1, 3, 3000, 3000, 30),
+		testPair(3, 2, 3000, 3000, 30),
+		// T4 only trades with T5
+		testPair(4, 5, 100, 100, 30),
+	)
+	config := QuoteConfig{MaxHops: 3, MaxPaths: 8, MaxRoutes: 4, Parts: 20}
+	tests := []struct {
+		name string
+		// amount is in thousandths of a token
+		amount int64
+		config QuoteConfig
+		// best is the best route's tokens, split whether a split pays more
+		best  []int
+		split bool
+	}{
+		{"small amount takes the direct pool", 10, config, []int{1, 2}, false},
+		{"large amount takes the detour", 50000, config, []int{1, 3, 2}, true},
+		{"one hop only", 50000, QuoteConfig{MaxHops: 1, MaxPaths: 8, MaxRoutes: 4, Parts: 20}, []int{1, 2}, false},
+		{"one path kept per token", 50000, QuoteConfig{MaxHops: 3, MaxPaths: 1, MaxRoutes: 1, Parts: 20}, []int{1, 3, 2}, false},
+	}
+	for _, test := range tests {
+		amount := new(big.Int).Mul(big.NewInt(test.amount), unit)
+		amount.Div(amount, big.NewInt(1000))
+		quote, err := quoteRoutes(graph.routeGraph(), testToken(1), testToken(2), amount, test.config)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if len(quote.Best.Tokens) != len(test.best) {
+			t.Fatalf("%s: best route %v, want tokens %v", test.name, quote.Best.Path, test.best)
+		}
+		for i, token := range test.best {
+			if quote.Best.Tokens[i] != testToken(token) {
+				t.Errorf("%s: best route %v, want tokens %v", test.name, quote.Best.Path, test.best)
+				break
+			}
+		}
+		if split := len(quote.Split) > 1; split != test.split {
+			t.Errorf("%s: split over %d routes, want a split %v", test.name, len(quote.Split), test.split)
+		}
+		if quote.AmountOut.Cmp(quote.Best.AmountOut) < 0 {
+			t.Errorf("%s: quote pays %s, less than its best route's %s", test.name, quote.AmountOut, quote.Best.AmountOut)
+		}
+	}
+
+	if _, err := quoteRoutes(graph.routeGraph(), testToken(1), testToken(4), unit, config); !errors.Is(err, errNoRoute) {
+		t.Errorf("quote to an unconnected token: %v, want errNoRoute", err)
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"math/big"
+	"strings"
+
+	"example.com/m/pancakeRouter"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+var (
+	pancakeRouterAddress  = common.HexToAddress("0x10ED43C718714eb63d5aA57B78B54704E256024E")
+	pancakeFactoryAddress = common.HexToAddress("0xca143ce32fe78f1f7019d7d551a6402fc5350c73")
+	// keccak of the PancakePair creation code, used to derive pair addresses
+	pancakeInitCodeHash = common.HexToHash("0x00fb7f630766e6a796048ea87d01acd3068e8ff67d078148a3fa3f4a84f69bd5")
+)
+
+// pairFor derives the pair a V2 factory deploys for two tokens.
+func pairFor(factory common.Address, initCodeHash common.Hash, tokenA, tokenB common.Address) common.Address {
+	if bytes.Compare(tokenA.Bytes(), tokenB.Bytes()) > 0 {
+		tokenA, tokenB = tokenB, tokenA
+	}
+	salt := crypto.Keccak256Hash(tokenA.Bytes(), tokenB.Bytes())
+	return crypto.CreateAddress2(factory, salt, initCodeHash.Bytes())
+}
+
+// routerFactory is the factory whose pairs a router trades through.
+type routerFactory struct {
+	factory      common.Address
+	initCodeHash common.Hash
+}
+
+// routerFactories lists the routers whose calls can be decoded.
+var routerFactories = map[common.Address]routerFactory{
+	pancakeRouterAddress: {pancakeFactoryAddress, pancakeInitCodeHash},
+}
+
+var errNotSwap = errors.New("not a router swap")
+
+// RouterHop is one pool a router swap trades through. The amounts are nil
+// until the swap is quoted against the pool's reserves.
+type RouterHop struct {
+	Pool      common.Address
+	TokenIn   common.Address
+	TokenOut  common.Address
+	AmountIn  *big.Int
+	AmountOut *big.Int
+}
+
+// RouterSwap is a decoded call to one of the router's swap methods.
+type RouterSwap struct {
+	Method string
+	// ExactInput swaps fix AmountIn and bound AmountOut from below, the
+	// ForExact ones fix AmountOut and bound AmountIn from above.
+	ExactInput bool
+	// FeeOnTransfer swaps measure what each pair received instead of
+	// trusting the amounts, so taxed tokens go through.
+	FeeOnTransfer bool
+	Path          []common.Address
+	Hops          []RouterHop
+	AmountIn      *big.Int
+	AmountOut     *big.Int
+	To            common.Address
+	Deadline      *big.Int
+}
+
+// decodeRouterSwap decodes calldata sent to router with value attached.
+// It returns errNotSwap for anything other than a swap.
+func decodeRouterSwap(router common.Address, data []byte, value *big.Int) (*RouterSwap, error) {
+	factory, ok := routerFactories[router]
+	if !ok || len(data) < 4 {
+		return nil, errNotSwap
+	}
+	parsed, err := pancakeRouter.PancakeRouterMetaData.GetAbi()
+	if err != nil {
+		return nil, err
+	}
+	method, err := parsed.MethodById(data[:4])
+	if err != nil || !strings.HasPrefix(method.Name, "swap") {
+		return nil, errNotSwap
+	}
+	args, err := method.Inputs.Unpack(data[4:])
+	if err != nil {
+		return nil, err
+	}
+
+	swap := &RouterSwap{
+		Method:        method.Name,
+		ExactInput:    strings.HasPrefix(method.Name, "swapExact"),
+		FeeOnTransfer: strings.HasSuffix(method.Name, "SupportingFeeOnTransferTokens"),
+	}
+	for i, input := range method.Inputs {
+		switch input.Name {
+		case "amountIn", "amountInMax":
+			swap.AmountIn = args[i].(*big.Int)
+		case "amountOut", "amountOutMin":
+			swap.AmountOut = args[i].(*big.Int)
+		case "path":
+			swap.Path = args[i].([]common.Address)
+		case "to":
+			swap.To = args[i].(common.Address)
+		case "deadline":
+			swap.Deadline = args[i].(*big.Int)
+		}
+	}
+	// native coin swaps pay with the transaction value instead
+	if swap.AmountIn == nil {
+		swap.AmountIn = value
+	}
+	if len(swap.Path) < 2 {
+		return nil, errors.New("router swap path is too short")
+	}
+
+	for i := 0; i+1 < len(swap.Path); i++ {
+		swap.Hops = append(swap.Hops, RouterHop{
+			Pool:     pairFor(factory.factory, factory.initCodeHash, swap.Path[i], swap.Path[i+1]),
+			TokenIn:  swap.Path[i],
+			TokenOut: swap.Path[i+1],
+		})
+	}
+	return swap, nil
+}
+
+// Quote fills in hop amounts from the pools' reserves the way the router
+// would: forwards from AmountIn for exact-input swaps, backwards from
+// AmountOut for exact-output ones. It stops at the first pool not in index
+// and reports whether every hop was quoted.
+func (s *RouterSwap) Quote(pools []PoolState, index map[common.Address]int) bool {
+	if s.ExactInput {
+		amount := s.AmountIn
+		for i := range s.Hops {
+			j, ok := index[s.Hops[i].Pool]
+			if !ok {
+				return false
+			}
+			reserveIn, reserveOut := pools[j].reserves(s.Hops[i].TokenIn)
+			s.Hops[i].AmountIn = amount
+			amount = getAmountOut(amount, reserveIn, reserveOut, pools[j].in.Fee)
+			s.Hops[i].AmountOut = amount
+		}
+		return true
+	}
+
+	amount := s.AmountOut
+	for i := len(s.Hops) - 1; i >= 0; i-- {
+		j, ok := index[s.Hops[i].Pool]
+		if !ok {
+			return false
+		}
+		reserveIn, reserveOut := pools[j].reserves(s.Hops[i].TokenIn)
+		if amount.Cmp(reserveOut) >= 0 {
+			return false
+		}
+		s.Hops[i].AmountOut = amount
+		amount = getAmountIn(amount, reserveIn, reserveOut, pools[j].in.Fee)
+		s.Hops[i].AmountIn = amount
+	}
+	return true
+}
+
+// predictedSwaps are the quoted hops in the form applySwaps takes.
+func (s *RouterSwap) predictedSwaps() []predictedSwap {
+	swaps := []predictedSwap{}
+	for _, hop := range s.Hops {
+		if hop.AmountIn == nil {
+			continue
+		}
+		swaps = append(swaps, predictedSwap{hop.Pool, hop.TokenIn, hop.AmountIn})
+	}
+	return swaps
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+
+	"example.com/m/pancakeRouter"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+var (
+	testWBNB = common.HexToAddress("0xbb4CdB9CBd36B01bD1cBaEBF2De08d9173bc095c")
+	testBUSD = common.HexToAddress("0xe9e7CEA3DedcA5984780Bafc599bD69ADd087D56")
+	testCAKE = common.HexToAddress("0x0E09FaBB73Bd3Ade0a17ECC321fD13a19e81cE82")
+	// the pairs the PancakeSwap v2 factory deployed for them
+	testWBNBBUSD = common.HexToAddress("0x58F876857a02D6762E0101bb5C46A8c1ED44Dc16")
+	testCAKEWBNB = common.HexToAddress("0x0eD7e52944161450477ee417DE9Cd3a859b14fD0")
+)
+
+// packRouterCall is calldata for one of the router's methods.
+func packRouterCall(t *testing.T, method string, args ...interface{}) []byte {
+	parsed, err := pancakeRouter.PancakeRouterMetaData.GetAbi()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := parsed.Pack(method, args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestPairFor(t *testing.T) {
+	tests := []struct {
+		a, b common.Address
+		want common.Address
+	}{
+		{testWBNB, testBUSD, testWBNBBUSD},
+		{testBUSD, testWBNB, testWBNBBUSD},
+		{testCAKE, testWBNB, testCAKEWBNB},
+	}
+	for _, test := range tests {
+		if got := pairFor(pancakeFactoryAddress, pancakeInitCodeHash, test.a, test.b); got != test.want {
+			t.Errorf("pairFor(%s, %s) = %s, want %s", test.a, test.b, got, test.want)
+		}
+	}
+}
+
+// TestProfileInitCodeHashes checks every DEX profile that knows its init
+// code hash derives a pair the factory really deployed, so pairFees can
+// skip the factory() call for its pairs.
+func TestProfileInitCodeHashes(t *testing.T) {
+	tests := []struct {
+		chain, dex string
+		a, b       common.Address
+		want       common.Address
+	}{
+		{"bsc", "pancake", testWBNB, testBUSD, testWBNBBUSD},
+		// WBNB/USDT
+		{"bsc", "biswap", testWBNB, common.HexToAddress("0x55d398326f99059fF775485246999027B3197955"), common.HexToAddress("0x8840C6252e2e86e545deFb6da98B2a0E26d8C1BA")},
+		// WETH/USDC
+		{"ethereum", "uniswap", common.HexToAddress("0xC02aaA39b223FE8D0A0e5C4F27eAD9083C756Cc2"), common.HexToAddress("0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48"), common.HexToAddress("0xB4e16d0168e52d35CaCD2c6185b44281Ec28C9Dc")},
+		{"ethereum", "sushiswap", common.HexToAddress("0xC02aaA39b223FE8D0A0e5C4F27eAD9083C756Cc2"), common.HexToAddress("0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48"), common.HexToAddress("0x397FF1542f962076d0BFE58eA045FfA2d347ACa0")},
+		{"arbitrum", "sushiswap", common.HexToAddress("0x82aF49447D8a07e3bd95BD0d56f35241523fBab1"), common.HexToAddress("0xFF970A61A04b1cA14834A43f5dE4533eBDDB5CC8"), common.HexToAddress("0x905dfCD5649217c42684f23958568e533C711Aa3")},
+	}
+	for _, test := range tests {
+		var dex *DEXConfig
+		for i, d := range chainProfiles[test.chain].DEXes {
+			if d.Name == test.dex {
+				dex = &chainProfiles[test.chain].DEXes[i]
+			}
+		}
+		if dex == nil {
+			t.Errorf("%s %s: no such DEX", test.chain, test.dex)
+			continue
+		}
+		if got := pairFor(dex.Factory, dex.InitCodeHash, test.a, test.b); got != test.want {
+			t.Errorf("%s %s: pairFor(%s, %s) = %s, want %s", test.chain, test.dex, test.a, test.b, got, test.want)
+		}
+	}
+}
+
+func TestDecodeRouterSwap(t *testing.T) {
+	to := common.HexToAddress("0x5a1E8B6d1F1e9C7D3F2b4c6E8d0A1b2C3d4E5f60")
+	deadline := big.NewInt(1700000000)
+	// swapExactETHForTokens(1e18, [WBNB, BUSD], to, 1700000000) as a wallet
+	// sends it
+	ethForTokens := common.FromHex("0x7ff36ab5" +
+		"0000000000000000000000000000000000000000000000000de0b6b3a7640000" +
+		"0000000000000000000000000000000000000000000000000000000000000080" +
+		"0000000000000000000000005a1e8b6d1f1e9c7d3f2b4c6e8d0a1b2c3d4e5f60" +
+		"000000000000000000000000000000000000000000000000000000006553f100" +
+		"0000000000000000000000000000000000000000000000000000000000000002" +
+		"000000000000000000000000bb4cdb9cbd36b01bd1cbaebf2de08d9173bc095c" +
+		"000000000000000000000000e9e7cea3dedca5984780bafc599bd69add087d56")
+
+	tests := []struct {
+		name   string
+		router common.Address
+		data   []byte
+		value  *big.Int
+		// a nil swap wants err
+		swap *RouterSwap
+		err  error
+	}{
+		{
+			name:   "exact native in",
+			router: pancakeRouterAddress,
+			data:   ethForTokens,
+			value:  big.NewInt(5e17),
+			swap: &RouterSwap{
+				Method: "swapExactETHForTokens", ExactInput: true,
+				Path:     []common.Address{testWBNB, testBUSD},
+				Hops:     []RouterHop{{Pool: testWBNBBUSD, TokenIn: testWBNB, TokenOut: testBUSD}},
+				AmountIn: big.NewInt(5e17), AmountOut: big.NewInt(1e18), To: to, Deadline: deadline,
+			},
+		},
+		{
+			name:   "exact tokens in",
+			router: pancakeRouterAddress,
+			data:   packRouterCall(t, "swapExactTokensForTokens", big.NewInt(300), big.NewInt(200), []common.Address{testCAKE, testWBNB, testBUSD}, to, deadline),
+			value:  new(big.Int),
+			swap: &RouterSwap{
+				Method: "swapExactTokensForTokens", ExactInput: true,
+				Path: []common.Address{testCAKE, testWBNB, testBUSD},
+				Hops: []RouterHop{
+					{Pool: testCAKEWBNB, TokenIn: testCAKE, TokenOut: testWBNB},
+					{Pool: testWBNBBUSD, TokenIn: testWBNB, TokenOut: testBUSD},
+				},
+				AmountIn: big.NewInt(300), AmountOut: big.NewInt(200), To: to, Deadline: deadline,
+			},
+		},
+		{
+			name:   "exact tokens out",
+			router: pancakeRouterAddress,
+			data:   packRouterCall(t, "swapTokensForExactTokens", big.NewInt(200), big.NewInt(300), []common.Address{testBUSD, testWBNB}, to, deadline),
+			value:  new(big.Int),
+			swap: &RouterSwap{
+				Method: "swapTokensForExactTokens",
+				Path:   []common.Address{testBUSD, testWBNB},
+				Hops:   []RouterHop{{Pool: testWBNBBUSD, TokenIn: testBUSD, TokenOut: testWBNB}},
+				// amountInMax bounds the input
+				AmountIn: big.NewInt(300), AmountOut: big.NewInt(200), To: to, Deadline: deadline,
+			},
+		},
+		{
+			name:   "fee on transfer",
+			router: pancakeRouterAddress,
+			data:   packRouterCall(t, "swapExactTokensForTokensSupportingFeeOnTransferTokens", big.NewInt(300), big.NewInt(200), []common.Address{testCAKE, testWBNB}, to, deadline),
+			value:  new(big.Int),
+			swap: &RouterSwap{
+				Method: "swapExactTokensForTokensSupportingFeeOnTransferTokens", ExactInput: true, FeeOnTransfer: true,
+				Path:     []common.Address{testCAKE, testWBNB},
+				Hops:     []RouterHop{{Pool: testCAKEWBNB, TokenIn: testCAKE, TokenOut: testWBNB}},
+				AmountIn: big.NewInt(300), AmountOut: big.NewInt(200), To: to, Deadline: deadline,
+			},
+		},
+		{
+			name:   "not a swap",
+			router: pancakeRouterAddress,
+			data:   packRouterCall(t, "addLiquidity", testCAKE, testWBNB, big.NewInt(1), big.NewInt(1), big.NewInt(1), big.NewInt(1), to, deadline),
+			value:  new(big.Int),
+			err:    errNotSwap,
+		},
+		{
+			name:   "unknown router",
+			router: common.HexToAddress("0x05fF2B0DB69458A0750badebc4f9e13aDd608C7F"),
+			data:   ethForTokens,
+			value:  big.NewInt(5e17),
+			err:    errNotSwap,
+		},
+		{
+			name:   "short calldata",
+			router: pancakeRouterAddress,
+			data:   ethForTokens[:3],
+			value:  new(big.Int),
+			err:    errNotSwap,
+		},
+	}
+	for _, test := range tests {
+		swap, err := decodeRouterSwap(test.router, test.data, test.value)
+		if test.swap == nil {
+			if !errors.Is(err, test.err) {
+				t.Errorf("%s: err = %v, want %v", test.name, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		want := test.swap
+		if swap.Method != want.Method || swap.ExactInput != want.ExactInput || swap.FeeOnTransfer != want.FeeOnTransfer {
+			t.Errorf("%s: decoded %s exact input %v fee on transfer %v, want %s %v %v", test.name,
+				swap.Method, swap.ExactInput, swap.FeeOnTransfer, want.Method, want.ExactInput, want.FeeOnTransfer)
+		}
+		if swap.AmountIn.Cmp(want.AmountIn) != 0 || swap.AmountOut.Cmp(want.AmountOut) != 0 {
+			t.Errorf("%s: amounts %s -> %s, want %s -> %s", test.name, swap.AmountIn, swap.AmountOut, want.AmountIn, want.AmountOut)
+		}
+		if swap.To != want.To || swap.Deadline.Cmp(want.Deadline) != 0 {
+			t.Errorf("%s: to %s by %s, want %s by %s", test.name, swap.To, swap.Deadline, want.To, want.Deadline)
+		}
+		if len(swap.Path) != len(want.Path) || len(swap.Hops) != len(want.Hops) {
+			t.Errorf("%s: %d tokens %d hops, want %d %d", test.name, len(swap.Path), len(swap.Hops), len(want.Path), len(want.Hops))
+			continue
+		}
+		for i, hop := range swap.Hops {
+			if hop.Pool != want.Hops[i].Pool || hop.TokenIn != want.Hops[i].TokenIn || hop.TokenOut != want.Hops[i].TokenOut {
+				t.Errorf("%s: hop %d is %+v, want %+v", test.name, i, hop, want.Hops[i])
+			}
+		}
+	}
+}
+
+func TestDecodeRouterSwapShortPath(t *testing.T) {
+	data := packRouterCall(t, "swapExactTokensForTokens", big.NewInt(300), big.NewInt(200), []common.Address{testCAKE}, testCAKE, big.NewInt(1))
+	_, err := decodeRouterSwap(pancakeRouterAddress, data, new(big.Int))
+	if err == nil || errors.Is(err, errNotSwap) {
+		t.Errorf("one token path: err = %v, want a path error", err)
+	}
+}
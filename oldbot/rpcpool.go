@@ -0,0 +1,462 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/rpc"
+	"golang.org/x/time/rate"
+)
+
+// rpcEndpoint is one node of the pool with its own rate limit and health.
+type rpcEndpoint struct {
+	url string
+	// label is url without path or query, for metrics
+	label   string
+	rpc     *rpc.Client
+	client  *ethclient.Client
+	limiter *rate.Limiter
+
+	mu       sync.Mutex
+	head     uint64
+	healthy  bool
+	failures int
+	lastErr  error
+}
+
+func (e *rpcEndpoint) record(err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err == nil {
+		e.failures = 0
+		return
+	}
+	e.failures++
+	e.lastErr = err
+}
+
+// RPCPool spreads calls over several nodes of one chain. Each call goes to
+// the healthiest endpoint that is within MaxLagBlocks of the best head, and
+// transient failures are retried with backoff on the next one. It satisfies
+// bind.ContractBackend and every backend interface the bot uses, so the
+// bindings fail over without knowing.
+type RPCPool struct {
+	endpoints []*rpcEndpoint
+	maxLag    uint64
+	retries   int
+	backoff   time.Duration
+	timeout   time.Duration
+}
+
+// DialRPCPool connects to the config's URL and fallbacks, HTTP or
+// websocket, and keeps checking their heads every HealthSeconds until ctx
+// is done. Endpoints that fail to dial are left out; it only fails when
+// none connect.
+func DialRPCPool(ctx context.Context, config RPCConfig) (*RPCPool, error) {
+	pool := &RPCPool{
+		maxLag:  config.MaxLagBlocks,
+		retries: config.Retries,
+		backoff: 250 * time.Millisecond,
+		timeout: time.Duration(config.TimeoutSeconds) * time.Second,
+	}
+	limit := rate.Inf
+	if config.RateLimit > 0 {
+		limit = rate.Limit(config.RateLimit)
+	}
+	burst := config.Burst
+	if burst < 1 {
+		burst = 1
+	}
+	var dialErr error
+	for _, url := range append([]string{config.URL}, config.Fallbacks...) {
+		client, err := rpc.DialContext(ctx, url)
+		if err != nil {
+			log.Warn("RPC endpoint unavailable", "url", url, "err", err)
+			dialErr = err
+			continue
+		}
+		pool.endpoints = append(pool.endpoints, &rpcEndpoint{
+			url:     url,
+			label:   endpointLabel(url),
+			rpc:     client,
+			client:  ethclient.NewClient(client),
+			limiter: rate.NewLimiter(limit, burst),
+			healthy: true,
+		})
+	}
+	if len(pool.endpoints) == 0 {
+		return nil, fmt.Errorf("rpc: no endpoint connected: %w", dialErr)
+	}
+	pool.checkHealth(ctx)
+	if config.HealthSeconds > 0 {
+		go pool.watch(ctx, time.Duration(config.HealthSeconds)*time.Second)
+	}
+	return pool, nil
+}
+
+func (p *RPCPool) watch(ctx context.Context, every time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.checkHealth(ctx)
+		}
+	}
+}
+
+// checkHealth reads every endpoint's head. Endpoints that fail or trail the
+// best head by more than maxLag blocks are skipped until they catch up.
+func (p *RPCPool) checkHealth(ctx context.Context) {
+	heads := make([]uint64, len(p.endpoints))
+	errs := make([]error, len(p.endpoints))
+	var wg sync.WaitGroup
+	for i, e := range p.endpoints {
+		wg.Add(1)
+		go func(i int, e *rpcEndpoint) {
+			defer wg.Done()
+			if errs[i] = e.limiter.Wait(ctx); errs[i] == nil {
+				callCtx, cancel := context.WithTimeout(ctx, p.timeout)
+				heads[i], errs[i] = e.client.BlockNumber(callCtx)
+				cancel()
+			}
+		}(i, e)
+	}
+	wg.Wait()
+
+	best := uint64(0)
+	for i := range heads {
+		if errs[i] == nil && heads[i] > best {
+			best = heads[i]
+		}
+	}
+	for i, e := range p.endpoints {
+		e.mu.Lock()
+		wasHealthy := e.healthy
+		if errs[i] != nil {
+			e.healthy = false
+			e.lastErr = errs[i]
+		} else {
+			e.head = heads[i]
+			e.healthy = best-heads[i] <= p.maxLag
+			e.failures = 0
+		}
+		healthy := 0.0
+		if e.healthy {
+			healthy = 1
+		}
+		metrics.Set(metricRPCHealthy, healthy, e.label)
+		metrics.Set(metricRPCHead, float64(e.head), e.label)
+		if wasHealthy != e.healthy {
+			if e.healthy {
+				log.Info("RPC endpoint back", "url", e.url, "head", e.head)
+			} else {
+				log.Warn("RPC endpoint unhealthy", "url", e.url, "head", e.head, "best", best, "err", e.lastErr)
+			}
+		}
+		e.mu.Unlock()
+	}
+}
+
+// ranked orders the endpoints to try: healthy ones first, then those with
+// fewer recent failures and higher heads. Unhealthy endpoints stay at the
+// end so a call still has somewhere to go when every node is lagging.
+func (p *RPCPool) ranked() []*rpcEndpoint {
+	type entry struct {
+		e        *rpcEndpoint
+		healthy  bool
+		failures int
+		head     uint64
+	}
+	entries := make([]entry, len(p.endpoints))
+	for i, e := range p.endpoints {
+		e.mu.Lock()
+		entries[i] = entry{e, e.healthy, e.failures, e.head}
+		e.mu.Unlock()
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].healthy != entries[j].healthy {
+			return entries[i].healthy
+		}
+		if entries[i].failures != entries[j].failures {
+			return entries[i].failures < entries[j].failures
+		}
+		return entries[i].head > entries[j].head
+	})
+	ranked := make([]*rpcEndpoint, len(entries))
+	for i := range entries {
+		ranked[i] = entries[i].e
+	}
+	return ranked
+}
+
+// do runs call against the best endpoint, moving on to the best one not
+// yet tried with doubling backoff for up to retries more attempts while
+// errors are transient. Each attempt gets its own timeout, so a hanging node costs one
+// attempt rather than the caller's whole deadline.
+func (p *RPCPool) do(ctx context.Context, call func(context.Context, *ethclient.Client) error) error {
+	var err error
+	backoff := p.backoff
+	tried := make(map[*rpcEndpoint]bool)
+	for attempt := 0; attempt <= p.retries; attempt++ {
+		ranked := p.ranked()
+		if len(tried) == len(ranked) {
+			tried = make(map[*rpcEndpoint]bool)
+		}
+		e := ranked[0]
+		for _, next := range ranked {
+			if !tried[next] {
+				e = next
+				break
+			}
+		}
+		tried[e] = true
+		if err = e.limiter.Wait(ctx); err != nil {
+			return err
+		}
+		callCtx, cancel := context.WithTimeout(ctx, p.timeout)
+		started := time.Now()
+		err = call(callCtx, e.client)
+		cancel()
+		metrics.Observe(metricRPCSeconds, time.Since(started), e.label)
+		if err != nil && !errors.Is(err, ethereum.NotFound) {
+			metrics.Add(metricRPCErrors, 1, e.label)
+		}
+		e.record(err)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err == nil || !retryable(err) {
+			return err
+		}
+		if attempt == p.retries {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+	return err
+}
+
+// retryable tells transport failures, overloaded nodes and nodes behind
+// the block a call reads, worth another endpoint, from answers another node
+// would give the same way, like reverts, unknown transactions and rejected
+// nonces.
+func retryable(err error) bool {
+	if errors.Is(err, ethereum.NotFound) {
+		return false
+	}
+	var httpErr rpc.HTTPError
+	if errors.As(err, &httpErr) {
+		return httpErr.StatusCode == 429 || httpErr.StatusCode >= 500
+	}
+	var rpcErr rpc.Error
+	if errors.As(err, &rpcErr) {
+		switch rpcErr.ErrorCode() {
+		case -32005, -32603:
+			// the usual "limit exceeded" and an internal error
+			return true
+		case -32000:
+			// a node behind the block asked for answers "header not found"
+			// where one that has it would not
+			message := strings.ToLower(rpcErr.Error())
+			return strings.Contains(message, "header not found") || strings.Contains(message, "unknown block")
+		}
+		return false
+	}
+	// Anything else, timeouts included, failed before a node answered
+	return true
+}
+
+// Close disconnects every endpoint.
+func (p *RPCPool) Close() {
+	for _, e := range p.endpoints {
+		e.rpc.Close()
+	}
+}
+
+func (p *RPCPool) ChainID(ctx context.Context) (id *big.Int, err error) {
+	err = p.do(ctx, func(ctx context.Context, c *ethclient.Client) error { id, err = c.ChainID(ctx); return err })
+	return id, err
+}
+
+func (p *RPCPool) BlockNumber(ctx context.Context) (number uint64, err error) {
+	err = p.do(ctx, func(ctx context.Context, c *ethclient.Client) error { number, err = c.BlockNumber(ctx); return err })
+	return number, err
+}
+
+func (p *RPCPool) HeaderByNumber(ctx context.Context, number *big.Int) (header *types.Header, err error) {
+	err = p.do(ctx, func(ctx context.Context, c *ethclient.Client) error {
+		header, err = c.HeaderByNumber(ctx, number)
+		return err
+	})
+	return header, err
+}
+
+func (p *RPCPool) HeaderByHash(ctx context.Context, hash common.Hash) (header *types.Header, err error) {
+	err = p.do(ctx, func(ctx context.Context, c *ethclient.Client) error {
+		header, err = c.HeaderByHash(ctx, hash)
+		return err
+	})
+	return header, err
+}
+
+func (p *RPCPool) BalanceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (balance *big.Int, err error) {
+	err = p.do(ctx, func(ctx context.Context, c *ethclient.Client) error {
+		balance, err = c.BalanceAt(ctx, account, blockNumber)
+		return err
+	})
+	return balance, err
+}
+
+func (p *RPCPool) NonceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (nonce uint64, err error) {
+	err = p.do(ctx, func(ctx context.Context, c *ethclient.Client) error {
+		nonce, err = c.NonceAt(ctx, account, blockNumber)
+		return err
+	})
+	return nonce, err
+}
+
+func (p *RPCPool) CodeAt(ctx context.Context, account common.Address, blockNumber *big.Int) (code []byte, err error) {
+	err = p.do(ctx, func(ctx context.Context, c *ethclient.Client) error {
+		code, err = c.CodeAt(ctx, account, blockNumber)
+		return err
+	})
+	return code, err
+}
+
+func (p *RPCPool) StorageAt(ctx context.Context, account common.Address, key common.Hash, blockNumber *big.Int) (value []byte, err error) {
+	err = p.do(ctx, func(ctx context.Context, c *ethclient.Client) error {
+		value, err = c.StorageAt(ctx, account, key, blockNumber)
+		return err
+	})
+	return value, err
+}
+
+func (p *RPCPool) CallContract(ctx context.Context, msg ethereum.CallMsg, blockNumber *big.Int) (out []byte, err error) {
+	err = p.do(ctx, func(ctx context.Context, c *ethclient.Client) error {
+		out, err = c.CallContract(ctx, msg, blockNumber)
+		return err
+	})
+	return out, err
+}
+
+func (p *RPCPool) PendingCallContract(ctx context.Context, msg ethereum.CallMsg) (out []byte, err error) {
+	err = p.do(ctx, func(ctx context.Context, c *ethclient.Client) error {
+		out, err = c.PendingCallContract(ctx, msg)
+		return err
+	})
+	return out, err
+}
+
+func (p *RPCPool) PendingCodeAt(ctx context.Context, account common.Address) (code []byte, err error) {
+	err = p.do(ctx, func(ctx context.Context, c *ethclient.Client) error {
+		code, err = c.PendingCodeAt(ctx, account)
+		return err
+	})
+	return code, err
+}
+
+func (p *RPCPool) PendingNonceAt(ctx context.Context, account common.Address) (nonce uint64, err error) {
+	err = p.do(ctx, func(ctx context.Context, c *ethclient.Client) error {
+		nonce, err = c.PendingNonceAt(ctx, account)
+		return err
+	})
+	return nonce, err
+}
+
+func (p *RPCPool) SuggestGasPrice(ctx context.Context) (price *big.Int, err error) {
+	err = p.do(ctx, func(ctx context.Context, c *ethclient.Client) error { price, err = c.SuggestGasPrice(ctx); return err })
+	return price, err
+}
+
+func (p *RPCPool) SuggestGasTipCap(ctx context.Context) (tip *big.Int, err error) {
+	err = p.do(ctx, func(ctx context.Context, c *ethclient.Client) error { tip, err = c.SuggestGasTipCap(ctx); return err })
+	return tip, err
+}
+
+func (p *RPCPool) EstimateGas(ctx context.Context, msg ethereum.CallMsg) (gas uint64, err error) {
+	err = p.do(ctx, func(ctx context.Context, c *ethclient.Client) error { gas, err = c.EstimateGas(ctx, msg); return err })
+	return gas, err
+}
+
+// SendTransaction fails over like any call. Sending a signed transaction
+// twice is harmless, but a node that already has it from an attempt that
+// timed out answers "already known", or "nonce too low" once it is mined.
+// Those count as sent when the node knows the transaction by its hash; a
+// different transaction taking the nonce is still an error.
+func (p *RPCPool) SendTransaction(ctx context.Context, tx *types.Transaction) error {
+	return p.do(ctx, func(ctx context.Context, c *ethclient.Client) error {
+		err := c.SendTransaction(ctx, tx)
+		if err == nil || !alreadySent(err) {
+			return err
+		}
+		if _, _, known := c.TransactionByHash(ctx, tx.Hash()); known != nil {
+			return err
+		}
+		return nil
+	})
+}
+
+// alreadySent tells the answers a node gives to a transaction it has seen.
+func alreadySent(err error) bool {
+	message := strings.ToLower(err.Error())
+	for _, known := range []string{"already known", "known transaction", "nonce too low"} {
+		if strings.Contains(message, known) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *RPCPool) TransactionByHash(ctx context.Context, hash common.Hash) (tx *types.Transaction, pending bool, err error) {
+	err = p.do(ctx, func(ctx context.Context, c *ethclient.Client) error {
+		tx, pending, err = c.TransactionByHash(ctx, hash)
+		return err
+	})
+	return tx, pending, err
+}
+
+func (p *RPCPool) TransactionReceipt(ctx context.Context, hash common.Hash) (receipt *types.Receipt, err error) {
+	err = p.do(ctx, func(ctx context.Context, c *ethclient.Client) error {
+		receipt, err = c.TransactionReceipt(ctx, hash)
+		return err
+	})
+	return receipt, err
+}
+
+func (p *RPCPool) FilterLogs(ctx context.Context, query ethereum.FilterQuery) (logs []types.Log, err error) {
+	err = p.do(ctx, func(ctx context.Context, c *ethclient.Client) error { logs, err = c.FilterLogs(ctx, query); return err })
+	return logs, err
+}
+
+// SubscribeFilterLogs subscribes on the first endpoint that supports
+// subscriptions. A dropped subscription reports on its error channel and
+// is not moved to another endpoint.
+func (p *RPCPool) SubscribeFilterLogs(ctx context.Context, query ethereum.FilterQuery, ch chan<- types.Log) (ethereum.Subscription, error) {
+	var err error = rpc.ErrNotificationsUnsupported
+	for _, e := range p.ranked() {
+		var sub ethereum.Subscription
+		if sub, err = e.client.SubscribeFilterLogs(ctx, query, ch); err == nil {
+			return sub, nil
+		}
+	}
+	return nil, err
+}
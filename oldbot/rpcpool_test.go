@@ -0,0 +1,308 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// fakeNode answers eth_sendRawTransaction with sendErr, or success when it
+// is empty, and knows tx by hash when known is set.
+func fakeNode(t *testing.T, tx *types.Transaction, sendErr string, known bool) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			ID     json.RawMessage `json:"id"`
+			Method string          `json:"method"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Error(err)
+			return
+		}
+		resp := map[string]interface{}{"jsonrpc": "2.0", "id": req.ID}
+		switch req.Method {
+		case "eth_blockNumber":
+			resp["result"] = "0x1"
+		case "eth_sendRawTransaction":
+			if sendErr != "" {
+				resp["error"] = map[string]interface{}{"code": -32000, "message": sendErr}
+			} else {
+				resp["result"] = tx.Hash()
+			}
+		case "eth_getTransactionByHash":
+			if known {
+				resp["result"] = tx
+			} else {
+				resp["result"] = nil
+			}
+		default:
+			resp["error"] = map[string]interface{}{"code": -32601, "message": "no method " + req.Method}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	}))
+}
+
+func TestSendTransactionAlreadySent(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	tx, err := types.SignTx(types.NewTransaction(7, crypto.PubkeyToAddress(key.PublicKey), big.NewInt(1), 21000, big.NewInt(1), nil),
+		types.NewEIP155Signer(big.NewInt(56)), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		sendErr string
+		known   bool
+		ok      bool
+	}{
+		{"accepted", "", false, true},
+		{"already known", "already known", true, true},
+		{"known transaction", "known transaction: " + tx.Hash().Hex()[2:], true, true},
+		{"mined", "nonce too low", true, true},
+		{"nonce taken by another", "nonce too low", false, false},
+		{"rejected", "insufficient funds for gas * price + value", true, false},
+	}
+	for _, test := range tests {
+		node := fakeNode(t, tx, test.sendErr, test.known)
+		pool, err := DialRPCPool(context.Background(), RPCConfig{URL: node.URL, TimeoutSeconds: 5})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = pool.SendTransaction(context.Background(), tx)
+		if test.ok && err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		}
+		if !test.ok && err == nil {
+			t.Errorf("%s: sent", test.name)
+		}
+		pool.Close()
+		node.Close()
+	}
+}
+
+// fakeEndpoint is a node at head that fails calls to failMethod with an
+// HTTP status, or with a JSON-RPC error when status is 0, and counts the
+// calls it is sent by method.
+type fakeEndpoint struct {
+	*httptest.Server
+
+	mu         sync.Mutex
+	head       uint64
+	failMethod string
+	status     int
+	code       int
+	message    string
+	calls      map[string]int
+}
+
+func newFakeEndpoint(t *testing.T, head uint64) *fakeEndpoint {
+	f := &fakeEndpoint{head: head, calls: make(map[string]int)}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			ID     json.RawMessage `json:"id"`
+			Method string          `json:"method"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Error(err)
+			return
+		}
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		f.calls[req.Method]++
+		resp := map[string]interface{}{"jsonrpc": "2.0", "id": req.ID}
+		switch {
+		case req.Method == f.failMethod && f.status != 0:
+			http.Error(w, http.StatusText(f.status), f.status)
+			return
+		case req.Method == f.failMethod:
+			resp["error"] = map[string]interface{}{"code": f.code, "message": f.message}
+		case req.Method == "eth_blockNumber":
+			resp["result"] = hexutil.Uint64(f.head)
+		case req.Method == "eth_chainId":
+			resp["result"] = "0x38"
+		default:
+			resp["error"] = map[string]interface{}{"code": -32601, "message": "no method " + req.Method}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	}))
+	return f
+}
+
+func (f *fakeEndpoint) set(head uint64, failMethod string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.head, f.failMethod = head, failMethod
+}
+
+func (f *fakeEndpoint) called(method string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls[method]
+}
+
+// dialFakeEndpoints connects a pool to the endpoints, the first as URL.
+func dialFakeEndpoints(t *testing.T, config RPCConfig, endpoints ...*fakeEndpoint) *RPCPool {
+	config.URL = endpoints[0].URL
+	for _, f := range endpoints[1:] {
+		config.Fallbacks = append(config.Fallbacks, f.URL)
+	}
+	if config.TimeoutSeconds == 0 {
+		config.TimeoutSeconds = 5
+	}
+	pool, err := DialRPCPool(context.Background(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool.backoff = time.Millisecond
+	return pool
+}
+
+func TestRPCPoolFailover(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		code    int
+		message string
+		// retried is whether the call moves on to the second endpoint
+		retried bool
+	}{
+		{name: "overloaded", status: http.StatusServiceUnavailable, retried: true},
+		{name: "rate limited", status: http.StatusTooManyRequests, retried: true},
+		{name: "limit exceeded", code: -32005, message: "limit exceeded", retried: true},
+		{name: "lagging", code: -32000, message: "header not found", retried: true},
+		{name: "reverted", code: 3, message: "execution reverted"},
+		{name: "bad request", status: http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		first, second := newFakeEndpoint(t, 100), newFakeEndpoint(t, 100)
+		first.failMethod, first.status, first.code, first.message = "eth_chainId", test.status, test.code, test.message
+		pool := dialFakeEndpoints(t, RPCConfig{Retries: 2}, first, second)
+
+		id, err := pool.ChainID(context.Background())
+		if test.retried && (err != nil || id.Int64() != 56) {
+			t.Errorf("%s: chain id %v, %v, want 56 from the second endpoint", test.name, id, err)
+		}
+		if !test.retried && err == nil {
+			t.Errorf("%s: chain id %v, want the first endpoint's error", test.name, id)
+		}
+		if first.called("eth_chainId") != 1 {
+			t.Errorf("%s: first endpoint called %d times, want once", test.name, first.called("eth_chainId"))
+		}
+		if retried := second.called("eth_chainId") > 0; retried != test.retried {
+			t.Errorf("%s: second endpoint called %v, want %v", test.name, retried, test.retried)
+		}
+		pool.Close()
+		first.Close()
+		second.Close()
+	}
+}
+
+func TestRPCPoolHealth(t *testing.T) {
+	best, behind, lagging := newFakeEndpoint(t, 100), newFakeEndpoint(t, 98), newFakeEndpoint(t, 90)
+	defer best.Close()
+	defer behind.Close()
+	defer lagging.Close()
+	// lagging is listed first: ranking, not order, picks the endpoint
+	pool := dialFakeEndpoints(t, RPCConfig{MaxLagBlocks: 5}, lagging, behind, best)
+	defer pool.Close()
+
+	// check wants the endpoints ranked in order and only the first healthy
+	// of them healthy
+	check := func(step string, healthy int, order ...*fakeEndpoint) {
+		ranked := pool.ranked()
+		for i, want := range order {
+			e := ranked[i]
+			if e.url != want.URL {
+				t.Errorf("%s: endpoint %d is %s, want %s", step, i, e.url, want.URL)
+			}
+			if e.healthy != (i < healthy) {
+				t.Errorf("%s: endpoint %d healthy %v, want %v", step, i, e.healthy, i < healthy)
+			}
+		}
+	}
+	// more than 5 blocks behind is demoted, highest head first
+	check("dialed", 2, best, behind, lagging)
+
+	// recent failures rank a healthy endpoint below one at a lower head
+	pool.endpoints[2].record(errors.New("connection reset"))
+	check("failed a call", 2, behind, best, lagging)
+
+	// a head read that fails marks the endpoint unhealthy until it answers,
+	// and its failures keep it below the lagging one
+	best.set(101, "eth_blockNumber")
+	pool.checkHealth(context.Background())
+	check("failing", 1, behind, lagging, best)
+
+	best.set(101, "")
+	lagging.set(97, "")
+	pool.checkHealth(context.Background())
+	check("caught up", 3, best, behind, lagging)
+}
+
+func TestRPCPoolRateLimit(t *testing.T) {
+	node := newFakeEndpoint(t, 100)
+	defer node.Close()
+	// the health check at dial takes the only token, and the next arrives
+	// after a second
+	pool := dialFakeEndpoints(t, RPCConfig{RateLimit: 1, Burst: 1}, node)
+	defer pool.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := pool.BlockNumber(ctx); err == nil {
+		t.Errorf("call over the rate limit succeeded")
+	}
+	if calls := node.called("eth_blockNumber"); calls != 1 {
+		t.Errorf("node saw %d head reads, want only the health check's", calls)
+	}
+}
+
+// testRPCError is a JSON-RPC error answer.
+type testRPCError struct {
+	code    int
+	message string
+}
+
+func (e testRPCError) Error() string  { return e.message }
+func (e testRPCError) ErrorCode() int { return e.code }
+
+func TestRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"not found", ethereum.NotFound, false},
+		{"too many requests", rpc.HTTPError{StatusCode: 429}, true},
+		{"bad gateway", rpc.HTTPError{StatusCode: 502}, true},
+		{"bad request", rpc.HTTPError{StatusCode: 400}, false},
+		{"limit exceeded", testRPCError{-32005, "limit exceeded"}, true},
+		{"internal error", testRPCError{-32603, "internal error"}, true},
+		{"header not found", testRPCError{-32000, "header not found"}, true},
+		{"unknown block", testRPCError{-32000, "Unknown block"}, true},
+		{"nonce too low", testRPCError{-32000, "nonce too low"}, false},
+		{"reverted", testRPCError{3, "execution reverted"}, false},
+		{"wrapped", fmt.Errorf("reserves: %w", testRPCError{-32005, "limit exceeded"}), true},
+		{"timeout", context.DeadlineExceeded, true},
+		{"connection refused", errors.New("dial tcp: connection refused"), true},
+	}
+	for _, test := range tests {
+		if got := retryable(test.err); got != test.want {
+			t.Errorf("%s: retryable %v, want %v", test.name, got, test.want)
+		}
+	}
+}
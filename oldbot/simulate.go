@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+
+	"example.com/m/arbExecutor"
+	ethereum "github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// simulationBackend is the part of a node simulations run against. Both
+// ethclient.Client and the simulated backend satisfy it.
+type simulationBackend interface {
+	bind.ContractCaller
+	bind.PendingContractCaller
+	EstimateGas(ctx context.Context, call ethereum.CallMsg) (uint64, error)
+}
+
+// Simulation is the result of dry-running an opportunity with eth_call.
+type Simulation struct {
+	profit *big.Int
+	gas    uint64
+}
+
+// expectedProfit is what the executor should report for the opportunity in
+// the given mode. A flash loop pays back the first pool rather than spending
+// amountIn, so the difference is added back.
+func expectedProfit(opp Opportunity, mode executionMode) *big.Int {
+	expected := new(big.Int).Set(&opp.profit)
+	if mode == modeFlash {
+		_, repay := flashAmounts(opp.pairs, &opp.amountIn)
+		expected.Add(expected, &opp.amountIn)
+		expected.Sub(expected, repay)
+	}
+	return expected
+}
+
+// simulateOpportunity runs calldata against the executor at the latest or
+// pending block and checks that the reported profit is within toleranceBps
+// (out of 10000) of expected. Any error is the reason to drop the trade.
+func simulateOpportunity(ctx context.Context, backend simulationBackend, from, executor common.Address, calldata []byte, mode executionMode, expected *big.Int, toleranceBps int64, pending bool) (*Simulation, error) {
+	msg := ethereum.CallMsg{From: from, To: &executor, Data: calldata}
+
+	var output []byte
+	var err error
+	if pending {
+		output, err = backend.PendingCallContract(ctx, msg)
+	} else {
+		output, err = backend.CallContract(ctx, msg, nil)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("simulation reverted: %w", err)
+	}
+
+	parsed, err := arbExecutor.ArbExecutorMetaData.GetAbi()
+	if err != nil {
+		return nil, err
+	}
+	method := "execute"
+	if mode == modeFlash {
+		method = "executeFlash"
+	}
+	results, err := parsed.Unpack(method, output)
+	if err != nil {
+		return nil, fmt.Errorf("simulation returned undecodable output: %w", err)
+	}
+	profit := results[0].(*big.Int)
+
+	floor := new(big.Int).Mul(expected, big.NewInt(10000-toleranceBps))
+	floor.Div(floor, big.NewInt(10000))
+	if profit.Cmp(floor) < 0 {
+		return nil, fmt.Errorf("simulated profit %v is below %v (expected %v, tolerance %d bps)", profit, floor, expected, toleranceBps)
+	}
+
+	gas, err := backend.EstimateGas(ctx, msg)
+	if err != nil {
+		return nil, fmt.Errorf("gas estimation failed: %w", err)
+	}
+	return &Simulation{profit: profit, gas: gas}, nil
+}
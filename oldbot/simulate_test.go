@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+
+	"example.com/m/arbExecutor"
+	ethereum "github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestExpectedProfit(t *testing.T) {
+	pairs := []Pair{testPair(1, 2, 1000, 2000, 25), testPair(2, 1, 1000, 1000, 25)}
+	amountIn := big.NewInt(20)
+	_, repay := flashAmounts(pairs, amountIn)
+	tests := []struct {
+		name   string
+		mode   executionMode
+		profit int64
+		want   *big.Int
+	}{
+		{"capital", modeCapital, 7, big.NewInt(7)},
+		// the loop's output still has to cover the repayment instead of amountIn
+		{"flash", modeFlash, 7, new(big.Int).Sub(big.NewInt(7+20), repay)},
+		{"flash at a loss", modeFlash, -3, new(big.Int).Sub(big.NewInt(-3+20), repay)},
+	}
+	for _, test := range tests {
+		opp := Opportunity{pairs: pairs}
+		opp.amountIn.Set(amountIn)
+		opp.profit.SetInt64(test.profit)
+		if got := expectedProfit(opp, test.mode); got.Cmp(test.want) != 0 {
+			t.Errorf("%s: expected profit %s, want %s", test.name, got, test.want)
+		}
+	}
+	// borrowing what amountIn would have bought never costs more than it
+	if repay.Cmp(amountIn) > 0 {
+		t.Errorf("repaying %s for a %s input", repay, amountIn)
+	}
+}
+
+// fixedSimulation answers every call with profit, or err.
+type fixedSimulation struct {
+	profit  *big.Int
+	err     error
+	pending bool
+}
+
+func (s *fixedSimulation) output() ([]byte, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	return common.LeftPadBytes(s.profit.Bytes(), 32), nil
+}
+
+func (s *fixedSimulation) CodeAt(ctx context.Context, contract common.Address, blockNumber *big.Int) ([]byte, error) {
+	return []byte{1}, nil
+}
+
+func (s *fixedSimulation) CallContract(ctx context.Context, call ethereum.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	s.pending = false
+	return s.output()
+}
+
+func (s *fixedSimulation) PendingCodeAt(ctx context.Context, contract common.Address) ([]byte, error) {
+	return []byte{1}, nil
+}
+
+func (s *fixedSimulation) PendingCallContract(ctx context.Context, call ethereum.CallMsg) ([]byte, error) {
+	s.pending = true
+	return s.output()
+}
+
+func (s *fixedSimulation) EstimateGas(ctx context.Context, call ethereum.CallMsg) (uint64, error) {
+	return 210000, nil
+}
+
+func TestSimulateOpportunityTolerance(t *testing.T) {
+	executor := common.HexToAddress("0x4000000000000000000000000000000000000004")
+	tests := []struct {
+		name      string
+		profit    int64
+		err       error
+		expected  int64
+		tolerance int64
+		pending   bool
+		want      string
+	}{
+		{"as expected", 10000, nil, 10000, 100, false, ""},
+		{"more than expected", 12000, nil, 10000, 0, false, ""},
+		{"at the floor", 9900, nil, 10000, 100, false, ""},
+		{"below the floor", 9899, nil, 10000, 100, false, "below 9900"},
+		{"no tolerance", 9999, nil, 10000, 0, false, "below 10000"},
+		{"pending block", 10000, nil, 10000, 100, true, ""},
+		{"reverted", 0, errors.New("execution reverted: ArbExecutor: slippage"), 10000, 100, false, "simulation reverted"},
+	}
+	for _, test := range tests {
+		backend := &fixedSimulation{profit: big.NewInt(test.profit), err: test.err}
+		sim, err := simulateOpportunity(context.Background(), backend, common.Address{}, executor, nil, modeCapital, big.NewInt(test.expected), test.tolerance, test.pending)
+		if test.want != "" {
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("%s: %v, want an error with %q", test.name, err, test.want)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if sim.profit.Int64() != test.profit || sim.gas != 210000 {
+			t.Errorf("%s: simulated %s for %d gas, want %d for 210000", test.name, sim.profit, sim.gas, test.profit)
+		}
+		if backend.pending != test.pending {
+			t.Errorf("%s: simulated at the pending block %v, want %v", test.name, backend.pending, test.pending)
+		}
+	}
+}
+
+func TestSimulateOpportunityOnChain(t *testing.T) {
+	unit := new(big.Int).Exp(big.NewInt(10), big.NewInt(18), nil)
+	ether := func(n int64) *big.Int { return new(big.Int).Mul(big.NewInt(n), unit) }
+	chain := newMockChain(t)
+	a, b := chain.token("A", 0), chain.token("B", 0)
+	first := chain.pair(a, b, ether(1000), ether(2000), 0)
+	second := chain.pair(a, b, ether(1000), ether(1000), 0)
+	executor, _, _, err := arbExecutor.DeployArbExecutor(chain.auth(), chain.backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain.backend.Commit()
+
+	opp := Opportunity{pairs: []Pair{
+		{from: a, to: b, factory: first, fee: 25, r_from: *ether(1000), r_to: *ether(2000)},
+		{from: b, to: a, factory: second, fee: 25, r_from: *ether(1000), r_to: *ether(1000)},
+	}}
+	opp.amountIn.Set(ether(20))
+	amounts := hopAmounts(opp.pairs, &opp.amountIn)
+	opp.profit.Sub(amounts[1], &opp.amountIn)
+	expected := expectedProfit(opp, modeFlash)
+	calldata, err := opportunityCalldata(opp, modeFlash, 50)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, pending := range []bool{false, true} {
+		sim, err := simulateOpportunity(context.Background(), chain.backend, chain.owner, executor, calldata, modeFlash, expected, 0, pending)
+		if err != nil {
+			t.Fatalf("pending %v: %v", pending, err)
+		}
+		if sim.profit.Cmp(expected) != 0 || sim.gas == 0 {
+			t.Errorf("pending %v: simulated %s for %d gas, want %s", pending, sim.profit, sim.gas, expected)
+		}
+	}
+
+	// Someone else's swap moved the second pool first
+	chain.transact("MockToken", b, "transfer", second, ether(200))
+	chain.transact("MockPair", second, "sync")
+	if _, err := simulateOpportunity(context.Background(), chain.backend, chain.owner, executor, calldata, modeFlash, expected, 100, false); err == nil {
+		t.Errorf("simulation passed after the second pool moved against the loop")
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"math/big"
+)
+
+// hopAmounts is what each pool of a loop pays out when the loop is entered
+// with amountIn, at the reserves the loop was found with, after the
+// transfer tax of every token sent in.
+func hopAmounts(pairs []Pair, amountIn *big.Int) []*big.Int {
+	amounts := make([]*big.Int, len(pairs))
+	amount := amountIn
+	for i, pair := range pairs {
+		amount = getAmountOut(amount, &pair.r_from, &pair.r_to, pair.inputFee())
+		amounts[i] = amount
+	}
+	return amounts
+}
+
+// minAmountsOut lowers each expected output by slippageBps out of 10000.
+// These are the per-hop floors the executor reverts below.
+func minAmountsOut(amounts []*big.Int, slippageBps int64) []*big.Int {
+	minOuts := make([]*big.Int, len(amounts))
+	for i, amount := range amounts {
+		minOut := new(big.Int).Mul(amount, big.NewInt(10000-slippageBps))
+		minOuts[i] = minOut.Div(minOut, big.NewInt(10000))
+	}
+	return minOuts
+}
+
+// minProfitFor is the end-of-loop floor: whatever the last hop's minimum
+// output leaves over cost, or zero if slippage would eat the whole profit.
+// The executor still refuses to finish a loop at a loss.
+func minProfitFor(minOuts []*big.Int, cost *big.Int) *big.Int {
+	minProfit := new(big.Int).Sub(minOuts[len(minOuts)-1], cost)
+	if minProfit.Sign() < 0 {
+		return new(big.Int)
+	}
+	return minProfit
+}
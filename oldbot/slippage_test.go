@@ -0,0 +1,64 @@
+package main
+
+import (
+	"math/big"
+	"testing"
+)
+
+func TestMinAmountsOut(t *testing.T) {
+	tests := []struct {
+		name     string
+		amounts  []int64
+		slippage int64
+		want     []int64
+	}{
+		{"no slippage", []int64{1000, 2500}, 0, []int64{1000, 2500}},
+		{"half a percent", []int64{1000, 2500}, 50, []int64{995, 2487}},
+		// floors round down so the executor never demands more than expected
+		{"rounds down", []int64{999, 1}, 1, []int64{998, 0}},
+		{"everything", []int64{1000}, 10000, []int64{0}},
+		{"no hops", []int64{}, 50, []int64{}},
+	}
+	for _, test := range tests {
+		amounts := make([]*big.Int, len(test.amounts))
+		for i, amount := range test.amounts {
+			amounts[i] = big.NewInt(amount)
+		}
+		got := minAmountsOut(amounts, test.slippage)
+		if len(got) != len(test.want) {
+			t.Errorf("%s: %d floors, want %d", test.name, len(got), len(test.want))
+			continue
+		}
+		for i := range got {
+			if got[i].Int64() != test.want[i] {
+				t.Errorf("%s: hop %d floor is %s, want %d", test.name, i, got[i], test.want[i])
+			}
+			if amounts[i].Int64() != test.amounts[i] {
+				t.Errorf("%s: hop %d amount changed to %s", test.name, i, amounts[i])
+			}
+		}
+	}
+}
+
+func TestMinProfitFor(t *testing.T) {
+	tests := []struct {
+		name    string
+		minOuts []int64
+		cost    int64
+		want    int64
+	}{
+		{"profit left", []int64{500, 1100}, 1000, 100},
+		{"break even", []int64{500, 1000}, 1000, 0},
+		// slippage ate the profit; the executor still refuses a loss
+		{"below cost", []int64{500, 990}, 1000, 0},
+	}
+	for _, test := range tests {
+		minOuts := make([]*big.Int, len(test.minOuts))
+		for i, amount := range test.minOuts {
+			minOuts[i] = big.NewInt(amount)
+		}
+		if got := minProfitFor(minOuts, big.NewInt(test.cost)); got.Int64() != test.want {
+			t.Errorf("%s: min profit %s, want %d", test.name, got, test.want)
+		}
+	}
+}
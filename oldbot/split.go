@@ -0,0 +1,185 @@
+package main
+
+import (
+	"math/big"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// splitPrec is the precision of the split math, well past the 112 bits of
+// a pair's reserves.
+const splitPrec = 256
+
+// splitParallel divides amount among parallel pools, given by their
+// reserves in and out and their fees, so that together they pay the most.
+// The pools are traded down to one marginal price: a pool only takes part
+// once the ones priced better have been traded down to its spot price, and
+// then gets
+//
+//	x_i = (sqrt(r_i*in_i*out_i)*m - in_i) / r_i,  m = (amount + sum in/r) / sum sqrt(r*in*out)/r
+//
+// with r_i what pool i's fee leaves and the sums over the pools taking part.
+// The best priced pool takes what rounding leaves.
+func splitParallel(reservesIn, reservesOut []*big.Int, fees []int64, amount *big.Int) []*big.Int {
+	amounts := make([]*big.Int, len(reservesIn))
+	for i := range amounts {
+		amounts[i] = new(big.Int)
+	}
+	if len(amounts) == 1 {
+		amounts[0].Set(amount)
+		return amounts
+	}
+	newFloat := func() *big.Float { return new(big.Float).SetPrec(splitPrec) }
+
+	fee := make([]*big.Float, len(reservesIn))
+	in := make([]*big.Float, len(reservesIn))
+	root := make([]*big.Float, len(reservesIn))
+	// threshold is the m past which a pool takes part
+	threshold := make([]*big.Float, len(reservesIn))
+	order := make([]int, 0, len(reservesIn))
+	for i := range reservesIn {
+		if reservesIn[i].Sign() <= 0 || reservesOut[i].Sign() <= 0 {
+			continue
+		}
+		fee[i] = newFloat().Quo(big.NewFloat(float64(feeDenom-fees[i])), big.NewFloat(feeDenom))
+		in[i] = newFloat().SetInt(reservesIn[i])
+		root[i] = newFloat().Mul(fee[i], in[i])
+		root[i].Sqrt(root[i].Mul(root[i], newFloat().SetInt(reservesOut[i])))
+		threshold[i] = newFloat().Quo(in[i], root[i])
+		order = append(order, i)
+	}
+	if len(order) == 0 {
+		if len(amounts) > 0 {
+			amounts[0].Set(amount)
+		}
+		return amounts
+	}
+	// Best spot price first
+	sort.SliceStable(order, func(a, b int) bool {
+		return threshold[order[a]].Cmp(threshold[order[b]]) < 0
+	})
+
+	whole := newFloat().SetInt(amount)
+	sumIn, sumRoot := newFloat(), newFloat()
+	m := newFloat()
+	taking := 0
+	for taking < len(order) {
+		i := order[taking]
+		sumIn.Add(sumIn, newFloat().Quo(in[i], fee[i]))
+		sumRoot.Add(sumRoot, newFloat().Quo(root[i], fee[i]))
+		taking++
+		m.Quo(newFloat().Add(whole, sumIn), sumRoot)
+		if taking == len(order) || m.Cmp(threshold[order[taking]]) <= 0 {
+			break
+		}
+	}
+
+	left := new(big.Int).Set(amount)
+	for _, i := range order[1:taking] {
+		share := newFloat().Mul(root[i], m)
+		share.Quo(share.Sub(share, in[i]), fee[i])
+		amounts[i], _ = share.Int(nil)
+		if amounts[i].Sign() < 0 {
+			amounts[i].SetInt64(0)
+		}
+		if amounts[i].Cmp(left) > 0 {
+			amounts[i].Set(left)
+		}
+		left.Sub(left, amounts[i])
+	}
+	amounts[order[0]] = left
+	return amounts
+}
+
+// hopPools are all the pools from one node to the next, the edge's pair
+// first. The caller holds mu.
+func (g *Graph) hopPools(from, to int) []Pair {
+	best := g.nodes[from].edgePair[to]
+	pools := []Pair{best}
+	for _, pair := range g.nodes[from].edgePools[to] {
+		if pair.factory != best.factory {
+			pools = append(pools, pair)
+		}
+	}
+	return pools
+}
+
+// parallelPools are the pools a loop can trade its hop from one node to
+// the next through, the loop's own pair first. Pools the loop trades on
+// another hop are left out, and without splitPools there are no others.
+// The caller holds mu.
+func (g *Graph) parallelPools(from, to int, loop []Pair) []Pair {
+	pools := g.hopPools(from, to)
+	if !g.splitPools {
+		return pools[:1]
+	}
+	used := make(map[common.Address]bool, len(loop))
+	for _, pair := range loop {
+		used[pair.factory] = true
+	}
+	hop := []Pair{pools[0]}
+	for _, pair := range pools[1:] {
+		if !used[pair.factory] {
+			hop = append(hop, pair)
+		}
+	}
+	return hop
+}
+
+// LoopSplit is a loop sized with each hop's input split across the pools
+// parallel to it, reported next to the single pool sizing that is traded.
+type LoopSplit struct {
+	AmountIn *big.Int
+	Profit   *big.Int
+	Hops     []RouteHop
+}
+
+// splitVolume sizes a loop whose hops split their input across parallel
+// pools. The loop's profit is concave in its input, so it is searched
+// from seed, the volume without splitting, out to where the profit falls
+// and then narrowed down. The split is nil when no hop uses more than one
+// pool at that volume.
+func splitVolume(hops [][]Pair, seed *big.Int) (big.Int, big.Int, []RouteHop) {
+	profit := func(amount *big.Int) *big.Int {
+		out, _ := routeReserves{}.swap(hops, amount, true)
+		return out.Sub(out, amount)
+	}
+	lo, hi := new(big.Int), new(big.Int).Set(seed)
+	if hi.Sign() <= 0 {
+		hi.SetInt64(1)
+	}
+	for i := 0; i < 64 && profit(new(big.Int).Lsh(hi, 1)).Cmp(profit(hi)) > 0; i++ {
+		hi.Lsh(hi, 1)
+	}
+	hi.Lsh(hi, 1)
+	two := big.NewInt(2)
+	for new(big.Int).Sub(hi, lo).Cmp(two) > 0 {
+		third := new(big.Int).Sub(hi, lo)
+		third.Div(third, big.NewInt(3))
+		m1 := new(big.Int).Add(lo, third)
+		m2 := new(big.Int).Sub(hi, third)
+		if profit(m1).Cmp(profit(m2)) < 0 {
+			lo = m1
+		} else {
+			hi = m2
+		}
+	}
+	best, bestProfit := new(big.Int).Set(lo), profit(lo)
+	for x := new(big.Int).Add(lo, big.NewInt(1)); x.Cmp(hi) <= 0; x.Add(x, big.NewInt(1)) {
+		if p := profit(x); p.Cmp(bestProfit) > 0 {
+			best.Set(x)
+			bestProfit = p
+		}
+	}
+	if bestProfit.Sign() <= 0 {
+		return *big.NewInt(0), *big.NewInt(0), nil
+	}
+	_, traded := routeReserves{}.swap(hops, best, true)
+	for _, hop := range traded {
+		if len(hop.Pools) > 1 {
+			return *best, *bestProfit, traded
+		}
+	}
+	return *best, *bestProfit, nil
+}
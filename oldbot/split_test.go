@@ -0,0 +1,112 @@
+package main
+
+import (
+	"math/big"
+	"testing"
+)
+
+func TestSplitParallel(t *testing.T) {
+	unit := new(big.Int).Exp(big.NewInt(10), big.NewInt(18), nil)
+	tokens := func(n int64) *big.Int { return new(big.Int).Mul(big.NewInt(n), unit) }
+	tests := []struct {
+		name        string
+		reservesIn  []int64
+		reservesOut []int64
+		fees        []int64
+		amount      int64
+		// want is each pool's share of amount in percent, rounded down
+		want []int64
+	}{
+		{"one pool", []int64{1000}, []int64{1000}, []int64{25}, 10, []int64{100}},
+		{"twin pools", []int64{1000, 1000}, []int64{1000, 1000}, []int64{25, 25}, 10, []int64{50, 50}},
+		{"deeper pool", []int64{1000, 3000}, []int64{1000, 3000}, []int64{25, 25}, 100, []int64{24, 75}},
+		// The second pool's spot price is below where the first one ends up
+		{"worse pool left out", []int64{1000, 1000}, []int64{1000, 900}, []int64{25, 25}, 10, []int64{100, 0}},
+		{"cheaper fee takes more", []int64{1000, 1000}, []int64{1000, 1000}, []int64{10, 30}, 10, []int64{54, 45}},
+		{"empty pool left out", []int64{0, 1000}, []int64{0, 1000}, []int64{25, 25}, 10, []int64{0, 100}},
+	}
+	for _, test := range tests {
+		reservesIn := make([]*big.Int, len(test.reservesIn))
+		reservesOut := make([]*big.Int, len(test.reservesIn))
+		for i := range reservesIn {
+			reservesIn[i], reservesOut[i] = tokens(test.reservesIn[i]), tokens(test.reservesOut[i])
+		}
+		amount := tokens(test.amount)
+		amounts := splitParallel(reservesIn, reservesOut, test.fees, amount)
+		total := new(big.Int)
+		for i, got := range amounts {
+			total.Add(total, got)
+			pct := new(big.Int).Div(new(big.Int).Mul(got, big.NewInt(100)), amount).Int64()
+			if pct != test.want[i] {
+				t.Errorf("%s: pool %d gets %d%%, want %d%%", test.name, i, pct, test.want[i])
+			}
+		}
+		if total.Cmp(amount) != 0 {
+			t.Errorf("%s: shares add up to %s, want %s", test.name, total, amount)
+		}
+
+		// Moving a little between two pools pays no more
+		out := func(amounts []*big.Int) *big.Int {
+			sum := new(big.Int)
+			for i, in := range amounts {
+				if in.Sign() > 0 {
+					sum.Add(sum, getAmountOut(in, reservesIn[i], reservesOut[i], test.fees[i]))
+				}
+			}
+			return sum
+		}
+		best := out(amounts)
+		for i := range amounts {
+			for j := range amounts {
+				step := new(big.Int).Div(amount, big.NewInt(100))
+				if i == j || amounts[i].Cmp(step) < 0 || reservesIn[j].Sign() == 0 {
+					continue
+				}
+				moved := make([]*big.Int, len(amounts))
+				for k := range amounts {
+					moved[k] = new(big.Int).Set(amounts[k])
+				}
+				moved[i].Sub(moved[i], step)
+				moved[j].Add(moved[j], step)
+				if out(moved).Cmp(best) > 0 {
+					t.Errorf("%s: moving 1%% from pool %d to %d pays more", test.name, i, j)
+				}
+			}
+		}
+	}
+}
+
+func TestOptimalVolumeKeepsPairSizing(t *testing.T) {
+	loop := []Pair{testPair(1, 2, 1000, 2000, 25), testPair(2, 3, 2000, 1000, 25), testPair(3, 1, 1000, 1100, 25)}
+	parallel := testPair(1, 2, 1000, 2000, 10)
+	parallel.factory = testToken(99)
+	wantIn, wantProfit := loopVolume(loop)
+
+	tests := []struct {
+		name  string
+		hops  [][]Pair
+		split bool
+	}{
+		{"one pool a hop", [][]Pair{{loop[0]}, {loop[1]}, {loop[2]}}, false},
+		{"parallel first hop", [][]Pair{{loop[0], parallel}, {loop[1]}, {loop[2]}}, true},
+	}
+	for _, test := range tests {
+		in, profit, split := optimalVolume(test.hops)
+		// What is traded is always the loop through its own pairs
+		if in.Cmp(&wantIn) != 0 || profit.Cmp(&wantProfit) != 0 {
+			t.Errorf("%s: sized %s for %s, want %s for %s", test.name, &in, &profit, &wantIn, &wantProfit)
+		}
+		if (split != nil) != test.split {
+			t.Fatalf("%s: split %v, want one %v", test.name, split, test.split)
+		}
+		if split == nil {
+			continue
+		}
+		if split.Profit.Cmp(&profit) <= 0 {
+			t.Errorf("%s: split profit %s is not above %s", test.name, split.Profit, &profit)
+		}
+		if len(split.Hops[0].Pools) != 2 {
+			t.Errorf("%s: first hop split over %d pools, want 2", test.name, len(split.Hops[0].Pools))
+		}
+	}
+}
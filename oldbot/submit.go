@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// Submitter gets a signed transaction to block producers.
+type Submitter interface {
+	Name() string
+	Submit(ctx context.Context, tx *types.Transaction) error
+}
+
+// mempoolSubmitter broadcasts to the public mempool through a node.
+type mempoolSubmitter struct {
+	backend interface {
+		SendTransaction(ctx context.Context, tx *types.Transaction) error
+	}
+}
+
+func (s *mempoolSubmitter) Name() string { return "mempool" }
+
+func (s *mempoolSubmitter) Submit(ctx context.Context, tx *types.Transaction) error {
+	return s.backend.SendTransaction(ctx, tx)
+}
+
+// windowedSubmitter is a Submitter whose transactions can only be mined up
+// to a block it picks when submitting, like bundles, and never after.
+type windowedSubmitter interface {
+	Submitter
+	// SubmitUntil submits tx and returns the last block it can be mined in.
+	SubmitUntil(ctx context.Context, tx *types.Transaction) (uint64, error)
+}
+
+// bundleArgs are the eth_sendBundle parameters. A transaction left out of
+// RevertingTxHashes makes the relay drop the whole bundle if it reverts, so
+// a failed loop never lands on chain and costs no gas.
+type bundleArgs struct {
+	Txs               []hexutil.Bytes `json:"txs"`
+	BlockNumber       hexutil.Uint64  `json:"blockNumber"`
+	RevertingTxHashes []common.Hash   `json:"revertingTxHashes"`
+}
+
+// headReader tells bundles which block comes next.
+type headReader interface {
+	BlockNumber(ctx context.Context) (uint64, error)
+}
+
+// bundleSubmitter sends transactions as single-transaction bundles to a
+// private relay, targeting each of the next `blocks` blocks.
+type bundleSubmitter struct {
+	relay  *rpc.Client
+	chain  headReader
+	blocks uint64
+}
+
+func newBundleSubmitter(url string, chain headReader, blocks uint64) (*bundleSubmitter, error) {
+	relay, err := rpc.DialHTTP(url)
+	if err != nil {
+		return nil, err
+	}
+	if blocks == 0 {
+		blocks = 1
+	}
+	return &bundleSubmitter{relay: relay, chain: chain, blocks: blocks}, nil
+}
+
+func (s *bundleSubmitter) Name() string { return "bundle" }
+
+func (s *bundleSubmitter) Submit(ctx context.Context, tx *types.Transaction) error {
+	_, err := s.SubmitUntil(ctx, tx)
+	return err
+}
+
+// SubmitUntil targets the bundle at each of the next blocks in turn. When
+// the relay rejects one, the bundle can still land in the blocks before it,
+// so it counts as sent until the last block accepted.
+func (s *bundleSubmitter) SubmitUntil(ctx context.Context, tx *types.Transaction) (uint64, error) {
+	raw, err := tx.MarshalBinary()
+	if err != nil {
+		return 0, err
+	}
+	head, err := s.chain.BlockNumber(ctx)
+	if err != nil {
+		return 0, err
+	}
+	for target := head + 1; target <= head+s.blocks; target++ {
+		args := bundleArgs{
+			Txs:               []hexutil.Bytes{raw},
+			BlockNumber:       hexutil.Uint64(target),
+			RevertingTxHashes: []common.Hash{},
+		}
+		var result interface{}
+		if err := s.relay.CallContext(ctx, &result, "eth_sendBundle", args); err != nil {
+			if target == head+1 {
+				return 0, fmt.Errorf("relay rejected bundle for block %d: %w", target, err)
+			}
+			log.Warn("Relay rejected bundle", "tx", tx.Hash(), "block", target, "err", err)
+			return target - 1, nil
+		}
+	}
+	return head + s.blocks, nil
+}
+
+// submissionPolicy picks a submitter for each opportunity. With "auto",
+// loops expected to make at least bundleMinProfit go through the relay and
+// smaller ones through the mempool.
+type submissionPolicy struct {
+	mode            string
+	mempool         Submitter
+	bundle          Submitter
+	bundleMinProfit *big.Int
+}
+
+func (p *submissionPolicy) choose(expected *big.Int) (Submitter, error) {
+	switch p.mode {
+	case "mempool":
+		return p.mempool, nil
+	case "bundle", "auto":
+		if p.bundle == nil {
+			return nil, fmt.Errorf("submission mode %q needs a relay", p.mode)
+		}
+		if p.mode == "auto" && expected.Cmp(p.bundleMinProfit) < 0 {
+			return p.mempool, nil
+		}
+		return p.bundle, nil
+	default:
+		return nil, fmt.Errorf("unknown submission mode %q", p.mode)
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math/big"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+type fixedHead uint64
+
+func (h fixedHead) BlockNumber(ctx context.Context) (uint64, error) {
+	return uint64(h), nil
+}
+
+func TestSubmissionPolicy(t *testing.T) {
+	mempool := &mempoolSubmitter{}
+	bundle := &bundleSubmitter{}
+	tests := []struct {
+		mode     string
+		bundle   Submitter
+		expected int64
+		want     Submitter
+		err      bool
+	}{
+		{"mempool", bundle, 100, mempool, false},
+		{"mempool", nil, 100, mempool, false},
+		{"bundle", bundle, 0, bundle, false},
+		{"bundle", nil, 100, nil, true},
+		{"auto", bundle, 99, mempool, false},
+		{"auto", bundle, 100, bundle, false},
+		{"auto", bundle, 101, bundle, false},
+		{"auto", nil, 100, nil, true},
+		{"private", bundle, 100, nil, true},
+	}
+	for _, test := range tests {
+		policy := &submissionPolicy{mode: test.mode, mempool: mempool, bundle: test.bundle, bundleMinProfit: big.NewInt(100)}
+		got, err := policy.choose(big.NewInt(test.expected))
+		if test.err {
+			if err == nil {
+				t.Errorf("%s, profit %d: chose %s, want an error", test.mode, test.expected, got.Name())
+			}
+			continue
+		}
+		if err != nil || got != test.want {
+			t.Errorf("%s, profit %d: chose %v (%v), want %s", test.mode, test.expected, got, err, test.want.Name())
+		}
+	}
+}
+
+func TestBundleSubmitterRequests(t *testing.T) {
+	relay, err := NewStandInRelay(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer relay.Close()
+	submitter, err := newBundleSubmitter(relay.URL(), fixedHead(100), 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, _ := crypto.GenerateKey()
+	tx, err := types.SignTx(types.NewTransaction(5, crypto.PubkeyToAddress(key.PublicKey), big.NewInt(0), 21000, big.NewInt(1), nil),
+		types.NewEIP155Signer(big.NewInt(56)), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := submitter.Submit(context.Background(), tx); err != nil {
+		t.Fatal(err)
+	}
+
+	raw, _ := tx.MarshalBinary()
+	bundles := relay.Bundles()
+	if len(bundles) != 3 {
+		t.Fatalf("%d bundles sent, want one for each of 3 blocks", len(bundles))
+	}
+	for i, bundle := range bundles {
+		if want := uint64(101 + i); uint64(bundle.BlockNumber) != want {
+			t.Errorf("bundle %d targets block %d, want %d", i, bundle.BlockNumber, want)
+		}
+		if len(bundle.Txs) != 1 || !bytes.Equal(bundle.Txs[0], raw) {
+			t.Errorf("bundle %d carries %d transactions, want only the signed one", i, len(bundle.Txs))
+		}
+		// a reverting loop must drop the bundle rather than land
+		if len(bundle.RevertingTxHashes) != 0 {
+			t.Errorf("bundle %d lets %v revert", i, bundle.RevertingTxHashes)
+		}
+	}
+}
+
+// limitedRelay accepts bundles for blocks before limit and rejects the rest,
+// the way relays refuse blocks too far ahead.
+type limitedRelay struct {
+	limit    uint64
+	accepted []uint64
+}
+
+func (r *limitedRelay) SendBundle(ctx context.Context, args bundleArgs) (common.Hash, error) {
+	if uint64(args.BlockNumber) >= r.limit {
+		return common.Hash{}, errors.New("block too far in the future")
+	}
+	r.accepted = append(r.accepted, uint64(args.BlockNumber))
+	return common.Hash{}, nil
+}
+
+func TestBundleSubmitterPartialAcceptance(t *testing.T) {
+	ctx := context.Background()
+	to := common.HexToAddress("0x7000000000000000000000000000000000000007")
+	price := big.NewInt(1000000000)
+	tests := []struct {
+		name  string
+		limit uint64
+		// until is the last block the bundle can land in, 0 for unsent
+		until    uint64
+		accepted int
+	}{
+		{"all accepted", 100, 3, 3},
+		{"second block rejected", 2, 1, 1},
+		{"first block rejected", 1, 0, 0},
+	}
+	for _, test := range tests {
+		m, backend, _, _ := newTestTxManager(t)
+		relay := &limitedRelay{limit: test.limit}
+		server := rpc.NewServer()
+		if err := server.RegisterName("eth", relay); err != nil {
+			t.Fatal(err)
+		}
+		endpoint := httptest.NewServer(server)
+		bundle, err := newBundleSubmitter(endpoint.URL, simHead{backend}, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		tracked, err := m.Send(ctx, bundle, to, nil, 21000, price, big.NewInt(1), to)
+		endpoint.Close()
+		if test.until == 0 {
+			if err == nil || m.nonce != 0 {
+				t.Errorf("%s: sent (%v) with next nonce %d, want an error and nonce 0 kept", test.name, err, m.nonce)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if tracked.Until != test.until || len(relay.accepted) != test.accepted || m.nonce != 1 {
+			t.Errorf("%s: until block %d with %d bundles accepted, next nonce %d, want until %d with %d, next 1",
+				test.name, tracked.Until, len(relay.accepted), m.nonce, test.until, test.accepted)
+		}
+	}
+}
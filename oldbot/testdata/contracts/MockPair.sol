@@ -0,0 +1,106 @@
+// SPDX-License-Identifier: MIT
+pragma solidity 0.8.21;
+
+interface IToken {
+    function balanceOf(address owner) external view returns (uint256);
+
+    function transfer(address to, uint256 value) external returns (bool);
+}
+
+/// @notice Uniswap V2 style pair for tests: swap pays out first, calls back
+/// when data is given, then checks the constant product net of feeBps. Which
+/// callback it makes picks the DEX it stands in for.
+contract MockPair {
+    address public immutable token0;
+    address public immutable token1;
+    uint256 public immutable feeBps;
+    // 0 pancakeCall, 1 BiswapCall, 2 uniswapV2Call
+    uint8 public immutable callback;
+
+    uint112 private reserve0;
+    uint112 private reserve1;
+
+    constructor(
+        address token0_,
+        address token1_,
+        uint256 feeBps_,
+        uint8 callback_
+    ) {
+        token0 = token0_;
+        token1 = token1_;
+        feeBps = feeBps_;
+        callback = callback_;
+    }
+
+    function getReserves()
+        external
+        view
+        returns (
+            uint112,
+            uint112,
+            uint32
+        )
+    {
+        return (reserve0, reserve1, 0);
+    }
+
+    function sync() public {
+        reserve0 = uint112(IToken(token0).balanceOf(address(this)));
+        reserve1 = uint112(IToken(token1).balanceOf(address(this)));
+    }
+
+    function swap(
+        uint256 amount0Out,
+        uint256 amount1Out,
+        address to,
+        bytes calldata data
+    ) external {
+        require(amount0Out > 0 || amount1Out > 0, "MockPair: no output");
+        require(
+            amount0Out < reserve0 && amount1Out < reserve1,
+            "MockPair: liquidity"
+        );
+        if (amount0Out > 0) IToken(token0).transfer(to, amount0Out);
+        if (amount1Out > 0) IToken(token1).transfer(to, amount1Out);
+        if (data.length > 0) {
+            bytes4 selector = callback == 0
+                ? bytes4(keccak256("pancakeCall(address,uint256,uint256,bytes)"))
+                : callback == 1
+                ? bytes4(keccak256("BiswapCall(address,uint256,uint256,bytes)"))
+                : bytes4(keccak256("uniswapV2Call(address,uint256,uint256,bytes)"));
+            (bool ok, bytes memory ret) = to.call(
+                abi.encodeWithSelector(
+                    selector,
+                    msg.sender,
+                    amount0Out,
+                    amount1Out,
+                    data
+                )
+            );
+            if (!ok) {
+                assembly {
+                    revert(add(ret, 32), mload(ret))
+                }
+            }
+        }
+
+        uint256 balance0 = IToken(token0).balanceOf(address(this));
+        uint256 balance1 = IToken(token1).balanceOf(address(this));
+        uint256 amount0In = balance0 > reserve0 - amount0Out
+            ? balance0 - (reserve0 - amount0Out)
+            : 0;
+        uint256 amount1In = balance1 > reserve1 - amount1Out
+            ? balance1 - (reserve1 - amount1Out)
+            : 0;
+        require(amount0In > 0 || amount1In > 0, "MockPair: no input");
+        uint256 adjusted0 = balance0 * 10000 - amount0In * feeBps;
+        uint256 adjusted1 = balance1 * 10000 - amount1In * feeBps;
+        require(
+            adjusted0 * adjusted1 >=
+                uint256(reserve0) * reserve1 * 10000 * 10000,
+            "MockPair: K"
+        );
+        reserve0 = uint112(balance0);
+        reserve1 = uint112(balance1);
+    }
+}
@@ -0,0 +1,48 @@
+// SPDX-License-Identifier: MIT
+pragma solidity 0.8.21;
+
+/// @notice ERC20 for tests. Transfers not sent by the owner lose taxBps out of
+/// 10000 on the way, and once a pool is blocked only the owner can send to it,
+/// the way honeypot tokens stop sells.
+contract MockToken {
+    event Transfer(address indexed from, address indexed to, uint256 value);
+
+    string public name;
+    string public symbol;
+    uint8 public constant decimals = 18;
+    uint256 public totalSupply;
+    address public immutable owner;
+    uint256 public immutable taxBps;
+
+    mapping(address => uint256) public balanceOf;
+    mapping(address => bool) public blocked;
+
+    constructor(string memory symbol_, uint256 supply, uint256 taxBps_) {
+        name = symbol_;
+        symbol = symbol_;
+        owner = msg.sender;
+        taxBps = taxBps_;
+        totalSupply = supply;
+        balanceOf[msg.sender] = supply;
+        emit Transfer(address(0), msg.sender, supply);
+    }
+
+    function blockSells(address pool) external {
+        require(msg.sender == owner, "MockToken: not owner");
+        blocked[pool] = true;
+    }
+
+    function transfer(address to, uint256 value) external returns (bool) {
+        require(
+            msg.sender == owner || !blocked[to],
+            "MockToken: sells disabled"
+        );
+        require(balanceOf[msg.sender] >= value, "MockToken: balance");
+        uint256 tax = msg.sender == owner ? 0 : (value * taxBps) / 10000;
+        balanceOf[msg.sender] -= value;
+        balanceOf[to] += value - tax;
+        totalSupply -= tax;
+        emit Transfer(msg.sender, to, value - tax);
+        return true;
+    }
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"sync"
+
+	"example.com/m/erc20"
+	ethereum "github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// TokenInfo is a token's ERC20 metadata as read from the chain.
+type TokenInfo struct {
+	Address  common.Address `json:"address"`
+	Name     string         `json:"name"`
+	Symbol   string         `json:"symbol"`
+	Decimals uint8          `json:"decimals"`
+}
+
+// TokenRegistry reads token metadata once and keeps it in a JSON file, so
+// symbols and decimals come from the tokens themselves rather than from
+// the pairs file.
+type TokenRegistry struct {
+	backend bind.ContractCaller
+	abi     *abi.ABI
+	path    string
+
+	mu     sync.Mutex
+	tokens map[common.Address]TokenInfo
+}
+
+func NewTokenRegistry(backend bind.ContractCaller, path string) (*TokenRegistry, error) {
+	parsed, err := erc20.ERC20MetaData.GetAbi()
+	if err != nil {
+		return nil, err
+	}
+	r := &TokenRegistry{backend: backend, abi: parsed, path: path, tokens: make(map[common.Address]TokenInfo)}
+	if data, err := os.ReadFile(path); err == nil {
+		var tokens []TokenInfo
+		if err := json.Unmarshal(data, &tokens); err != nil {
+			return nil, fmt.Errorf("tokens: corrupt registry in %s: %w", path, err)
+		}
+		for _, token := range tokens {
+			r.tokens[token.Address] = token
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Resolve reads the metadata of every token not yet registered. Tokens
+// whose decimals cannot be read are reported and left out, and so are
+// their pools.
+func (r *TokenRegistry) Resolve(ctx context.Context, tokens []common.Address) error {
+	added := 0
+	for _, token := range tokens {
+		if _, ok := r.Lookup(token); ok {
+			continue
+		}
+		info, err := r.fetch(ctx, token)
+		if err != nil {
+			log.Warn("Cannot read token", "token", token, "err", err)
+			continue
+		}
+		r.mu.Lock()
+		r.tokens[token] = info
+		r.mu.Unlock()
+		added++
+	}
+	if added == 0 {
+		return nil
+	}
+	return r.save()
+}
+
+func (r *TokenRegistry) fetch(ctx context.Context, token common.Address) (TokenInfo, error) {
+	info := TokenInfo{Address: token}
+	out, err := r.call(ctx, token, "decimals")
+	if err != nil {
+		return info, err
+	}
+	decimals, err := r.abi.Unpack("decimals", out)
+	if err != nil {
+		return info, err
+	}
+	info.Decimals = decimals[0].(uint8)
+	// prices only need the decimals: a token without a readable name or
+	// symbol is kept, and shown by its address
+	if info.Name, err = r.text(ctx, token, "name"); err != nil {
+		log.Debug("Cannot read token name", "token", token, "err", err)
+	}
+	if info.Symbol, err = r.text(ctx, token, "symbol"); err != nil {
+		log.Debug("Cannot read token symbol", "token", token, "err", err)
+	}
+	return info, nil
+}
+
+// text reads a string getter. Some older tokens return bytes32 instead.
+func (r *TokenRegistry) text(ctx context.Context, token common.Address, method string) (string, error) {
+	out, err := r.call(ctx, token, method)
+	if err != nil {
+		return "", err
+	}
+	if len(out) == 32 {
+		return strings.TrimRight(string(out), "\x00"), nil
+	}
+	values, err := r.abi.Unpack(method, out)
+	if err != nil {
+		return "", err
+	}
+	return values[0].(string), nil
+}
+
+func (r *TokenRegistry) call(ctx context.Context, token common.Address, method string) ([]byte, error) {
+	input, err := r.abi.Pack(method)
+	if err != nil {
+		return nil, err
+	}
+	out, err := r.backend.CallContract(ctx, ethereum.CallMsg{To: &token, Data: input}, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(out) == 0 {
+		return nil, errors.New(method + " returned nothing")
+	}
+	return out, nil
+}
+
+// Lookup returns what is known about token. A nil registry knows nothing.
+func (r *TokenRegistry) Lookup(token common.Address) (TokenInfo, bool) {
+	if r == nil {
+		return TokenInfo{}, false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	info, ok := r.tokens[token]
+	return info, ok
+}
+
+// Symbol is the token's on-chain symbol, or fallback when it is unknown.
+func (r *TokenRegistry) Symbol(token common.Address, fallback string) string {
+	if info, ok := r.Lookup(token); ok && info.Symbol != "" {
+		return info.Symbol
+	}
+	return fallback
+}
+
+// Resolved says whether token's metadata was read. Pools of other tokens
+// are kept out of the graph, whose prices need decimals.
+func (r *TokenRegistry) Resolved(token common.Address) bool {
+	_, ok := r.Lookup(token)
+	return ok
+}
+
+// Decimals is the token's on-chain decimals, 18 when unknown. Only tokens
+// left out of the graph are unknown, so the fallback is for output alone.
+func (r *TokenRegistry) Decimals(token common.Address) uint8 {
+	if info, ok := r.Lookup(token); ok {
+		return info.Decimals
+	}
+	return 18
+}
+
+// Normalize turns a raw amount of token into whole tokens.
+func (r *TokenRegistry) Normalize(token common.Address, amount *big.Int) *big.Float {
+	unit := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(r.Decimals(token))), nil)
+	return new(big.Float).Quo(new(big.Float).SetInt(amount), new(big.Float).SetInt(unit))
+}
+
+// Format renders a raw amount of token for output, e.g. "1.250000 WBNB".
+func (r *TokenRegistry) Format(token common.Address, amount *big.Int) string {
+	return r.Normalize(token, amount).Text('f', 6) + " " + r.Symbol(token, token.Hex())
+}
+
+// Parse turns an amount in whole tokens, like "1.25", into a raw amount of
+// token. Digits past the token's decimals are dropped.
+func (r *TokenRegistry) Parse(token common.Address, amount string) (*big.Int, error) {
+	whole, ok := new(big.Float).SetPrec(256).SetString(amount)
+	if !ok || whole.Sign() <= 0 {
+		return nil, fmt.Errorf("%q is not a positive amount", amount)
+	}
+	unit := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(r.Decimals(token))), nil)
+	raw, _ := whole.Mul(whole, new(big.Float).SetInt(unit)).Int(nil)
+	return raw, nil
+}
+
+// Price is how many whole `to` tokens one whole `from` token buys at the
+// given raw reserves.
+func (r *TokenRegistry) Price(from, to common.Address, reserveFrom, reserveTo *big.Int) *big.Float {
+	return new(big.Float).Quo(r.Normalize(to, reserveTo), r.Normalize(from, reserveFrom))
+}
+
+func (r *TokenRegistry) save() error {
+	return r.saveAs(r.path)
+}
+
+// saveAs writes the registry to path, which need not be its own.
+func (r *TokenRegistry) saveAs(path string) error {
+	r.mu.Lock()
+	tokens := make([]TokenInfo, 0, len(r.tokens))
+	for _, token := range r.tokens {
+		tokens = append(tokens, token)
+	}
+	r.mu.Unlock()
+	data, err := json.MarshalIndent(tokens, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"path/filepath"
+	"testing"
+
+	"example.com/m/erc20"
+	ethereum "github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// fakeTokens answers ERC20 getters from a table of outputs by token and
+// method; a missing entry reverts.
+type fakeTokens struct {
+	outputs map[common.Address]map[string][]byte
+	calls   int
+}
+
+func (f *fakeTokens) CodeAt(ctx context.Context, contract common.Address, blockNumber *big.Int) ([]byte, error) {
+	return []byte{1}, nil
+}
+
+func (f *fakeTokens) CallContract(ctx context.Context, call ethereum.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	f.calls++
+	parsed, err := erc20.ERC20MetaData.GetAbi()
+	if err != nil {
+		return nil, err
+	}
+	method, err := parsed.MethodById(call.Data[:4])
+	if err != nil {
+		return nil, err
+	}
+	out, ok := f.outputs[*call.To][method.Name]
+	if !ok {
+		return nil, errors.New("execution reverted")
+	}
+	return out, nil
+}
+
+func TestTokenRegistryResolve(t *testing.T) {
+	parsed, err := erc20.ERC20MetaData.GetAbi()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pack := func(method string, value interface{}) []byte {
+		out, err := parsed.Methods[method].Outputs.Pack(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+	standard, old, nameless, broken := testToken(1), testToken(2), testToken(3), testToken(4)
+	backend := &fakeTokens{outputs: map[common.Address]map[string][]byte{
+		standard: {"decimals": pack("decimals", uint8(6)), "name": pack("name", "Tether USD"), "symbol": pack("symbol", "USDT")},
+		// like MKR, which returns bytes32 for both
+		old:      {"decimals": pack("decimals", uint8(18)), "name": common.RightPadBytes([]byte("Maker"), 32), "symbol": common.RightPadBytes([]byte("MKR"), 32)},
+		nameless: {"decimals": pack("decimals", uint8(9))},
+		broken:   {"name": pack("name", "Broken"), "symbol": pack("symbol", "BRK")},
+	}}
+	path := filepath.Join(t.TempDir(), "tokens.json")
+	registry, err := NewTokenRegistry(backend, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := registry.Resolve(context.Background(), []common.Address{standard, old, nameless, broken}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		token    common.Address
+		resolved bool
+		info     TokenInfo
+	}{
+		{"string getters", standard, true, TokenInfo{standard, "Tether USD", "USDT", 6}},
+		{"bytes32 getters", old, true, TokenInfo{old, "Maker", "MKR", 18}},
+		{"no name or symbol", nameless, true, TokenInfo{nameless, "", "", 9}},
+		{"no decimals", broken, false, TokenInfo{}},
+	}
+	for _, test := range tests {
+		info, ok := registry.Lookup(test.token)
+		if ok != test.resolved || info != test.info {
+			t.Errorf("%s: resolved %v as %+v, want %v as %+v", test.name, ok, info, test.resolved, test.info)
+		}
+	}
+	if got := registry.Symbol(nameless, "fallback"); got != "fallback" {
+		t.Errorf("symbol of a token without one is %q, want the fallback", got)
+	}
+
+	// A reloaded registry knows every resolved token without asking again
+	reloaded, err := NewTokenRegistry(backend, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	calls := backend.calls
+	if err := reloaded.Resolve(context.Background(), []common.Address{standard, old, nameless}); err != nil {
+		t.Fatal(err)
+	}
+	if backend.calls != calls {
+		t.Errorf("reloaded registry made %d calls for known tokens", backend.calls-calls)
+	}
+	if info, _ := reloaded.Lookup(old); info.Symbol != "MKR" {
+		t.Errorf("reloaded symbol %q, want MKR", info.Symbol)
+	}
+}
+
+func TestTokenRegistryAmounts(t *testing.T) {
+	usdt, unknown := testToken(1), testToken(2)
+	registry := &TokenRegistry{tokens: map[common.Address]TokenInfo{usdt: {Address: usdt, Symbol: "USDT", Decimals: 6}}}
+	raw := func(s string) *big.Int {
+		n, _ := new(big.Int).SetString(s, 10)
+		return n
+	}
+
+	parses := []struct {
+		token  common.Address
+		amount string
+		want   *big.Int
+	}{
+		{usdt, "1.25", raw("1250000")},
+		{usdt, "0.000001", raw("1")},
+		// digits past the decimals are dropped
+		{usdt, "0.0000019", raw("1")},
+		{unknown, "2", raw("2000000000000000000")},
+		{usdt, "0", nil},
+		{usdt, "-1", nil},
+		{usdt, "lots", nil},
+	}
+	for _, test := range parses {
+		got, err := registry.Parse(test.token, test.amount)
+		if test.want == nil {
+			if err == nil {
+				t.Errorf("Parse(%q) = %s, want an error", test.amount, got)
+			}
+			continue
+		}
+		if err != nil || got.Cmp(test.want) != 0 {
+			t.Errorf("Parse(%q) = %s, %v, want %s", test.amount, got, err, test.want)
+		}
+	}
+
+	formats := []struct {
+		token  common.Address
+		amount *big.Int
+		want   string
+	}{
+		{usdt, raw("1250000"), "1.250000 USDT"},
+		{usdt, raw("1"), "0.000001 USDT"},
+		{unknown, raw("2500000000000000000"), "2.500000 " + unknown.Hex()},
+	}
+	for _, test := range formats {
+		if got := registry.Format(test.token, test.amount); got != test.want {
+			t.Errorf("Format(%s) = %q, want %q", test.amount, got, test.want)
+		}
+	}
+}
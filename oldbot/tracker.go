@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"sort"
+	"sync"
+
+	"example.com/m/pancakePair"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// trackerWindow is how many blocks of reserve changes are kept to undo. A
+// reorg or a gap deeper than that reseeds the tracker from getReserves.
+const trackerWindow = 64
+
+// errTrackerReset is a head the tracker cannot reach from its window.
+var errTrackerReset = errors.New("tracker: head not reachable from tracked blocks")
+
+// trackerBackend is what the tracker needs from a node. RPCPool satisfies
+// it.
+type trackerBackend interface {
+	HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error)
+	HeaderByHash(ctx context.Context, hash common.Hash) (*types.Header, error)
+	FilterLogs(ctx context.Context, query ethereum.FilterQuery) ([]types.Log, error)
+}
+
+// reserveDelta is the reserves one Sync replaced, to undo it.
+type reserveDelta struct {
+	pool             int
+	before0, before1 big.Int
+}
+
+// trackedBlock is a block whose Syncs have been applied.
+type trackedBlock struct {
+	number uint64
+	hash   common.Hash
+	parent common.Hash
+	deltas []reserveDelta
+}
+
+// ReserveTracker keeps pool reserves current from the Sync logs of each new
+// block instead of reading every pool again. It holds the last
+// trackerWindow blocks it applied, so when a new head does not build on
+// them it can undo back to the common ancestor and replay the new branch.
+type ReserveTracker struct {
+	backend   trackerBackend
+	parser    *pancakePair.PancakePairFilterer
+	syncTopic common.Hash
+
+	mu     sync.Mutex
+	pools  []PoolState
+	index  map[common.Address]int
+	blocks []trackedBlock
+}
+
+func NewReserveTracker(backend trackerBackend) (*ReserveTracker, error) {
+	parser, err := pancakePair.NewPancakePairFilterer(common.Address{}, nil)
+	if err != nil {
+		return nil, err
+	}
+	parsed, err := pancakePair.PancakePairMetaData.GetAbi()
+	if err != nil {
+		return nil, err
+	}
+	return &ReserveTracker{backend: backend, parser: parser, syncTopic: parsed.Events["Sync"].ID}, nil
+}
+
+// copyPools copies pools with reserves of their own, so updating one copy
+// never writes through to the other.
+func copyPools(pools []PoolState) []PoolState {
+	copied := make([]PoolState, len(pools))
+	for i := range pools {
+		copied[i] = PoolState{in: pools[i].in, tax0: pools[i].tax0, tax1: pools[i].tax1}
+		copied[i].reserve0.Set(&pools[i].reserve0)
+		copied[i].reserve1.Set(&pools[i].reserve1)
+	}
+	return copied
+}
+
+// Seed starts tracking from pools as read at head.
+func (t *ReserveTracker) Seed(pools []PoolState, head *types.Header) {
+	index := make(map[common.Address]int, len(pools))
+	for i, pool := range pools {
+		index[pool.in.Factory] = i
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.pools, t.index = copyPools(pools), index
+	t.blocks = []trackedBlock{{number: head.Number.Uint64(), hash: head.Hash(), parent: head.ParentHash}}
+}
+
+func (t *ReserveTracker) seeded() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.blocks) > 0
+}
+
+// Pools are the tracked reserves at the tracked head.
+func (t *ReserveTracker) Pools() []PoolState {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return copyPools(t.pools)
+}
+
+func (t *ReserveTracker) find(hash common.Hash) int {
+	for i := len(t.blocks) - 1; i >= 0; i-- {
+		if t.blocks[i].hash == hash {
+			return i
+		}
+	}
+	return -1
+}
+
+// Canonical reports whether hash is one of the tracked blocks, i.e. on the
+// chain as far as the tracker last saw it.
+func (t *ReserveTracker) Canonical(hash common.Hash) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.find(hash) >= 0
+}
+
+// Advance moves the tracker to head. Blocks between the tracked tip and
+// head are fetched by parent hash, so the applied chain always links up.
+// When head is on another branch the tracked blocks after the common
+// ancestor are undone and returned as orphaned. A head that is already
+// tracked, as a lagging node reports, changes nothing. errTrackerReset
+// means the tracker has to be seeded again.
+func (t *ReserveTracker) Advance(ctx context.Context, head *types.Header) ([]common.Hash, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.blocks) == 0 {
+		return nil, errTrackerReset
+	}
+	if t.find(head.Hash()) >= 0 {
+		return nil, nil
+	}
+
+	branch := []*types.Header{}
+	ancestor := -1
+	for h := head; ; {
+		branch = append(branch, h)
+		if ancestor = t.find(h.ParentHash); ancestor >= 0 {
+			break
+		}
+		if len(branch) >= trackerWindow || h.Number.Uint64() <= t.blocks[0].number {
+			return nil, errTrackerReset
+		}
+		parent, err := t.backend.HeaderByHash(ctx, h.ParentHash)
+		if err != nil {
+			return nil, err
+		}
+		h = parent
+	}
+
+	orphaned := []common.Hash{}
+	for len(t.blocks)-1 > ancestor {
+		last := t.blocks[len(t.blocks)-1]
+		for i := len(last.deltas) - 1; i >= 0; i-- {
+			delta := &last.deltas[i]
+			t.pools[delta.pool].reserve0.Set(&delta.before0)
+			t.pools[delta.pool].reserve1.Set(&delta.before1)
+		}
+		orphaned = append(orphaned, last.hash)
+		t.blocks = t.blocks[:len(t.blocks)-1]
+	}
+	for i := len(branch) - 1; i >= 0; i-- {
+		if err := t.apply(ctx, branch[i]); err != nil {
+			return orphaned, err
+		}
+	}
+	if len(t.blocks) > trackerWindow {
+		t.blocks = append([]trackedBlock{}, t.blocks[len(t.blocks)-trackerWindow:]...)
+	}
+	return orphaned, nil
+}
+
+// apply reads the Syncs of one block by its hash, so they belong to that
+// block even if it is reorganized away meanwhile.
+func (t *ReserveTracker) apply(ctx context.Context, header *types.Header) error {
+	hash := header.Hash()
+	logs, err := t.backend.FilterLogs(ctx, ethereum.FilterQuery{BlockHash: &hash, Topics: [][]common.Hash{{t.syncTopic}}})
+	if err != nil {
+		return err
+	}
+	sort.Slice(logs, func(i, j int) bool { return logs[i].Index < logs[j].Index })
+	block := trackedBlock{number: header.Number.Uint64(), hash: hash, parent: header.ParentHash}
+	for _, log := range logs {
+		i, ok := t.index[log.Address]
+		if !ok || log.Removed {
+			continue
+		}
+		sync, err := t.parser.ParseSync(log)
+		if err != nil {
+			return &PoolError{Pool: log.Address, Kind: ErrDecode, Err: err}
+		}
+		delta := reserveDelta{pool: i}
+		delta.before0.Set(&t.pools[i].reserve0)
+		delta.before1.Set(&t.pools[i].reserve1)
+		t.pools[i].reserve0.Set(sync.Reserve0)
+		t.pools[i].reserve1.Set(sync.Reserve1)
+		block.deltas = append(block.deltas, delta)
+	}
+	t.blocks = append(t.blocks, block)
+	return nil
+}
+
+// Confirm advances to the chain's head and reports whether hash is still
+// on it. Opportunities computed on a block that is not are stale.
+func (t *ReserveTracker) Confirm(ctx context.Context, hash common.Hash) (bool, error) {
+	head, err := t.backend.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	orphaned, err := t.Advance(ctx, head)
+	if len(orphaned) > 0 {
+		log.Warn("Reorg: orphaned tracked blocks", "blocks", len(orphaned))
+	}
+	if err != nil {
+		return false, err
+	}
+	return t.Canonical(hash), nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"reflect"
+	"testing"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// fakeForks serves headers by hash and the Syncs of each block hash.
+type fakeForks struct {
+	headers map[common.Hash]*types.Header
+	syncs   map[common.Hash][]types.Log
+}
+
+func (f *fakeForks) HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error) {
+	return nil, errors.New("not used")
+}
+
+func (f *fakeForks) HeaderByHash(ctx context.Context, hash common.Hash) (*types.Header, error) {
+	if header, ok := f.headers[hash]; ok {
+		return header, nil
+	}
+	return nil, ethereum.NotFound
+}
+
+func (f *fakeForks) FilterLogs(ctx context.Context, query ethereum.FilterQuery) ([]types.Log, error) {
+	return f.syncs[*query.BlockHash], nil
+}
+
+// block adds a header on parent, its fork told apart by extra, with a Sync
+// of pool to reserve0 and reserve1 when pool is set.
+func (f *fakeForks) block(parent *types.Header, extra byte, syncTopic common.Hash, pool common.Address, reserve0, reserve1 int64) *types.Header {
+	header := &types.Header{Number: new(big.Int).Add(parent.Number, big.NewInt(1)), ParentHash: parent.Hash(), Extra: []byte{extra}}
+	f.headers[header.Hash()] = header
+	if pool != (common.Address{}) {
+		data := append(common.LeftPadBytes(big.NewInt(reserve0).Bytes(), 32), common.LeftPadBytes(big.NewInt(reserve1).Bytes(), 32)...)
+		f.syncs[header.Hash()] = []types.Log{{Address: pool, Topics: []common.Hash{syncTopic}, Data: data, BlockNumber: header.Number.Uint64(), BlockHash: header.Hash()}}
+	}
+	return header
+}
+
+func TestReserveTrackerAdvance(t *testing.T) {
+	forks := &fakeForks{headers: map[common.Hash]*types.Header{}, syncs: map[common.Hash][]types.Log{}}
+	tracker, err := NewReserveTracker(forks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pools := []PoolState{testPool(1, 2), testPool(2, 3)}
+	for i := range pools {
+		pools[i].reserve0.SetInt64(100)
+		pools[i].reserve1.SetInt64(100)
+	}
+	first, second := pools[0].in.Factory, pools[1].in.Factory
+	topic := tracker.syncTopic
+
+	seed := &types.Header{Number: big.NewInt(10)}
+	forks.headers[seed.Hash()] = seed
+	a11 := forks.block(seed, 'a', topic, first, 110, 90)
+	a12 := forks.block(a11, 'a', topic, first, 120, 80)
+	b11 := forks.block(seed, 'b', topic, second, 50, 150)
+	b12 := forks.block(b11, 'b', topic, common.Address{}, 0, 0)
+	b13 := forks.block(b12, 'b', topic, first, 105, 95)
+	// not built on anything tracked, at the seed's height
+	stranger := &types.Header{Number: big.NewInt(10), Extra: []byte{'x'}}
+	forks.headers[stranger.Hash()] = stranger
+	strangerChild := forks.block(stranger, 'x', topic, first, 1, 1)
+
+	tracker.Seed(pools, seed)
+	tests := []struct {
+		name     string
+		head     *types.Header
+		orphaned []common.Hash
+		reserves [2][2]int64
+		err      error
+	}{
+		{"two blocks ahead", a12, nil, [2][2]int64{{120, 80}, {100, 100}}, nil},
+		{"same head again", a12, nil, [2][2]int64{{120, 80}, {100, 100}}, nil},
+		{"lagging node", a11, nil, [2][2]int64{{120, 80}, {100, 100}}, nil},
+		{"reorg to a longer fork", b13, []common.Hash{a12.Hash(), a11.Hash()}, [2][2]int64{{105, 95}, {50, 150}}, nil},
+		{"back to the first fork", a12, []common.Hash{b13.Hash(), b12.Hash(), b11.Hash()}, [2][2]int64{{120, 80}, {100, 100}}, nil},
+		{"unrelated chain", strangerChild, nil, [2][2]int64{{120, 80}, {100, 100}}, errTrackerReset},
+	}
+	for _, test := range tests {
+		orphaned, err := tracker.Advance(context.Background(), test.head)
+		if !errors.Is(err, test.err) {
+			t.Fatalf("%s: %v, want %v", test.name, err, test.err)
+		}
+		if len(orphaned) != 0 || len(test.orphaned) != 0 {
+			if !reflect.DeepEqual(orphaned, test.orphaned) {
+				t.Errorf("%s: orphaned %v, want %v", test.name, orphaned, test.orphaned)
+			}
+		}
+		for i, pool := range tracker.Pools() {
+			if pool.reserve0.Int64() != test.reserves[i][0] || pool.reserve1.Int64() != test.reserves[i][1] {
+				t.Errorf("%s: pool %d at %s/%s, want %v", test.name, i, &pool.reserve0, &pool.reserve1, test.reserves[i])
+			}
+		}
+		if test.err == nil && !tracker.Canonical(test.head.Hash()) {
+			t.Errorf("%s: head not tracked", test.name)
+		}
+	}
+}
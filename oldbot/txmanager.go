@@ -0,0 +1,482 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"example.com/m/erc20"
+	ethereum "github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// txBackend is what the transaction manager needs from a node. ethclient.Client
+// and backends.SimulatedBackend both satisfy it.
+type txBackend interface {
+	bind.ContractTransactor
+	bind.DeployBackend
+	NonceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (uint64, error)
+	TransactionByHash(ctx context.Context, hash common.Hash) (*types.Transaction, bool, error)
+}
+
+type txOutcome string
+
+const (
+	outcomePending    txOutcome = "pending"
+	outcomeProfitable txOutcome = "mined-profitable"
+	outcomeReverted   txOutcome = "reverted"
+	outcomeDropped    txOutcome = "dropped"
+	outcomeReplaced   txOutcome = "replaced"
+	// only used for the attempt that cancelled a trade
+	outcomeCancelled txOutcome = "cancelled"
+)
+
+// txAttempt is one signed transaction sent for a nonce. Speed-ups and
+// cancels add attempts; at most one of them is ever mined.
+type txAttempt struct {
+	Hash     common.Hash   `json:"hash"`
+	Raw      hexutil.Bytes `json:"raw"`
+	GasPrice *big.Int      `json:"gasPrice"`
+	Cancel   bool          `json:"cancel,omitempty"`
+	Outcome  txOutcome     `json:"outcome"`
+}
+
+// TrackedTx follows everything sent with one nonce until it is settled.
+// Via names the Submitter its attempts go through.
+type TrackedTx struct {
+	Nonce          uint64      `json:"nonce"`
+	Via            string      `json:"via"`
+	Attempts       []txAttempt `json:"attempts"`
+	Outcome        txOutcome   `json:"outcome"`
+	ExpectedProfit *big.Int    `json:"expectedProfit"`
+	// ProfitToken is the token ExpectedProfit is in
+	ProfitToken common.Address `json:"profitToken"`
+	Sent        time.Time      `json:"sent"`
+	Block       uint64         `json:"block,omitempty"`
+	// GasCost is what the mined attempt paid for gas, in the native coin
+	GasCost *big.Int `json:"gasCost,omitempty"`
+	// Profit is what a mined trade left its contract with, in ProfitToken,
+	// from the Transfer logs of its receipt. Gas is not taken off.
+	Profit *big.Int `json:"profit,omitempty"`
+	// Until is the last block a windowed submission, like a bundle, can be
+	// mined in; past it the nonce is released or cancelled
+	Until uint64 `json:"until,omitempty"`
+}
+
+func (t *TrackedTx) latest() *txAttempt {
+	return &t.Attempts[len(t.Attempts)-1]
+}
+
+// txState is what the manager writes to disk after every change.
+type txState struct {
+	Nonce   uint64       `json:"nonce"`
+	Tracked []*TrackedTx `json:"tracked"`
+}
+
+// TxManager hands out nonces locally, sends and replaces transactions and
+// follows them until they are mined or dropped. Its state is persisted so a
+// restart never reuses a nonce that is still in flight.
+type TxManager struct {
+	backend txBackend
+	key     *ecdsa.PrivateKey
+	from    common.Address
+	signer  types.Signer
+	path    string
+	stuck   time.Duration
+	bumpPct int64
+	nonce   uint64
+	tracked map[uint64]*TrackedTx
+	via     map[string]Submitter
+	mu      sync.Mutex
+
+	transferTopic common.Hash
+}
+
+// NewTxManager loads the state at path, if any, and starts numbering from
+// whichever is higher of the stored nonce and the account's pending nonce.
+func NewTxManager(ctx context.Context, backend txBackend, key *ecdsa.PrivateKey, chainID *big.Int, path string) (*TxManager, error) {
+	parsed, err := erc20.ERC20MetaData.GetAbi()
+	if err != nil {
+		return nil, err
+	}
+	m := &TxManager{
+		backend: backend,
+		key:     key,
+		from:    crypto.PubkeyToAddress(key.PublicKey),
+		signer:  types.LatestSignerForChainID(chainID),
+		path:    path,
+		stuck:   30 * time.Second,
+		bumpPct: 12,
+		tracked: make(map[uint64]*TrackedTx),
+		via:     make(map[string]Submitter),
+
+		transferTopic: parsed.Events["Transfer"].ID,
+	}
+	m.AddSubmitter(&mempoolSubmitter{backend})
+
+	if data, err := os.ReadFile(path); err == nil {
+		var state txState
+		if err := json.Unmarshal(data, &state); err != nil {
+			return nil, fmt.Errorf("txmanager: corrupt state in %s: %w", path, err)
+		}
+		m.nonce = state.Nonce
+		for _, tracked := range state.Tracked {
+			m.tracked[tracked.Nonce] = tracked
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	pending, err := backend.PendingNonceAt(ctx, m.from)
+	if err != nil {
+		return nil, err
+	}
+	if pending > m.nonce {
+		m.nonce = pending
+	}
+	return m, m.save()
+}
+
+// AddSubmitter makes s available to Send and to the replacements of
+// transactions first sent through it. A mempool submitter is always present.
+func (m *TxManager) AddSubmitter(s Submitter) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.via[s.Name()] = s
+}
+
+// submitter is the Submitter tracked went through. Callers hold m.mu.
+func (m *TxManager) submitter(tracked *TrackedTx) Submitter {
+	if s, ok := m.via[tracked.Via]; ok {
+		return s
+	}
+	return m.via["mempool"]
+}
+
+// Send signs a call to `to` with the next local nonce and submits it through
+// via. expectedProfit is in profitToken.
+func (m *TxManager) Send(ctx context.Context, via Submitter, to common.Address, data []byte, gasLimit uint64, gasPrice, expectedProfit *big.Int, profitToken common.Address) (*TrackedTx, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tx, err := types.SignTx(types.NewTransaction(m.nonce, to, new(big.Int), gasLimit, gasPrice, data), m.signer, m.key)
+	if err != nil {
+		return nil, err
+	}
+	until, err := m.submit(ctx, via, tx)
+	if err != nil {
+		return nil, err
+	}
+	m.via[via.Name()] = via
+	raw, _ := tx.MarshalBinary()
+	tracked := &TrackedTx{
+		Nonce:          m.nonce,
+		Via:            via.Name(),
+		Attempts:       []txAttempt{{Hash: tx.Hash(), Raw: raw, GasPrice: gasPrice, Outcome: outcomePending}},
+		Outcome:        outcomePending,
+		ExpectedProfit: expectedProfit,
+		ProfitToken:    profitToken,
+		Sent:           time.Now(),
+		Until:          until,
+	}
+	m.tracked[m.nonce] = tracked
+	m.nonce++
+	return tracked, m.save()
+}
+
+// submit sends tx through via and returns the last block it can be mined
+// in, or 0 when via does not limit it.
+func (m *TxManager) submit(ctx context.Context, via Submitter, tx *types.Transaction) (uint64, error) {
+	if windowed, ok := via.(windowedSubmitter); ok {
+		return windowed.SubmitUntil(ctx, tx)
+	}
+	return 0, via.Submit(ctx, tx)
+}
+
+// SpeedUp resends the latest attempt for nonce with a higher gas price.
+func (m *TxManager) SpeedUp(ctx context.Context, nonce uint64) (*types.Transaction, error) {
+	return m.replace(ctx, nonce, false)
+}
+
+// Cancel replaces the transaction at nonce with an empty transfer to self.
+func (m *TxManager) Cancel(ctx context.Context, nonce uint64) (*types.Transaction, error) {
+	return m.replace(ctx, nonce, true)
+}
+
+func (m *TxManager) replace(ctx context.Context, nonce uint64, cancel bool) (*types.Transaction, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tracked, ok := m.tracked[nonce]
+	if !ok {
+		return nil, fmt.Errorf("txmanager: nonce %d is not pending", nonce)
+	}
+	last := tracked.latest()
+	previous := new(types.Transaction)
+	if err := previous.UnmarshalBinary(last.Raw); err != nil {
+		return nil, err
+	}
+
+	// nodes only accept a replacement that pays at least 10% more
+	gasPrice := new(big.Int).Mul(last.GasPrice, big.NewInt(100+m.bumpPct))
+	gasPrice.Div(gasPrice, big.NewInt(100))
+
+	var unsigned *types.Transaction
+	if cancel || last.Cancel {
+		unsigned = types.NewTransaction(nonce, m.from, new(big.Int), 21000, gasPrice, nil)
+	} else {
+		unsigned = types.NewTransaction(nonce, *previous.To(), previous.Value(), previous.Gas(), gasPrice, previous.Data())
+	}
+	tx, err := types.SignTx(unsigned, m.signer, m.key)
+	if err != nil {
+		return nil, err
+	}
+	until, err := m.submit(ctx, m.submitter(tracked), tx)
+	if err != nil {
+		return nil, err
+	}
+	tracked.Until = until
+	raw, _ := tx.MarshalBinary()
+	tracked.Attempts = append(tracked.Attempts, txAttempt{
+		Hash:     tx.Hash(),
+		Raw:      raw,
+		GasPrice: gasPrice,
+		Cancel:   cancel || last.Cancel,
+		Outcome:  outcomePending,
+	})
+	return tx, m.save()
+}
+
+// Poll checks every pending nonce for receipts and returns the ones that
+// settled. Transactions pending for longer than the stuck timeout are sped
+// up, and ones the node has forgotten are broadcast again.
+func (m *TxManager) Poll(ctx context.Context) ([]*TrackedTx, error) {
+	confirmed, err := m.backend.NonceAt(ctx, m.from, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	nonces := make([]uint64, 0, len(m.tracked))
+	for nonce := range m.tracked {
+		nonces = append(nonces, nonce)
+	}
+	m.mu.Unlock()
+	// newest first, so expired bundles release their nonces from the top
+	// down instead of being cancelled
+	sort.Slice(nonces, func(i, j int) bool { return nonces[i] > nonces[j] })
+
+	settled := []*TrackedTx{}
+	for _, nonce := range nonces {
+		tracked, err := m.check(ctx, nonce, confirmed)
+		if err != nil {
+			return settled, err
+		}
+		if tracked != nil {
+			settled = append(settled, tracked)
+			continue
+		}
+		m.mu.Lock()
+		tracked = m.tracked[nonce]
+		stuck := time.Since(tracked.Sent) > m.stuck*time.Duration(len(tracked.Attempts))
+		m.mu.Unlock()
+		if stuck {
+			if _, err := m.SpeedUp(ctx, nonce); err != nil {
+				log.Warn("Cannot speed up transaction", "nonce", nonce, "err", err)
+			}
+		}
+	}
+	return settled, nil
+}
+
+// check looks for a receipt of any attempt at nonce and settles it if one is
+// found or the nonce was used by a transaction we did not send.
+func (m *TxManager) check(ctx context.Context, nonce, confirmed uint64) (*TrackedTx, error) {
+	m.mu.Lock()
+	tracked := m.tracked[nonce]
+	attempts := append([]txAttempt(nil), tracked.Attempts...)
+	m.mu.Unlock()
+
+	for i, attempt := range attempts {
+		receipt, err := m.backend.TransactionReceipt(ctx, attempt.Hash)
+		// the simulated backend answers an unknown hash with no receipt
+		if errors.Is(err, ethereum.NotFound) || err == nil && receipt == nil {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		tx := new(types.Transaction)
+		if err := tx.UnmarshalBinary(attempt.Raw); err != nil {
+			return nil, err
+		}
+		gasPrice := attempt.GasPrice
+		if tx.Type() != types.LegacyTxType {
+			header, err := m.backend.HeaderByNumber(ctx, receipt.BlockNumber)
+			if err != nil {
+				return nil, err
+			}
+			gasPrice = effectiveGasPrice(tx, header.BaseFee)
+		}
+
+		m.mu.Lock()
+		for j := range tracked.Attempts {
+			tracked.Attempts[j].Outcome = outcomeReplaced
+		}
+		switch {
+		case attempt.Cancel:
+			tracked.Outcome = outcomeReplaced
+			tracked.Attempts[i].Outcome = outcomeCancelled
+		case receipt.Status == types.ReceiptStatusSuccessful:
+			// the executor reverts anything below minProfit, so a
+			// successful loop made money
+			tracked.Outcome = outcomeProfitable
+			tracked.Attempts[i].Outcome = outcomeProfitable
+			tracked.Profit = m.receiptProfit(receipt, tracked.ProfitToken, *tx.To())
+		default:
+			tracked.Outcome = outcomeReverted
+			tracked.Attempts[i].Outcome = outcomeReverted
+		}
+		tracked.Block = receipt.BlockNumber.Uint64()
+		tracked.GasCost = new(big.Int).Mul(gasPrice, new(big.Int).SetUint64(receipt.GasUsed))
+		m.mu.Unlock()
+		return tracked, m.settle(nonce)
+	}
+
+	if nonce < confirmed {
+		m.mu.Lock()
+		tracked.Outcome = outcomeDropped
+		for j := range tracked.Attempts {
+			tracked.Attempts[j].Outcome = outcomeDropped
+		}
+		m.mu.Unlock()
+		return tracked, m.settle(nonce)
+	}
+
+	m.mu.Lock()
+	until := tracked.Until
+	m.mu.Unlock()
+	if until > 0 {
+		// A bundle never reaches the public pool, so the node cannot be
+		// asked about it. Once its blocks are past it will never be mined.
+		head, err := m.backend.HeaderByNumber(ctx, nil)
+		if err != nil {
+			return nil, err
+		}
+		if head.Number.Uint64() <= until {
+			return nil, nil
+		}
+		return m.expire(ctx, nonce)
+	}
+
+	// still waiting, make sure the node has not evicted it
+	last := attempts[len(attempts)-1]
+	if _, _, err := m.backend.TransactionByHash(ctx, last.Hash); errors.Is(err, ethereum.NotFound) {
+		tx := new(types.Transaction)
+		if err := tx.UnmarshalBinary(last.Raw); err != nil {
+			return nil, err
+		}
+		m.mu.Lock()
+		via := m.submitter(tracked)
+		m.mu.Unlock()
+		if err := via.Submit(ctx, tx); err != nil {
+			log.Warn("Cannot rebroadcast transaction", "nonce", nonce, "err", err)
+		}
+	}
+	return nil, nil
+}
+
+// receiptProfit is how much more of token holder has after receipt's
+// transaction, from its Transfer logs.
+func (m *TxManager) receiptProfit(receipt *types.Receipt, token, holder common.Address) *big.Int {
+	profit := new(big.Int)
+	for _, entry := range receipt.Logs {
+		if entry.Address != token || len(entry.Topics) != 3 || entry.Topics[0] != m.transferTopic {
+			continue
+		}
+		value := new(big.Int).SetBytes(entry.Data)
+		if common.BytesToAddress(entry.Topics[2].Bytes()) == holder {
+			profit.Add(profit, value)
+		}
+		if common.BytesToAddress(entry.Topics[1].Bytes()) == holder {
+			profit.Sub(profit, value)
+		}
+	}
+	return profit
+}
+
+// expire gives up on a bundle whose target blocks have passed. Its nonce
+// is handed out again when nothing was sent after it; otherwise later
+// transactions wait on it, so it is cancelled through the mempool. A
+// released nonce is returned as dropped.
+func (m *TxManager) expire(ctx context.Context, nonce uint64) (*TrackedTx, error) {
+	m.mu.Lock()
+	tracked := m.tracked[nonce]
+	if nonce+1 == m.nonce {
+		m.nonce = nonce
+		tracked.Outcome = outcomeDropped
+		for j := range tracked.Attempts {
+			tracked.Attempts[j].Outcome = outcomeDropped
+		}
+		m.mu.Unlock()
+		log.Info("Bundle not included, releasing nonce", "nonce", nonce)
+		return tracked, m.settle(nonce)
+	}
+	tracked.Via = "mempool"
+	tracked.Until = 0
+	m.mu.Unlock()
+	log.Info("Bundle not included, cancelling nonce", "nonce", nonce)
+	if _, err := m.Cancel(ctx, nonce); err != nil {
+		log.Warn("Cannot cancel expired bundle", "nonce", nonce, "err", err)
+	}
+	return nil, nil
+}
+
+// settle stops tracking nonce once its outcome is known.
+func (m *TxManager) settle(nonce uint64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.tracked, nonce)
+	return m.save()
+}
+
+// Pending lists the nonces still in flight.
+func (m *TxManager) Pending() []*TrackedTx {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	pending := make([]*TrackedTx, 0, len(m.tracked))
+	for _, tracked := range m.tracked {
+		pending = append(pending, tracked)
+	}
+	return pending
+}
+
+// save writes the nonce and pending transactions, replacing the old state
+// file only once the new one is complete. Callers hold m.mu.
+func (m *TxManager) save() error {
+	state := txState{Nonce: m.nonce}
+	for _, tracked := range m.tracked {
+		state.Tracked = append(state.Tracked, tracked)
+	}
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := m.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, m.path)
+}
@@ -0,0 +1,432 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"errors"
+	"math/big"
+	"path/filepath"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind/backends"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// simHead reads the simulated chain's head for a bundleSubmitter.
+type simHead struct {
+	backend *backends.SimulatedBackend
+}
+
+func (h simHead) BlockNumber(ctx context.Context) (uint64, error) {
+	header, err := h.backend.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	return header.Number.Uint64(), nil
+}
+
+// newTestTxManager funds a fresh key on a simulated chain and manages its
+// transactions with state in a temporary file.
+func newTestTxManager(t *testing.T) (*TxManager, *backends.SimulatedBackend, *ecdsa.PrivateKey, string) {
+	key, _ := crypto.GenerateKey()
+	backend := backends.NewSimulatedBackend(core.GenesisAlloc{
+		crypto.PubkeyToAddress(key.PublicKey): {Balance: new(big.Int).Lsh(big.NewInt(1), 100)},
+	}, 30000000)
+	t.Cleanup(func() { backend.Close() })
+	path := filepath.Join(t.TempDir(), "txstate.json")
+	m, err := NewTxManager(context.Background(), backend, key, big.NewInt(1337), path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m, backend, key, path
+}
+
+func TestTxManagerBundleExpiry(t *testing.T) {
+	ctx := context.Background()
+	to := common.HexToAddress("0x7000000000000000000000000000000000000007")
+	price := big.NewInt(1000000000)
+
+	t.Run("last nonce released", func(t *testing.T) {
+		m, backend, _, _ := newTestTxManager(t)
+		relay, err := NewStandInRelay(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer relay.Close()
+		bundle, err := newBundleSubmitter(relay.URL(), simHead{backend}, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 2; i++ {
+			if _, err := m.Send(ctx, bundle, to, nil, 21000, price, big.NewInt(1), to); err != nil {
+				t.Fatal(err)
+			}
+		}
+		backend.Commit()
+		backend.Commit()
+		if settled, err := m.Poll(ctx); err != nil || len(settled) != 0 {
+			t.Fatalf("settled %d (%v) while the bundles can still land", len(settled), err)
+		}
+		backend.Commit()
+		settled, err := m.Poll(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(settled) != 2 || settled[0].Outcome != outcomeDropped || settled[1].Outcome != outcomeDropped {
+			t.Fatalf("settled %v, want both bundles dropped", settled)
+		}
+		if m.nonce != 0 || len(m.Pending()) != 0 {
+			t.Errorf("next nonce %d with %d pending, want 0 with none", m.nonce, len(m.Pending()))
+		}
+	})
+
+	t.Run("earlier nonce cancelled", func(t *testing.T) {
+		m, backend, _, _ := newTestTxManager(t)
+		relay, err := NewStandInRelay(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer relay.Close()
+		short, err := newBundleSubmitter(relay.URL(), simHead{backend}, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		long, err := newBundleSubmitter(relay.URL(), simHead{backend}, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := m.Send(ctx, short, to, nil, 21000, price, big.NewInt(1), to); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := m.Send(ctx, long, to, nil, 21000, price, big.NewInt(1), to); err != nil {
+			t.Fatal(err)
+		}
+		backend.Commit()
+		backend.Commit()
+		if settled, err := m.Poll(ctx); err != nil || len(settled) != 0 {
+			t.Fatalf("settled %d (%v), want the expired bundle cancelled", len(settled), err)
+		}
+		cancelling := m.tracked[0]
+		if cancelling.Via != "mempool" || !cancelling.latest().Cancel {
+			t.Fatalf("nonce 0 via %s, cancel %v, want a cancel through the mempool", cancelling.Via, cancelling.latest().Cancel)
+		}
+		backend.Commit()
+		settled, err := m.Poll(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(settled) != 1 || settled[0].Nonce != 0 || settled[0].Outcome != outcomeReplaced || settled[0].latest().Outcome != outcomeCancelled {
+			t.Fatalf("settled %v, want nonce 0 cancelled", settled)
+		}
+		if m.nonce != 2 {
+			t.Errorf("next nonce %d, want 2 with nonce 1 still out", m.nonce)
+		}
+	})
+}
+
+// flakyHeaders fails header reads while fail is set.
+type flakyHeaders struct {
+	*backends.SimulatedBackend
+	fail bool
+}
+
+func (b *flakyHeaders) HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error) {
+	if b.fail {
+		return nil, errors.New("header not found")
+	}
+	return b.SimulatedBackend.HeaderByNumber(ctx, number)
+}
+
+func TestTxManagerHeaderFailure(t *testing.T) {
+	ctx := context.Background()
+	to := common.HexToAddress("0x7000000000000000000000000000000000000007")
+	price := big.NewInt(1000000000)
+	key, _ := crypto.GenerateKey()
+	sim := backends.NewSimulatedBackend(core.GenesisAlloc{
+		crypto.PubkeyToAddress(key.PublicKey): {Balance: new(big.Int).Lsh(big.NewInt(1), 100)},
+	}, 30000000)
+	defer sim.Close()
+	backend := &flakyHeaders{SimulatedBackend: sim}
+	m, err := NewTxManager(ctx, backend, key, big.NewInt(1337), filepath.Join(t.TempDir(), "txstate.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	relay, err := NewStandInRelay(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer relay.Close()
+	bundle, err := newBundleSubmitter(relay.URL(), simHead{sim}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The window comes from the submitter, so a node failing to serve the
+	// head cannot leave a bundle out untracked
+	backend.fail = true
+	tracked, err := m.Send(ctx, bundle, to, nil, 21000, price, big.NewInt(1), to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tracked.Nonce != 0 || m.nonce != 1 || tracked.Until != 2 || len(relay.Bundles()) != 2 {
+		t.Errorf("sent nonce %d until block %d in %d bundles, next %d, want nonce 0 until 2 in 2, next 1",
+			tracked.Nonce, tracked.Until, len(relay.Bundles()), m.nonce)
+	}
+}
+
+// recordingSubmitter keeps every transaction it is given and, when forward
+// is set, sends it on to the chain.
+type recordingSubmitter struct {
+	sent    []*types.Transaction
+	forward *backends.SimulatedBackend
+}
+
+func (s *recordingSubmitter) Name() string { return "recording" }
+
+func (s *recordingSubmitter) Submit(ctx context.Context, tx *types.Transaction) error {
+	s.sent = append(s.sent, tx)
+	if s.forward != nil {
+		return s.forward.SendTransaction(ctx, tx)
+	}
+	return nil
+}
+
+func TestTxManagerNonces(t *testing.T) {
+	ctx := context.Background()
+	to := common.HexToAddress("0x7000000000000000000000000000000000000007")
+	price := big.NewInt(1000000000)
+	m, backend, key, path := newTestTxManager(t)
+
+	via := &recordingSubmitter{}
+	for want := uint64(0); want < 3; want++ {
+		tracked, err := m.Send(ctx, via, to, nil, 21000, price, big.NewInt(1), to)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tracked.Nonce != want || via.sent[want].Nonce() != want {
+			t.Errorf("send %d got nonce %d, signed %d", want, tracked.Nonce, via.sent[want].Nonce())
+		}
+	}
+
+	// A restart resumes after the stored nonces, which the chain has not
+	// seen, rather than reusing them
+	reloaded, err := NewTxManager(ctx, backend, key, big.NewInt(1337), path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reloaded.nonce != 3 || len(reloaded.Pending()) != 3 {
+		t.Errorf("reloaded at nonce %d with %d pending, want 3 with 3", reloaded.nonce, len(reloaded.Pending()))
+	}
+
+	// Transactions sent around the manager move its first nonce up
+	signer := types.LatestSignerForChainID(big.NewInt(1337))
+	for nonce := uint64(0); nonce < 5; nonce++ {
+		tx, err := types.SignTx(types.NewTransaction(nonce, to, big.NewInt(0), 21000, price, nil), signer, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := backend.SendTransaction(ctx, tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ahead, err := NewTxManager(ctx, backend, key, big.NewInt(1337), filepath.Join(t.TempDir(), "txstate.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ahead.nonce != 5 {
+		t.Errorf("fresh manager starts at %d, want the pending nonce 5", ahead.nonce)
+	}
+	behind, err := NewTxManager(ctx, backend, key, big.NewInt(1337), path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if behind.nonce != 5 {
+		t.Errorf("stored nonce 3 behind the chain's 5 resumed at %d", behind.nonce)
+	}
+}
+
+func TestTxManagerReplace(t *testing.T) {
+	ctx := context.Background()
+	to := common.HexToAddress("0x7000000000000000000000000000000000000007")
+	data := []byte{1, 2, 3}
+	m, backend, key, path := newTestTxManager(t)
+	via := &recordingSubmitter{}
+	if _, err := m.Send(ctx, via, to, data, 100000, big.NewInt(1000), big.NewInt(1), to); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		cancel bool
+		price  int64
+		to     common.Address
+		gas    uint64
+		data   int
+	}{
+		{"speed up", false, 1120, to, 100000, 3},
+		{"speed up again", false, 1254, to, 100000, 3},
+		{"cancel", true, 1404, m.from, 21000, 0},
+		// once cancelled, a speed-up speeds up the cancel
+		{"speed up the cancel", false, 1572, m.from, 21000, 0},
+	}
+	for i, test := range tests {
+		var tx *types.Transaction
+		var err error
+		if test.cancel {
+			tx, err = m.Cancel(ctx, 0)
+		} else {
+			tx, err = m.SpeedUp(ctx, 0)
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if tx.Nonce() != 0 || tx.GasPrice().Int64() != test.price || *tx.To() != test.to || tx.Gas() != test.gas || len(tx.Data()) != test.data {
+			t.Errorf("%s: nonce %d price %d to %s gas %d data %d", test.name, tx.Nonce(), tx.GasPrice(), tx.To().Hex(), tx.Gas(), len(tx.Data()))
+		}
+		if len(via.sent) != i+2 || via.sent[i+1] != tx {
+			t.Errorf("%s: replacement not submitted through the first submitter", test.name)
+		}
+	}
+	if _, err := m.SpeedUp(ctx, 1); err == nil {
+		t.Error("sped up a nonce never sent")
+	}
+
+	reloaded, err := NewTxManager(ctx, backend, key, big.NewInt(1337), path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracked := reloaded.tracked[0]
+	if tracked == nil || len(tracked.Attempts) != 5 || !tracked.latest().Cancel || tracked.latest().GasPrice.Int64() != 1572 || tracked.Via != "recording" {
+		t.Fatalf("reloaded %+v, want all 5 attempts ending in the cancel", tracked)
+	}
+}
+
+func TestTxManagerPoll(t *testing.T) {
+	ctx := context.Background()
+	key, _ := crypto.GenerateKey()
+	reverter := common.HexToAddress("0x7e7e000000000000000000000000000000000000")
+	backend := backends.NewSimulatedBackend(core.GenesisAlloc{
+		crypto.PubkeyToAddress(key.PublicKey): {Balance: new(big.Int).Lsh(big.NewInt(1), 100)},
+		// PUSH1 0 PUSH1 0 REVERT
+		reverter: {Code: []byte{0x60, 0x00, 0x60, 0x00, 0xfd}, Balance: new(big.Int)},
+	}, 30000000)
+	defer backend.Close()
+	m, err := NewTxManager(ctx, backend, key, big.NewInt(1337), filepath.Join(t.TempDir(), "txstate.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	to := common.HexToAddress("0x7000000000000000000000000000000000000007")
+	price := big.NewInt(1000000000)
+	mempool := m.via["mempool"]
+
+	// nonce 0 succeeds and nonce 1 reverts
+	if _, err := m.Send(ctx, mempool, to, nil, 21000, price, big.NewInt(1), to); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Send(ctx, mempool, reverter, nil, 100000, price, big.NewInt(1), to); err != nil {
+		t.Fatal(err)
+	}
+	// nonce 2 is cancelled: the trade never leaves, the cancel is mined
+	cancelled := &recordingSubmitter{}
+	if _, err := m.Send(ctx, cancelled, to, nil, 21000, price, big.NewInt(1), to); err != nil {
+		t.Fatal(err)
+	}
+	cancelled.forward = backend
+	if _, err := m.Cancel(ctx, 2); err != nil {
+		t.Fatal(err)
+	}
+	// nonce 3 is taken by a transaction the manager did not send
+	if _, err := m.Send(ctx, &recordingSubmitter{}, to, nil, 21000, price, big.NewInt(1), to); err != nil {
+		t.Fatal(err)
+	}
+	other, err := types.SignTx(types.NewTransaction(3, to, big.NewInt(1), 21000, price, nil), m.signer, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := backend.SendTransaction(ctx, other); err != nil {
+		t.Fatal(err)
+	}
+	// nonce 4 is still out and stuck
+	stuck := &recordingSubmitter{}
+	if _, err := m.Send(ctx, stuck, to, nil, 21000, price, big.NewInt(1), to); err != nil {
+		t.Fatal(err)
+	}
+	backend.Commit()
+
+	m.stuck = 0
+	settled, err := m.Poll(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[uint64]txOutcome{0: outcomeProfitable, 1: outcomeReverted, 2: outcomeReplaced, 3: outcomeDropped}
+	if len(settled) != len(want) {
+		t.Errorf("%d settled, want %d", len(settled), len(want))
+	}
+	for _, tracked := range settled {
+		if tracked.Outcome != want[tracked.Nonce] {
+			t.Errorf("nonce %d %s, want %s", tracked.Nonce, tracked.Outcome, want[tracked.Nonce])
+		}
+		if tracked.Outcome != outcomeDropped && (tracked.Block != 1 || tracked.GasCost == nil || tracked.GasCost.Sign() <= 0) {
+			t.Errorf("nonce %d mined in block %d for %v gas", tracked.Nonce, tracked.Block, tracked.GasCost)
+		}
+	}
+	for _, tracked := range settled {
+		if tracked.Nonce == 2 && tracked.latest().Outcome != outcomeCancelled {
+			t.Errorf("cancel of nonce 2 is %s", tracked.latest().Outcome)
+		}
+	}
+
+	pending := m.Pending()
+	if len(pending) != 1 || pending[0].Nonce != 4 {
+		t.Fatalf("%d pending, want only nonce 4", len(pending))
+	}
+	// the node never saw it, so it is broadcast again, then sped up
+	last := stuck.sent[len(stuck.sent)-1]
+	if len(pending[0].Attempts) != 2 || len(stuck.sent) != 3 || last.Hash() != pending[0].latest().Hash || last.GasPrice().Cmp(price) <= 0 {
+		t.Errorf("stuck nonce has %d attempts after %d submissions, want it rebroadcast and sped up once", len(pending[0].Attempts), len(stuck.sent))
+	}
+}
+
+func TestReceiptProfit(t *testing.T) {
+	m, _, _, _ := newTestTxManager(t)
+	token := common.HexToAddress("0x7070000000000000000000000000000000000000")
+	other := common.HexToAddress("0x7171000000000000000000000000000000000000")
+	executor := common.HexToAddress("0xe0e0000000000000000000000000000000000000")
+	pool := common.HexToAddress("0x9090000000000000000000000000000000000000")
+	transfer := func(token, from, to common.Address, value int64) *types.Log {
+		return &types.Log{
+			Address: token,
+			Topics:  []common.Hash{m.transferTopic, from.Hash(), to.Hash()},
+			Data:    common.LeftPadBytes(big.NewInt(value).Bytes(), 32),
+		}
+	}
+	tests := []struct {
+		name string
+		logs []*types.Log
+		want int64
+	}{
+		{"no transfers", nil, 0},
+		{"loop back with more", []*types.Log{
+			transfer(token, executor, pool, 1000),
+			transfer(other, pool, pool, 1200),
+			transfer(token, pool, executor, 1030),
+		}, 30},
+		{"flash swap", []*types.Log{
+			transfer(other, pool, executor, 500),
+			transfer(other, executor, pool, 500),
+			transfer(token, pool, executor, 1040),
+			transfer(token, executor, pool, 1000),
+		}, 40},
+		{"other tokens ignored", []*types.Log{transfer(other, pool, executor, 99)}, 0},
+		{"approval ignored", []*types.Log{{Address: token, Topics: []common.Hash{common.HexToHash("0x01"), pool.Hash(), executor.Hash()}, Data: common.LeftPadBytes([]byte{9}, 32)}}, 0},
+	}
+	for _, test := range tests {
+		got := m.receiptProfit(&types.Receipt{Logs: test.logs}, token, executor)
+		if got.Int64() != test.want {
+			t.Errorf("%s: profit %s, want %d", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,402 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"time"
+
+	"example.com/m/pancakePair"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// vetAccount is the buyer in simulations, an address nobody holds a key for
+// so no token has it whitelisted.
+var vetAccount = common.BytesToAddress(crypto.Keccak256([]byte("oldbot token vetting")))
+
+const vetGas = 5000000
+
+// vetBackend is what vetting needs from a node. ethclient.Client satisfies it.
+type vetBackend interface {
+	stateReader
+	HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error)
+	ChainID(ctx context.Context) (*big.Int, error)
+}
+
+// TokenVet is what a simulated buy and sell of a token through one pool
+// showed. Taxes are the fraction of each transfer that went missing.
+type TokenVet struct {
+	Token    common.Address `json:"token"`
+	Pool     common.Address `json:"pool"`
+	Block    uint64         `json:"block"`
+	BuyTax   float64        `json:"buyTax"`
+	SellTax  float64        `json:"sellTax"`
+	Sellable bool           `json:"sellable"`
+	Reason   string         `json:"reason,omitempty"`
+}
+
+// TokenVetter simulates buying and selling every token on a local fork of
+// the chain and keeps the results in a JSON file between runs.
+type TokenVetter struct {
+	backend vetBackend
+	abi     *abi.ABI
+	// bases are what tokens are bought with. They are assumed to transfer
+	// without tax.
+	bases  []common.Address
+	maxTax float64
+	// maxAge is how many blocks a result is trusted for before the token is
+	// simulated again. Owners can change taxes.
+	maxAge uint64
+	// blockTime is how far apart the chain's blocks are, for the fork
+	blockTime time.Duration
+	path      string
+	results   map[common.Address]*TokenVet
+}
+
+// NewTokenVetter loads earlier results from path. Tokens taxed above maxTax
+// on either side are excluded along with unsellable ones. Tokens are
+// simulated in a block blockTime after the head.
+func NewTokenVetter(backend vetBackend, bases []common.Address, maxTax float64, maxAge uint64, blockTime time.Duration, path string) (*TokenVetter, error) {
+	// The pair ABI covers the ERC20 calls as well as swap and getReserves.
+	parsed, err := pancakePair.PancakePairMetaData.GetAbi()
+	if err != nil {
+		return nil, err
+	}
+	v := &TokenVetter{backend: backend, abi: parsed, bases: bases, maxTax: maxTax, maxAge: maxAge, blockTime: blockTime, path: path, results: make(map[common.Address]*TokenVet)}
+	if data, err := os.ReadFile(path); err == nil {
+		var results []*TokenVet
+		if err := json.Unmarshal(data, &results); err != nil {
+			return nil, fmt.Errorf("vetting: corrupt results in %s: %w", path, err)
+		}
+		for _, result := range results {
+			v.results[result.Token] = result
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	return v, nil
+}
+
+// Vet simulates every token in pools that has no recent result and pairs
+// with a base token. Tokens that cannot be simulated are left unvetted.
+func (v *TokenVetter) Vet(ctx context.Context, pools []PoolState) error {
+	header, err := v.backend.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return err
+	}
+	chainID, err := v.backend.ChainID(ctx)
+	if err != nil {
+		return err
+	}
+	state := newForkState(ctx, v.backend, header.Number)
+	evm := newForkEVM(state, header, chainID, v.blockTime)
+
+	vetted := 0
+	for token, pool := range v.candidates(pools, header.Number.Uint64()) {
+		donor, ok := donorFor(pools, pool, otherToken(pool, token))
+		if !ok {
+			continue
+		}
+		state.reset()
+		result, err := v.simulate(evm, state, token, pool, donor)
+		if state.err != nil {
+			return state.err
+		}
+		if err != nil {
+			log.Warn("Cannot vet token", "token", token, "err", err)
+			continue
+		}
+		result.Block = header.Number.Uint64()
+		v.results[token] = result
+		vetted++
+	}
+	if vetted == 0 {
+		return nil
+	}
+	return v.save()
+}
+
+// candidates picks, for each token due for vetting, its deepest pool
+// against a base token.
+func (v *TokenVetter) candidates(pools []PoolState, head uint64) map[common.Address]PoolState {
+	candidates := make(map[common.Address]PoolState)
+	for _, pool := range pools {
+		for _, base := range v.bases {
+			var token common.Address
+			switch base {
+			case pool.in.From:
+				token = pool.in.To
+			case pool.in.To:
+				token = pool.in.From
+			default:
+				continue
+			}
+			if v.isBase(token) {
+				continue
+			}
+			if result, ok := v.results[token]; ok && result.Block+v.maxAge > head {
+				continue
+			}
+			baseReserve, _ := pool.reserves(base)
+			if best, ok := candidates[token]; ok {
+				bestReserve, _ := best.reserves(otherToken(best, token))
+				if bestReserve.Cmp(baseReserve) >= 0 {
+					continue
+				}
+			}
+			candidates[token] = pool
+		}
+	}
+	return candidates
+}
+
+func (v *TokenVetter) isBase(token common.Address) bool {
+	for _, base := range v.bases {
+		if token == base {
+			return true
+		}
+	}
+	return false
+}
+
+func otherToken(pool PoolState, token common.Address) common.Address {
+	if token == pool.in.From {
+		return pool.in.To
+	}
+	return pool.in.From
+}
+
+// donorFor finds another pool holding enough of base to fund a simulated
+// buy through pool. Its balance is borrowed by calling transfer as the donor.
+func donorFor(pools []PoolState, pool PoolState, base common.Address) (common.Address, bool) {
+	need, _ := pool.reserves(base)
+	need = new(big.Int).Div(need, big.NewInt(1000))
+	for _, donor := range pools {
+		if donor.in.Factory == pool.in.Factory || (donor.in.From != base && donor.in.To != base) {
+			continue
+		}
+		if have, _ := donor.reserves(base); have.Cmp(need) >= 0 {
+			return donor.in.Factory, true
+		}
+	}
+	return common.Address{}, false
+}
+
+// simulate buys token with a thousandth of the pool's base reserve, sells
+// everything received back into the same pool, and measures what each
+// transfer lost on the way.
+func (v *TokenVetter) simulate(evm *vm.EVM, state *forkState, token common.Address, pool PoolState, donor common.Address) (*TokenVet, error) {
+	pair := pool.in.Factory
+	base := otherToken(pool, token)
+	result := &TokenVet{Token: token, Pool: pair}
+	reserveBase, reserveToken := pool.reserves(base)
+	amount := new(big.Int).Div(reserveBase, big.NewInt(1000))
+	if amount.Sign() == 0 {
+		return nil, errors.New("pool is too shallow")
+	}
+
+	// Funding goes straight to the pair, as a router would send it.
+	if err := v.transfer(evm, state, base, donor, pair, amount); err != nil {
+		return nil, fmt.Errorf("cannot fund buy: %w", err)
+	}
+	paid, err := v.balanceOf(evm, state, base, pair)
+	if err != nil {
+		return nil, err
+	}
+	paid.Sub(paid, reserveBase)
+	expected := getAmountOut(paid, reserveBase, reserveToken, pool.in.Fee)
+	before, err := v.balanceOf(evm, state, token, vetAccount)
+	if err != nil {
+		return nil, err
+	}
+	if err := v.swap(evm, state, pool, token, expected); err != nil {
+		result.Reason = "buy reverted: " + err.Error()
+		return result, nil
+	}
+	received, err := v.balanceOf(evm, state, token, vetAccount)
+	if err != nil {
+		return nil, err
+	}
+	received.Sub(received, before)
+	result.BuyTax = lostFraction(expected, received)
+	if received.Sign() == 0 {
+		result.Reason = "buy paid out nothing"
+		return result, nil
+	}
+
+	reserves, err := v.call(evm, state, vetAccount, pair, "getReserves")
+	if err != nil {
+		return nil, err
+	}
+	after := PoolState{in: pool.in}
+	after.reserve0.Set(reserves[0].(*big.Int))
+	after.reserve1.Set(reserves[1].(*big.Int))
+	reserveToken, reserveBase = after.reserves(token)
+
+	if err := v.transfer(evm, state, token, vetAccount, pair, received); err != nil {
+		result.Reason = "sell transfer reverted: " + err.Error()
+		return result, nil
+	}
+	arrived, err := v.balanceOf(evm, state, token, pair)
+	if err != nil {
+		return nil, err
+	}
+	arrived.Sub(arrived, reserveToken)
+	result.SellTax = lostFraction(received, arrived)
+	if arrived.Sign() <= 0 {
+		result.Reason = "sell delivered nothing to the pool"
+		return result, nil
+	}
+	if err := v.swap(evm, state, after, base, getAmountOut(arrived, reserveToken, reserveBase, pool.in.Fee)); err != nil {
+		result.Reason = "sell reverted: " + err.Error()
+		return result, nil
+	}
+	result.Sellable = true
+	return result, nil
+}
+
+// lostFraction is how much of expected did not turn up as got.
+func lostFraction(expected, got *big.Int) float64 {
+	if expected.Sign() == 0 {
+		return 0
+	}
+	ratio, _ := new(big.Float).Quo(new(big.Float).SetInt(got), new(big.Float).SetInt(expected)).Float64()
+	return 1 - ratio
+}
+
+// call runs method on contract as from and unpacks the result. Reverts come
+// back as errors carrying the revert reason when there is one.
+func (v *TokenVetter) call(evm *vm.EVM, state *forkState, from, contract common.Address, method string, args ...interface{}) ([]interface{}, error) {
+	input, err := v.abi.Pack(method, args...)
+	if err != nil {
+		return nil, err
+	}
+	evm.Reset(vm.TxContext{Origin: from, GasPrice: new(big.Int)}, state)
+	ret, _, err := evm.Call(vm.AccountRef(from), contract, input, vetGas, new(big.Int))
+	if errors.Is(err, vm.ErrExecutionReverted) {
+		if reason, unpackErr := abi.UnpackRevert(ret); unpackErr == nil {
+			return nil, errors.New(reason)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	// some tokens return nothing from transfer
+	if len(ret) == 0 {
+		return nil, nil
+	}
+	return v.abi.Unpack(method, ret)
+}
+
+func (v *TokenVetter) balanceOf(evm *vm.EVM, state *forkState, token, owner common.Address) (*big.Int, error) {
+	out, err := v.call(evm, state, vetAccount, token, "balanceOf", owner)
+	if err != nil {
+		return nil, err
+	}
+	if len(out) == 0 {
+		return nil, errors.New("balanceOf returned nothing")
+	}
+	return new(big.Int).Set(out[0].(*big.Int)), nil
+}
+
+func (v *TokenVetter) transfer(evm *vm.EVM, state *forkState, token, from, to common.Address, amount *big.Int) error {
+	out, err := v.call(evm, state, from, token, "transfer", to, amount)
+	if err != nil {
+		return err
+	}
+	if len(out) > 0 && !out[0].(bool) {
+		return errors.New("transfer returned false")
+	}
+	return nil
+}
+
+// swap takes amountOut of tokenOut from the pool to vetAccount, paid for by
+// whatever was sent to the pool beforehand.
+func (v *TokenVetter) swap(evm *vm.EVM, state *forkState, pool PoolState, tokenOut common.Address, amountOut *big.Int) error {
+	amount0Out, amount1Out := new(big.Int), new(big.Int)
+	if tokenOut == pool.in.From {
+		amount0Out = amountOut
+	} else {
+		amount1Out = amountOut
+	}
+	_, err := v.call(evm, state, vetAccount, pool.in.Factory, "swap", amount0Out, amount1Out, vetAccount, []byte{})
+	return err
+}
+
+// excluded reports whether a vetted token should stay out of the graph.
+func (v *TokenVetter) excluded(token common.Address) (bool, string) {
+	result, ok := v.results[token]
+	if !ok {
+		return false, ""
+	}
+	if !result.Sellable {
+		return true, result.Reason
+	}
+	if result.BuyTax > v.maxTax || result.SellTax > v.maxTax {
+		return true, fmt.Sprintf("taxed %.2f%% on buys and %.2f%% on sells", result.BuyTax*100, result.SellTax*100)
+	}
+	return false, ""
+}
+
+// Apply drops pools with an excluded token and records the transfer taxes
+// of the rest, which buildMarket charges on whatever is sent into a pool.
+func (v *TokenVetter) Apply(pools []PoolState) []PoolState {
+	kept := make([]PoolState, 0, len(pools))
+	for _, pool := range pools {
+		drop := false
+		for _, token := range []common.Address{pool.in.From, pool.in.To} {
+			if excluded, reason := v.excluded(token); excluded {
+				log.Debug("Excluding pool", "pool", pool.in.Factory, "token", token, "reason", reason)
+				drop = true
+			}
+		}
+		if drop {
+			continue
+		}
+		if result, ok := v.results[pool.in.From]; ok {
+			pool.tax0 = transferTax(result)
+		}
+		if result, ok := v.results[pool.in.To]; ok {
+			pool.tax1 = transferTax(result)
+		}
+		kept = append(kept, pool)
+	}
+	return kept
+}
+
+// transferTax is the tax charged on a plain transfer into or out of a pool.
+// Taxes are measured with rounding noise, so tiny negatives count as none.
+func transferTax(result *TokenVet) float64 {
+	tax := result.BuyTax
+	if result.SellTax > tax {
+		tax = result.SellTax
+	}
+	if tax < 0 {
+		return 0
+	}
+	return tax
+}
+
+func (v *TokenVetter) save() error {
+	results := make([]*TokenVet, 0, len(v.results))
+	for _, result := range v.results {
+		results = append(results, result)
+	}
+	data, err := json.MarshalIndent(results, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := v.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, v.path)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"math"
+	"math/big"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind/backends"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// simVetBackend gives the simulated chain the chain id vetting asks for.
+type simVetBackend struct {
+	*backends.SimulatedBackend
+}
+
+func (b simVetBackend) ChainID(ctx context.Context) (*big.Int, error) {
+	return big.NewInt(1337), nil
+}
+
+func TestTokenVetter(t *testing.T) {
+	unit := new(big.Int).Exp(big.NewInt(10), big.NewInt(18), nil)
+	ether := func(n int64) *big.Int { return new(big.Int).Mul(big.NewInt(n), unit) }
+	chain := newMockChain(t)
+	base := chain.token("BASE", 0)
+	plain, taxed, honeypot := chain.token("PLAIN", 0), chain.token("TAXED", 500), chain.token("HONEY", 0)
+
+	pools := []PoolState{}
+	for _, token := range []common.Address{plain, taxed, honeypot} {
+		pair := chain.pair(base, token, ether(1000), ether(1000), 0)
+		pool := PoolState{in: PairIn{From: base, To: token, Factory: pair, Fee: 25}}
+		pool.reserve0.Set(ether(1000))
+		pool.reserve1.Set(ether(1000))
+		pools = append(pools, pool)
+	}
+	chain.transact("MockToken", honeypot, "blockSells", pools[2].in.Factory)
+
+	vetter, err := NewTokenVetter(simVetBackend{chain.backend}, []common.Address{base}, 0.1, 100, 3*time.Second, filepath.Join(t.TempDir(), "tokens.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := vetter.Vet(context.Background(), pools); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		token    common.Address
+		tax      float64
+		sellable bool
+	}{
+		{"plain", plain, 0, true},
+		{"taxed", taxed, 0.05, true},
+		{"honeypot", honeypot, 0, false},
+	}
+	for _, test := range tests {
+		result, ok := vetter.results[test.token]
+		if !ok {
+			t.Errorf("%s: not vetted", test.name)
+			continue
+		}
+		if result.Sellable != test.sellable {
+			t.Errorf("%s: sellable %v (%s), want %v", test.name, result.Sellable, result.Reason, test.sellable)
+		}
+		if math.Abs(result.BuyTax-test.tax) > 0.001 {
+			t.Errorf("%s: buy tax %.4f, want %.4f", test.name, result.BuyTax, test.tax)
+		}
+		if test.sellable && math.Abs(result.SellTax-test.tax) > 0.001 {
+			t.Errorf("%s: sell tax %.4f, want %.4f", test.name, result.SellTax, test.tax)
+		}
+	}
+
+	// The honeypot's pool goes and the taxed one is charged its tax
+	kept := vetter.Apply(pools)
+	if len(kept) != 2 {
+		t.Fatalf("kept %d pools, want the honeypot's dropped", len(kept))
+	}
+	for _, pool := range kept {
+		if pool.in.To == taxed && math.Abs(pool.tax1-0.05) > 0.001 {
+			t.Errorf("taxed pool charges %.4f, want 0.05", pool.tax1)
+		}
+	}
+
+	// Results are kept until they are maxAge blocks old
+	reloaded, err := NewTokenVetter(simVetBackend{chain.backend}, []common.Address{base}, 0.1, 100, 3*time.Second, vetter.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if due := reloaded.candidates(pools, vetter.results[plain].Block+1); len(due) != 0 {
+		t.Errorf("%d tokens due again a block later, want none", len(due))
+	}
+	if due := reloaded.candidates(pools, vetter.results[plain].Block+100); len(due) != 3 {
+		t.Errorf("%d tokens due after maxAge blocks, want 3", len(due))
+	}
+}